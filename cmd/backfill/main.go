@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/minghsu0107/saga-account/dep"
+	log "github.com/sirupsen/logrus"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "report changes and collisions without writing them")
+	flag.Parse()
+
+	backfiller, err := dep.InitializePhoneBackfiller()
+	if err != nil {
+		log.Fatal(err)
+	}
+	report, err := backfiller.Run(context.Background(), *dryRun)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(report.Collisions) > 0 || len(report.Invalid) > 0 || len(report.InvalidAddresses) > 0 {
+		log.Warn("some phone numbers need manual resolution")
+	}
+}
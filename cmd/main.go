@@ -2,10 +2,8 @@ package main
 
 import (
 	"context"
-	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
 	"github.com/minghsu0107/saga-account/dep"
 	log "github.com/sirupsen/logrus"
@@ -25,26 +23,10 @@ func main() {
 		log.Fatal(err)
 	}
 
-	go func() {
-		err := server.Run()
-		if err != nil {
-			log.Fatal(err)
-		}
-	}()
-
-	// catch shutdown
-	done := make(chan bool, 1)
-	go func() {
-		sig := make(chan os.Signal, 1)
-		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
-		<-sig
-
-		// graceful shutdown
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		server.GracefulStop(ctx, done)
-	}()
-
-	// wait for graceful shutdown
-	<-done
+	// run until shutdown is caught or a component fails, then shutdown gracefully
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if err := server.Run(ctx); err != nil {
+		log.Fatal(err)
+	}
 }
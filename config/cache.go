@@ -0,0 +1,152 @@
+package config
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minghsu0107/saga-account/pkg"
+)
+
+// CacheConfig is cache policy config type
+// a family policy overrides the non-zero fields of the default policy, and disabled if it is set; policies reload at runtime
+type CacheConfig struct {
+	Default  CachePolicy            `yaml:"default"`
+	Families map[string]CachePolicy `yaml:"families"`
+}
+
+// CachePolicy is the cache policy of a key family, such as cuscheck
+// local and redis expirations fall back to LocalCacheConfig and RedisConfig if zero,
+// and misses are not cached if NegativeExpirationSeconds is zero. Disabled is a pointer so that a family
+// can enable caching disabled by the default policy
+type CachePolicy struct {
+	Disabled                  *bool `yaml:"disabled"`
+	LocalExpirationSeconds    int64 `yaml:"localExpirationSeconds"`
+	RedisExpirationSeconds    int64 `yaml:"redisExpirationSeconds"`
+	JitterPercent             int64 `yaml:"jitterPercent"`
+	NegativeExpirationSeconds int64 `yaml:"negativeExpirationSeconds"`
+}
+
+// IsDisabled reports whether the family bypasses the caches
+func (p CachePolicy) IsDisabled() bool {
+	return p.Disabled != nil && *p.Disabled
+}
+
+// LocalExpiration is the expiration of local entries
+func (p CachePolicy) LocalExpiration() time.Duration {
+	return time.Duration(p.LocalExpirationSeconds) * time.Second
+}
+
+// RedisExpiration is the expiration of redis entries, extended by a random jitter of up to JitterPercent
+func (p CachePolicy) RedisExpiration() time.Duration {
+	expiration := time.Duration(p.RedisExpirationSeconds) * time.Second
+	jitter := int64(expiration) * p.JitterPercent / 100
+	if jitter <= 0 {
+		return expiration
+	}
+	return expiration + time.Duration(rand.Int63n(jitter+1))
+}
+
+// NegativeExpiration is the expiration of cached misses
+func (p CachePolicy) NegativeExpiration() time.Duration {
+	return time.Duration(p.NegativeExpirationSeconds) * time.Second
+}
+
+// CacheKey returns the cache key of id in a key family
+func CacheKey(family, id string) string {
+	return pkg.Join(family, ":", id)
+}
+
+// CustomerInfoKeys returns the cache keys of the personal and shipping info of a customer
+// both share the row version of the customer, so both are invalidated on any update of it
+func CustomerInfoKeys(customerID uint64) []string {
+	id := strconv.FormatUint(customerID, 10)
+	return []string{
+		CacheKey(PersonalInfoFamily, id),
+		CacheKey(ShippingInfoFamily, id),
+	}
+}
+
+// KeyFamily returns the family of a cache key, which is the prefix before the first colon
+func KeyFamily(key string) string {
+	if i := strings.IndexByte(key, ':'); i >= 0 {
+		return key[:i]
+	}
+	return key
+}
+
+// CachePolicy returns the current cache policy of a key family
+func (c *Config) CachePolicy(family string) CachePolicy {
+	cacheConfig, _ := c.cacheConfig.Load().(*CacheConfig)
+	if cacheConfig == nil {
+		cacheConfig = c.CacheConfig
+	}
+	var policy CachePolicy
+	if cacheConfig != nil {
+		policy = cacheConfig.Default
+		if familyPolicy, ok := cacheConfig.Families[family]; ok {
+			policy = mergeCachePolicy(policy, familyPolicy)
+		}
+	}
+	if policy.LocalExpirationSeconds == 0 && c.LocalCacheConfig != nil {
+		policy.LocalExpirationSeconds = c.LocalCacheConfig.ExpirationSeconds
+	}
+	if policy.RedisExpirationSeconds == 0 && c.RedisConfig != nil {
+		policy.RedisExpirationSeconds = c.RedisConfig.ExpirationSeconds
+	}
+	return policy
+}
+
+// CacheFamilies returns the sorted key families that have their own cache policies
+func (c *Config) CacheFamilies() []string {
+	cacheConfig, _ := c.cacheConfig.Load().(*CacheConfig)
+	if cacheConfig == nil {
+		cacheConfig = c.CacheConfig
+	}
+	if cacheConfig == nil {
+		return nil
+	}
+	families := make([]string, 0, len(cacheConfig.Families))
+	for family := range cacheConfig.Families {
+		families = append(families, family)
+	}
+	sort.Strings(families)
+	return families
+}
+
+// ReloadCachePolicies rereads cache policies from the config file
+func (c *Config) ReloadCachePolicies() error {
+	var config Config
+	if err := readFile(&config); err != nil {
+		return err
+	}
+	if err := readEnv(&config); err != nil {
+		return err
+	}
+	if config.CacheConfig == nil {
+		config.CacheConfig = &CacheConfig{}
+	}
+	c.cacheConfig.Store(config.CacheConfig)
+	return nil
+}
+
+func mergeCachePolicy(policy, override CachePolicy) CachePolicy {
+	if override.Disabled != nil {
+		policy.Disabled = override.Disabled
+	}
+	if override.LocalExpirationSeconds != 0 {
+		policy.LocalExpirationSeconds = override.LocalExpirationSeconds
+	}
+	if override.RedisExpirationSeconds != 0 {
+		policy.RedisExpirationSeconds = override.RedisExpirationSeconds
+	}
+	if override.JitterPercent != 0 {
+		policy.JitterPercent = override.JitterPercent
+	}
+	if override.NegativeExpirationSeconds != 0 {
+		policy.NegativeExpirationSeconds = override.NegativeExpirationSeconds
+	}
+	return policy
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"sync/atomic"
 
 	"github.com/kelseyhightower/envconfig"
 	log "github.com/sirupsen/logrus"
@@ -10,17 +11,31 @@ import (
 
 // Config is a type for general configuration
 type Config struct {
-	App              string            `yaml:"app" envconfig:"APP"`
-	GinMode          string            `yaml:"ginMode" envconfig:"GIN_MODE"`
-	HTTPPort         string            `yaml:"httpPort" envconfig:"HTTP_PORT"`
-	GRPCPort         string            `yaml:"grpcPort" envconfig:"GRPC_PORT"`
-	PromPort         string            `yaml:"promPort" envconfig:"PROM_PORT"`
-	JaegerUrl        string            `yaml:"jaegerUrl" envconfig:"JAEGER_URL"`
-	JWTConfig        *JWTConfig        `yaml:"jwtConfig"`
-	DBConfig         *DBConfig         `yaml:"dbConfig"`
-	LocalCacheConfig *LocalCacheConfig `yaml:"localCacheConfig"`
-	RedisConfig      *RedisConfig      `yaml:"redisConfig"`
-	Logger           *Logger
+	App               string             `yaml:"app" envconfig:"APP"`
+	GinMode           string             `yaml:"ginMode" envconfig:"GIN_MODE"`
+	HTTPPort          string             `yaml:"httpPort" envconfig:"HTTP_PORT"`
+	GRPCPort          string             `yaml:"grpcPort" envconfig:"GRPC_PORT"`
+	PromPort          string             `yaml:"promPort" envconfig:"PROM_PORT"`
+	JaegerUrl         string             `yaml:"jaegerUrl" envconfig:"JAEGER_URL"`
+	PhoneRegion       string             `yaml:"phoneRegion" envconfig:"PHONE_REGION"`
+	CacheCodec        string             `yaml:"cacheCodec" envconfig:"CACHE_CODEC"`
+	JWTConfig         *JWTConfig         `yaml:"jwtConfig"`
+	DBConfig          *DBConfig          `yaml:"dbConfig"`
+	LocalCacheConfig  *LocalCacheConfig  `yaml:"localCacheConfig"`
+	RedisConfig       *RedisConfig       `yaml:"redisConfig"`
+	CacheConfig       *CacheConfig       `yaml:"cacheConfig"`
+	AdminConfig       *AdminConfig       `yaml:"adminConfig"`
+	OutboxConfig      *OutboxConfig      `yaml:"outboxConfig"`
+	SagaConfig        *SagaConfig        `yaml:"sagaConfig"`
+	IdempotencyConfig *IdempotencyConfig `yaml:"idempotencyConfig"`
+	ShutdownConfig    *ShutdownConfig    `yaml:"shutdownConfig"`
+	TracingConfig     *TracingConfig     `yaml:"tracingConfig"`
+	LogConfig         *LogConfig         `yaml:"logConfig"`
+	AdminServerConfig *AdminServerConfig `yaml:"adminServerConfig"`
+	Logger            *Logger
+
+	// cacheConfig is the latest reloaded *CacheConfig
+	cacheConfig atomic.Value
 }
 
 // JWTConfig is jwt config type
@@ -31,25 +46,149 @@ type JWTConfig struct {
 }
 
 // DBConfig is database config type
+// a repository operation is canceled after QueryTimeoutMillis, or the timeout of the operation in
+// OperationTimeoutsMillis keyed by its method name; it has no deadline if zero. transient errors are retried
+// up to MaxRetries times with jittered exponential backoff, and retries are limited to RetryBudgetPercent
+// of the operations. the database is bypassed for BreakerOpenSeconds after BreakerFailureThreshold
+// consecutive connection failures; the circuit breaker is disabled if the threshold is zero.
+// reads are routed to the comma-separated ReplicaDsns, which are checked every ReplicaCheckIntervalSeconds
+// and ejected while unreachable or lagging more than ReplicaMaxLagSeconds behind Dsn, the primary. reads of
+// a customer written within ReadYourWritesSeconds by this instance are routed to the primary
 type DBConfig struct {
-	Dsn          string `yaml:"dsn" envconfig:"DB_DSN"`
-	MaxIdleConns int    `yaml:"maxIdleConns" envconfig:"DB_MAX_IDLE_CONNS"`
-	MaxOpenConns int    `yaml:"maxOpenConns" envconfig:"DB_MAX_OPEN_CONNS"`
+	Dsn                         string           `yaml:"dsn" envconfig:"DB_DSN"`
+	MaxIdleConns                int              `yaml:"maxIdleConns" envconfig:"DB_MAX_IDLE_CONNS"`
+	MaxOpenConns                int              `yaml:"maxOpenConns" envconfig:"DB_MAX_OPEN_CONNS"`
+	QueryTimeoutMillis          int64            `yaml:"queryTimeoutMillis" envconfig:"DB_QUERY_TIMEOUT_MILLIS"`
+	OperationTimeoutsMillis     map[string]int64 `yaml:"operationTimeoutsMillis" envconfig:"DB_OPERATION_TIMEOUTS_MILLIS"`
+	MaxRetries                  int              `yaml:"maxRetries" envconfig:"DB_MAX_RETRIES"`
+	RetryBaseDelayMillis        int64            `yaml:"retryBaseDelayMillis" envconfig:"DB_RETRY_BASE_DELAY_MILLIS"`
+	RetryMaxDelayMillis         int64            `yaml:"retryMaxDelayMillis" envconfig:"DB_RETRY_MAX_DELAY_MILLIS"`
+	RetryBudgetPercent          float64          `yaml:"retryBudgetPercent" envconfig:"DB_RETRY_BUDGET_PERCENT"`
+	BreakerFailureThreshold     int              `yaml:"breakerFailureThreshold" envconfig:"DB_BREAKER_FAILURE_THRESHOLD"`
+	BreakerOpenSeconds          int64            `yaml:"breakerOpenSeconds" envconfig:"DB_BREAKER_OPEN_SECONDS"`
+	ReplicaDsns                 string           `yaml:"replicaDsns" envconfig:"DB_REPLICA_DSNS"`
+	ReplicaMaxLagSeconds        int64            `yaml:"replicaMaxLagSeconds" envconfig:"DB_REPLICA_MAX_LAG_SECONDS"`
+	ReplicaCheckIntervalSeconds int64            `yaml:"replicaCheckIntervalSeconds" envconfig:"DB_REPLICA_CHECK_INTERVAL_SECONDS"`
+	ReadYourWritesSeconds       int64            `yaml:"readYourWritesSeconds" envconfig:"DB_READ_YOUR_WRITES_SECONDS"`
 }
 
 // LocalCacheConfig defines cache related settings
+// ExpirationSeconds is also the longest local expiration of any key family.
+// Invalidation selects how local entries are invalidated: "stream" reads InvalidationStream, and "tracking"
+// uses redis server-assisted client-side caching for the key families in CacheConfig.
+// invalidations missed while disconnected from the stream are replayed within InvalidationReplaySeconds,
+// after which the local cache is flushed instead
 type LocalCacheConfig struct {
-	ExpirationSeconds int64 `yaml:"expirationSeconds" envconfig:"LOCAL_CACHE_EXPIRATION_SECONDS"`
+	ExpirationSeconds         int64  `yaml:"expirationSeconds" envconfig:"LOCAL_CACHE_EXPIRATION_SECONDS"`
+	CleanWindowSeconds        int64  `yaml:"cleanWindowSeconds" envconfig:"LOCAL_CACHE_CLEAN_WINDOW_SECONDS"`
+	Invalidation              string `yaml:"invalidation" envconfig:"LOCAL_CACHE_INVALIDATION"`
+	InvalidationReplaySeconds int64  `yaml:"invalidationReplaySeconds" envconfig:"LOCAL_CACHE_INVALIDATION_REPLAY_SECONDS"`
 }
 
 // RedisConfig is redis config type
+// Mode is standalone, sentinel or cluster, which is the default. Addrs are the comma-separated seed addresses
+// in cluster mode and the server address in standalone mode; sentinel mode finds the master MasterName
+// through SentinelAddrs. DB must be 0 in cluster mode. timeouts fall back to the go-redis defaults if zero.
+// cached values are kept StaleSeconds after they expire, to be served while they are refreshed.
+// redis is bypassed for BreakerOpenSeconds after BreakerFailureThreshold consecutive connection failures;
+// the circuit breaker is disabled if the threshold is zero. stream messages pending on a consumer for
+// StreamClaimIdleSeconds are claimed by another one, and a message failing StreamMaxDeliveries deliveries
+// is moved to a dead letter stream; both fall back to defaults if zero
 type RedisConfig struct {
-	Addrs             string `yaml:"addrs" envconfig:"REDIS_ADDRS"`
-	Password          string `yaml:"password" envconfig:"REDIS_PASSWORD"`
-	DB                int    `yaml:"db" envconfig:"REDIS_DB"`
-	PoolSize          int    `yaml:"poolSize" envconfig:"REDIS_POOL_SIZE"`
-	MaxRetries        int    `yaml:"maxRetries" envconfig:"REDIS_MAX_RETRIES"`
-	ExpirationSeconds int64  `yaml:"expirationSeconds" envconfig:"REDIS_EXPIRATION_SECONDS"`
+	Mode                    string `yaml:"mode" envconfig:"REDIS_MODE"`
+	Addrs                   string `yaml:"addrs" envconfig:"REDIS_ADDRS"`
+	MasterName              string `yaml:"masterName" envconfig:"REDIS_MASTER_NAME"`
+	SentinelAddrs           string `yaml:"sentinelAddrs" envconfig:"REDIS_SENTINEL_ADDRS"`
+	SentinelUsername        string `yaml:"sentinelUsername" envconfig:"REDIS_SENTINEL_USERNAME"`
+	SentinelPassword        string `yaml:"sentinelPassword" envconfig:"REDIS_SENTINEL_PASSWORD"`
+	Username                string `yaml:"username" envconfig:"REDIS_USERNAME"`
+	Password                string `yaml:"password" envconfig:"REDIS_PASSWORD"`
+	DB                      int    `yaml:"db" envconfig:"REDIS_DB"`
+	PoolSize                int    `yaml:"poolSize" envconfig:"REDIS_POOL_SIZE"`
+	MaxRetries              int    `yaml:"maxRetries" envconfig:"REDIS_MAX_RETRIES"`
+	DialTimeoutMillis       int64  `yaml:"dialTimeoutMillis" envconfig:"REDIS_DIAL_TIMEOUT_MILLIS"`
+	ReadTimeoutMillis       int64  `yaml:"readTimeoutMillis" envconfig:"REDIS_READ_TIMEOUT_MILLIS"`
+	WriteTimeoutMillis      int64  `yaml:"writeTimeoutMillis" envconfig:"REDIS_WRITE_TIMEOUT_MILLIS"`
+	TLSEnabled              bool   `yaml:"tlsEnabled" envconfig:"REDIS_TLS_ENABLED"`
+	TLSServerName           string `yaml:"tlsServerName" envconfig:"REDIS_TLS_SERVER_NAME"`
+	TLSCAFile               string `yaml:"tlsCAFile" envconfig:"REDIS_TLS_CA_FILE"`
+	TLSInsecureSkipVerify   bool   `yaml:"tlsInsecureSkipVerify" envconfig:"REDIS_TLS_INSECURE_SKIP_VERIFY"`
+	ExpirationSeconds       int64  `yaml:"expirationSeconds" envconfig:"REDIS_EXPIRATION_SECONDS"`
+	StaleSeconds            int64  `yaml:"staleSeconds" envconfig:"REDIS_STALE_SECONDS"`
+	StreamMaxLen            int64  `yaml:"streamMaxLen" envconfig:"REDIS_STREAM_MAX_LEN"`
+	StreamClaimIdleSeconds  int64  `yaml:"streamClaimIdleSeconds" envconfig:"REDIS_STREAM_CLAIM_IDLE_SECONDS"`
+	StreamMaxDeliveries     int64  `yaml:"streamMaxDeliveries" envconfig:"REDIS_STREAM_MAX_DELIVERIES"`
+	BreakerFailureThreshold int    `yaml:"breakerFailureThreshold" envconfig:"REDIS_BREAKER_FAILURE_THRESHOLD"`
+	BreakerOpenSeconds      int64  `yaml:"breakerOpenSeconds" envconfig:"REDIS_BREAKER_OPEN_SECONDS"`
+}
+
+// OutboxConfig is transactional outbox relay config type
+type OutboxConfig struct {
+	Topic              string `yaml:"topic" envconfig:"OUTBOX_TOPIC"`
+	BatchSize          int    `yaml:"batchSize" envconfig:"OUTBOX_BATCH_SIZE"`
+	PollIntervalMillis int64  `yaml:"pollIntervalMillis" envconfig:"OUTBOX_POLL_INTERVAL_MILLIS"`
+}
+
+// SagaConfig is saga participant config type
+type SagaConfig struct {
+	CommandTopic  string `yaml:"commandTopic" envconfig:"SAGA_COMMAND_TOPIC"`
+	ReplyTopic    string `yaml:"replyTopic" envconfig:"SAGA_REPLY_TOPIC"`
+	ConsumerGroup string `yaml:"consumerGroup" envconfig:"SAGA_CONSUMER_GROUP"`
+}
+
+// IdempotencyConfig is idempotency key config type
+// responses are replayed for requests with the same Idempotency-Key header within ExpirationSeconds
+type IdempotencyConfig struct {
+	ExpirationSeconds int64 `yaml:"expirationSeconds" envconfig:"IDEMPOTENCY_EXPIRATION_SECONDS"`
+}
+
+// ShutdownConfig is graceful shutdown config type
+// the service reports not serving for DrainSeconds before its servers stop, so that load balancers stop
+// routing requests to it. components are stopped within TimeoutSeconds in total, and each within its
+// timeout in ComponentTimeoutsSeconds keyed by its name
+type ShutdownConfig struct {
+	TimeoutSeconds           int64            `yaml:"timeoutSeconds" envconfig:"SHUTDOWN_TIMEOUT_SECONDS"`
+	DrainSeconds             int64            `yaml:"drainSeconds" envconfig:"SHUTDOWN_DRAIN_SECONDS"`
+	ComponentTimeoutsSeconds map[string]int64 `yaml:"componentTimeoutsSeconds" envconfig:"SHUTDOWN_COMPONENT_TIMEOUTS_SECONDS"`
+}
+
+// TracingConfig is distributed tracing config type
+// spans are exported by Exporter: "otlpgrpc" and "otlphttp" export to the OTLP collector at Endpoint, and
+// "jaeger" exports to JaegerUrl; tracing is disabled if Exporter is empty, unless JaegerUrl is set.
+// Sampler is one of "always_on", "always_off", "traceidratio", "parentbased_always_on",
+// "parentbased_always_off" and "parentbased_traceidratio", where the ratio is SampleRatio.
+// ResourceAttributes are added to the resource of every span, besides the service and host names
+type TracingConfig struct {
+	Exporter           string            `yaml:"exporter" envconfig:"TRACING_EXPORTER"`
+	Endpoint           string            `yaml:"endpoint" envconfig:"TRACING_ENDPOINT"`
+	Insecure           bool              `yaml:"insecure" envconfig:"TRACING_INSECURE"`
+	Sampler            string            `yaml:"sampler" envconfig:"TRACING_SAMPLER"`
+	SampleRatio        float64           `yaml:"sampleRatio" envconfig:"TRACING_SAMPLE_RATIO"`
+	ResourceAttributes map[string]string `yaml:"resourceAttributes" envconfig:"TRACING_RESOURCE_ATTRIBUTES"`
+}
+
+// LogConfig is logging config type
+// Format is "json" or "text" (default). Level is a logrus level such as "info"; it defaults to
+// "info" in gin release mode and "debug" otherwise
+type LogConfig struct {
+	Format string `yaml:"format" envconfig:"LOG_FORMAT"`
+	Level  string `yaml:"level" envconfig:"LOG_LEVEL"`
+}
+
+// AdminConfig is admin api config type
+// the admin api is disabled if Token is empty. Token changes customer data, so it is kept apart from
+// AdminServerConfig.Token, which operators use to debug a single instance
+type AdminConfig struct {
+	Token string `yaml:"token" envconfig:"ADMIN_TOKEN"`
+}
+
+// AdminServerConfig is admin server config type
+// the admin server serves profiles, build info, the log level and the local cache to requests bearing Token.
+// it is disabled unless Enabled is set, and listens on Port, or on PromPort together with metrics if Port is empty
+type AdminServerConfig struct {
+	Enabled bool   `yaml:"enabled" envconfig:"ADMIN_SERVER_ENABLED"`
+	Port    string `yaml:"port" envconfig:"ADMIN_SERVER_PORT"`
+	Token   string `yaml:"token" envconfig:"ADMIN_SERVER_TOKEN"`
 }
 
 // NewConfig is the factory of Config instance
@@ -61,7 +200,11 @@ func NewConfig() (*Config, error) {
 	if err := readEnv(&config); err != nil {
 		return nil, err
 	}
-	config.Logger = newLogger(config.App, config.GinMode)
+	logger, err := newLogger(config.App, config.GinMode, config.LogConfig)
+	if err != nil {
+		return nil, err
+	}
+	config.Logger = logger
 	log.SetOutput(config.Logger.Writer)
 
 	return &config, nil
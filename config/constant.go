@@ -4,11 +4,27 @@ import "github.com/minghsu0107/saga-account/pkg"
 
 type HTTPContextKey string
 
+// cache key families, whose policies are configured in CacheConfig.Families
+const (
+	// CustomerCheckFamily caches the status of customers by id
+	CustomerCheckFamily = "cuscheck"
+	// CredentialsFamily caches the credentials of customers by email
+	CredentialsFamily = "cuscred"
+	// PersonalInfoFamily caches the personal info of customers by id
+	PersonalInfoFamily = "cuspersonalinfo"
+	// ShippingInfoFamily caches the shipping info of customers by id
+	ShippingInfoFamily = "cusshippinginfo"
+	// AddressesFamily caches the address books of customers by id
+	AddressesFamily = "cusaddresses"
+)
+
 var (
 	// JWTAuthHeader is the auth header containing customer ID
 	JWTAuthHeader = "Authorization"
-	// InvalidationTopic is the cache invalidation topic
-	InvalidationTopic = pkg.Join("invalidate_cache:", "account")
+	// InvalidationStream is the stream of invalidated cache keys
+	InvalidationStream = pkg.Join("invalidate_cache:", "account")
 	// CustomerKey is the key name for retrieving jwt-decoded customer id in a http request context
 	CustomerKey HTTPContextKey = "customer_key"
+	// RequestMetaKey is the key name for retrieving the request metadata for auditing in a request context
+	RequestMetaKey HTTPContextKey = "request_meta_key"
 )
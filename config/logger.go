@@ -1,14 +1,25 @@
 package config
 
 import (
+	"errors"
 	"io"
 	"os"
 	"time"
 
+	"github.com/minghsu0107/saga-account/pkg/logging"
 	log "github.com/sirupsen/logrus"
 	dblog "gorm.io/gorm/logger"
 )
 
+// log formats
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+// ErrUnknownLogFormat is unknown log format error
+var ErrUnknownLogFormat = errors.New("unknown log format; supports only json and text")
+
 func init() {
 	log.SetFormatter(&log.TextFormatter{FullTimestamp: true, DisableColors: true})
 }
@@ -20,7 +31,10 @@ type Logger struct {
 	ContextLogger *log.Entry
 }
 
-func newLogger(app, ginMode string) *Logger {
+// newLogger configures the standard logger, and returns the loggers of the service
+// entries are correlated with the trace, span and request ids in their context, and personal
+// information and secrets are masked in every entry, including those of the db logger
+func newLogger(app, ginMode string, logConfig *LogConfig) (*Logger, error) {
 	var dbLogLevel dblog.LogLevel
 	var dbLoggerLevel log.Level
 
@@ -33,12 +47,28 @@ func newLogger(app, ginMode string) *Logger {
 		dbLogLevel = dblog.Info
 		dbLoggerLevel = log.DebugLevel
 	}
+	if logConfig == nil {
+		logConfig = &LogConfig{}
+	}
+	formatter, err := newFormatter(logConfig.Format)
+	if err != nil {
+		return nil, err
+	}
+	level, err := newLevel(ginMode, logConfig.Level)
+	if err != nil {
+		return nil, err
+	}
+	log.SetFormatter(formatter)
+	log.SetLevel(level)
+	addHooks(log.StandardLogger())
 
 	var dbLogger = &log.Logger{
 		Out:       writer,
-		Formatter: new(log.TextFormatter),
+		Formatter: formatter,
+		Hooks:     make(log.LevelHooks),
 		Level:     dbLoggerLevel,
 	}
+	addHooks(dbLogger)
 
 	contextLogger := log.WithFields(log.Fields{
 		"app": app,
@@ -49,11 +79,39 @@ func newLogger(app, ginMode string) *Logger {
 		DBLogger: dblog.New(
 			dbLogger,
 			dblog.Config{
-				SlowThreshold: time.Second, // Slow SQL threshold
-				LogLevel:      dbLogLevel,  // Log level
-				Colorful:      true,        // Enable color
+				SlowThreshold: time.Second,                       // Slow SQL threshold
+				LogLevel:      dbLogLevel,                        // Log level
+				Colorful:      logConfig.Format != LogFormatJSON, // Enable color
 			},
 		),
 		ContextLogger: contextLogger,
+	}, nil
+}
+
+func newFormatter(format string) (log.Formatter, error) {
+	switch format {
+	case "", LogFormatText:
+		return &log.TextFormatter{FullTimestamp: true, DisableColors: true}, nil
+	case LogFormatJSON:
+		return &log.JSONFormatter{TimestampFormat: time.RFC3339Nano}, nil
 	}
+	return nil, ErrUnknownLogFormat
+}
+
+func newLevel(ginMode, level string) (log.Level, error) {
+	if level != "" {
+		return log.ParseLevel(level)
+	}
+	if ginMode == "release" {
+		return log.InfoLevel, nil
+	}
+	return log.DebugLevel, nil
+}
+
+// addHooks replaces the hooks of logger; redaction runs last, so that it covers the fields of other hooks
+func addHooks(logger *log.Logger) {
+	hooks := make(log.LevelHooks)
+	hooks.Add(logging.ContextHook{})
+	hooks.Add(logging.RedactionHook{})
+	logger.ReplaceHooks(hooks)
 }
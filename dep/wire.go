@@ -8,17 +8,24 @@ import (
 	"github.com/google/wire"
 	conf "github.com/minghsu0107/saga-account/config"
 	"github.com/minghsu0107/saga-account/infra"
+	infra_admin "github.com/minghsu0107/saga-account/infra/admin"
+	"github.com/minghsu0107/saga-account/infra/broker"
 	"github.com/minghsu0107/saga-account/infra/cache"
 	"github.com/minghsu0107/saga-account/infra/db"
 	infra_grpc "github.com/minghsu0107/saga-account/infra/grpc"
 	infra_http "github.com/minghsu0107/saga-account/infra/http"
 	http_middleware "github.com/minghsu0107/saga-account/infra/http/middleware"
 	infra_observe "github.com/minghsu0107/saga-account/infra/observe"
+	"github.com/minghsu0107/saga-account/infra/outbox"
+	infra_saga "github.com/minghsu0107/saga-account/infra/saga"
 	"github.com/minghsu0107/saga-account/pkg"
-	"github.com/minghsu0107/saga-account/repo"
+	"github.com/minghsu0107/saga-account/pkg/health"
 	"github.com/minghsu0107/saga-account/repo/proxy"
+	"github.com/minghsu0107/saga-account/repo/resilience"
 	"github.com/minghsu0107/saga-account/service/account"
+	"github.com/minghsu0107/saga-account/service/audit"
 	"github.com/minghsu0107/saga-account/service/auth"
+	"github.com/minghsu0107/saga-account/service/saga"
 )
 
 func InitializeServer() (*infra.Server, error) {
@@ -30,30 +37,51 @@ func InitializeServer() (*infra.Server, error) {
 		infra_http.NewServer,
 		infra_http.NewEngine,
 		infra_http.NewRouter,
+		infra_http.NewHealthProbe,
 
 		http_middleware.NewJWTAuthChecker,
+		http_middleware.NewIdempotencyChecker,
 
 		infra_grpc.NewGRPCServer,
 
 		infra_observe.NewObservabilityInjector,
+		infra_admin.NewServer,
+
+		health.NewRegistry,
 
 		db.NewDatabaseConnection,
+		db.NewRouter,
 
 		cache.NewLocalCache,
 		cache.NewRedisClient,
-		cache.NewRedisCache,
+		cache.NewBreakerRedisCache,
 		cache.NewLocalCacheCleaner,
+		cache.NewPolicyReloader,
+
+		broker.NewRedisStreamPublisher,
+		broker.NewRedisStreamSubscriber,
+		outbox.NewRelay,
+		infra_saga.NewCommandHandler,
 
 		proxy.NewCustomerRepoCache,
 		proxy.NewJWTAuthRepoCache,
+		proxy.NewAddressRepoCache,
 
 		pkg.NewSonyFlake,
 
 		auth.NewJWTAuthService,
 		account.NewCustomerService,
+		account.NewAddressService,
+		audit.NewAuditService,
+		saga.NewSagaService,
 
-		repo.NewJWTAuthRepository,
-		repo.NewCustomerRepository,
+		resilience.NewPolicy,
+		resilience.NewJWTAuthRepository,
+		resilience.NewCustomerRepository,
+		resilience.NewAddressRepository,
+		resilience.NewAuditRepository,
+		resilience.NewOutboxRepository,
+		resilience.NewSagaRepository,
 	)
 	return &infra.Server{}, nil
 }
@@ -66,3 +94,15 @@ func InitializeMigrator() (*db.Migrator, error) {
 	)
 	return &db.Migrator{}, nil
 }
+
+func InitializePhoneBackfiller() (*db.PhoneBackfiller, error) {
+	wire.Build(
+		conf.NewConfig,
+		db.NewDatabaseConnection,
+		health.NewRegistry,
+		cache.NewRedisClient,
+		cache.NewRedisCache,
+		db.NewPhoneBackfiller,
+	)
+	return &db.PhoneBackfiller{}, nil
+}
@@ -9,17 +9,24 @@ package dep
 import (
 	"github.com/minghsu0107/saga-account/config"
 	"github.com/minghsu0107/saga-account/infra"
+	"github.com/minghsu0107/saga-account/infra/admin"
+	"github.com/minghsu0107/saga-account/infra/broker"
 	"github.com/minghsu0107/saga-account/infra/cache"
 	"github.com/minghsu0107/saga-account/infra/db"
 	"github.com/minghsu0107/saga-account/infra/grpc"
 	"github.com/minghsu0107/saga-account/infra/http"
 	"github.com/minghsu0107/saga-account/infra/http/middleware"
 	pkg2 "github.com/minghsu0107/saga-account/infra/observe"
+	"github.com/minghsu0107/saga-account/infra/outbox"
+	saga2 "github.com/minghsu0107/saga-account/infra/saga"
 	"github.com/minghsu0107/saga-account/pkg"
-	"github.com/minghsu0107/saga-account/repo"
+	"github.com/minghsu0107/saga-account/pkg/health"
 	"github.com/minghsu0107/saga-account/repo/proxy"
+	"github.com/minghsu0107/saga-account/repo/resilience"
 	"github.com/minghsu0107/saga-account/service/account"
+	"github.com/minghsu0107/saga-account/service/audit"
 	"github.com/minghsu0107/saga-account/service/auth"
+	"github.com/minghsu0107/saga-account/service/saga"
 )
 
 // Injectors from wire.go:
@@ -34,35 +41,75 @@ func InitializeServer() (*infra.Server, error) {
 	if err != nil {
 		return nil, err
 	}
-	jwtAuthRepository := repo.NewJWTAuthRepository(gormDB)
+	registry := health.NewRegistry()
 	localCache, err := cache.NewLocalCache(configConfig)
 	if err != nil {
 		return nil, err
 	}
-	universalClient, err := cache.NewRedisClient(configConfig)
+	universalClient, err := cache.NewRedisClient(configConfig, registry)
 	if err != nil {
 		return nil, err
 	}
-	redisCache := cache.NewRedisCache(configConfig, universalClient)
+	redisCache, err := cache.NewBreakerRedisCache(configConfig, universalClient, localCache, registry)
+	if err != nil {
+		return nil, err
+	}
+	router, err := db.NewRouter(configConfig, gormDB, registry, redisCache)
+	if err != nil {
+		return nil, err
+	}
+	policy := resilience.NewPolicy(configConfig, registry)
+	jwtAuthRepository := resilience.NewJWTAuthRepository(router, policy)
 	jwtAuthRepoCache := proxy.NewJWTAuthRepoCache(configConfig, jwtAuthRepository, localCache, redisCache)
 	idGenerator, err := pkg.NewSonyFlake()
 	if err != nil {
 		return nil, err
 	}
-	jwtAuthService := auth.NewJWTAuthService(configConfig, jwtAuthRepoCache, idGenerator)
-	customerRepository := repo.NewCustomerRepository(gormDB)
+	auditRepository := resilience.NewAuditRepository(router, policy)
+	auditService := audit.NewAuditService(configConfig, auditRepository, idGenerator)
+	jwtAuthService := auth.NewJWTAuthService(configConfig, jwtAuthRepoCache, auditService, idGenerator)
+	customerRepository := resilience.NewCustomerRepository(router, policy)
 	customerRepoCache := proxy.NewCustomerRepoCache(configConfig, customerRepository, localCache, redisCache)
-	customerService := account.NewCustomerService(configConfig, customerRepoCache)
-	router := http.NewRouter(jwtAuthService, customerService)
+	addressRepository := resilience.NewAddressRepository(router, policy)
+	addressRepoCache := proxy.NewAddressRepoCache(configConfig, addressRepository, localCache, redisCache)
+	customerService := account.NewCustomerService(configConfig, customerRepoCache, addressRepoCache, auditService)
+	addressService := account.NewAddressService(configConfig, addressRepoCache, idGenerator)
+	router2 := http.NewRouter(jwtAuthService, customerService, addressService, auditService)
 	jwtAuthChecker := middleware.NewJWTAuthChecker(configConfig, jwtAuthService)
-	server := http.NewServer(configConfig, engine, router, jwtAuthChecker)
-	grpcServer := grpc.NewGRPCServer(configConfig, jwtAuthService)
+	idempotencyChecker, err := middleware.NewIdempotencyChecker(configConfig, redisCache)
+	if err != nil {
+		return nil, err
+	}
+	healthProbe := http.NewHealthProbe(registry)
+	server := http.NewServer(configConfig, engine, router2, jwtAuthChecker, idempotencyChecker, healthProbe)
+	grpcServer := grpc.NewGRPCServer(configConfig, jwtAuthService, registry)
 	observabilityInjector, err := pkg2.NewObservabilityInjector(configConfig)
 	if err != nil {
 		return nil, err
 	}
-	localCacheCleaner := cache.NewLocalCacheCleaner(universalClient, localCache)
-	infraServer := infra.NewServer(server, grpcServer, observabilityInjector, localCacheCleaner)
+	adminServer, err := admin.NewServer(configConfig, observabilityInjector, localCache, registry)
+	if err != nil {
+		return nil, err
+	}
+	localCacheCleaner, err := cache.NewLocalCacheCleaner(configConfig, universalClient, localCache, registry)
+	if err != nil {
+		return nil, err
+	}
+	policyReloader, err := cache.NewPolicyReloader(configConfig)
+	if err != nil {
+		return nil, err
+	}
+	outboxRepository := resilience.NewOutboxRepository(gormDB, policy)
+	publisher := broker.NewRedisStreamPublisher(configConfig, universalClient)
+	relay := outbox.NewRelay(configConfig, outboxRepository, publisher, redisCache)
+	sagaRepository := resilience.NewSagaRepository(gormDB, policy)
+	sagaService := saga.NewSagaService(configConfig, customerService, jwtAuthRepoCache, sagaRepository)
+	subscriber, err := broker.NewRedisStreamSubscriber(configConfig, universalClient)
+	if err != nil {
+		return nil, err
+	}
+	commandHandler := saga2.NewCommandHandler(configConfig, sagaService, subscriber, publisher)
+	infraServer := infra.NewServer(configConfig, server, grpcServer, observabilityInjector, adminServer, localCacheCleaner, policyReloader, relay, commandHandler, router, registry, gormDB, universalClient)
 	return infraServer, nil
 }
 
@@ -78,3 +125,25 @@ func InitializeMigrator() (*db.Migrator, error) {
 	migrator := db.NewMigrator(gormDB)
 	return migrator, nil
 }
+
+func InitializePhoneBackfiller() (*db.PhoneBackfiller, error) {
+	configConfig, err := config.NewConfig()
+	if err != nil {
+		return nil, err
+	}
+	gormDB, err := db.NewDatabaseConnection(configConfig)
+	if err != nil {
+		return nil, err
+	}
+	registry := health.NewRegistry()
+	universalClient, err := cache.NewRedisClient(configConfig, registry)
+	if err != nil {
+		return nil, err
+	}
+	redisCache, err := cache.NewRedisCache(configConfig, universalClient)
+	if err != nil {
+		return nil, err
+	}
+	phoneBackfiller := db.NewPhoneBackfiller(configConfig, gormDB, redisCache)
+	return phoneBackfiller, nil
+}
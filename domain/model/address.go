@@ -0,0 +1,34 @@
+package model
+
+import "strings"
+
+// PostalAddress value object
+type PostalAddress struct {
+	Line1      string
+	Line2      string
+	City       string
+	Region     string
+	PostalCode string
+	Country    string
+}
+
+// Format returns the single-line representation of a postal address
+func (a *PostalAddress) Format() string {
+	var parts []string
+	for _, part := range []string{a.Line1, a.Line2, a.City, strings.TrimSpace(a.Region + " " + a.PostalCode), a.Country} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Address entity
+type Address struct {
+	ID          uint64
+	CustomerID  uint64
+	Recipient   string
+	PhoneNumber string
+	PostalAddress
+	Default bool
+}
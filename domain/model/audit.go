@@ -0,0 +1,69 @@
+package model
+
+import "time"
+
+// AuditEventType is the type of an audited account event
+type AuditEventType string
+
+const (
+	// AuditSignUp is recorded when a customer signs up
+	AuditSignUp AuditEventType = "signup"
+	// AuditLoginSuccess is recorded when a customer logs in
+	AuditLoginSuccess AuditEventType = "login_success"
+	// AuditLoginFailure is recorded when a login attempt is rejected
+	AuditLoginFailure AuditEventType = "login_failure"
+	// AuditTokenRefresh is recorded when a token pair is refreshed
+	AuditTokenRefresh AuditEventType = "token_refresh"
+	// AuditPersonalInfoChange is recorded when personal info changes
+	AuditPersonalInfoChange AuditEventType = "personal_info_change"
+	// AuditShippingInfoChange is recorded when shipping info changes
+	AuditShippingInfoChange AuditEventType = "shipping_info_change"
+	// AuditStatusChange is recorded when a customer is activated or deactivated
+	AuditStatusChange AuditEventType = "status_change"
+)
+
+// AuditEvent entity
+// it is append-only and never updated once recorded
+type AuditEvent struct {
+	ID         uint64
+	CustomerID uint64
+	Type       AuditEventType
+	Actor      string
+	IP         string
+	UserAgent  string
+	RequestID  string
+	Changes    map[string]AuditChange
+	CreatedAt  time.Time
+}
+
+// AuditChange value object
+// PII values are redacted before they are recorded
+type AuditChange struct {
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// AuditQuery value object
+// zero CustomerID matches all customers and an empty Types matches all event types;
+// Before is an exclusive event ID cursor, zero means the latest event
+type AuditQuery struct {
+	CustomerID uint64
+	Types      []AuditEventType
+	Before     uint64
+	Limit      int
+}
+
+// AuditPage value object
+// NextCursor is zero if there are no more events
+type AuditPage struct {
+	Events     []AuditEvent
+	NextCursor uint64
+}
+
+// RequestMeta value object
+// it describes where a request comes from and who issues it
+type RequestMeta struct {
+	IP        string
+	UserAgent string
+	Actor     string
+}
@@ -14,10 +14,33 @@ type CustomerPersonalInfo struct {
 	FirstName string
 	LastName  string
 	Email     string
+	Version   uint64
 }
 
 // CustomerShippingInfo value object
+// PostalAddress is the structured address of a signup or an update, which is stored formatted as Address
 type CustomerShippingInfo struct {
-	Address     string
-	PhoneNumber string
+	Address       string
+	PostalAddress *PostalAddress
+	PhoneNumber   string
+	Version       uint64
+}
+
+// CustomerPersonalInfoPatch value object
+// nil attributes are left unchanged
+type CustomerPersonalInfoPatch struct {
+	FirstName *string
+	LastName  *string
+	Email     *string
+	Version   uint64
+}
+
+// CustomerShippingInfoPatch value object
+// nil attributes are left unchanged
+// PostalAddress replaces the whole address, and Address is set to its formatted representation
+type CustomerShippingInfoPatch struct {
+	Address       *string
+	PostalAddress *PostalAddress
+	PhoneNumber   *string
+	Version       uint64
 }
@@ -0,0 +1,29 @@
+package model
+
+import "time"
+
+// CustomerEventType is the type of a customer domain event
+type CustomerEventType string
+
+const (
+	// CustomerSignedUp is emitted when a customer signs up
+	CustomerSignedUp CustomerEventType = "customer.signed_up"
+	// CustomerEmailChanged is emitted when a customer changes email
+	CustomerEmailChanged CustomerEventType = "customer.email_changed"
+	// CustomerAddressChanged is emitted when the shipping address of a customer changes,
+	// including changes of the default address in the address book
+	CustomerAddressChanged CustomerEventType = "customer.address_changed"
+	// CustomerDeactivated is emitted when a customer is deactivated
+	CustomerDeactivated CustomerEventType = "customer.deactivated"
+	// CustomerActivated is emitted when a deactivated customer is activated again
+	CustomerActivated CustomerEventType = "customer.activated"
+)
+
+// CustomerEvent is the domain event published to other services
+// it only carries what changed; consumers query the account service for the current state
+type CustomerEvent struct {
+	Type       CustomerEventType `json:"type"`
+	CustomerID uint64            `json:"customer_id,string"`
+	Email      string            `json:"email,omitempty"`
+	OccurredAt time.Time         `json:"occurred_at"`
+}
@@ -0,0 +1,52 @@
+package model
+
+// SagaCommandType is the type of a saga command handled by the account service
+type SagaCommandType string
+
+const (
+	// SagaReserveCustomer validates that a customer is active and takes a snapshot of its shipping info
+	SagaReserveCustomer SagaCommandType = "customer.reserve"
+	// SagaReleaseCustomer compensates SagaReserveCustomer
+	SagaReleaseCustomer SagaCommandType = "customer.release"
+)
+
+// SagaReplyType is the type of a reply to a saga command
+type SagaReplyType string
+
+const (
+	// SagaCustomerReserved replies a successful SagaReserveCustomer
+	SagaCustomerReserved SagaReplyType = "customer.reserved"
+	// SagaCustomerReservationFailed replies a failed SagaReserveCustomer
+	SagaCustomerReservationFailed SagaReplyType = "customer.reservation_failed"
+	// SagaCustomerReleased replies SagaReleaseCustomer
+	SagaCustomerReleased SagaReplyType = "customer.released"
+)
+
+// SagaCommand value object
+type SagaCommand struct {
+	SagaID     string          `json:"saga_id"`
+	Type       SagaCommandType `json:"type"`
+	CustomerID uint64          `json:"customer_id,string"`
+	OrderID    uint64          `json:"order_id,string"`
+}
+
+// SagaReply value object
+// ShippingSnapshot is only set on SagaCustomerReserved and Reason is only set on SagaCustomerReservationFailed
+type SagaReply struct {
+	SagaID           string            `json:"saga_id"`
+	Type             SagaReplyType     `json:"type"`
+	CustomerID       uint64            `json:"customer_id,string"`
+	OrderID          uint64            `json:"order_id,string"`
+	ShippingSnapshot *ShippingSnapshot `json:"shipping_snapshot,omitempty"`
+	Reason           string            `json:"reason,omitempty"`
+}
+
+// ShippingSnapshot value object
+// it is the shipping info of a customer at the time an order is placed
+type ShippingSnapshot struct {
+	FirstName   string `json:"firstname"`
+	LastName    string `json:"lastname"`
+	Email       string `json:"email"`
+	Address     string `json:"address"`
+	PhoneNumber string `json:"phone_number"`
+}
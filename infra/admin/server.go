@@ -0,0 +1,262 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+	"sync"
+
+	conf "github.com/minghsu0107/saga-account/config"
+	"github.com/minghsu0107/saga-account/infra/cache"
+	"github.com/minghsu0107/saga-account/infra/http/presenter"
+	infra_observe "github.com/minghsu0107/saga-account/infra/observe"
+	"github.com/minghsu0107/saga-account/pkg/buildinfo"
+	"github.com/minghsu0107/saga-account/pkg/health"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrNoToken is the error of an enabled admin server without a token
+	ErrNoToken = errors.New("admin server requires a token")
+	// ErrSharedToken is the error of an admin server token equal to the admin api token
+	ErrSharedToken = errors.New("admin server token must differ from the admin api token")
+	// ErrNoPort is the error of an admin server sharing the prom port while metrics are disabled
+	ErrNoPort = errors.New("admin server requires a port if metrics are disabled")
+	// ErrEntryNotFound is the error of inspecting a key without a local entry
+	ErrEntryNotFound = errors.New("entry not found")
+	// ErrMethodNotAllowed is the error of a method not served by an endpoint
+	ErrMethodNotAllowed = errors.New("method not allowed")
+)
+
+// LogLevel request/response payload
+type LogLevel struct {
+	Level string `json:"level"`
+}
+
+// Server is the admin server
+// it serves net/http/pprof, build info, the log level, the local cache and the detailed health of this instance
+// under /debug/, either on its own port or next to prometheus metrics
+type Server struct {
+	port     string
+	token    string
+	lc       cache.LocalCache
+	registry *health.Registry
+	logger   *log.Entry
+	mu       sync.Mutex
+	svr      *http.Server
+	stopped  bool
+}
+
+// NewServer is the factory of admin server
+// the admin server is mounted on the metrics server if it has no port of its own
+func NewServer(config *conf.Config, obsInjector *infra_observe.ObservabilityInjector, lc cache.LocalCache, registry *health.Registry) (*Server, error) {
+	s := &Server{
+		lc:       lc,
+		registry: registry,
+		logger:   config.Logger.ContextLogger.WithField("type", "admin:Server"),
+	}
+	adminConfig := config.AdminServerConfig
+	if adminConfig == nil || !adminConfig.Enabled {
+		return s, nil
+	}
+	if adminConfig.Token == "" {
+		return nil, ErrNoToken
+	}
+	if config.AdminConfig != nil && config.AdminConfig.Token == adminConfig.Token {
+		return nil, ErrSharedToken
+	}
+	s.token = adminConfig.Token
+	if adminConfig.Port != "" && adminConfig.Port != obsInjector.PromPort() {
+		s.port = adminConfig.Port
+		return s, nil
+	}
+	if !obsInjector.MetricsEnabled() {
+		return nil, ErrNoPort
+	}
+	obsInjector.Handle("/debug/", s.Handler())
+	return s, nil
+}
+
+// Listening reports whether the admin server listens on its own port
+func (s *Server) Listening() bool {
+	return s.port != ""
+}
+
+// Handler returns the handler of the admin endpoints, which authorizes requests by the admin server token
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/buildinfo", s.BuildInfo)
+	mux.HandleFunc("/debug/loglevel", s.LogLevel)
+	mux.HandleFunc("/debug/cache/local", s.LocalCache)
+	mux.HandleFunc("/debug/health", s.Health)
+	return s.authorize(mux)
+}
+
+// Run serves the admin endpoints on the admin port until GracefulStop is called
+func (s *Server) Run() error {
+	addr := ":" + s.port
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return nil
+	}
+	s.svr = &http.Server{
+		Addr:    addr,
+		Handler: s.Handler(),
+	}
+	s.mu.Unlock()
+	log.Infoln("admin server listening on ", addr)
+	err := s.svr.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// GracefulStop the server
+// profiles still in progress when ctx is done are aborted; the server does not start if it is not running yet
+func (s *Server) GracefulStop(ctx context.Context) error {
+	s.mu.Lock()
+	s.stopped = true
+	svr := s.svr
+	s.mu.Unlock()
+	if svr == nil {
+		return nil
+	}
+	if err := svr.Shutdown(ctx); err != nil {
+		svr.Close()
+		return err
+	}
+	return nil
+}
+
+// BuildInfo responds the version, commit and go version of the binary
+func (s *Server) BuildInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	writeJSON(w, http.StatusOK, buildinfo.Get())
+}
+
+// Health responds the readiness of the service with the state of each component, like GET /readyz
+func (s *Server) Health(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	report := s.registry.Check(r.Context())
+	code := http.StatusOK
+	if !report.Serving {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, presenter.NewReadiness(report, true))
+}
+
+// LogLevel responds the log level on GET, and changes it on PUT until the process restarts
+func (s *Server) LogLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req LogLevel
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, presenter.ErrInvalidParam)
+			return
+		}
+		level, err := log.ParseLevel(req.Level)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, presenter.ErrInvalidParam)
+			return
+		}
+		s.logger.WithFields(log.Fields{
+			"from": log.GetLevel().String(),
+			"to":   level.String(),
+		}).Warn("log level changed")
+		log.SetLevel(level)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPut)
+		return
+	}
+	writeJSON(w, http.StatusOK, &LogLevel{
+		Level: log.GetLevel().String(),
+	})
+}
+
+// LocalCache serves the local cache of this instance
+// GET responds its statistics, or the entry of the key parameter without its value, and DELETE flushes
+// the whole cache, or invalidates the key parameter
+func (s *Server) LocalCache(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	switch r.Method {
+	case http.MethodGet:
+		if key == "" {
+			writeJSON(w, http.StatusOK, s.lc.Stats())
+			return
+		}
+		entry, err := s.lc.Inspect(key)
+		if err != nil {
+			s.logger.Error(err.Error())
+			writeError(w, http.StatusInternalServerError, presenter.ErrServer)
+			return
+		}
+		if entry == nil {
+			writeError(w, http.StatusNotFound, ErrEntryNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, entry)
+	case http.MethodDelete:
+		var err error
+		if key == "" {
+			err = s.lc.Reset()
+		} else {
+			err = s.lc.Invalidate(key)
+		}
+		if err != nil {
+			s.logger.Error(err.Error())
+			writeError(w, http.StatusInternalServerError, presenter.ErrServer)
+			return
+		}
+		s.logger.WithField("key", key).Warn("local cache flushed")
+		writeJSON(w, http.StatusOK, presenter.OkMsg)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodDelete)
+	}
+}
+
+// authorize compares the bearer token in the Authorization header with the admin server token
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get(conf.JWTAuthHeader), "Bearer ")
+		if s.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, presenter.ErrUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func methodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, &presenter.ErrResponse{
+		Message: err.Error(),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
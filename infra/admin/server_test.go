@@ -0,0 +1,209 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	conf "github.com/minghsu0107/saga-account/config"
+	"github.com/minghsu0107/saga-account/infra/cache"
+	"github.com/minghsu0107/saga-account/infra/http/presenter"
+	infra_observe "github.com/minghsu0107/saga-account/infra/observe"
+	"github.com/minghsu0107/saga-account/pkg/buildinfo"
+	"github.com/minghsu0107/saga-account/pkg/health"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+)
+
+const testToken = "admintoken"
+
+func TestAdmin(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "admin suite")
+}
+
+func newTestConfig(adminConfig *conf.AdminServerConfig) *conf.Config {
+	return &conf.Config{
+		App:               "account",
+		PromPort:          "8080",
+		AdminServerConfig: adminConfig,
+		LocalCacheConfig: &conf.LocalCacheConfig{
+			ExpirationSeconds: 60,
+		},
+		RedisConfig: &conf.RedisConfig{
+			ExpirationSeconds: 60,
+		},
+		CacheConfig: &conf.CacheConfig{},
+		Logger: &conf.Logger{
+			Writer: ioutil.Discard,
+			ContextLogger: log.WithFields(log.Fields{
+				"app": "test",
+			}),
+		},
+	}
+}
+
+var _ = Describe("admin server", func() {
+	var lc cache.LocalCache
+	var handler http.Handler
+	BeforeEach(func() {
+		config := newTestConfig(&conf.AdminServerConfig{
+			Enabled: true,
+			Port:    "8081",
+			Token:   testToken,
+		})
+		var err error
+		lc, err = cache.NewLocalCache(config)
+		Expect(err).To(BeNil())
+		obsInjector, err := infra_observe.NewObservabilityInjector(config)
+		Expect(err).To(BeNil())
+		registry := health.NewRegistry()
+		registry.Register(health.CheckerFunc(func(ctx context.Context) health.Status {
+			return health.Status{
+				Name:  "db-primary",
+				State: "down: dial tcp 10.0.0.1:3306: connection refused",
+			}
+		}))
+		server, err := NewServer(config, obsInjector, lc, registry)
+		Expect(err).To(BeNil())
+		Expect(server.Listening()).To(BeTrue())
+		handler = server.Handler()
+	})
+	// serve sends an authorized request and returns the response
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	It("should reject requests without the token", func() {
+		for _, token := range []string{"", "Bearer wrong"} {
+			req := httptest.NewRequest(http.MethodGet, "/debug/buildinfo", nil)
+			req.Header.Set("Authorization", token)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			Expect(w.Code).To(Equal(http.StatusUnauthorized))
+		}
+	})
+	It("should serve profiles and build info", func() {
+		Expect(serve(http.MethodGet, "/debug/pprof/", "").Code).To(Equal(http.StatusOK))
+		Expect(serve(http.MethodGet, "/debug/pprof/goroutine?debug=1", "").Code).To(Equal(http.StatusOK))
+
+		w := serve(http.MethodGet, "/debug/buildinfo", "")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		var info buildinfo.Info
+		Expect(json.Unmarshal(w.Body.Bytes(), &info)).To(BeNil())
+		Expect(info.Version).To(Equal(buildinfo.Version))
+		Expect(info.GoVersion).To(Equal(runtime.Version()))
+	})
+	It("should change the log level", func() {
+		level := log.GetLevel()
+		defer log.SetLevel(level)
+		log.SetLevel(log.InfoLevel)
+
+		w := serve(http.MethodPut, "/debug/loglevel", `{"level":"debug"}`)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(log.GetLevel()).To(Equal(log.DebugLevel))
+		w = serve(http.MethodGet, "/debug/loglevel", "")
+		Expect(w.Body.String()).To(MatchJSON(`{"level":"debug"}`))
+
+		Expect(serve(http.MethodPut, "/debug/loglevel", `{"level":"loud"}`).Code).To(Equal(http.StatusBadRequest))
+		Expect(log.GetLevel()).To(Equal(log.DebugLevel))
+		Expect(serve(http.MethodPost, "/debug/loglevel", "").Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+	It("should inspect and flush the local cache", func() {
+		Expect(lc.SetRaw("cuscheck:1", []byte("val"), time.Now().UnixNano())).To(BeNil())
+		Expect(lc.SetRaw("cuscheck:2", []byte("val"), time.Now().UnixNano())).To(BeNil())
+
+		w := serve(http.MethodGet, "/debug/cache/local", "")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		var stats cache.LocalCacheStats
+		Expect(json.Unmarshal(w.Body.Bytes(), &stats)).To(BeNil())
+		Expect(stats.Entries).To(Equal(2))
+
+		w = serve(http.MethodGet, "/debug/cache/local?key=cuscheck:1", "")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		var entry cache.LocalEntry
+		Expect(json.Unmarshal(w.Body.Bytes(), &entry)).To(BeNil())
+		Expect(entry.Tombstone).To(BeFalse())
+		Expect(entry.SizeBytes).To(Equal(3))
+		Expect(serve(http.MethodGet, "/debug/cache/local?key=cuscheck:3", "").Code).To(Equal(http.StatusNotFound))
+
+		Expect(serve(http.MethodDelete, "/debug/cache/local?key=cuscheck:1", "").Code).To(Equal(http.StatusOK))
+		ok, _, err := lc.GetRaw("cuscheck:1")
+		Expect(err).To(BeNil())
+		Expect(ok).To(BeFalse())
+		ok, _, err = lc.GetRaw("cuscheck:2")
+		Expect(err).To(BeNil())
+		Expect(ok).To(BeTrue())
+
+		Expect(serve(http.MethodDelete, "/debug/cache/local", "").Code).To(Equal(http.StatusOK))
+		ok, _, err = lc.GetRaw("cuscheck:2")
+		Expect(err).To(BeNil())
+		Expect(ok).To(BeFalse())
+	})
+	It("should report the state of each component", func() {
+		w := serve(http.MethodGet, "/debug/health", "")
+		Expect(w.Code).To(Equal(http.StatusServiceUnavailable))
+		var readiness presenter.Readiness
+		Expect(json.Unmarshal(w.Body.Bytes(), &readiness)).To(BeNil())
+		Expect(readiness.Status).To(Equal(health.StatusUnavailable))
+		Expect(readiness.Components).To(Equal([]presenter.ComponentHealth{
+			{Name: "db-primary", State: "down: dial tcp 10.0.0.1:3306: connection refused"},
+		}))
+	})
+})
+
+var _ = Describe("admin server config", func() {
+	newServer := func(config *conf.Config) (*Server, error) {
+		obsInjector, err := infra_observe.NewObservabilityInjector(config)
+		Expect(err).To(BeNil())
+		return NewServer(config, obsInjector, nil, nil)
+	}
+	It("should be disabled by default", func() {
+		server, err := newServer(newTestConfig(nil))
+		Expect(err).To(BeNil())
+		Expect(server.Listening()).To(BeFalse())
+	})
+	It("should require a token", func() {
+		_, err := newServer(newTestConfig(&conf.AdminServerConfig{
+			Enabled: true,
+		}))
+		Expect(err).To(Equal(ErrNoToken))
+	})
+	It("should not share the token of the admin api", func() {
+		config := newTestConfig(&conf.AdminServerConfig{
+			Enabled: true,
+			Token:   testToken,
+		})
+		config.AdminConfig = &conf.AdminConfig{
+			Token: testToken,
+		}
+		_, err := newServer(config)
+		Expect(err).To(Equal(ErrSharedToken))
+	})
+	It("should share the prom port if it has no port", func() {
+		server, err := newServer(newTestConfig(&conf.AdminServerConfig{
+			Enabled: true,
+			Token:   testToken,
+		}))
+		Expect(err).To(BeNil())
+		Expect(server.Listening()).To(BeFalse())
+
+		config := newTestConfig(&conf.AdminServerConfig{
+			Enabled: true,
+			Token:   testToken,
+		})
+		config.PromPort = ""
+		_, err = newServer(config)
+		Expect(err).To(Equal(ErrNoPort))
+	})
+})
@@ -0,0 +1,33 @@
+package broker
+
+import "context"
+
+// Message is a message exchanged through a broker
+type Message struct {
+	// ID uniquely identifies a message; consumers use it to discard redelivered messages
+	ID string
+	// Key is the ordering key; messages with the same key are delivered in publish order
+	Key  string
+	Type string
+	// RequestID is the id of the request causing the message, if any
+	RequestID string
+	Payload   []byte
+}
+
+// Publisher publishes messages to a topic
+// messages are published in the given order
+type Publisher interface {
+	Publish(ctx context.Context, topic string, msgs ...*Message) error
+}
+
+// Handler handles a message
+// the message is delivered again if the handler returns an error, up to a limit of the subscriber
+type Handler func(ctx context.Context, msg *Message) error
+
+// Subscriber consumes messages of a topic as a member of a consumer group
+// each message is delivered to one member of the group at least once, in publish order;
+// a subscriber may set aside a message that keeps failing, so that it does not block the others
+type Subscriber interface {
+	// Subscribe blocks and handles messages until ctx is done
+	Subscribe(ctx context.Context, topic, group string, handler Handler) error
+}
@@ -0,0 +1,271 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	conf "github.com/minghsu0107/saga-account/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/redis/go-redis/v9"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	mr        *miniredis.Miniredis
+	client    redis.UniversalClient
+	testTopic = "test:saga_commands"
+	testGroup = "account"
+)
+
+func TestBroker(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "broker suite")
+}
+
+func newTestConfig() *conf.Config {
+	return &conf.Config{
+		RedisConfig: &conf.RedisConfig{
+			StreamMaxLen: 1000,
+		},
+		Logger: &conf.Logger{
+			Writer: ioutil.Discard,
+			ContextLogger: log.WithFields(log.Fields{
+				"app": "test",
+			}),
+		},
+	}
+}
+
+// recorder records handled message IDs and fails each message the configured number of times
+type recorder struct {
+	mu       sync.Mutex
+	handled  []string
+	failures map[string]int
+}
+
+func (r *recorder) handle(ctx context.Context, msg *Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failures[msg.ID] > 0 {
+		r.failures[msg.ID]--
+		return errors.New("transient failure")
+	}
+	r.handled = append(r.handled, msg.ID)
+	return nil
+}
+
+func (r *recorder) ids() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.handled...)
+}
+
+var _ = Describe("broker", func() {
+	var msgs []*Message
+	var rec *recorder
+	BeforeEach(func() {
+		msgs = []*Message{
+			{ID: "1", Key: "saga-1", Type: "customer.reserve", Payload: []byte(`{"saga_id":"saga-1"}`)},
+			{ID: "2", Key: "saga-2", Type: "customer.reserve", Payload: []byte(`{"saga_id":"saga-2"}`)},
+			{ID: "3", Key: "saga-1", Type: "customer.release", Payload: []byte(`{"saga_id":"saga-1"}`)},
+		}
+		rec = &recorder{
+			failures: make(map[string]int),
+		}
+	})
+	var _ = Describe("memory broker", func() {
+		It("should deliver messages in order and retry failed ones", func() {
+			mb := NewMemoryBroker()
+			rec.failures["2"] = 2
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				mb.Subscribe(ctx, testTopic, testGroup, rec.handle)
+			}()
+			Expect(mb.Publish(context.Background(), testTopic, msgs...)).To(BeNil())
+
+			Eventually(rec.ids, time.Second).Should(Equal([]string{"1", "2", "3"}))
+			cancel()
+			<-done
+			Expect(mb.Messages(testTopic)).To(Equal(msgs))
+		})
+	})
+	var _ = Describe("redis stream broker", func() {
+		// a fresh server per spec, since miniredis keeps stream groups across FLUSHALL
+		BeforeEach(func() {
+			var err error
+			mr, err = miniredis.Run()
+			Expect(err).To(BeNil())
+			client = redis.NewClient(&redis.Options{
+				Addr: mr.Addr(),
+			})
+		})
+		AfterEach(func() {
+			client.Close()
+			mr.Close()
+		})
+		It("should deliver published messages to the consumer group and acknowledge them", func() {
+			publisher := NewRedisStreamPublisher(newTestConfig(), client)
+			subscriber, err := NewRedisStreamSubscriber(newTestConfig(), client)
+			Expect(err).To(BeNil())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				subscriber.Subscribe(ctx, testTopic, testGroup, rec.handle)
+			}()
+			// miniredis truncates an existing stream on MKSTREAM, so publish once the group is created
+			Eventually(func() bool { return mr.Exists(testTopic) }, time.Second).Should(BeTrue())
+			Expect(publisher.Publish(context.Background(), testTopic, msgs...)).To(BeNil())
+			Eventually(rec.ids, time.Second).Should(Equal([]string{"1", "2", "3"}))
+			cancel()
+			<-done
+
+			// acknowledged messages are no longer pending
+			pending, err := client.XPending(context.Background(), testTopic, testGroup).Result()
+			Expect(err).To(BeNil())
+			Expect(pending.Count).To(Equal(int64(0)))
+		})
+		It("should wait for redis to create the consumer group", func() {
+			// redis is not listening yet on the address of the client
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).To(BeNil())
+			addr := listener.Addr().String()
+			listener.Close()
+			downClient := redis.NewClient(&redis.Options{
+				Addr: addr,
+			})
+			defer downClient.Close()
+			publisher := NewRedisStreamPublisher(newTestConfig(), downClient)
+			subscriber, err := NewRedisStreamSubscriber(newTestConfig(), downClient)
+			Expect(err).To(BeNil())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				Expect(subscriber.Subscribe(ctx, testTopic, testGroup, rec.handle)).To(BeNil())
+			}()
+			Consistently(done, 1500*time.Millisecond).ShouldNot(BeClosed())
+
+			downServer := miniredis.NewMiniRedis()
+			Expect(downServer.StartAddr(addr)).To(BeNil())
+			defer downServer.Close()
+			Eventually(func() bool { return downServer.Exists(testTopic) }, 3*time.Second).Should(BeTrue())
+			Expect(publisher.Publish(context.Background(), testTopic, msgs...)).To(BeNil())
+			Eventually(rec.ids, time.Second).Should(Equal([]string{"1", "2", "3"}))
+			cancel()
+			<-done
+		})
+		It("should deliver the request id of a message", func() {
+			publisher := NewRedisStreamPublisher(newTestConfig(), client)
+			subscriber, err := NewRedisStreamSubscriber(newTestConfig(), client)
+			Expect(err).To(BeNil())
+
+			received := make(chan *Message, 2)
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				subscriber.Subscribe(ctx, testTopic, testGroup, func(ctx context.Context, msg *Message) error {
+					received <- msg
+					return nil
+				})
+			}()
+			Eventually(func() bool { return mr.Exists(testTopic) }, time.Second).Should(BeTrue())
+			msgs[0].RequestID = "req-1"
+			Expect(publisher.Publish(context.Background(), testTopic, msgs[:2]...)).To(BeNil())
+			Expect((<-received).RequestID).To(Equal("req-1"))
+			Expect((<-received).RequestID).To(BeEmpty())
+			cancel()
+			<-done
+		})
+		It("should keep a failed message pending and retry it before newer ones", func() {
+			publisher := NewRedisStreamPublisher(newTestConfig(), client)
+			subscriber, err := NewRedisStreamSubscriber(newTestConfig(), client)
+			Expect(err).To(BeNil())
+			rec.failures["2"] = 1
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				subscriber.Subscribe(ctx, testTopic, testGroup, rec.handle)
+			}()
+			Eventually(func() bool { return mr.Exists(testTopic) }, time.Second).Should(BeTrue())
+			Expect(publisher.Publish(context.Background(), testTopic, msgs...)).To(BeNil())
+			Eventually(rec.ids, 3*time.Second).Should(Equal([]string{"1", "2", "3"}))
+			cancel()
+			<-done
+		})
+		It("should move a message failing too many deliveries to the dead letter stream", func() {
+			config := newTestConfig()
+			config.RedisConfig.StreamMaxDeliveries = 2
+			publisher := NewRedisStreamPublisher(config, client)
+			subscriber, err := NewRedisStreamSubscriber(config, client)
+			Expect(err).To(BeNil())
+			rec.failures["2"] = 100
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				subscriber.Subscribe(ctx, testTopic, testGroup, rec.handle)
+			}()
+			Eventually(func() bool { return mr.Exists(testTopic) }, time.Second).Should(BeTrue())
+			Expect(publisher.Publish(context.Background(), testTopic, msgs...)).To(BeNil())
+			Eventually(rec.ids, 5*time.Second).Should(Equal([]string{"1", "3"}))
+			cancel()
+			<-done
+
+			dead, err := client.XRange(context.Background(), testTopic+deadLetterSuffix, "-", "+").Result()
+			Expect(err).To(BeNil())
+			Expect(len(dead)).To(Equal(1))
+			Expect(newMessage(&dead[0])).To(Equal(msgs[1]))
+			Expect(dead[0].Values[fieldError]).To(Equal("transient failure"))
+			pending, err := client.XPending(context.Background(), testTopic, testGroup).Result()
+			Expect(err).To(BeNil())
+			Expect(pending.Count).To(Equal(int64(0)))
+		})
+		It("should claim messages left pending by another consumer once idle", func() {
+			publisher := NewRedisStreamPublisher(newTestConfig(), client)
+			subscriber, err := NewRedisStreamSubscriber(newTestConfig(), client)
+			Expect(err).To(BeNil())
+
+			// a terminated instance received the messages without acknowledging them
+			Expect(client.XGroupCreateMkStream(context.Background(), testTopic, testGroup, "0").Err()).To(BeNil())
+			Expect(publisher.Publish(context.Background(), testTopic, msgs...)).To(BeNil())
+			_, err = client.XReadGroup(context.Background(), &redis.XReadGroupArgs{
+				Group:    testGroup,
+				Consumer: "terminated",
+				Streams:  []string{testTopic, ">"},
+				Block:    -1,
+			}).Result()
+			Expect(err).To(BeNil())
+			mr.SetTime(time.Now().Add(defaultClaimMinIdle))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				subscriber.Subscribe(ctx, testTopic, testGroup, rec.handle)
+			}()
+			Eventually(rec.ids, time.Second).Should(Equal([]string{"1", "2", "3"}))
+			cancel()
+			<-done
+
+			pending, err := client.XPending(context.Background(), testTopic, testGroup).Result()
+			Expect(err).To(BeNil())
+			Expect(pending.Count).To(Equal(int64(0)))
+		})
+	})
+})
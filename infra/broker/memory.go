@@ -0,0 +1,77 @@
+package broker
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryBroker is an in-process broker for tests
+// topics are kept in memory and each consumer group keeps its own offset
+type MemoryBroker struct {
+	mu      sync.Mutex
+	topics  map[string][]*Message
+	offsets map[string]int
+	// published is closed and replaced on every publish to wake up subscribers
+	published chan struct{}
+}
+
+// NewMemoryBroker is the factory of MemoryBroker
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		topics:    make(map[string][]*Message),
+		offsets:   make(map[string]int),
+		published: make(chan struct{}),
+	}
+}
+
+// Publish appends messages to a topic
+func (b *MemoryBroker) Publish(ctx context.Context, topic string, msgs ...*Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.topics[topic] = append(b.topics[topic], msgs...)
+	close(b.published)
+	b.published = make(chan struct{})
+	return nil
+}
+
+// Messages returns all messages published to a topic
+func (b *MemoryBroker) Messages(topic string) []*Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]*Message(nil), b.topics[topic]...)
+}
+
+// Subscribe handles messages of a topic from the group offset until ctx is done
+// a message failing to be handled is retried before the next one is delivered
+func (b *MemoryBroker) Subscribe(ctx context.Context, topic, group string, handler Handler) error {
+	groupKey := topic + "/" + group
+	for {
+		b.mu.Lock()
+		offset := b.offsets[groupKey]
+		msgs := b.topics[topic]
+		published := b.published
+		b.mu.Unlock()
+
+		if offset < len(msgs) {
+			if err := handler(ctx, msgs[offset]); err != nil {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(10 * time.Millisecond):
+				}
+				continue
+			}
+			b.mu.Lock()
+			b.offsets[groupKey] = offset + 1
+			b.mu.Unlock()
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-published:
+		}
+	}
+}
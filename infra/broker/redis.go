@@ -0,0 +1,300 @@
+package broker
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	conf "github.com/minghsu0107/saga-account/config"
+	"github.com/redis/go-redis/v9"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	fieldID        = "id"
+	fieldKey       = "key"
+	fieldType      = "type"
+	fieldRequestID = "request_id"
+	fieldPayload   = "payload"
+	fieldError     = "error"
+
+	deadLetterSuffix = ":dead"
+
+	subscribeBatchSize  = 10
+	subscribeBlock      = 2 * time.Second
+	subscribeRetryDelay = time.Second
+
+	defaultClaimMinIdle  = time.Minute
+	defaultMaxDeliveries = 5
+)
+
+// RedisStreamPublisher publishes messages to redis streams
+// each topic is a single stream, so messages of a topic are totally ordered
+type RedisStreamPublisher struct {
+	client redis.UniversalClient
+	maxLen int64
+}
+
+// NewRedisStreamPublisher is the factory of redis stream publisher
+func NewRedisStreamPublisher(config *conf.Config, client redis.UniversalClient) Publisher {
+	return &RedisStreamPublisher{
+		client: client,
+		maxLen: config.RedisConfig.StreamMaxLen,
+	}
+}
+
+// Publish appends messages to the stream named after the topic in a single pipeline
+// the stream is approximately trimmed to maxLen entries if maxLen is positive
+func (p *RedisStreamPublisher) Publish(ctx context.Context, topic string, msgs ...*Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	pipe := p.client.Pipeline()
+	cmds := make([]*redis.StringCmd, len(msgs))
+	for i, msg := range msgs {
+		values := []interface{}{
+			fieldID, msg.ID,
+			fieldKey, msg.Key,
+			fieldType, msg.Type,
+			fieldPayload, msg.Payload,
+		}
+		if msg.RequestID != "" {
+			values = append(values, fieldRequestID, msg.RequestID)
+		}
+		args := &redis.XAddArgs{
+			Stream: topic,
+			Values: values,
+		}
+		if p.maxLen > 0 {
+			args.MaxLen = p.maxLen
+			args.Approx = true
+		}
+		cmds[i] = pipe.XAdd(ctx, args)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	for _, cmd := range cmds {
+		if err := cmd.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RedisStreamSubscriber consumes redis streams with consumer groups
+type RedisStreamSubscriber struct {
+	client   redis.UniversalClient
+	consumer string
+	maxLen   int64
+	// messages pending on another consumer for claimMinIdle are claimed every claimMinIdle
+	claimMinIdle time.Duration
+	// a message failing maxDeliveries deliveries is moved to the dead letter stream
+	maxDeliveries int64
+	logger        *log.Entry
+}
+
+// NewRedisStreamSubscriber is the factory of redis stream subscriber
+// the host name identifies the consumer in its group, so that a restarted instance resumes its pending messages;
+// messages of an instance that does not come back are claimed by the others once idle
+func NewRedisStreamSubscriber(config *conf.Config, client redis.UniversalClient) (Subscriber, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	claimMinIdle := defaultClaimMinIdle
+	if config.RedisConfig.StreamClaimIdleSeconds > 0 {
+		claimMinIdle = time.Duration(config.RedisConfig.StreamClaimIdleSeconds) * time.Second
+	}
+	maxDeliveries := int64(defaultMaxDeliveries)
+	if config.RedisConfig.StreamMaxDeliveries > 0 {
+		maxDeliveries = config.RedisConfig.StreamMaxDeliveries
+	}
+	return &RedisStreamSubscriber{
+		client:        client,
+		consumer:      hostname,
+		maxLen:        config.RedisConfig.StreamMaxLen,
+		claimMinIdle:  claimMinIdle,
+		maxDeliveries: maxDeliveries,
+		logger:        config.Logger.ContextLogger.WithField("type", "broker:RedisStreamSubscriber"),
+	}, nil
+}
+
+// Subscribe reads the stream named after the topic as a member of the consumer group, creating the group if needed
+// it retries the creation of the group until redis is reachable, instead of failing the caller
+// messages are acknowledged once handled; a failed message stays pending and is retried before newer ones,
+// until it has been delivered maxDeliveries times and is moved to the dead letter stream of the topic.
+// messages idle for claimMinIdle on other consumers of the group are periodically claimed and handled as pending ones
+func (s *RedisStreamSubscriber) Subscribe(ctx context.Context, topic, group string, handler Handler) error {
+	// redis may be down at startup, so the group is created once it is reachable
+	for {
+		err := s.client.XGroupCreateMkStream(ctx, topic, group, "0").Err()
+		if err == nil || strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			break
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		s.logger.Error(err.Error())
+		sleep(ctx, subscribeRetryDelay)
+	}
+
+	// start from pending messages left by a previous run
+	pending := true
+	var nextClaim time.Time
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if !time.Now().Before(nextClaim) {
+			claimed, err := s.claim(ctx, topic, group)
+			if err != nil && ctx.Err() == nil {
+				s.logger.Error(err.Error())
+			}
+			if claimed > 0 {
+				s.logger.Infof("claimed %d idle messages", claimed)
+				pending = true
+			}
+			nextClaim = time.Now().Add(s.claimMinIdle)
+		}
+		id := ">"
+		if pending {
+			id = "0"
+		}
+		streams, err := s.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    group,
+			Consumer: s.consumer,
+			Streams:  []string{topic, id},
+			Count:    subscribeBatchSize,
+			Block:    subscribeBlock,
+		}).Result()
+		if err == redis.Nil {
+			pending = false
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			s.logger.Error(err.Error())
+			sleep(ctx, subscribeRetryDelay)
+			continue
+		}
+
+		var msgs []redis.XMessage
+		if len(streams) > 0 {
+			msgs = streams[0].Messages
+		}
+		if pending && len(msgs) == 0 {
+			pending = false
+			continue
+		}
+		for _, msg := range msgs {
+			if err := handler(ctx, newMessage(&msg)); err != nil {
+				s.logger.WithField("message_id", msg.ID).Error(err.Error())
+				dead, deadErr := s.deadLetter(ctx, topic, group, &msg, err)
+				if deadErr != nil {
+					s.logger.Error(deadErr.Error())
+				}
+				if dead {
+					continue
+				}
+				pending = true
+				sleep(ctx, subscribeRetryDelay)
+				break
+			}
+			if err := s.client.XAck(ctx, topic, group, msg.ID).Err(); err != nil {
+				s.logger.Error(err.Error())
+				pending = true
+				break
+			}
+		}
+	}
+}
+
+// claim takes over the messages pending on other consumers of the group for at least claimMinIdle
+// it returns the number of claimed messages, which are delivered by reading the pending messages of this consumer
+func (s *RedisStreamSubscriber) claim(ctx context.Context, topic, group string) (int, error) {
+	claimed := 0
+	start := "0-0"
+	for {
+		ids, next, err := s.client.XAutoClaimJustID(ctx, &redis.XAutoClaimArgs{
+			Stream:   topic,
+			Group:    group,
+			Consumer: s.consumer,
+			MinIdle:  s.claimMinIdle,
+			Start:    start,
+			Count:    subscribeBatchSize,
+		}).Result()
+		if err != nil {
+			return claimed, err
+		}
+		claimed += len(ids)
+		if next == "0-0" || next == "" {
+			return claimed, nil
+		}
+		start = next
+	}
+}
+
+// deadLetter moves a failed message to the dead letter stream of the topic if it has been delivered maxDeliveries times
+// the message is appended before it is acknowledged, so it may be dead-lettered twice but is never lost
+func (s *RedisStreamSubscriber) deadLetter(ctx context.Context, topic, group string, msg *redis.XMessage, handleErr error) (bool, error) {
+	entries, err := s.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: topic,
+		Group:  group,
+		Start:  msg.ID,
+		End:    msg.ID,
+		Count:  1,
+	}).Result()
+	if err != nil {
+		return false, err
+	}
+	if len(entries) == 0 || entries[0].RetryCount < s.maxDeliveries {
+		return false, nil
+	}
+
+	values := make([]interface{}, 0, 2*len(msg.Values)+2)
+	for field, val := range msg.Values {
+		values = append(values, field, val)
+	}
+	values = append(values, fieldError, handleErr.Error())
+	args := &redis.XAddArgs{
+		Stream: topic + deadLetterSuffix,
+		Values: values,
+	}
+	if s.maxLen > 0 {
+		args.MaxLen = s.maxLen
+		args.Approx = true
+	}
+	if err := s.client.XAdd(ctx, args).Err(); err != nil {
+		return false, err
+	}
+	if err := s.client.XAck(ctx, topic, group, msg.ID).Err(); err != nil {
+		return false, err
+	}
+	s.logger.WithField("message_id", msg.ID).Errorf("moved to %s after %d deliveries", args.Stream, entries[0].RetryCount)
+	return true, nil
+}
+
+func newMessage(msg *redis.XMessage) *Message {
+	field := func(name string) string {
+		val, _ := msg.Values[name].(string)
+		return val
+	}
+	return &Message{
+		ID:        field(fieldID),
+		Key:       field(fieldKey),
+		Type:      field(fieldType),
+		RequestID: field(fieldRequestID),
+		Payload:   []byte(field(fieldPayload)),
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
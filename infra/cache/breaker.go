@@ -0,0 +1,381 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redsync/redsync/v4"
+	conf "github.com/minghsu0107/saga-account/config"
+	"github.com/minghsu0107/saga-account/pkg/breaker"
+	"github.com/minghsu0107/saga-account/pkg/health"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxPendingInvalidations bounds the keys queued while redis is unavailable
+	maxPendingInvalidations = 10000
+	replayBatchSize         = 100
+	replayTimeout           = 5 * time.Second
+	defaultBreakerOpen      = 10 * time.Second
+)
+
+var (
+	pendingInvalidations = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "account_redis_pending_invalidations",
+		Help: "The number of invalidated keys queued while redis is unavailable.",
+	})
+	droppedInvalidations = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "account_redis_dropped_invalidations_total",
+		Help: "The number of invalidated keys dropped because the queue of pending invalidations is full.",
+	})
+)
+
+// BreakerRedisCache is a RedisCache that bypasses redis while it is unavailable
+// a circuit breaker opens after consecutive connection failures. while it is open, reads miss so that
+// they fall through to the repository, writes are skipped and locks are granted at once, leaving
+// request coalescing to the singleflight of the caller. invalidations are applied to the local cache
+// and queued, and the queue is replayed to redis once a call succeeds again
+type BreakerRedisCache struct {
+	RedisCache
+	lc      LocalCache
+	breaker *breaker.Breaker
+	logger  *log.Entry
+
+	mu        sync.Mutex
+	pending   map[string]struct{}
+	nPending  int64
+	replaying int32
+}
+
+// NewBreakerRedisCache is the factory of the redis cache guarded by a circuit breaker
+// it returns the redis cache itself if RedisConfig.BreakerFailureThreshold is not positive,
+// and registers the state of the breaker to registry otherwise
+func NewBreakerRedisCache(config *conf.Config, client redis.UniversalClient, lc LocalCache, registry *health.Registry) (RedisCache, error) {
+	rc, err := NewRedisCache(config, client)
+	if err != nil {
+		return nil, err
+	}
+	if config.RedisConfig.BreakerFailureThreshold <= 0 {
+		return rc, nil
+	}
+	b := newBreakerRedisCache(config, rc, lc)
+	registry.Register(b)
+	return b, nil
+}
+
+func newBreakerRedisCache(config *conf.Config, rc RedisCache, lc LocalCache) *BreakerRedisCache {
+	openTimeout := defaultBreakerOpen
+	if config.RedisConfig.BreakerOpenSeconds > 0 {
+		openTimeout = time.Duration(config.RedisConfig.BreakerOpenSeconds) * time.Second
+	}
+	b := &BreakerRedisCache{
+		RedisCache: rc,
+		lc:         lc,
+		logger:     config.Logger.ContextLogger.WithField("type", "cache:BreakerRedisCache"),
+		pending:    make(map[string]struct{}),
+	}
+	b.breaker = breaker.New(breaker.Options{
+		Name:             "redis",
+		FailureThreshold: config.RedisConfig.BreakerFailureThreshold,
+		OpenTimeout:      openTimeout,
+		OnStateChange: func(from, to breaker.State) {
+			b.logger.Warnf("redis circuit breaker %s -> %s", from, to)
+		},
+	})
+	return b
+}
+
+// Get returns true if the key already exists and set dst to the corresponding value
+func (b *BreakerRedisCache) Get(ctx context.Context, key string, dst interface{}) (bool, error) {
+	if b.breaker.Allow() != nil {
+		return false, nil
+	}
+	ok, err := b.RedisCache.Get(ctx, key, dst)
+	if b.record(err) {
+		return false, nil
+	}
+	return ok, err
+}
+
+// GetRaw returns true if the key already exists, together with its encoded value and remaining time to live
+func (b *BreakerRedisCache) GetRaw(ctx context.Context, key string) (bool, []byte, time.Duration, error) {
+	if b.breaker.Allow() != nil {
+		return false, nil, 0, nil
+	}
+	ok, val, ttl, err := b.RedisCache.GetRaw(ctx, key)
+	if b.record(err) {
+		return false, nil, 0, nil
+	}
+	return ok, val, ttl, err
+}
+
+// Set sets a key-value pair that expires after the redis expiration of its key family
+func (b *BreakerRedisCache) Set(ctx context.Context, key string, val interface{}) error {
+	return b.do(func() error {
+		return b.RedisCache.Set(ctx, key, val)
+	})
+}
+
+// SetWithExpiration sets a key-value pair that expires after the given duration
+func (b *BreakerRedisCache) SetWithExpiration(ctx context.Context, key string, val interface{}, expiration time.Duration) error {
+	return b.do(func() error {
+		return b.RedisCache.SetWithExpiration(ctx, key, val, expiration)
+	})
+}
+
+// SetRaw sets an encoded value that expires after the given duration
+func (b *BreakerRedisCache) SetRaw(ctx context.Context, key string, val []byte, expiration time.Duration) error {
+	return b.do(func() error {
+		return b.RedisCache.SetRaw(ctx, key, val, expiration)
+	})
+}
+
+// SetRawIfVersion sets an encoded value unless the key has been invalidated since its version was read
+// the value is discarded while redis is unavailable
+func (b *BreakerRedisCache) SetRawIfVersion(ctx context.Context, key string, val []byte, expiration time.Duration, version int64) (bool, error) {
+	if b.breaker.Allow() != nil {
+		return false, nil
+	}
+	set, err := b.RedisCache.SetRawIfVersion(ctx, key, val, expiration, version)
+	if b.record(err) {
+		return false, nil
+	}
+	return set, err
+}
+
+// Version returns the number of times a key has been invalidated recently, or 0 while redis is unavailable
+func (b *BreakerRedisCache) Version(ctx context.Context, key string) (int64, error) {
+	if b.breaker.Allow() != nil {
+		return 0, nil
+	}
+	version, err := b.RedisCache.Version(ctx, key)
+	if b.record(err) {
+		return 0, nil
+	}
+	return version, err
+}
+
+// Delete deletes a key, or queues its invalidation while redis is unavailable
+func (b *BreakerRedisCache) Delete(ctx context.Context, key string) error {
+	if b.breaker.Allow() != nil {
+		b.enqueue(key)
+		return nil
+	}
+	err := b.RedisCache.Delete(ctx, key)
+	if b.record(err) {
+		b.enqueue(key)
+		return nil
+	}
+	return err
+}
+
+// Invalidate invalidates keys, or invalidates their local entries and queues them while redis is unavailable
+func (b *BreakerRedisCache) Invalidate(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if b.breaker.Allow() != nil {
+		b.enqueue(keys...)
+		return nil
+	}
+	err := b.RedisCache.Invalidate(ctx, keys...)
+	if b.record(err) {
+		b.enqueue(keys...)
+		return nil
+	}
+	return err
+}
+
+// Lock acquires the redis mutex of name, or grants it at once while redis is unavailable
+func (b *BreakerRedisCache) Lock(ctx context.Context, name string) (func(), error) {
+	if b.breaker.Allow() != nil {
+		return func() {}, nil
+	}
+	unlock, err := b.RedisCache.Lock(ctx, name)
+	if b.record(err) {
+		return func() {}, nil
+	}
+	return unlock, err
+}
+
+// ExecPipeLine execute the given commands in a pipline
+// while redis is unavailable, SET commands are skipped and DELETE commands are queued
+func (b *BreakerRedisCache) ExecPipeLine(ctx context.Context, cmds *[]RedisCmd) error {
+	if b.breaker.Allow() != nil {
+		return b.enqueuePipeline(cmds)
+	}
+	err := b.RedisCache.ExecPipeLine(ctx, cmds)
+	if b.record(err) {
+		return b.enqueuePipeline(cmds)
+	}
+	return err
+}
+
+// Health implements health.Checker interface
+// the service is still ready while redis is unavailable, serving reads from the repository
+func (b *BreakerRedisCache) Health(ctx context.Context) health.Status {
+	state := b.breaker.State()
+	return health.Status{
+		Name:     "redis",
+		State:    state.String(),
+		Ready:    true,
+		Degraded: state != breaker.Closed,
+	}
+}
+
+// do runs a write that is skipped while redis is unavailable
+func (b *BreakerRedisCache) do(fn func() error) error {
+	if b.breaker.Allow() != nil {
+		return nil
+	}
+	err := fn()
+	if b.record(err) {
+		return nil
+	}
+	return err
+}
+
+// record records the result of an allowed call and returns true if redis is unavailable
+// after a success, invalidations queued before are replayed
+func (b *BreakerRedisCache) record(err error) bool {
+	if unavailable(err) {
+		b.breaker.Record(false)
+		b.logger.Error(err.Error())
+		return true
+	}
+	b.breaker.Record(true)
+	if atomic.LoadInt64(&b.nPending) > 0 && atomic.CompareAndSwapInt32(&b.replaying, 0, 1) {
+		go b.replay()
+	}
+	return false
+}
+
+// enqueue invalidates the local entries of keys and queues the keys for replay
+func (b *BreakerRedisCache) enqueue(keys ...string) {
+	for _, key := range keys {
+		if err := b.lc.Invalidate(key); err != nil {
+			b.logger.WithField("key", key).Error(err.Error())
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, key := range keys {
+		if _, ok := b.pending[key]; ok {
+			continue
+		}
+		if len(b.pending) >= maxPendingInvalidations {
+			droppedInvalidations.Inc()
+			b.logger.WithField("key", key).Error("pending invalidation dropped")
+			continue
+		}
+		b.pending[key] = struct{}{}
+	}
+	b.setPending()
+}
+
+func (b *BreakerRedisCache) enqueuePipeline(cmds *[]RedisCmd) error {
+	for _, cmd := range *cmds {
+		switch cmd.OpType {
+		case SET:
+		case DELETE:
+			b.enqueue(cmd.Payload.(RedisDeletePayload).Key)
+		default:
+			return ErrRedisPipelineCmdNotFound
+		}
+	}
+	return nil
+}
+
+// replay invalidates the queued keys in batches; keys of a failed batch are queued again
+func (b *BreakerRedisCache) replay() {
+	defer atomic.StoreInt32(&b.replaying, 0)
+	b.mu.Lock()
+	keys := make([]string, 0, len(b.pending))
+	for key := range b.pending {
+		keys = append(keys, key)
+	}
+	b.pending = make(map[string]struct{})
+	b.setPending()
+	b.mu.Unlock()
+
+	for len(keys) > 0 {
+		n := replayBatchSize
+		if n > len(keys) {
+			n = len(keys)
+		}
+		if err := b.replayBatch(keys[:n]); err != nil {
+			b.logger.Error(err.Error())
+			b.requeue(keys)
+			return
+		}
+		keys = keys[n:]
+	}
+	b.logger.Info("pending invalidations replayed")
+}
+
+func (b *BreakerRedisCache) replayBatch(keys []string) error {
+	if err := b.breaker.Allow(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), replayTimeout)
+	defer cancel()
+	err := b.RedisCache.Invalidate(ctx, keys...)
+	b.breaker.Record(!unavailable(err))
+	return err
+}
+
+// requeue queues keys again without touching the local cache, whose entries were invalidated when they were queued
+func (b *BreakerRedisCache) requeue(keys []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, key := range keys {
+		if len(b.pending) >= maxPendingInvalidations {
+			droppedInvalidations.Inc()
+			continue
+		}
+		b.pending[key] = struct{}{}
+	}
+	b.setPending()
+}
+
+func (b *BreakerRedisCache) setPending() {
+	atomic.StoreInt64(&b.nPending, int64(len(b.pending)))
+	pendingInvalidations.Set(float64(len(b.pending)))
+}
+
+// unavailable reports whether an error means that redis cannot be reached,
+// as opposed to a miss, a rejected command or a canceled request
+func unavailable(err error) bool {
+	if err == nil || err == redis.Nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	// redsync wraps the errors of each node
+	var nodeErr *redsync.RedisError
+	if errors.As(err, &nodeErr) {
+		err = nodeErr.Err
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, redis.ErrClosed) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	msg := err.Error()
+	if msg == "redis: connection pool timeout" {
+		return true
+	}
+	for _, prefix := range []string{"LOADING ", "CLUSTERDOWN ", "MASTERDOWN ", "TRYAGAIN "} {
+		if strings.HasPrefix(msg, prefix) {
+			return true
+		}
+	}
+	return false
+}
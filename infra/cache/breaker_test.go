@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redsync/redsync/v4"
+	"github.com/hashicorp/go-multierror"
+	"github.com/minghsu0107/saga-account/config"
+	"github.com/minghsu0107/saga-account/pkg/health"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/redis/go-redis/v9"
+	log "github.com/sirupsen/logrus"
+)
+
+var _ = Describe("redis circuit breaker", func() {
+	conf := &config.Config{
+		LocalCacheConfig: &config.LocalCacheConfig{
+			ExpirationSeconds: 60,
+		},
+		RedisConfig: &config.RedisConfig{
+			ExpirationSeconds:       60,
+			BreakerFailureThreshold: 2,
+			BreakerOpenSeconds:      1,
+		},
+		Logger: &config.Logger{
+			Writer: ioutil.Discard,
+			ContextLogger: log.WithFields(log.Fields{
+				"app": "test",
+			}),
+		},
+	}
+	var (
+		server *miniredis.Miniredis
+		c      redis.UniversalClient
+		lc     LocalCache
+		rc     *BreakerRedisCache
+	)
+	BeforeEach(func() {
+		var err error
+		server, err = miniredis.Run()
+		Expect(err).To(BeNil())
+		c = redis.NewClient(&redis.Options{
+			Addr:       server.Addr(),
+			MaxRetries: -1,
+		})
+		lc, err = NewLocalCache(conf)
+		Expect(err).To(BeNil())
+		cache, err := NewBreakerRedisCache(conf, c, lc, nil)
+		Expect(err).To(BeNil())
+		rc = cache.(*BreakerRedisCache)
+	})
+	AfterEach(func() {
+		c.Close()
+		server.Close()
+	})
+	// trip fails calls until the breaker opens
+	trip := func() {
+		for i := 0; i < 2; i++ {
+			ok, _, _, err := rc.GetRaw(context.Background(), "breakertest:probe")
+			Expect(ok).To(BeFalse())
+			Expect(err).To(BeNil())
+		}
+		Expect(rc.Health(context.Background()).Degraded).To(BeTrue())
+	}
+	It("should bypass redis while it is unavailable", func() {
+		ctx := context.Background()
+		server.Close()
+		trip()
+		Expect(rc.Health(context.Background())).To(Equal(health.Status{
+			Name:     "redis",
+			State:    "open",
+			Ready:    true,
+			Degraded: true,
+		}))
+
+		unlock, err := rc.Lock(ctx, "mutex:breakertest:1")
+		Expect(err).To(BeNil())
+		unlock()
+		version, err := rc.Version(ctx, "breakertest:1")
+		Expect(err).To(BeNil())
+		Expect(version).To(Equal(int64(0)))
+		set, err := rc.SetRawIfVersion(ctx, "breakertest:1", []byte("val"), time.Minute, version)
+		Expect(err).To(BeNil())
+		Expect(set).To(BeFalse())
+		Expect(rc.Set(ctx, "breakertest:1", "val")).To(BeNil())
+	})
+	It("should queue invalidations and replay them once redis recovers", func() {
+		ctx := context.Background()
+		Expect(rc.SetRaw(ctx, "breakertest:1", []byte("val"), time.Minute)).To(BeNil())
+		Expect(lc.SetRaw("breakertest:1", []byte("val"), time.Now().UnixNano())).To(BeNil())
+		server.Close()
+		trip()
+
+		Expect(rc.Invalidate(ctx, "breakertest:1")).To(BeNil())
+		Expect(rc.Delete(ctx, "breakertest:2")).To(BeNil())
+		ok, _, err := lc.GetRaw("breakertest:1")
+		Expect(ok).To(BeFalse())
+		Expect(err).To(BeNil())
+		Expect(rc.nPending).To(Equal(int64(2)))
+
+		Expect(server.Restart()).To(BeNil())
+		Eventually(func() bool {
+			rc.GetRaw(ctx, "breakertest:probe")
+			return server.Exists("breakertest:1")
+		}, 3*time.Second, 100*time.Millisecond).Should(BeFalse())
+		Expect(rc.Health(context.Background()).Degraded).To(BeFalse())
+		Eventually(func() int64 {
+			rc.mu.Lock()
+			defer rc.mu.Unlock()
+			return int64(len(rc.pending))
+		}, time.Second).Should(Equal(int64(0)))
+		entries, err := c.XRange(ctx, config.InvalidationStream, "-", "+").Result()
+		Expect(err).To(BeNil())
+		Expect(entries).To(HaveLen(1))
+	})
+	It("should not count misses and rejected commands as failures", func() {
+		Expect(unavailable(nil)).To(BeFalse())
+		Expect(unavailable(redis.Nil)).To(BeFalse())
+		Expect(unavailable(context.Canceled)).To(BeFalse())
+		Expect(unavailable(redsync.ErrFailed)).To(BeFalse())
+		Expect(unavailable(errors.New("WRONGTYPE Operation against a key holding the wrong kind of value"))).To(BeFalse())
+
+		Expect(unavailable(io.EOF)).To(BeTrue())
+		Expect(unavailable(redis.ErrClosed)).To(BeTrue())
+		Expect(unavailable(errors.New("LOADING Redis is loading the dataset in memory"))).To(BeTrue())
+		Expect(unavailable(multierror.Append(nil, &redsync.RedisError{Err: io.EOF}))).To(BeTrue())
+	})
+})
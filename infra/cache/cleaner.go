@@ -3,75 +3,299 @@ package cache
 import (
 	"context"
 	"encoding/json"
-	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	retry "github.com/avast/retry-go"
-	"github.com/minghsu0107/saga-account/config"
-	"github.com/minghsu0107/saga-account/pkg/workerpool"
+	conf "github.com/minghsu0107/saga-account/config"
+	"github.com/minghsu0107/saga-account/pkg/health"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/redis/go-redis/v9"
+	log "github.com/sirupsen/logrus"
 )
 
-// LocalCacheCleaner subscribes to InvalidationTopic and invalidates entries
+const (
+	cleanerBatchSize  = 100
+	cleanerBlock      = 5 * time.Second
+	cleanerRetryDelay = time.Second
+
+	// defaultReplayWindow is how long a disconnected cleaner may replay missed invalidations instead of flushing
+	defaultReplayWindow = time.Minute
+	// streamStart is the id before every stream entry
+	streamStart = "0-0"
+	// maxObservedBacklog bounds the invalidations counted for the backlog gauge
+	maxObservedBacklog = 10 * cleanerBatchSize
+)
+
+var invalidations = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "account_local_cache_invalidations_total",
+	Help: "The number of local cache keys invalidated by a cache cleaner by result.",
+}, []string{"cleaner", "result"})
+
+var invalidationBacklog = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "account_local_cache_invalidation_backlog",
+	Help: "The number of invalidations in the stream not yet applied by the local cache cleaner.",
+})
+
+// countInvalidation counts a key invalidated by cleaner
+func countInvalidation(cleaner string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	invalidations.WithLabelValues(cleaner, result).Inc()
+}
+
+// LocalCacheCleaner receives cache invalidations from redis and invalidates local entries
 type LocalCacheCleaner interface {
 	SubscribeInvalidationEvent() error
 	Close()
 }
 
 // LocalCacheCleanerImpl implements CacheCleaner interface
+// it tracks the id of the last stream entry it read, so that invalidations published while it was
+// disconnected are replayed; the whole local cache is flushed if they may have been trimmed
+// or if it was disconnected for longer than the replay window
 type LocalCacheCleanerImpl struct {
-	client redis.UniversalClient
-	lc     LocalCache
-	pool   *workerpool.Pool
+	client       redis.UniversalClient
+	lc           LocalCache
+	lastID       string
+	replayWindow time.Duration
+	// startDisconnected is true if redis was unavailable when the cleaner was created
+	startDisconnected bool
+	ctx               context.Context
+	cancel            context.CancelFunc
+	done              chan struct{}
+	logger            *log.Entry
+
+	// subscribed is 1 while invalidations are read from the stream
+	subscribed int32
 }
 
-func NewLocalCacheCleaner(client redis.UniversalClient, lc LocalCache) LocalCacheCleaner {
-	return &LocalCacheCleanerImpl{
-		client: client,
-		lc:     lc,
+// NewLocalCacheCleaner is the factory of local cache cleaner
+// it returns a TrackingCleaner for a TrackingLocalCache. otherwise it starts reading InvalidationStream
+// after the newest invalidation, since the local cache is empty on start. the state of its subscription
+// is registered to registry
+func NewLocalCacheCleaner(config *conf.Config, client redis.UniversalClient, lc LocalCache, registry *health.Registry) (LocalCacheCleaner, error) {
+	if lc, ok := lc.(*TrackingLocalCache); ok {
+		t, err := NewTrackingCleaner(config, client, lc)
+		if err != nil {
+			return nil, err
+		}
+		registry.Register(t)
+		return t, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &LocalCacheCleanerImpl{
+		client:       client,
+		lc:           lc,
+		replayWindow: defaultReplayWindow,
+		ctx:          ctx,
+		cancel:       cancel,
+		done:         make(chan struct{}),
+		logger:       config.Logger.ContextLogger.WithField("type", "cache:LocalCacheCleaner"),
 	}
+	if config.LocalCacheConfig.InvalidationReplaySeconds > 0 {
+		l.replayWindow = time.Duration(config.LocalCacheConfig.InvalidationReplaySeconds) * time.Second
+	}
+	registry.Register(l)
+	lastID, err := l.newestID(ctx)
+	if err != nil {
+		// redis is unavailable; the cleaner starts as disconnected, so it catches up once redis is reachable
+		l.logger.Warn("invalidations unavailable on start: " + err.Error())
+		l.lastID = streamStart
+		l.startDisconnected = true
+		return l, nil
+	}
+	l.lastID = lastID
+	return l, nil
 }
 
-// SubscribeInvalidationEvent starts a consumer that subscribe to cache invalidation event from redis
+// SubscribeInvalidationEvent reads cache invalidations from redis until closed
 func (l *LocalCacheCleanerImpl) SubscribeInvalidationEvent() error {
-	ctx := context.Background()
-	pubsub := l.client.Subscribe(ctx, config.InvalidationTopic)
-	_, err := pubsub.Receive(ctx)
-	if err != nil {
-		return err
+	defer close(l.done)
+	lastRead := time.Now()
+	disconnected := l.startDisconnected
+	for {
+		if l.ctx.Err() != nil {
+			return nil
+		}
+		if disconnected {
+			if err := l.recover(l.ctx, lastRead); err != nil {
+				l.logger.Error(err.Error())
+				sleep(l.ctx, cleanerRetryDelay)
+				continue
+			}
+			disconnected = false
+		}
+		atomic.StoreInt32(&l.subscribed, 1)
+		streams, err := l.client.XRead(l.ctx, &redis.XReadArgs{
+			Streams: []string{conf.InvalidationStream, l.lastID},
+			Count:   cleanerBatchSize,
+			Block:   cleanerBlock,
+		}).Result()
+		if err == redis.Nil {
+			lastRead = time.Now()
+			invalidationBacklog.Set(0)
+			continue
+		}
+		if err != nil {
+			if l.ctx.Err() != nil {
+				return nil
+			}
+			l.logger.Error(err.Error())
+			disconnected = true
+			atomic.StoreInt32(&l.subscribed, 0)
+			sleep(l.ctx, cleanerRetryDelay)
+			continue
+		}
+		lastRead = time.Now()
+		read := 0
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				l.invalidate(msg)
+				l.lastID = msg.ID
+			}
+			read += len(stream.Messages)
+		}
+		l.observeBacklog(l.ctx, read)
 	}
-	channel := pubsub.Channel()
-	l.pool = workerpool.NewPool(ctx, workerpool.Option{NumberWorker: runtime.NumCPU()})
-	l.pool.Start()
+}
+
+// Close stops reading invalidations
+func (l *LocalCacheCleanerImpl) Close() {
+	l.cancel()
+	<-l.done
+}
+
+// Health implements health.Checker interface
+// local entries may be stale while invalidations are not read, so the service is degraded but ready
+func (l *LocalCacheCleanerImpl) Health(ctx context.Context) health.Status {
+	return invalidationHealth(atomic.LoadInt32(&l.subscribed) == 1)
+}
 
-	for msg := range channel {
-		var keys []string
-		err := json.Unmarshal([]byte(msg.Payload), &keys)
+func invalidationHealth(subscribed bool) health.Status {
+	status := health.Status{
+		Name:  "cache-invalidation",
+		State: "subscribed",
+		Ready: true,
+	}
+	if !subscribed {
+		status.State = "disconnected"
+		status.Degraded = true
+	}
+	return status
+}
+
+// recover replays the invalidations missed while disconnected, or flushes the local cache if they may be lost
+func (l *LocalCacheCleanerImpl) recover(ctx context.Context, lastRead time.Time) error {
+	if time.Since(lastRead) <= l.replayWindow {
+		oldest, err := l.client.XRangeN(ctx, conf.InvalidationStream, "-", "+", 1).Result()
 		if err != nil {
-			continue
+			return err
 		}
-		for _, key := range keys {
-			task := l.cleanTask(ctx, key)
-			l.pool.Do(task)
+		if len(oldest) == 0 || compareStreamIDs(oldest[0].ID, l.lastID) <= 0 {
+			l.observeBacklog(ctx, cleanerBatchSize)
+			return nil
 		}
 	}
+	// skip the invalidations before the flush, which the flush covers
+	lastID, err := l.newestID(ctx)
+	if err != nil {
+		return err
+	}
+	if err := l.lc.Reset(); err != nil {
+		return err
+	}
+	l.lastID = lastID
+	invalidationBacklog.Set(0)
+	l.logger.Warn("local cache flushed after missing invalidations")
 	return nil
 }
 
-func (l *LocalCacheCleanerImpl) Close() {
-	l.pool.Stop()
+// observeBacklog records the number of invalidations after the last one read
+// a batch shorter than cleanerBatchSize read every invalidation, so the stream is only counted after a full batch
+func (l *LocalCacheCleanerImpl) observeBacklog(ctx context.Context, read int) {
+	if read < cleanerBatchSize {
+		invalidationBacklog.Set(0)
+		return
+	}
+	pending, err := l.client.XRangeN(ctx, conf.InvalidationStream, "("+l.lastID, "+", maxObservedBacklog).Result()
+	if err != nil {
+		l.logger.Error(err.Error())
+		return
+	}
+	invalidationBacklog.Set(float64(len(pending)))
 }
 
-// cleanTask closure
-func (l *LocalCacheCleanerImpl) cleanTask(ctx context.Context, key string) *workerpool.Task {
-	return workerpool.NewTask(ctx, func(ctx context.Context) (interface{}, error) {
-		return nil, retry.Do(
+// invalidate replaces the local entries of the keys in a stream entry with tombstones
+func (l *LocalCacheCleanerImpl) invalidate(msg redis.XMessage) {
+	payload, _ := msg.Values[InvalidationField].(string)
+	var keys []string
+	if err := json.Unmarshal([]byte(payload), &keys); err != nil {
+		l.logger.WithField("message_id", msg.ID).Error(err.Error())
+		return
+	}
+	for _, key := range keys {
+		err := retry.Do(
 			func() error {
-				return l.lc.Delete(key)
+				return l.lc.Invalidate(key)
 			},
 			retry.Attempts(3),
 			retry.DelayType(retry.RandomDelay),
 			retry.MaxJitter(10*time.Millisecond),
 		)
-	})
+		countInvalidation(StreamInvalidation, err)
+		if err != nil {
+			l.logger.WithField("key", key).Error(err.Error())
+		}
+	}
+}
+
+// newestID returns the id of the newest invalidation
+func (l *LocalCacheCleanerImpl) newestID(ctx context.Context) (string, error) {
+	newest, err := l.client.XRevRangeN(ctx, conf.InvalidationStream, "+", "-", 1).Result()
+	if err != nil {
+		return "", err
+	}
+	if len(newest) == 0 {
+		return streamStart, nil
+	}
+	return newest[0].ID, nil
+}
+
+// compareStreamIDs compares two stream ids of the form milliseconds-sequence
+func compareStreamIDs(a, b string) int {
+	aMillis, aSeq := parseStreamID(a)
+	bMillis, bSeq := parseStreamID(b)
+	switch {
+	case aMillis != bMillis:
+		if aMillis < bMillis {
+			return -1
+		}
+		return 1
+	case aSeq != bSeq:
+		if aSeq < bSeq {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+func parseStreamID(id string) (uint64, uint64) {
+	millis, seq, _ := strings.Cut(id, "-")
+	m, _ := strconv.ParseUint(millis, 10, 64)
+	s, _ := strconv.ParseUint(seq, 10, 64)
+	return m, s
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
 }
@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"context"
+	"io/ioutil"
+	"time"
+
+	"github.com/minghsu0107/saga-account/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
+)
+
+var _ = Describe("local cache cleaner", func() {
+	conf := &config.Config{
+		LocalCacheConfig: &config.LocalCacheConfig{
+			ExpirationSeconds:         60,
+			InvalidationReplaySeconds: 60,
+		},
+		RedisConfig: &config.RedisConfig{
+			ExpirationSeconds: 60,
+		},
+		Logger: &config.Logger{
+			Writer: ioutil.Discard,
+			ContextLogger: log.WithFields(log.Fields{
+				"app": "test",
+			}),
+		},
+	}
+	var (
+		lc      LocalCache
+		rc      RedisCache
+		cleaner *LocalCacheCleanerImpl
+	)
+	localHit := func(key string) bool {
+		ok, _, err := lc.GetRaw(key)
+		Expect(err).To(BeNil())
+		return ok
+	}
+	BeforeEach(func() {
+		var err error
+		lc, err = NewLocalCache(conf)
+		Expect(err).To(BeNil())
+		rc, err = NewRedisCache(conf, client)
+		Expect(err).To(BeNil())
+		// miniredis fails to read a missing stream instead of blocking
+		Expect(rc.Invalidate(context.Background(), "cleanertest:init")).To(BeNil())
+		l, err := NewLocalCacheCleaner(conf, client, lc, nil)
+		Expect(err).To(BeNil())
+		cleaner = l.(*LocalCacheCleanerImpl)
+	})
+	It("should invalidate local entries of invalidated keys", func() {
+		Expect(lc.Set("cleanertest:1", "val")).To(BeNil())
+		Expect(lc.Set("cleanertest:2", "val")).To(BeNil())
+		Expect(cleaner.Health(context.Background()).Degraded).To(BeTrue())
+		go cleaner.SubscribeInvalidationEvent()
+		defer cleaner.Close()
+		Eventually(func() string {
+			return cleaner.Health(context.Background()).State
+		}, time.Second).Should(Equal("subscribed"))
+
+		Expect(rc.Invalidate(context.Background(), "cleanertest:1")).To(BeNil())
+		Eventually(func() bool {
+			return localHit("cleanertest:1")
+		}, time.Second).Should(BeFalse())
+		Expect(localHit("cleanertest:2")).To(BeTrue())
+	})
+	It("should replay invalidations missed while disconnected", func() {
+		Expect(lc.Set("cleanertest:1", "val")).To(BeNil())
+		Expect(lc.Set("cleanertest:2", "val")).To(BeNil())
+		Expect(rc.Invalidate(context.Background(), "cleanertest:1")).To(BeNil())
+
+		Expect(cleaner.recover(context.Background(), time.Now())).To(BeNil())
+		Expect(localHit("cleanertest:2")).To(BeTrue())
+		Expect(testutil.ToFloat64(invalidationBacklog)).To(Equal(float64(1)))
+		go cleaner.SubscribeInvalidationEvent()
+		defer cleaner.Close()
+		Eventually(func() bool {
+			return localHit("cleanertest:1")
+		}, time.Second).Should(BeFalse())
+		Eventually(func() float64 {
+			return testutil.ToFloat64(invalidationBacklog)
+		}, time.Second).Should(Equal(float64(0)))
+	})
+	It("should flush the local cache after a long disconnection", func() {
+		Expect(lc.Set("cleanertest:1", "val")).To(BeNil())
+		Expect(cleaner.recover(context.Background(), time.Now().Add(-2*time.Minute))).To(BeNil())
+		Expect(localHit("cleanertest:1")).To(BeFalse())
+	})
+	It("should flush the local cache if missed invalidations were trimmed", func() {
+		Expect(lc.Set("cleanertest:1", "val")).To(BeNil())
+		Expect(rc.Invalidate(context.Background(), "cleanertest:2")).To(BeNil())
+		ids, err := client.XRange(context.Background(), config.InvalidationStream, "-", "+").Result()
+		Expect(err).To(BeNil())
+		// trim every entry up to the newest one
+		for _, msg := range ids[:len(ids)-1] {
+			Expect(client.XDel(context.Background(), config.InvalidationStream, msg.ID).Err()).To(BeNil())
+		}
+		cleaner.lastID = streamStart
+
+		Expect(cleaner.recover(context.Background(), time.Now())).To(BeNil())
+		Expect(localHit("cleanertest:1")).To(BeFalse())
+		Expect(cleaner.lastID).To(Equal(ids[len(ids)-1].ID))
+	})
+})
+
+var _ = Describe("redis cache versions", func() {
+	conf := &config.Config{
+		RedisConfig: &config.RedisConfig{
+			ExpirationSeconds: 60,
+		},
+	}
+	It("should not set values loaded before an invalidation", func() {
+		rc, err := NewRedisCache(conf, client)
+		Expect(err).To(BeNil())
+		ctx := context.Background()
+		version, err := rc.Version(ctx, "versiontest:1")
+		Expect(err).To(BeNil())
+		Expect(version).To(Equal(int64(0)))
+
+		Expect(rc.Invalidate(ctx, "versiontest:1")).To(BeNil())
+		set, err := rc.SetRawIfVersion(ctx, "versiontest:1", []byte("stale"), time.Minute, version)
+		Expect(err).To(BeNil())
+		Expect(set).To(BeFalse())
+		Expect(mr.Exists("versiontest:1")).To(BeFalse())
+
+		version, err = rc.Version(ctx, "versiontest:1")
+		Expect(err).To(BeNil())
+		Expect(version).To(Equal(int64(1)))
+		set, err = rc.SetRawIfVersion(ctx, "versiontest:1", []byte("val"), time.Minute, version)
+		Expect(err).To(BeNil())
+		Expect(set).To(BeTrue())
+		Expect(mr.Exists("versiontest:1")).To(BeTrue())
+	})
+})
@@ -0,0 +1,181 @@
+package cache
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"reflect"
+
+	"github.com/ugorji/go/codec"
+	"google.golang.org/protobuf/proto"
+)
+
+// codec ids written to envelopes; they never collide with the first byte of a legacy JSON entry
+const (
+	// JSONCodecID is the id of JSONCodec
+	JSONCodecID byte = iota + 1
+	// MsgpackCodecID is the id of MsgpackCodec
+	MsgpackCodecID
+	// ProtobufCodecID is the id of ProtobufCodec
+	ProtobufCodecID
+)
+
+// envelopeHeaderLen is the length of the codec id and the schema version
+const envelopeHeaderLen = 3
+
+var (
+	// ErrUnknownCodec is unknown codec error
+	ErrUnknownCodec = errors.New("unknown cache codec")
+	// ErrNotProtoMessage is non-protobuf value error
+	ErrNotProtoMessage = errors.New("cache value is not a protobuf message")
+	// ErrInvalidEnvelope is malformed cache entry error
+	ErrInvalidEnvelope = errors.New("invalid cache envelope")
+	// ErrSchemaVersionMismatch is outdated cache entry error
+	ErrSchemaVersionMismatch = errors.New("cache schema version mismatch")
+)
+
+var msgpackHandle = &codec.MsgpackHandle{
+	WriteExt: true,
+}
+
+// Codec serializes cached values
+type Codec interface {
+	ID() byte
+	Marshal(val interface{}) ([]byte, error)
+	Unmarshal(data []byte, dst interface{}) error
+}
+
+// Versioned is implemented by cached types whose schema changes across releases
+// entries written with another schema version are treated as misses; a slice has the version of its elements
+type Versioned interface {
+	CacheSchemaVersion() uint16
+}
+
+// JSONCodec serializes values as JSON
+type JSONCodec struct{}
+
+// ID implements Codec interface
+func (JSONCodec) ID() byte {
+	return JSONCodecID
+}
+
+// Marshal implements Codec interface
+func (JSONCodec) Marshal(val interface{}) ([]byte, error) {
+	return json.Marshal(val)
+}
+
+// Unmarshal implements Codec interface
+func (JSONCodec) Unmarshal(data []byte, dst interface{}) error {
+	return json.Unmarshal(data, dst)
+}
+
+// MsgpackCodec serializes values as MessagePack, honoring json struct tags
+type MsgpackCodec struct{}
+
+// ID implements Codec interface
+func (MsgpackCodec) ID() byte {
+	return MsgpackCodecID
+}
+
+// Marshal implements Codec interface
+func (MsgpackCodec) Marshal(val interface{}) ([]byte, error) {
+	var data []byte
+	if err := codec.NewEncoderBytes(&data, msgpackHandle).Encode(val); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Unmarshal implements Codec interface
+func (MsgpackCodec) Unmarshal(data []byte, dst interface{}) error {
+	return codec.NewDecoderBytes(data, msgpackHandle).Decode(dst)
+}
+
+// ProtobufCodec serializes protobuf messages
+type ProtobufCodec struct{}
+
+// ID implements Codec interface
+func (ProtobufCodec) ID() byte {
+	return ProtobufCodecID
+}
+
+// Marshal implements Codec interface
+func (ProtobufCodec) Marshal(val interface{}) ([]byte, error) {
+	msg, ok := val.(proto.Message)
+	if !ok {
+		return nil, ErrNotProtoMessage
+	}
+	return proto.Marshal(msg)
+}
+
+// Unmarshal implements Codec interface
+func (ProtobufCodec) Unmarshal(data []byte, dst interface{}) error {
+	msg, ok := dst.(proto.Message)
+	if !ok {
+		return ErrNotProtoMessage
+	}
+	return proto.Unmarshal(data, msg)
+}
+
+var codecs = map[byte]Codec{
+	JSONCodecID:     JSONCodec{},
+	MsgpackCodecID:  MsgpackCodec{},
+	ProtobufCodecID: ProtobufCodec{},
+}
+
+// NewCodec returns the codec of the given name, which is json or msgpack
+// protobuf only serializes protobuf messages, so a family of protobuf messages chooses it with ReadThroughOptions.Codec instead
+func NewCodec(name string) (Codec, error) {
+	switch name {
+	case "", "json":
+		return JSONCodec{}, nil
+	case "msgpack":
+		return MsgpackCodec{}, nil
+	default:
+		return nil, ErrUnknownCodec
+	}
+}
+
+// Seal serializes a value and wraps it in an envelope of the codec id and the schema version of the value
+func Seal(c Codec, val interface{}) ([]byte, error) {
+	payload, err := c.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, envelopeHeaderLen, envelopeHeaderLen+len(payload))
+	data[0] = c.ID()
+	binary.BigEndian.PutUint16(data[1:envelopeHeaderLen], schemaVersion(val))
+	return append(data, payload...), nil
+}
+
+// Open deserializes an envelope into dst with the codec it was sealed with
+// it fails if the envelope is malformed or was sealed with another schema version of dst
+func Open(data []byte, dst interface{}) error {
+	if len(data) < envelopeHeaderLen {
+		return ErrInvalidEnvelope
+	}
+	c, ok := codecs[data[0]]
+	if !ok {
+		return ErrUnknownCodec
+	}
+	if binary.BigEndian.Uint16(data[1:envelopeHeaderLen]) != schemaVersion(dst) {
+		return ErrSchemaVersionMismatch
+	}
+	return c.Unmarshal(data[envelopeHeaderLen:], dst)
+}
+
+func schemaVersion(val interface{}) uint16 {
+	if v, ok := val.(Versioned); ok {
+		return v.CacheSchemaVersion()
+	}
+	t := reflect.TypeOf(val)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t != nil && t.Kind() == reflect.Slice {
+		if v, ok := reflect.Zero(t.Elem()).Interface().(Versioned); ok {
+			return v.CacheSchemaVersion()
+		}
+	}
+	return 0
+}
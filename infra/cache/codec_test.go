@@ -0,0 +1,194 @@
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/minghsu0107/saga-account/config"
+	pb "github.com/minghsu0107/saga-pb"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/protobuf/proto"
+)
+
+type testCredentials struct {
+	ID               uint64 `json:"id"`
+	Active           bool   `json:"active"`
+	BcryptedPassword string `json:"bcrypted_password"`
+}
+
+type testCredentialsV2 testCredentials
+
+func (testCredentialsV2) CacheSchemaVersion() uint16 {
+	return 2
+}
+
+var (
+	mr     *miniredis.Miniredis
+	client redis.UniversalClient
+)
+
+func TestCache(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "cache suite")
+}
+
+var _ = BeforeSuite(func() {
+	var err error
+	mr, err = miniredis.Run()
+	Expect(err).To(BeNil())
+	client = redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+})
+
+var _ = AfterSuite(func() {
+	client.Close()
+	mr.Close()
+})
+
+var _ = Describe("cache codecs", func() {
+	credentials := &testCredentials{
+		ID:               1,
+		Active:           true,
+		BcryptedPassword: "testbcrypt",
+	}
+	It("should round trip values through every codec", func() {
+		for _, c := range []Codec{JSONCodec{}, MsgpackCodec{}} {
+			data, err := Seal(c, credentials)
+			Expect(err).To(BeNil())
+			Expect(data[0]).To(Equal(c.ID()))
+
+			decoded := &testCredentials{}
+			Expect(Open(data, decoded)).To(BeNil())
+			Expect(decoded).To(Equal(credentials))
+		}
+
+		data, err := Seal(ProtobufCodec{}, &pb.AuthResponse{CustomerId: 1, Expired: true})
+		Expect(err).To(BeNil())
+		Expect(data[0]).To(Equal(ProtobufCodecID))
+		decoded := &pb.AuthResponse{}
+		Expect(Open(data, decoded)).To(BeNil())
+		Expect(proto.Equal(decoded, &pb.AuthResponse{CustomerId: 1, Expired: true})).To(BeTrue())
+	})
+	It("should reject non-protobuf values with the protobuf codec", func() {
+		_, err := Seal(ProtobufCodec{}, credentials)
+		Expect(err).To(Equal(ErrNotProtoMessage))
+
+		data, err := Seal(ProtobufCodec{}, &pb.AuthResponse{CustomerId: 1})
+		Expect(err).To(BeNil())
+		Expect(Open(data, &testCredentials{})).To(Equal(ErrNotProtoMessage))
+	})
+	It("should reject malformed and outdated envelopes", func() {
+		Expect(Open([]byte(`{"id":1}`), &testCredentials{})).To(Equal(ErrUnknownCodec))
+		Expect(Open([]byte{JSONCodecID}, &testCredentials{})).To(Equal(ErrInvalidEnvelope))
+
+		data, err := Seal(JSONCodec{}, credentials)
+		Expect(err).To(BeNil())
+		Expect(Open(data, &testCredentialsV2{})).To(Equal(ErrSchemaVersionMismatch))
+	})
+	It("should version slices with the schema version of their elements", func() {
+		data, err := Seal(JSONCodec{}, []testCredentials{*credentials})
+		Expect(err).To(BeNil())
+		Expect(Open(data, &[]testCredentialsV2{})).To(Equal(ErrSchemaVersionMismatch))
+
+		data, err = Seal(JSONCodec{}, []testCredentialsV2{testCredentialsV2(*credentials)})
+		Expect(err).To(BeNil())
+		var decoded []testCredentialsV2
+		Expect(Open(data, &decoded)).To(BeNil())
+		Expect(decoded).To(Equal([]testCredentialsV2{testCredentialsV2(*credentials)}))
+	})
+	It("should look up codecs by name", func() {
+		c, err := NewCodec("")
+		Expect(err).To(BeNil())
+		Expect(c).To(Equal(JSONCodec{}))
+		c, err = NewCodec("msgpack")
+		Expect(err).To(BeNil())
+		Expect(c).To(Equal(MsgpackCodec{}))
+		_, err = NewCodec("protobuf")
+		Expect(err).To(Equal(ErrUnknownCodec))
+	})
+	Describe("undecodable entries", func() {
+		conf := &config.Config{
+			CacheCodec: "msgpack",
+			LocalCacheConfig: &config.LocalCacheConfig{
+				ExpirationSeconds: 10,
+			},
+			RedisConfig: &config.RedisConfig{
+				ExpirationSeconds: 60,
+			},
+		}
+		It("should treat outdated redis entries as misses and delete them", func() {
+			rc, err := NewRedisCache(conf, client)
+			Expect(err).To(BeNil())
+			Expect(rc.Set(context.Background(), "codectest:outdated", credentials)).To(BeNil())
+
+			ok, err := rc.Get(context.Background(), "codectest:outdated", &testCredentialsV2{})
+			Expect(ok).To(BeFalse())
+			Expect(err).To(BeNil())
+			Expect(mr.Exists("codectest:outdated")).To(BeFalse())
+
+			Expect(mr.Set("codectest:legacy", `{"id":1}`)).To(BeNil())
+			ok, err = rc.Get(context.Background(), "codectest:legacy", &testCredentials{})
+			Expect(ok).To(BeFalse())
+			Expect(err).To(BeNil())
+			Expect(mr.Exists("codectest:legacy")).To(BeFalse())
+		})
+		It("should treat outdated local entries as misses and delete them", func() {
+			lc, err := NewLocalCache(conf)
+			Expect(err).To(BeNil())
+			Expect(lc.Set("codectest:outdated", credentials)).To(BeNil())
+
+			ok, err := lc.Get("codectest:outdated", &testCredentialsV2{})
+			Expect(ok).To(BeFalse())
+			Expect(err).To(BeNil())
+			ok, _, err = lc.GetRaw("codectest:outdated")
+			Expect(ok).To(BeFalse())
+			Expect(err).To(BeNil())
+		})
+	})
+})
+
+func benchmarkCodec(b *testing.B, c Codec, val interface{}, newDst func() interface{}) {
+	data, err := Seal(c, val)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run("encode", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ReportMetric(float64(len(data)), "B/entry")
+		for i := 0; i < b.N; i++ {
+			if _, err := Seal(c, val); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("decode", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := Open(data, newDst()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkJSONCodec(b *testing.B) {
+	benchmarkCodec(b, JSONCodec{}, &testCredentials{ID: 1 << 40, Active: true, BcryptedPassword: "testbcrypt"}, func() interface{} {
+		return &testCredentials{}
+	})
+}
+
+func BenchmarkMsgpackCodec(b *testing.B) {
+	benchmarkCodec(b, MsgpackCodec{}, &testCredentials{ID: 1 << 40, Active: true, BcryptedPassword: "testbcrypt"}, func() interface{} {
+		return &testCredentials{}
+	})
+}
+
+func BenchmarkProtobufCodec(b *testing.B) {
+	benchmarkCodec(b, ProtobufCodec{}, &pb.AuthResponse{CustomerId: 1 << 40, Expired: true}, func() interface{} {
+		return &pb.AuthResponse{}
+	})
+}
@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"context"
+	"strings"
+
+	"github.com/minghsu0107/saga-account/pkg/health"
+	"github.com/redis/go-redis/v9"
+)
+
+// redisChecker checks that redis serves commands and, in cluster mode, that the cluster state is ok
+// an unavailable redis only degrades the service if the circuit breaker bypasses it
+type redisChecker struct {
+	client     redis.UniversalClient
+	bypassable bool
+}
+
+// Health implements health.Checker interface
+func (c *redisChecker) Health(ctx context.Context) health.Status {
+	status := health.Status{
+		Name:  "redis-server",
+		State: "up",
+		Ready: true,
+	}
+	var err error
+	if _, ok := c.client.(*redis.ClusterClient); ok {
+		var info string
+		info, err = c.client.ClusterInfo(ctx).Result()
+		if err == nil && !strings.Contains(info, "cluster_state:ok") {
+			status.State = "cluster state fail"
+			status.Ready = c.bypassable
+			status.Degraded = true
+			return status
+		}
+	} else {
+		err = c.client.Ping(ctx).Err()
+	}
+	if err != nil {
+		status.State = "down: " + err.Error()
+		status.Ready = c.bypassable
+		status.Degraded = true
+	}
+	return status
+}
@@ -1,63 +1,228 @@
 package cache
 
 import (
-	"encoding/json"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/allegro/bigcache/v3"
 	"github.com/minghsu0107/saga-account/config"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
+// local entries are prefixed with their kind, expiration deadline and version
+const (
+	entryValue byte = iota
+	entryTombstone
+
+	entryHeaderLen = 17
+)
+
+const defaultCleanWindow = 5 * time.Minute
+
 // LocalCache is the interface of local cache
 type LocalCache interface {
 	Get(key string, dst interface{}) (bool, error)
 	Set(key string, val interface{}) error
+	GetRaw(key string) (bool, []byte, error)
+	SetRaw(key string, val []byte, version int64) error
 	Delete(key string) error
+	Invalidate(key string) error
+	Reset() error
+	Stats() LocalCacheStats
+	Inspect(key string) (*LocalEntry, error)
+}
+
+// LocalCacheStats is the statistics of the local cache
+type LocalCacheStats struct {
+	Entries       int   `json:"entries"`
+	CapacityBytes int   `json:"capacity_bytes"`
+	Hits          int64 `json:"hits"`
+	Misses        int64 `json:"misses"`
+	DeleteHits    int64 `json:"delete_hits"`
+	DeleteMisses  int64 `json:"delete_misses"`
+	Collisions    int64 `json:"collisions"`
+}
+
+// LocalEntry describes a local entry without its value, which may hold personal information
+// Version is the time its value was read, or the time it was invalidated if Tombstone is set
+type LocalEntry struct {
+	Key       string    `json:"key"`
+	Tombstone bool      `json:"tombstone"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Version   time.Time `json:"version"`
+	SizeBytes int       `json:"size_bytes"`
 }
 
 // LocalCacheImpl implements the Cache interface
+// entries expire after the local expiration of their key family, which is at most LocalCacheConfig.ExpirationSeconds.
+// versions are the unix nanoseconds when values were read; an invalidated key keeps a tombstone holding
+// the invalidation time, so that a value read before the invalidation cannot be written after it
 type LocalCacheImpl struct {
-	cache *bigcache.BigCache
+	cache  *bigcache.BigCache
+	codec  Codec
+	config *config.Config
+	// mu serializes writes, so that a tombstone is never overwritten by an older value
+	mu sync.Mutex
+	// resetAt is the time of the last reset; values read before it are discarded
+	resetAt int64
 }
 
 // NewLocalCache is the factory of local cache
+// it returns a TrackingLocalCache if local entries are invalidated by client tracking
 func NewLocalCache(config *config.Config) (LocalCache, error) {
+	codec, err := NewCodec(config.CacheCodec)
+	if err != nil {
+		return nil, err
+	}
 	cacheConfig := bigcache.DefaultConfig(time.Duration(config.LocalCacheConfig.ExpirationSeconds) * time.Second)
-	cacheConfig.CleanWindow = 5 * time.Minute
+	cacheConfig.CleanWindow = defaultCleanWindow
+	if config.LocalCacheConfig.CleanWindowSeconds > 0 {
+		cacheConfig.CleanWindow = time.Duration(config.LocalCacheConfig.CleanWindowSeconds) * time.Second
+	}
 	cache, err := bigcache.NewBigCache(cacheConfig)
 	if err != nil {
 		return nil, err
 	}
-	return &LocalCacheImpl{
-		cache: cache,
-	}, nil
+	lc := &LocalCacheImpl{
+		cache:  cache,
+		codec:  codec,
+		config: config,
+	}
+	localStats.set(cache)
+	switch config.LocalCacheConfig.Invalidation {
+	case "", StreamInvalidation:
+		return lc, nil
+	case TrackingInvalidation:
+		return newTrackingLocalCache(config, lc)
+	}
+	return nil, ErrUnknownInvalidation
 }
 
 // Get returns true if the key already exists and set dst to the corresponding value
+// an entry that cannot be decoded into dst is deleted and treated as a miss
 func (lc *LocalCacheImpl) Get(key string, dst interface{}) (bool, error) {
-	val, err := lc.cache.Get(key)
-	if err == bigcache.ErrEntryNotFound {
-		return false, nil
-	} else if err != nil {
+	ok, val, err := lc.GetRaw(key)
+	if !ok || err != nil {
 		return false, err
-	} else {
-		if err = json.Unmarshal(val, dst); err != nil {
-			return false, err
-		}
+	}
+	if err = Open(val, dst); err != nil {
+		return false, lc.Delete(key)
 	}
 	return true, nil
 }
 
 // Set sets a value by key
 func (lc *LocalCacheImpl) Set(key string, val interface{}) error {
-	jsonVal, err := json.Marshal(val)
+	data, err := Seal(lc.codec, val)
 	if err != nil {
 		return err
 	}
-	if err = lc.cache.Set(key, jsonVal); err != nil {
+	return lc.SetRaw(key, data, time.Now().UnixNano())
+}
+
+// GetRaw returns true if the key already exists, together with its encoded value
+func (lc *LocalCacheImpl) GetRaw(key string) (bool, []byte, error) {
+	kind, deadline, _, val, err := lc.getEntry(key)
+	if err != nil || kind != entryValue {
+		return false, nil, err
+	}
+	if time.Now().UnixNano() >= deadline {
+		return false, nil, lc.Delete(key)
+	}
+	return true, val, nil
+}
+
+// SetRaw sets an encoded value read at version by key
+// it is a no-op if the key family is not cached, or if the key was invalidated or the cache was reset after version
+func (lc *LocalCacheImpl) SetRaw(key string, val []byte, version int64) error {
+	policy := lc.config.CachePolicy(config.KeyFamily(key))
+	if policy.IsDisabled() || policy.LocalExpirationSeconds <= 0 {
+		return nil
+	}
+	if version < atomic.LoadInt64(&lc.resetAt) {
+		return nil
+	}
+
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	kind, deadline, current, _, err := lc.getEntry(key)
+	if err != nil {
 		return err
 	}
-	return nil
+	if kind == entryTombstone && version < current && time.Now().UnixNano() < deadline {
+		return nil
+	}
+	return lc.setEntry(key, entryValue, policy.LocalExpiration(), version, val)
+}
+
+// Invalidate replaces the value of a key with a tombstone that lasts for the local expiration of the key family
+func (lc *LocalCacheImpl) Invalidate(key string) error {
+	expiration := lc.config.CachePolicy(config.KeyFamily(key)).LocalExpiration()
+	if expiration <= 0 {
+		return lc.Delete(key)
+	}
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return lc.setEntry(key, entryTombstone, expiration, time.Now().UnixNano(), nil)
+}
+
+// Reset removes every entry
+func (lc *LocalCacheImpl) Reset() error {
+	atomic.StoreInt64(&lc.resetAt, time.Now().UnixNano())
+	return lc.cache.Reset()
+}
+
+// Stats returns the statistics of the local cache
+func (lc *LocalCacheImpl) Stats() LocalCacheStats {
+	stats := lc.cache.Stats()
+	return LocalCacheStats{
+		Entries:       lc.cache.Len(),
+		CapacityBytes: lc.cache.Capacity(),
+		Hits:          stats.Hits,
+		Misses:        stats.Misses,
+		DeleteHits:    stats.DelHits,
+		DeleteMisses:  stats.DelMisses,
+		Collisions:    stats.Collisions,
+	}
+}
+
+// Inspect describes the entry of a key, or returns nil if the key has no entry or it has expired
+func (lc *LocalCacheImpl) Inspect(key string) (*LocalEntry, error) {
+	kind, deadline, version, val, err := lc.getEntry(key)
+	if err != nil || time.Now().UnixNano() >= deadline {
+		return nil, err
+	}
+	return &LocalEntry{
+		Key:       key,
+		Tombstone: kind == entryTombstone,
+		ExpiresAt: time.Unix(0, deadline),
+		Version:   time.Unix(0, version),
+		SizeBytes: len(val),
+	}, nil
+}
+
+// getEntry returns the kind, deadline, version and value of a key
+// a missing or malformed entry is returned as an expired tombstone
+func (lc *LocalCacheImpl) getEntry(key string) (byte, int64, int64, []byte, error) {
+	entry, err := lc.cache.Get(key)
+	if err == bigcache.ErrEntryNotFound || (err == nil && len(entry) < entryHeaderLen) {
+		return entryTombstone, 0, 0, nil, nil
+	} else if err != nil {
+		return entryTombstone, 0, 0, nil, err
+	}
+	deadline := int64(binary.BigEndian.Uint64(entry[1:9]))
+	version := int64(binary.BigEndian.Uint64(entry[9:entryHeaderLen]))
+	return entry[0], deadline, version, entry[entryHeaderLen:], nil
+}
+
+func (lc *LocalCacheImpl) setEntry(key string, kind byte, expiration time.Duration, version int64, val []byte) error {
+	entry := make([]byte, entryHeaderLen, entryHeaderLen+len(val))
+	entry[0] = kind
+	binary.BigEndian.PutUint64(entry[1:9], uint64(time.Now().Add(expiration).UnixNano()))
+	binary.BigEndian.PutUint64(entry[9:entryHeaderLen], uint64(version))
+	return lc.cache.Set(key, append(entry, val...))
 }
 
 // Delete deletes a key
@@ -68,3 +233,65 @@ func (lc *LocalCacheImpl) Delete(key string) error {
 	}
 	return err
 }
+
+// statsCollector exports the statistics of the local cache
+type statsCollector struct {
+	mu         sync.Mutex
+	cache      *bigcache.BigCache
+	entries    *prometheus.Desc
+	capacity   *prometheus.Desc
+	lookups    *prometheus.Desc
+	deletes    *prometheus.Desc
+	collisions *prometheus.Desc
+}
+
+var localStats = &statsCollector{
+	entries: prometheus.NewDesc("account_local_cache_entries",
+		"The number of entries in the local cache, including tombstones.", nil, nil),
+	capacity: prometheus.NewDesc("account_local_cache_capacity_bytes",
+		"The bytes allocated by the local cache.", nil, nil),
+	lookups: prometheus.NewDesc("account_local_cache_lookups_total",
+		"The number of lookups of the local cache by result.", []string{"result"}, nil),
+	deletes: prometheus.NewDesc("account_local_cache_deletes_total",
+		"The number of deletes of the local cache by result.", []string{"result"}, nil),
+	collisions: prometheus.NewDesc("account_local_cache_collisions_total",
+		"The number of key collisions of the local cache.", nil, nil),
+}
+
+func init() {
+	prometheus.MustRegister(localStats)
+}
+
+// set exports the statistics of cache, replacing the cache previously set
+func (c *statsCollector) set(cache *bigcache.BigCache) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cache = cache
+}
+
+// Describe implements prometheus.Collector interface
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.entries
+	ch <- c.capacity
+	ch <- c.lookups
+	ch <- c.deletes
+	ch <- c.collisions
+}
+
+// Collect implements prometheus.Collector interface
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	cache := c.cache
+	c.mu.Unlock()
+	if cache == nil {
+		return
+	}
+	stats := cache.Stats()
+	ch <- prometheus.MustNewConstMetric(c.entries, prometheus.GaugeValue, float64(cache.Len()))
+	ch <- prometheus.MustNewConstMetric(c.capacity, prometheus.GaugeValue, float64(cache.Capacity()))
+	ch <- prometheus.MustNewConstMetric(c.lookups, prometheus.CounterValue, float64(stats.Hits), "hit")
+	ch <- prometheus.MustNewConstMetric(c.lookups, prometheus.CounterValue, float64(stats.Misses), "miss")
+	ch <- prometheus.MustNewConstMetric(c.deletes, prometheus.CounterValue, float64(stats.DelHits), "hit")
+	ch <- prometheus.MustNewConstMetric(c.deletes, prometheus.CounterValue, float64(stats.DelMisses), "miss")
+	ch <- prometheus.MustNewConstMetric(c.collisions, prometheus.CounterValue, float64(stats.Collisions))
+}
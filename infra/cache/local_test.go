@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"strings"
+	"time"
+
+	"github.com/minghsu0107/saga-account/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var _ = Describe("local cache policies", func() {
+	disabled := true
+	conf := &config.Config{
+		LocalCacheConfig: &config.LocalCacheConfig{
+			ExpirationSeconds: 60,
+		},
+		RedisConfig: &config.RedisConfig{
+			ExpirationSeconds: 60,
+		},
+		CacheConfig: &config.CacheConfig{
+			Families: map[string]config.CachePolicy{
+				"short": {
+					LocalExpirationSeconds: 1,
+				},
+				"disabled": {
+					Disabled: &disabled,
+				},
+				"remote": {
+					LocalExpirationSeconds: -1,
+				},
+			},
+		},
+	}
+	var lc LocalCache
+	BeforeEach(func() {
+		var err error
+		lc, err = NewLocalCache(conf)
+		Expect(err).To(BeNil())
+	})
+	It("should expire entries after the local expiration of their family", func() {
+		Expect(lc.SetRaw("short:1", []byte("val"), time.Now().UnixNano())).To(BeNil())
+		Expect(lc.SetRaw("long:1", []byte("val"), time.Now().UnixNano())).To(BeNil())
+
+		ok, val, err := lc.GetRaw("short:1")
+		Expect(ok).To(BeTrue())
+		Expect(err).To(BeNil())
+		Expect(string(val)).To(Equal("val"))
+
+		Eventually(func() bool {
+			ok, _, _ := lc.GetRaw("short:1")
+			return ok
+		}, 2*time.Second, 100*time.Millisecond).Should(BeFalse())
+		ok, _, err = lc.GetRaw("long:1")
+		Expect(ok).To(BeTrue())
+		Expect(err).To(BeNil())
+	})
+	It("should describe entries without their values", func() {
+		readAt := time.Now().UnixNano()
+		Expect(lc.SetRaw("long:1", []byte("val"), readAt)).To(BeNil())
+		entry, err := lc.Inspect("long:1")
+		Expect(err).To(BeNil())
+		Expect(entry.Tombstone).To(BeFalse())
+		Expect(entry.Version).To(Equal(time.Unix(0, readAt)))
+		Expect(entry.SizeBytes).To(Equal(3))
+
+		Expect(lc.Invalidate("long:1")).To(BeNil())
+		entry, err = lc.Inspect("long:1")
+		Expect(err).To(BeNil())
+		Expect(entry.Tombstone).To(BeTrue())
+		entry, err = lc.Inspect("long:2")
+		Expect(err).To(BeNil())
+		Expect(entry).To(BeNil())
+	})
+	It("should not store entries of disabled or remote-only families", func() {
+		Expect(lc.SetRaw("disabled:1", []byte("val"), time.Now().UnixNano())).To(BeNil())
+		Expect(lc.SetRaw("remote:1", []byte("val"), time.Now().UnixNano())).To(BeNil())
+
+		ok, _, err := lc.GetRaw("disabled:1")
+		Expect(ok).To(BeFalse())
+		Expect(err).To(BeNil())
+		ok, _, err = lc.GetRaw("remote:1")
+		Expect(ok).To(BeFalse())
+		Expect(err).To(BeNil())
+	})
+	It("should discard values read before an invalidation or a reset", func() {
+		readAt := time.Now().UnixNano()
+		Expect(lc.Invalidate("long:1")).To(BeNil())
+		Expect(lc.SetRaw("long:1", []byte("stale"), readAt)).To(BeNil())
+		ok, _, err := lc.GetRaw("long:1")
+		Expect(ok).To(BeFalse())
+		Expect(err).To(BeNil())
+
+		Expect(lc.SetRaw("long:1", []byte("val"), time.Now().UnixNano())).To(BeNil())
+		ok, val, err := lc.GetRaw("long:1")
+		Expect(ok).To(BeTrue())
+		Expect(err).To(BeNil())
+		Expect(string(val)).To(Equal("val"))
+
+		readAt = time.Now().UnixNano()
+		Expect(lc.Reset()).To(BeNil())
+		Expect(lc.SetRaw("long:2", []byte("stale"), readAt)).To(BeNil())
+		ok, _, err = lc.GetRaw("long:1")
+		Expect(ok).To(BeFalse())
+		Expect(err).To(BeNil())
+		ok, _, err = lc.GetRaw("long:2")
+		Expect(ok).To(BeFalse())
+		Expect(err).To(BeNil())
+	})
+	It("should merge family policies over the default policy", func() {
+		policy := conf.CachePolicy("short")
+		Expect(policy.LocalExpirationSeconds).To(Equal(int64(1)))
+		Expect(policy.RedisExpirationSeconds).To(Equal(int64(60)))
+		Expect(policy.IsDisabled()).To(BeFalse())
+		Expect(config.KeyFamily("cuscred:ming@ming.com")).To(Equal("cuscred"))
+	})
+	It("should let a family policy enable caching disabled by default", func() {
+		enabled := false
+		conf := &config.Config{
+			CacheConfig: &config.CacheConfig{
+				Default: config.CachePolicy{
+					Disabled: &disabled,
+				},
+				Families: map[string]config.CachePolicy{
+					"enabled": {
+						Disabled: &enabled,
+					},
+					"inherited": {
+						LocalExpirationSeconds: 1,
+					},
+				},
+			},
+		}
+		Expect(conf.CachePolicy("enabled").IsDisabled()).To(BeFalse())
+		Expect(conf.CachePolicy("inherited").IsDisabled()).To(BeTrue())
+		Expect(conf.CachePolicy("other").IsDisabled()).To(BeTrue())
+	})
+	It("should export the statistics of the local cache", func() {
+		// the write looks up the key first, which misses
+		Expect(lc.SetRaw("long:1", []byte("val"), time.Now().UnixNano())).To(BeNil())
+		ok, _, err := lc.GetRaw("long:1")
+		Expect(ok).To(BeTrue())
+		Expect(err).To(BeNil())
+		ok, _, err = lc.GetRaw("long:2")
+		Expect(ok).To(BeFalse())
+		Expect(err).To(BeNil())
+
+		Expect(testutil.CollectAndCompare(localStats, strings.NewReader(`
+# HELP account_local_cache_entries The number of entries in the local cache, including tombstones.
+# TYPE account_local_cache_entries gauge
+account_local_cache_entries 1
+# HELP account_local_cache_lookups_total The number of lookups of the local cache by result.
+# TYPE account_local_cache_lookups_total counter
+account_local_cache_lookups_total{result="hit"} 1
+account_local_cache_lookups_total{result="miss"} 2
+`), "account_local_cache_entries", "account_local_cache_lookups_total")).To(BeNil())
+	})
+})
@@ -2,42 +2,99 @@ package cache
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
-	"math/rand"
+	"os"
 	"strings"
 	"time"
 
 	"github.com/go-redsync/redsync/v4"
 	"github.com/go-redsync/redsync/v4/redis/goredis/v9"
 	"github.com/minghsu0107/saga-account/config"
+	"github.com/minghsu0107/saga-account/pkg"
+	"github.com/minghsu0107/saga-account/pkg/health"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/redis/go-redis/extra/redisotel/v9"
 	"github.com/redis/go-redis/v9"
 )
 
+var (
+	lockWaits = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "account_redis_lock_wait_seconds",
+		Help:    "The time waited to acquire redis mutexes by result.",
+		Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"result"})
+	lockFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "account_redis_lock_failures_total",
+		Help: "The number of failures to acquire or release redis mutexes by operation.",
+	}, []string{"operation"})
+)
+
 var (
 	RedisClient redis.UniversalClient
 	//ErrRedisUnlockFail is redis unlock fail error
 	ErrRedisUnlockFail = errors.New("redis unlock fail")
 	// ErrRedisPipelineCmdNotFound is redis command not found error
 	ErrRedisPipelineCmdNotFound = errors.New("redis pipeline command not found; supports only SET and DELETE")
+	// ErrUnknownRedisMode is unknown redis mode error
+	ErrUnknownRedisMode = errors.New("unknown redis mode; supports only standalone, sentinel and cluster")
+	// ErrRedisClusterDB is the error of a non-zero db in cluster mode
+	ErrRedisClusterDB = errors.New("redis cluster supports only db 0")
+	// ErrRedisMasterNameRequired is the error of sentinel mode without a master name
+	ErrRedisMasterNameRequired = errors.New("redis sentinel mode requires a master name")
+	// ErrRedisInvalidCA is the error of a tls ca file without certificates
+	ErrRedisInvalidCA = errors.New("redis tls ca file contains no certificate")
+)
+
+// redis modes
+const (
+	RedisStandaloneMode = "standalone"
+	RedisSentinelMode   = "sentinel"
+	RedisClusterMode    = "cluster"
 )
 
+// versionExpiration is how long the version of an invalidated key is kept, which must exceed the longest load
+const versionExpiration = time.Hour
+
+// InvalidationField is the stream entry field holding the json array of invalidated keys
+const InvalidationField = "keys"
+
+// setIfVersionScript sets a key only if its version has not changed since it was read
+var setIfVersionScript = redis.NewScript(`
+local version = redis.call('GET', KEYS[2])
+if (version or '0') ~= ARGV[1] then
+  return 0
+end
+redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+return 1
+`)
+
 // RedisCache is the interface of redis cache
 type RedisCache interface {
 	Get(ctx context.Context, key string, dst interface{}) (bool, error)
+	GetRaw(ctx context.Context, key string) (bool, []byte, time.Duration, error)
 	Set(ctx context.Context, key string, val interface{}) error
+	SetWithExpiration(ctx context.Context, key string, val interface{}, expiration time.Duration) error
+	SetRaw(ctx context.Context, key string, val []byte, expiration time.Duration) error
+	SetRawIfVersion(ctx context.Context, key string, val []byte, expiration time.Duration, version int64) (bool, error)
+	Version(ctx context.Context, key string) (int64, error)
 	Delete(ctx context.Context, key string) error
-	GetMutex(mutexname string) *redsync.Mutex
+	Invalidate(ctx context.Context, keys ...string) error
+	GetMutex(mutexname string, options ...redsync.Option) *redsync.Mutex
+	Lock(ctx context.Context, name string) (func(), error)
 	ExecPipeLine(ctx context.Context, cmds *[]RedisCmd) error
-	Publish(ctx context.Context, topic string, payload interface{}) error
+	Codec() Codec
 }
 
 // RedisCacheImpl is the redis cache client type
 type RedisCacheImpl struct {
-	client     redis.UniversalClient
-	rs         *redsync.Redsync
-	expiration int64
+	client redis.UniversalClient
+	rs     *redsync.Redsync
+	codec  Codec
+	config *config.Config
 }
 
 // RedisOpType is the redis operation type
@@ -86,62 +143,221 @@ type RedisPipelineCmd struct {
 	Cmd    interface{}
 }
 
-func NewRedisClient(config *config.Config) (redis.UniversalClient, error) {
-	RedisClient = redis.NewClusterClient(&redis.ClusterOptions{
-		Addrs:         getServerAddrs(config.RedisConfig.Addrs),
-		Password:      config.RedisConfig.Password,
-		PoolSize:      config.RedisConfig.PoolSize,
-		MaxRetries:    config.RedisConfig.MaxRetries,
-		ReadOnly:      true,
-		RouteRandomly: true,
+// NewRedisClient connects to redis in the mode of RedisConfig
+// an unreachable redis fails the start only if the circuit breaker is disabled; otherwise the client
+// is returned as is, and redis is bypassed until it can be reached. the health of redis is registered to registry
+func NewRedisClient(config *config.Config, registry *health.Registry) (redis.UniversalClient, error) {
+	client, err := newUniversalClient(config.RedisConfig)
+	if err != nil {
+		return nil, err
+	}
+	RedisClient = client
+	redisotel.InstrumentTracing(RedisClient)
+	registry.Register(&redisChecker{
+		client:     RedisClient,
+		bypassable: config.RedisConfig.BreakerFailureThreshold > 0,
 	})
+	logger := config.Logger.ContextLogger.WithField("type", "setup:redis")
 	ctx := context.Background()
 	pong, err := RedisClient.Ping(ctx).Result()
-	if err == redis.Nil || err != nil {
-		return nil, err
+	if err != nil {
+		if config.RedisConfig.BreakerFailureThreshold <= 0 {
+			return nil, err
+		}
+		logger.Warn("redis unavailable on start: " + err.Error())
+		return RedisClient, nil
 	}
-	redisotel.InstrumentTracing(RedisClient)
-	config.Logger.ContextLogger.WithField("type", "setup:redis").Info("successful redis connection: " + pong)
+	logger.Info("successful redis connection: " + pong)
 	return RedisClient, nil
 }
 
+// newUniversalClient builds the client of a redis mode without connecting
+// a cluster client reads from replicas; the other clients use the master only
+func newUniversalClient(rc *config.RedisConfig) (redis.UniversalClient, error) {
+	tlsConfig, err := newTLSConfig(rc)
+	if err != nil {
+		return nil, err
+	}
+	switch rc.Mode {
+	case "", RedisClusterMode:
+		if rc.DB != 0 {
+			return nil, ErrRedisClusterDB
+		}
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:         getServerAddrs(rc.Addrs),
+			Username:      rc.Username,
+			Password:      rc.Password,
+			PoolSize:      rc.PoolSize,
+			MaxRetries:    rc.MaxRetries,
+			DialTimeout:   millis(rc.DialTimeoutMillis),
+			ReadTimeout:   millis(rc.ReadTimeoutMillis),
+			WriteTimeout:  millis(rc.WriteTimeoutMillis),
+			TLSConfig:     tlsConfig,
+			ReadOnly:      true,
+			RouteRandomly: true,
+		}), nil
+	case RedisSentinelMode:
+		if rc.MasterName == "" {
+			return nil, ErrRedisMasterNameRequired
+		}
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       rc.MasterName,
+			SentinelAddrs:    getServerAddrs(rc.SentinelAddrs),
+			SentinelUsername: rc.SentinelUsername,
+			SentinelPassword: rc.SentinelPassword,
+			Username:         rc.Username,
+			Password:         rc.Password,
+			DB:               rc.DB,
+			PoolSize:         rc.PoolSize,
+			MaxRetries:       rc.MaxRetries,
+			DialTimeout:      millis(rc.DialTimeoutMillis),
+			ReadTimeout:      millis(rc.ReadTimeoutMillis),
+			WriteTimeout:     millis(rc.WriteTimeoutMillis),
+			TLSConfig:        tlsConfig,
+		}), nil
+	case RedisStandaloneMode:
+		return redis.NewClient(&redis.Options{
+			Addr:         getServerAddrs(rc.Addrs)[0],
+			Username:     rc.Username,
+			Password:     rc.Password,
+			DB:           rc.DB,
+			PoolSize:     rc.PoolSize,
+			MaxRetries:   rc.MaxRetries,
+			DialTimeout:  millis(rc.DialTimeoutMillis),
+			ReadTimeout:  millis(rc.ReadTimeoutMillis),
+			WriteTimeout: millis(rc.WriteTimeoutMillis),
+			TLSConfig:    tlsConfig,
+		}), nil
+	}
+	return nil, ErrUnknownRedisMode
+}
+
+// newTLSConfig returns the tls config of redis connections, or nil if tls is disabled
+// the server name defaults to the host of each address
+func newTLSConfig(rc *config.RedisConfig) (*tls.Config, error) {
+	if !rc.TLSEnabled {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         rc.TLSServerName,
+		InsecureSkipVerify: rc.TLSInsecureSkipVerify,
+	}
+	if rc.TLSCAFile != "" {
+		ca, err := os.ReadFile(rc.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, ErrRedisInvalidCA
+		}
+	}
+	return tlsConfig, nil
+}
+
 // NewRedisCache is the factory of redis cache
-func NewRedisCache(config *config.Config, client redis.UniversalClient) RedisCache {
+func NewRedisCache(config *config.Config, client redis.UniversalClient) (RedisCache, error) {
+	codec, err := NewCodec(config.CacheCodec)
+	if err != nil {
+		return nil, err
+	}
 	pool := goredis.NewPool(client)
 	rs := redsync.New(pool)
 
 	return &RedisCacheImpl{
-		client:     client,
-		rs:         rs,
-		expiration: config.RedisConfig.ExpirationSeconds,
-	}
+		client: client,
+		rs:     rs,
+		codec:  codec,
+		config: config,
+	}, nil
 }
 
 // Get returns true if the key already exists and set dst to the corresponding value
+// an entry that cannot be decoded into dst is deleted and treated as a miss
 func (rc *RedisCacheImpl) Get(ctx context.Context, key string, dst interface{}) (bool, error) {
-	val, err := rc.client.Get(ctx, key).Result()
+	val, err := rc.client.Get(ctx, key).Bytes()
 	if err == redis.Nil {
 		return false, nil
 	} else if err != nil {
 		return false, err
 	} else {
-		json.Unmarshal([]byte(val), dst)
+		if err = Open(val, dst); err != nil {
+			return false, rc.Delete(ctx, key)
+		}
 	}
 	return true, nil
 }
 
-// Set sets a key-value pair
+// GetRaw returns true if the key already exists, together with its encoded value and remaining time to live
+func (rc *RedisCacheImpl) GetRaw(ctx context.Context, key string) (bool, []byte, time.Duration, error) {
+	pipe := rc.client.Pipeline()
+	getCmd := pipe.Get(ctx, key)
+	ttlCmd := pipe.PTTL(ctx, key)
+	_, err := pipe.Exec(ctx)
+	if err == redis.Nil {
+		return false, nil, 0, nil
+	} else if err != nil {
+		return false, nil, 0, err
+	}
+	val, err := getCmd.Bytes()
+	if err != nil {
+		return false, nil, 0, err
+	}
+	return true, val, ttlCmd.Val(), nil
+}
+
+// Set sets a key-value pair that expires after the redis expiration of its key family
+// it is a no-op if the key family is not cached
 func (rc *RedisCacheImpl) Set(ctx context.Context, key string, val interface{}) error {
-	strVal, err := json.Marshal(val)
+	policy := rc.config.CachePolicy(config.KeyFamily(key))
+	if policy.IsDisabled() {
+		return nil
+	}
+	data, err := Seal(rc.codec, val)
 	if err != nil {
 		return err
 	}
-	if err := rc.client.Set(ctx, key, strVal, getRandomExpiration(rc.expiration)).Err(); err != nil {
+	if err := rc.client.Set(ctx, key, data, policy.RedisExpiration()).Err(); err != nil {
 		return err
 	}
 	return nil
 }
 
+// SetWithExpiration sets a key-value pair that expires after the given duration
+func (rc *RedisCacheImpl) SetWithExpiration(ctx context.Context, key string, val interface{}, expiration time.Duration) error {
+	data, err := Seal(rc.codec, val)
+	if err != nil {
+		return err
+	}
+	return rc.client.Set(ctx, key, data, expiration).Err()
+}
+
+// SetRaw sets an encoded value that expires after the given duration
+func (rc *RedisCacheImpl) SetRaw(ctx context.Context, key string, val []byte, expiration time.Duration) error {
+	return rc.client.Set(ctx, key, val, expiration).Err()
+}
+
+// SetRawIfVersion sets an encoded value that expires after the given duration
+// unless the key has been invalidated since its version was read; it returns false if the value is discarded
+func (rc *RedisCacheImpl) SetRawIfVersion(ctx context.Context, key string, val []byte, expiration time.Duration, version int64) (bool, error) {
+	set, err := setIfVersionScript.Run(ctx, rc.client, []string{key, versionKey(key)},
+		version, val, expiration.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return set == 1, nil
+}
+
+// Version returns the number of times a key has been invalidated recently
+func (rc *RedisCacheImpl) Version(ctx context.Context, key string) (int64, error) {
+	version, err := rc.client.Get(ctx, versionKey(key)).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return version, err
+}
+
 // Delete deletes a key
 func (rc *RedisCacheImpl) Delete(ctx context.Context, key string) error {
 	if err := rc.client.Del(ctx, key).Err(); err != nil {
@@ -150,8 +366,26 @@ func (rc *RedisCacheImpl) Delete(ctx context.Context, key string) error {
 	return nil
 }
 
-func (rc *RedisCacheImpl) GetMutex(mutexname string) *redsync.Mutex {
-	return rc.rs.NewMutex(mutexname, redsync.WithExpiry(5*time.Second))
+// GetMutex returns the redis mutex of mutexname, which expires after 5 seconds unless options set another expiry
+func (rc *RedisCacheImpl) GetMutex(mutexname string, options ...redsync.Option) *redsync.Mutex {
+	return rc.rs.NewMutex(mutexname, append([]redsync.Option{redsync.WithExpiry(5 * time.Second)}, options...)...)
+}
+
+// Lock acquires the mutex of name and returns the function releasing it
+func (rc *RedisCacheImpl) Lock(ctx context.Context, name string) (func(), error) {
+	mutex := rc.GetMutex(name)
+	start := time.Now()
+	if err := mutex.LockContext(ctx); err != nil {
+		lockWaits.WithLabelValues("failed").Observe(time.Since(start).Seconds())
+		lockFailures.WithLabelValues("lock").Inc()
+		return nil, err
+	}
+	lockWaits.WithLabelValues("acquired").Observe(time.Since(start).Seconds())
+	return func() {
+		if ok, err := mutex.UnlockContext(ctx); !ok || err != nil {
+			lockFailures.WithLabelValues("unlock").Inc()
+		}
+	}, nil
 }
 
 // ExecPipeLine execute the given commands in a pipline
@@ -161,13 +395,14 @@ func (rc *RedisCacheImpl) ExecPipeLine(ctx context.Context, cmds *[]RedisCmd) er
 	for _, cmd := range *cmds {
 		switch cmd.OpType {
 		case SET:
-			strVal, err := json.Marshal(cmd.Payload.(RedisSetPayload).Val)
+			key := cmd.Payload.(RedisSetPayload).Key
+			data, err := Seal(rc.codec, cmd.Payload.(RedisSetPayload).Val)
 			if err != nil {
 				return err
 			}
 			pipelineCmds = append(pipelineCmds, RedisPipelineCmd{
 				OpType: SET,
-				Cmd:    pipe.Set(ctx, cmd.Payload.(RedisSetPayload).Key, strVal, getRandomExpiration(rc.expiration)),
+				Cmd:    pipe.Set(ctx, key, data, rc.config.CachePolicy(config.KeyFamily(key)).RedisExpiration()),
 			})
 		case DELETE:
 			pipelineCmds = append(pipelineCmds, RedisPipelineCmd{
@@ -198,18 +433,50 @@ func (rc *RedisCacheImpl) ExecPipeLine(ctx context.Context, cmds *[]RedisCmd) er
 	return nil
 }
 
-func (rc *RedisCacheImpl) Publish(ctx context.Context, topic string, payload interface{}) error {
-	strVal, err := json.Marshal(payload)
+// Codec returns the codec values are sealed with
+func (rc *RedisCacheImpl) Codec() Codec {
+	return rc.codec
+}
+
+// Invalidate deletes keys, bumps their versions so that values loaded before are discarded,
+// and appends the keys to InvalidationStream for every local cache
+func (rc *RedisCacheImpl) Invalidate(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(keys)
 	if err != nil {
 		return err
 	}
-	return rc.client.Publish(ctx, topic, strVal).Err()
+	pipe := rc.client.Pipeline()
+	for _, key := range keys {
+		pipe.Incr(ctx, versionKey(key))
+		pipe.Expire(ctx, versionKey(key), versionExpiration)
+		pipe.Del(ctx, key)
+	}
+	args := &redis.XAddArgs{
+		Stream: config.InvalidationStream,
+		Values: []interface{}{InvalidationField, payload},
+	}
+	if rc.config.RedisConfig.StreamMaxLen > 0 {
+		args.MaxLen = rc.config.RedisConfig.StreamMaxLen
+		args.Approx = true
+	}
+	pipe.XAdd(ctx, args)
+	_, err = pipe.Exec(ctx)
+	return err
 }
 
-func getRandomExpiration(expiration int64) time.Duration {
-	return time.Duration(expiration+rand.Int63n(10)) * time.Second
+// versionKey is the key of the version of a key, which is hashed to the same cluster slot
+func versionKey(key string) string {
+	return pkg.Join("{", key, "}:version")
 }
 
 func getServerAddrs(addrs string) []string {
 	return strings.Split(addrs, ",")
 }
+
+// millis converts milliseconds to a duration
+func millis(ms int64) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/minghsu0107/saga-account/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/redis/go-redis/v9"
+)
+
+var _ = Describe("redis modes", func() {
+	It("should select the db in standalone mode", func() {
+		client, err := newUniversalClient(&config.RedisConfig{
+			Mode:  RedisStandaloneMode,
+			Addrs: mr.Addr(),
+			DB:    1,
+		})
+		Expect(err).To(BeNil())
+		defer client.Close()
+		Expect(client).To(BeAssignableToTypeOf(&redis.Client{}))
+
+		Expect(client.Set(context.Background(), "modetest:1", "val", 0).Err()).To(BeNil())
+		val, err := mr.DB(1).Get("modetest:1")
+		Expect(err).To(BeNil())
+		Expect(val).To(Equal("val"))
+		Expect(mr.Exists("modetest:1")).To(BeFalse())
+	})
+	It("should authenticate with an acl user", func() {
+		s, err := miniredis.Run()
+		Expect(err).To(BeNil())
+		defer s.Close()
+		s.RequireUserAuth("account", "secret")
+
+		client, err := newUniversalClient(&config.RedisConfig{
+			Mode:     RedisStandaloneMode,
+			Addrs:    s.Addr(),
+			Username: "account",
+			Password: "secret",
+		})
+		Expect(err).To(BeNil())
+		defer client.Close()
+		Expect(client.Ping(context.Background()).Err()).To(BeNil())
+	})
+	It("should build a client of every mode", func() {
+		client, err := newUniversalClient(&config.RedisConfig{
+			Addrs: mr.Addr(),
+		})
+		Expect(err).To(BeNil())
+		Expect(client).To(BeAssignableToTypeOf(&redis.ClusterClient{}))
+		client.Close()
+
+		client, err = newUniversalClient(&config.RedisConfig{
+			Mode:          RedisSentinelMode,
+			MasterName:    "mymaster",
+			SentinelAddrs: "127.0.0.1:26379,127.0.0.1:26380",
+			DB:            2,
+		})
+		Expect(err).To(BeNil())
+		Expect(client).To(BeAssignableToTypeOf(&redis.Client{}))
+		client.Close()
+	})
+	It("should reject invalid modes", func() {
+		_, err := newUniversalClient(&config.RedisConfig{
+			Mode: "replicated",
+		})
+		Expect(err).To(Equal(ErrUnknownRedisMode))
+		_, err = newUniversalClient(&config.RedisConfig{
+			Mode: RedisClusterMode,
+			DB:   1,
+		})
+		Expect(err).To(Equal(ErrRedisClusterDB))
+		_, err = newUniversalClient(&config.RedisConfig{
+			Mode: RedisSentinelMode,
+		})
+		Expect(err).To(Equal(ErrRedisMasterNameRequired))
+	})
+	It("should build tls configs", func() {
+		tlsConfig, err := newTLSConfig(&config.RedisConfig{})
+		Expect(err).To(BeNil())
+		Expect(tlsConfig).To(BeNil())
+
+		tlsConfig, err = newTLSConfig(&config.RedisConfig{
+			TLSEnabled:    true,
+			TLSServerName: "redis.internal",
+		})
+		Expect(err).To(BeNil())
+		Expect(tlsConfig.ServerName).To(Equal("redis.internal"))
+		Expect(tlsConfig.RootCAs).To(BeNil())
+
+		dir, err := ioutil.TempDir("", "redis-tls")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		caFile := filepath.Join(dir, "ca.pem")
+		Expect(ioutil.WriteFile(caFile, []byte("not a certificate"), 0600)).To(BeNil())
+		_, err = newTLSConfig(&config.RedisConfig{
+			TLSEnabled: true,
+			TLSCAFile:  caFile,
+		})
+		Expect(err).To(Equal(ErrRedisInvalidCA))
+		_, err = newTLSConfig(&config.RedisConfig{
+			TLSEnabled: true,
+			TLSCAFile:  filepath.Join(os.TempDir(), "missing-ca.pem"),
+		})
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+})
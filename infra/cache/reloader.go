@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+	conf "github.com/minghsu0107/saga-account/config"
+	"github.com/sirupsen/logrus"
+)
+
+// configFile is the config file read by conf.NewConfig
+const configFile = "config.yml"
+
+// PolicyReloader reloads cache policies whenever the config file changes
+// it watches the directory of the file, so that atomic replacements such as kubernetes configmap updates are noticed
+type PolicyReloader struct {
+	config  *conf.Config
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+	logger  *logrus.Entry
+}
+
+// NewPolicyReloader is the factory of PolicyReloader
+func NewPolicyReloader(config *conf.Config) (*PolicyReloader, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(filepath.Dir(configFile)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	return &PolicyReloader{
+		config:  config,
+		watcher: watcher,
+		done:    make(chan struct{}),
+		logger:  config.Logger.ContextLogger.WithField("type", "cache:PolicyReloader"),
+	}, nil
+}
+
+// Run reloads cache policies on changes until closed
+func (r *PolicyReloader) Run() error {
+	defer close(r.done)
+	for {
+		select {
+		case event, ok := <-r.watcher.Events:
+			if !ok {
+				return nil
+			}
+			if !r.affectsConfig(event) {
+				continue
+			}
+			if err := r.config.ReloadCachePolicies(); err != nil {
+				r.logger.Error(err.Error())
+				continue
+			}
+			r.logger.Info("cache policies reloaded")
+		case err, ok := <-r.watcher.Errors:
+			if !ok {
+				return nil
+			}
+			r.logger.Error(err.Error())
+		}
+	}
+}
+
+// Close stops watching the config file
+func (r *PolicyReloader) Close() {
+	r.watcher.Close()
+	<-r.done
+}
+
+// affectsConfig reports whether an event may have changed the config file
+// kubernetes swaps the ..data symlink instead of writing the file
+func (r *PolicyReloader) affectsConfig(event fsnotify.Event) bool {
+	if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+		return false
+	}
+	name := filepath.Base(event.Name)
+	return name == configFile || name == "..data"
+}
@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/minghsu0107/saga-account/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+)
+
+var _ = Describe("cache policy reloader", func() {
+	var wd, dir string
+	writeConfig := func(content string) {
+		// replace the file atomically, as kubernetes does
+		tmp := filepath.Join(dir, "config.yml.tmp")
+		Expect(ioutil.WriteFile(tmp, []byte(content), 0644)).To(BeNil())
+		Expect(os.Rename(tmp, filepath.Join(dir, configFile))).To(BeNil())
+	}
+	BeforeEach(func() {
+		var err error
+		wd, err = os.Getwd()
+		Expect(err).To(BeNil())
+		dir, err = ioutil.TempDir("", "reloader")
+		Expect(err).To(BeNil())
+		// the config file is read from the working directory
+		Expect(os.Chdir(dir)).To(BeNil())
+	})
+	AfterEach(func() {
+		Expect(os.Chdir(wd)).To(BeNil())
+		Expect(os.RemoveAll(dir)).To(BeNil())
+	})
+	It("should apply cache policies of a rewritten config file", func() {
+		writeConfig(`
+cacheConfig:
+  default:
+    disabled: true
+  families:
+    cuscheck:
+      redisExpirationSeconds: 30
+`)
+		conf := &config.Config{
+			RedisConfig: &config.RedisConfig{
+				ExpirationSeconds: 60,
+			},
+			Logger: &config.Logger{
+				Writer: ioutil.Discard,
+				ContextLogger: log.WithFields(log.Fields{
+					"app": "test",
+				}),
+			},
+		}
+		Expect(conf.ReloadCachePolicies()).To(BeNil())
+		Expect(conf.CachePolicy("cuscheck").RedisExpirationSeconds).To(Equal(int64(30)))
+		Expect(conf.CachePolicy("cuscheck").IsDisabled()).To(BeTrue())
+
+		reloader, err := NewPolicyReloader(conf)
+		Expect(err).To(BeNil())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			reloader.Run()
+		}()
+		writeConfig(`
+cacheConfig:
+  default:
+    disabled: true
+  families:
+    cuscheck:
+      disabled: false
+      redisExpirationSeconds: 120
+`)
+		Eventually(func() int64 {
+			return conf.CachePolicy("cuscheck").RedisExpirationSeconds
+		}, 2*time.Second).Should(Equal(int64(120)))
+		Expect(conf.CachePolicy("cuscheck").IsDisabled()).To(BeFalse())
+		Expect(conf.CachePolicy("cuscred").IsDisabled()).To(BeTrue())
+		Expect(conf.CacheFamilies()).To(Equal([]string{"cuscheck"}))
+
+		reloader.Close()
+		<-done
+	})
+})
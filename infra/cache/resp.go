@@ -0,0 +1,201 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrUnexpectedReply is the error of a malformed or unexpected redis reply
+var ErrUnexpectedReply = errors.New("unexpected redis reply")
+
+// respError is an error reply of redis
+type respError string
+
+func (e respError) Error() string {
+	return string(e)
+}
+
+// respPush is an out-of-band push message of RESP3
+type respPush []interface{}
+
+// respConn is a RESP3 connection dedicated to receiving push messages
+// go-redis neither speaks RESP3 nor exposes push messages, so client tracking uses its own connection
+type respConn struct {
+	conn net.Conn
+	rd   *bufio.Reader
+	// mu serializes writes, since pings are written while push messages are read
+	mu sync.Mutex
+}
+
+// dialRESP connects to addr, over tls if tlsConfig is not nil
+func dialRESP(ctx context.Context, addr string, tlsConfig *tls.Config, timeout time.Duration) (*respConn, error) {
+	netDialer := &net.Dialer{
+		Timeout: timeout,
+	}
+	var conn net.Conn
+	var err error
+	if tlsConfig != nil {
+		dialer := &tls.Dialer{
+			NetDialer: netDialer,
+			Config:    tlsConfig,
+		}
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = netDialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &respConn{
+		conn: conn,
+		rd:   bufio.NewReader(conn),
+	}, nil
+}
+
+// do sends a command and returns its reply, handing push messages received in between to onPush
+func (c *respConn) do(args []string, onPush func(respPush)) (interface{}, error) {
+	if err := c.send(args...); err != nil {
+		return nil, err
+	}
+	for {
+		reply, err := c.read()
+		if err != nil {
+			return nil, err
+		}
+		if push, ok := reply.(respPush); ok {
+			onPush(push)
+			continue
+		}
+		if err, ok := reply.(respError); ok {
+			return nil, err
+		}
+		return reply, nil
+	}
+}
+
+// send writes a command without waiting for its reply
+func (c *respConn) send(args ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// read reads a reply; aggregates are returned as []interface{}, maps flattened into key-value pairs
+func (c *respConn) read() (interface{}, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, ErrUnexpectedReply
+	}
+	switch line[0] {
+	case '+', ',', '(', '#':
+		return string(line[1:]), nil
+	case '-':
+		return respError(line[1:]), nil
+	case '!':
+		data, err := c.readBlob(line)
+		if err != nil {
+			return nil, err
+		}
+		return respError(data), nil
+	case ':':
+		return strconv.ParseInt(string(line[1:]), 10, 64)
+	case '_':
+		return nil, nil
+	case '$', '=':
+		data, err := c.readBlob(line)
+		if err != nil || data == nil {
+			return nil, err
+		}
+		return string(data), nil
+	case '*', '~', '>':
+		vals, err := c.readAggregate(line, 1)
+		if line[0] == '>' && err == nil {
+			return respPush(vals), nil
+		}
+		return vals, err
+	case '%':
+		return c.readAggregate(line, 2)
+	case '|':
+		// attributes precede the reply they describe
+		if _, err := c.readAggregate(line, 2); err != nil {
+			return nil, err
+		}
+		return c.read()
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnexpectedReply, line)
+}
+
+func (c *respConn) readAggregate(line []byte, width int) ([]interface{}, error) {
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil {
+		return nil, ErrUnexpectedReply
+	}
+	if n < 0 {
+		return nil, nil
+	}
+	vals := make([]interface{}, n*width)
+	for i := range vals {
+		if vals[i], err = c.read(); err != nil {
+			return nil, err
+		}
+	}
+	return vals, nil
+}
+
+func (c *respConn) readBlob(line []byte) ([]byte, error) {
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil {
+		return nil, ErrUnexpectedReply
+	}
+	if n < 0 {
+		return nil, nil
+	}
+	data := make([]byte, n+2)
+	if _, err := io.ReadFull(c.rd, data); err != nil {
+		return nil, err
+	}
+	return data[:n], nil
+}
+
+func (c *respConn) readLine() ([]byte, error) {
+	line, err := c.rd.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, ErrUnexpectedReply
+	}
+	return line[:len(line)-2], nil
+}
+
+// setReadDeadline fails reads that wait longer than timeout
+func (c *respConn) setReadDeadline(timeout time.Duration) error {
+	return c.conn.SetReadDeadline(time.Now().Add(timeout))
+}
+
+func (c *respConn) close() error {
+	return c.conn.Close()
+}
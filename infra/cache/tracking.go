@@ -0,0 +1,401 @@
+package cache
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	conf "github.com/minghsu0107/saga-account/config"
+	"github.com/minghsu0107/saga-account/pkg"
+	"github.com/minghsu0107/saga-account/pkg/health"
+	"github.com/redis/go-redis/v9"
+	log "github.com/sirupsen/logrus"
+)
+
+// local cache invalidation modes
+const (
+	// StreamInvalidation invalidates local entries from InvalidationStream
+	StreamInvalidation = "stream"
+	// TrackingInvalidation invalidates local entries with redis server-assisted client-side caching
+	TrackingInvalidation = "tracking"
+)
+
+const (
+	// trackingPingInterval is how often tracking connections are checked; a connection silent for
+	// three intervals is reconnected
+	trackingPingInterval = 5 * time.Second
+	trackingRetryDelay   = time.Second
+)
+
+var (
+	// ErrUnknownInvalidation is the error of an unknown local cache invalidation mode
+	ErrUnknownInvalidation = errors.New("unknown local cache invalidation")
+	// ErrNoTrackedFamilies is returned if client tracking has no key family to track
+	ErrNoTrackedFamilies = errors.New("client tracking requires key families in cacheConfig")
+	// ErrTrackingUnsupported is returned if the nodes of a redis client cannot be found
+	ErrTrackingUnsupported = errors.New("client tracking requires a redis client or cluster client")
+	// ErrRedisMasterNotFound is returned if no sentinel knows the master
+	ErrRedisMasterNotFound = errors.New("redis master not found by sentinels")
+)
+
+// TrackingLocalCache is a local cache invalidated by redis client tracking
+// redis only promises to push invalidations while the tracking connections are up,
+// so entries are stored only for tracked key families and only while every redis node tracks them
+type TrackingLocalCache struct {
+	*LocalCacheImpl
+	families map[string]bool
+	// prefixes are the key prefixes of the tracked families
+	prefixes []string
+	// tracking is 1 while every redis node tracks the families for this instance
+	tracking int32
+}
+
+func newTrackingLocalCache(config *conf.Config, lc *LocalCacheImpl) (*TrackingLocalCache, error) {
+	families := make(map[string]bool)
+	var prefixes []string
+	for _, family := range config.CacheFamilies() {
+		families[family] = true
+		prefixes = append(prefixes, pkg.Join(family, ":"))
+	}
+	if len(families) == 0 {
+		return nil, ErrNoTrackedFamilies
+	}
+	return &TrackingLocalCache{
+		LocalCacheImpl: lc,
+		families:       families,
+		prefixes:       prefixes,
+	}, nil
+}
+
+// Get returns true if the key already exists and set dst to the corresponding value
+func (lc *TrackingLocalCache) Get(key string, dst interface{}) (bool, error) {
+	if !lc.tracked(key) {
+		return false, nil
+	}
+	return lc.LocalCacheImpl.Get(key, dst)
+}
+
+// Set sets a value by key
+func (lc *TrackingLocalCache) Set(key string, val interface{}) error {
+	if !lc.tracked(key) {
+		return nil
+	}
+	return lc.LocalCacheImpl.Set(key, val)
+}
+
+// GetRaw returns true if the key already exists, together with its encoded value
+func (lc *TrackingLocalCache) GetRaw(key string) (bool, []byte, error) {
+	if !lc.tracked(key) {
+		return false, nil, nil
+	}
+	return lc.LocalCacheImpl.GetRaw(key)
+}
+
+// SetRaw sets an encoded value read at version by key
+func (lc *TrackingLocalCache) SetRaw(key string, val []byte, version int64) error {
+	if !lc.tracked(key) {
+		return nil
+	}
+	return lc.LocalCacheImpl.SetRaw(key, val, version)
+}
+
+func (lc *TrackingLocalCache) tracked(key string) bool {
+	return atomic.LoadInt32(&lc.tracking) == 1 && lc.families[conf.KeyFamily(key)]
+}
+
+func (lc *TrackingLocalCache) setTracking(tracking bool) {
+	var val int32
+	if tracking {
+		val = 1
+	}
+	atomic.StoreInt32(&lc.tracking, val)
+}
+
+// TrackingCleaner invalidates a TrackingLocalCache with redis client tracking in broadcast mode
+// it keeps a RESP3 connection to every redis master, which pushes the keys of tracked families
+// whenever they are modified. the local cache is flushed whenever a connection is restored,
+// since the invalidations pushed while it was down are lost
+type TrackingCleaner struct {
+	client      redis.UniversalClient
+	lc          *TrackingLocalCache
+	redisConfig *conf.RedisConfig
+	tlsConfig   *tls.Config
+	ctx         context.Context
+	cancel      context.CancelFunc
+	done        chan struct{}
+	logger      *log.Entry
+
+	mu        sync.Mutex
+	nodes     int
+	connected map[string]bool
+}
+
+// trackingNode is a redis node to track, whose address may change over time
+type trackingNode struct {
+	name string
+	addr func(ctx context.Context) (string, error)
+}
+
+// NewTrackingCleaner is the factory of TrackingCleaner
+func NewTrackingCleaner(config *conf.Config, client redis.UniversalClient, lc *TrackingLocalCache) (*TrackingCleaner, error) {
+	tlsConfig, err := newTLSConfig(config.RedisConfig)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &TrackingCleaner{
+		client:      client,
+		lc:          lc,
+		redisConfig: config.RedisConfig,
+		tlsConfig:   tlsConfig,
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+		logger:      config.Logger.ContextLogger.WithField("type", "cache:TrackingCleaner"),
+		connected:   make(map[string]bool),
+	}, nil
+}
+
+// SubscribeInvalidationEvent tracks every redis master until closed
+// the masters are looked up until redis is reachable, since redis may be unavailable on start
+func (t *TrackingCleaner) SubscribeInvalidationEvent() error {
+	defer close(t.done)
+	var nodes []trackingNode
+	for {
+		var err error
+		nodes, err = t.trackingNodes(t.ctx)
+		if err == nil {
+			break
+		}
+		if err == ErrTrackingUnsupported {
+			return err
+		}
+		if t.ctx.Err() != nil {
+			return nil
+		}
+		t.logger.Error(err.Error())
+		sleep(t.ctx, trackingRetryDelay)
+	}
+	t.mu.Lock()
+	t.nodes = len(nodes)
+	t.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		wg.Add(1)
+		go func(node trackingNode) {
+			defer wg.Done()
+			t.track(node)
+		}(node)
+	}
+	wg.Wait()
+	return nil
+}
+
+// Close stops tracking
+func (t *TrackingCleaner) Close() {
+	t.cancel()
+	<-t.done
+}
+
+// track keeps a tracking connection to a redis node, reconnecting until closed
+func (t *TrackingCleaner) track(node trackingNode) {
+	for t.ctx.Err() == nil {
+		err := t.trackOnce(node)
+		t.setConnected(node.name, false)
+		if err != nil && t.ctx.Err() == nil {
+			t.logger.WithField("node", node.name).Error(err.Error())
+		}
+		sleep(t.ctx, trackingRetryDelay)
+	}
+}
+
+// trackOnce enables tracking on a new connection and handles its invalidations until it fails
+func (t *TrackingCleaner) trackOnce(node trackingNode) error {
+	addr, err := node.addr(t.ctx)
+	if err != nil {
+		return err
+	}
+	conn, err := dialRESP(t.ctx, addr, t.tlsConfig, millis(t.redisConfig.DialTimeoutMillis))
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	// closing the connection unblocks its reads
+	go func() {
+		select {
+		case <-t.ctx.Done():
+		case <-done:
+		}
+		conn.close()
+	}()
+
+	hello := []string{"HELLO", "3"}
+	if t.redisConfig.Password != "" {
+		username := t.redisConfig.Username
+		if username == "" {
+			username = "default"
+		}
+		hello = append(hello, "AUTH", username, t.redisConfig.Password)
+	}
+	if _, err := conn.do(hello, t.handlePush); err != nil {
+		return err
+	}
+	tracking := []string{"CLIENT", "TRACKING", "ON", "BCAST"}
+	for _, prefix := range t.lc.prefixes {
+		tracking = append(tracking, "PREFIX", prefix)
+	}
+	if _, err := conn.do(tracking, t.handlePush); err != nil {
+		return err
+	}
+	// entries may have missed invalidations while the node was not tracked
+	if err := t.lc.Reset(); err != nil {
+		return err
+	}
+	t.setConnected(node.name, true)
+
+	go t.ping(conn, done)
+	for {
+		if err := conn.setReadDeadline(3 * trackingPingInterval); err != nil {
+			return err
+		}
+		reply, err := conn.read()
+		if err != nil {
+			return err
+		}
+		// other replies answer pings
+		if push, ok := reply.(respPush); ok {
+			t.handlePush(push)
+		}
+	}
+}
+
+// ping keeps a connection busy, so that a broken connection is noticed before its read deadline
+func (t *TrackingCleaner) ping(conn *respConn, done chan struct{}) {
+	ticker := time.NewTicker(trackingPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := conn.send("PING"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handlePush invalidates the keys of an invalidate push message; a null key list means the database was flushed
+func (t *TrackingCleaner) handlePush(push respPush) {
+	if len(push) != 2 || push[0] != "invalidate" {
+		return
+	}
+	keys, _ := push[1].([]interface{})
+	if keys == nil {
+		t.logError(t.lc.Reset())
+		return
+	}
+	for _, key := range keys {
+		if key, ok := key.(string); ok {
+			err := t.lc.Invalidate(key)
+			countInvalidation(TrackingInvalidation, err)
+			t.logError(err)
+		}
+	}
+}
+
+func (t *TrackingCleaner) setConnected(name string, connected bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.connected[name] = connected
+	n := 0
+	for _, ok := range t.connected {
+		if ok {
+			n++
+		}
+	}
+	t.lc.setTracking(n == t.nodes)
+}
+
+// Health implements health.Checker interface
+// entries are not cached locally while any master is untracked, so the service is degraded but ready
+func (t *TrackingCleaner) Health(ctx context.Context) health.Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for _, ok := range t.connected {
+		if ok {
+			n++
+		}
+	}
+	return invalidationHealth(t.nodes > 0 && n == t.nodes)
+}
+
+func (t *TrackingCleaner) logError(err error) {
+	if err == nil {
+		return
+	}
+	t.logger.Error(err.Error())
+}
+
+// trackingNodes returns the redis nodes that modify keys, which are the masters
+// the master of sentinel mode is looked up on every connection, so that a failover is followed
+func (t *TrackingCleaner) trackingNodes(ctx context.Context) ([]trackingNode, error) {
+	if t.redisConfig.Mode == RedisSentinelMode {
+		return []trackingNode{{
+			name: t.redisConfig.MasterName,
+			addr: t.sentinelMaster,
+		}}, nil
+	}
+	switch c := t.client.(type) {
+	case *redis.Client:
+		return []trackingNode{fixedTrackingNode(c.Options().Addr)}, nil
+	case *redis.ClusterClient:
+		var mu sync.Mutex
+		var nodes []trackingNode
+		err := c.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+			mu.Lock()
+			defer mu.Unlock()
+			nodes = append(nodes, fixedTrackingNode(master.Options().Addr))
+			return nil
+		})
+		return nodes, err
+	}
+	return nil, ErrTrackingUnsupported
+}
+
+// sentinelMaster asks the sentinels for the address of the master
+func (t *TrackingCleaner) sentinelMaster(ctx context.Context) (string, error) {
+	err := ErrRedisMasterNotFound
+	for _, addr := range getServerAddrs(t.redisConfig.SentinelAddrs) {
+		sentinel := redis.NewSentinelClient(&redis.Options{
+			Addr:        addr,
+			Username:    t.redisConfig.SentinelUsername,
+			Password:    t.redisConfig.SentinelPassword,
+			DialTimeout: millis(t.redisConfig.DialTimeoutMillis),
+			TLSConfig:   t.tlsConfig,
+		})
+		var master []string
+		master, err = sentinel.GetMasterAddrByName(ctx, t.redisConfig.MasterName).Result()
+		sentinel.Close()
+		if err == nil && len(master) == 2 {
+			return net.JoinHostPort(master[0], master[1]), nil
+		}
+	}
+	return "", err
+}
+
+func fixedTrackingNode(addr string) trackingNode {
+	return trackingNode{
+		name: addr,
+		addr: func(ctx context.Context) (string, error) {
+			return addr, nil
+		},
+	}
+}
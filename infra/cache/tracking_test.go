@@ -0,0 +1,275 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minghsu0107/saga-account/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/redis/go-redis/v9"
+	log "github.com/sirupsen/logrus"
+)
+
+// trackingServer is a fake redis server that accepts client tracking and pushes invalidations on demand
+// it also answers the master address of sentinels if master is set
+type trackingServer struct {
+	listener net.Listener
+	master   string
+	mu       sync.Mutex
+	conns    []net.Conn
+	commands [][]string
+}
+
+func newTrackingServer() *trackingServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	s := &trackingServer{
+		listener: listener,
+	}
+	go s.serve()
+	return s
+}
+
+func (s *trackingServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *trackingServer) handle(conn net.Conn) {
+	rd := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(rd)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, args)
+		switch strings.ToUpper(args[0]) {
+		case "HELLO":
+			io.WriteString(conn, "%1\r\n$6\r\nserver\r\n$5\r\nredis\r\n")
+		case "PING":
+			io.WriteString(conn, "+PONG\r\n")
+		case "SENTINEL":
+			host, port, _ := net.SplitHostPort(s.master)
+			fmt.Fprintf(conn, "*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(host), host, len(port), port)
+		default:
+			io.WriteString(conn, "+OK\r\n")
+		}
+		s.mu.Unlock()
+	}
+}
+
+func readCommand(rd *bufio.Reader) ([]string, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if _, err := rd.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := rd.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(arg, "\r\n")
+	}
+	return args, nil
+}
+
+// invalidate pushes the invalidation of keys to every connection; no keys mean a flush
+func (s *trackingServer) invalidate(keys ...string) {
+	msg := ">2\r\n$10\r\ninvalidate\r\n_\r\n"
+	if len(keys) > 0 {
+		msg = fmt.Sprintf(">2\r\n$10\r\ninvalidate\r\n*%d\r\n", len(keys))
+		for _, key := range keys {
+			msg += fmt.Sprintf("$%d\r\n%s\r\n", len(key), key)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		io.WriteString(conn, msg)
+	}
+}
+
+// drop closes every connection
+func (s *trackingServer) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *trackingServer) tracking() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, args := range s.commands {
+		if strings.ToUpper(args[0]) == "CLIENT" {
+			return args
+		}
+	}
+	return nil
+}
+
+func (s *trackingServer) close() {
+	s.listener.Close()
+	s.drop()
+}
+
+var _ = Describe("client tracking", func() {
+	newConfig := func(families map[string]config.CachePolicy) *config.Config {
+		return &config.Config{
+			LocalCacheConfig: &config.LocalCacheConfig{
+				ExpirationSeconds: 60,
+				Invalidation:      TrackingInvalidation,
+			},
+			RedisConfig: &config.RedisConfig{
+				ExpirationSeconds: 60,
+			},
+			CacheConfig: &config.CacheConfig{
+				Families: families,
+			},
+			Logger: &config.Logger{
+				Writer: ioutil.Discard,
+				ContextLogger: log.WithFields(log.Fields{
+					"app": "test",
+				}),
+			},
+		}
+	}
+	conf := newConfig(map[string]config.CachePolicy{
+		"trackcheck": {},
+		"trackcred":  {},
+	})
+	var (
+		server  *trackingServer
+		lc      LocalCache
+		cleaner LocalCacheCleaner
+	)
+	localHit := func(key string) bool {
+		ok, _, err := lc.GetRaw(key)
+		Expect(err).To(BeNil())
+		return ok
+	}
+	tracking := func() bool {
+		return lc.(*TrackingLocalCache).tracked("trackcheck:0")
+	}
+	BeforeEach(func() {
+		server = newTrackingServer()
+		var err error
+		lc, err = NewLocalCache(conf)
+		Expect(err).To(BeNil())
+		client := redis.NewClient(&redis.Options{
+			Addr: server.listener.Addr().String(),
+		})
+		cleaner, err = NewLocalCacheCleaner(conf, client, lc, nil)
+		Expect(err).To(BeNil())
+		Expect(cleaner).To(BeAssignableToTypeOf(&TrackingCleaner{}))
+	})
+	AfterEach(func() {
+		server.close()
+	})
+	It("should reject unknown modes and missing families", func() {
+		_, err := NewLocalCache(newConfig(nil))
+		Expect(err).To(Equal(ErrNoTrackedFamilies))
+		unknown := newConfig(nil)
+		unknown.LocalCacheConfig.Invalidation = "pubsub"
+		_, err = NewLocalCache(unknown)
+		Expect(err).To(Equal(ErrUnknownInvalidation))
+	})
+	It("should cache tracked families only while tracking", func() {
+		Expect(lc.Set("trackcheck:1", "val")).To(BeNil())
+		Expect(localHit("trackcheck:1")).To(BeFalse())
+
+		go cleaner.SubscribeInvalidationEvent()
+		defer cleaner.Close()
+		Eventually(tracking, time.Second).Should(BeTrue())
+		Expect(server.tracking()).To(Equal([]string{
+			"CLIENT", "TRACKING", "ON", "BCAST", "PREFIX", "trackcheck:", "PREFIX", "trackcred:",
+		}))
+
+		Expect(lc.Set("trackcheck:1", "val")).To(BeNil())
+		Expect(lc.Set("untracked:1", "val")).To(BeNil())
+		Expect(localHit("trackcheck:1")).To(BeTrue())
+		Expect(localHit("untracked:1")).To(BeFalse())
+	})
+	It("should invalidate pushed keys and flush on a flushed database", func() {
+		go cleaner.SubscribeInvalidationEvent()
+		defer cleaner.Close()
+		Eventually(tracking, time.Second).Should(BeTrue())
+
+		Expect(lc.Set("trackcheck:1", "val")).To(BeNil())
+		Expect(lc.Set("trackcheck:2", "val")).To(BeNil())
+		Expect(lc.Set("trackcred:1", "val")).To(BeNil())
+		server.invalidate("trackcheck:1")
+		Eventually(func() bool {
+			return localHit("trackcheck:1")
+		}, time.Second).Should(BeFalse())
+		Expect(localHit("trackcheck:2")).To(BeTrue())
+
+		server.invalidate()
+		Eventually(func() bool {
+			return localHit("trackcheck:2") || localHit("trackcred:1")
+		}, time.Second).Should(BeFalse())
+	})
+	It("should flush entries after reconnecting", func() {
+		go cleaner.SubscribeInvalidationEvent()
+		defer cleaner.Close()
+		Eventually(tracking, time.Second).Should(BeTrue())
+		Expect(lc.Set("trackcheck:1", "val")).To(BeNil())
+
+		server.drop()
+		Eventually(tracking, time.Second).Should(BeFalse())
+		Expect(localHit("trackcheck:1")).To(BeFalse())
+
+		Eventually(tracking, 3*time.Second).Should(BeTrue())
+		Expect(localHit("trackcheck:1")).To(BeFalse())
+	})
+	It("should track the master found by sentinels", func() {
+		sentinel := newTrackingServer()
+		defer sentinel.close()
+		sentinel.master = server.listener.Addr().String()
+		sentinelConf := newConfig(map[string]config.CachePolicy{
+			"trackcheck": {},
+		})
+		sentinelConf.RedisConfig.Mode = RedisSentinelMode
+		sentinelConf.RedisConfig.MasterName = "mymaster"
+		sentinelConf.RedisConfig.SentinelAddrs = sentinel.listener.Addr().String()
+		var err error
+		lc, err = NewLocalCache(sentinelConf)
+		Expect(err).To(BeNil())
+		sentinelCleaner, err := NewLocalCacheCleaner(sentinelConf, nil, lc, nil)
+		Expect(err).To(BeNil())
+
+		go sentinelCleaner.SubscribeInvalidationEvent()
+		defer sentinelCleaner.Close()
+		Eventually(tracking, time.Second).Should(BeTrue())
+		Expect(server.tracking()).To(Equal([]string{
+			"CLIENT", "TRACKING", "ON", "BCAST", "PREFIX", "trackcheck:",
+		}))
+	})
+})
@@ -0,0 +1,210 @@
+package db
+
+import (
+	"context"
+	"strconv"
+
+	conf "github.com/minghsu0107/saga-account/config"
+	"github.com/minghsu0107/saga-account/infra/cache"
+	"github.com/minghsu0107/saga-account/infra/db/model"
+	"github.com/minghsu0107/saga-account/pkg/contact"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const backfillBatchSize = 500
+
+// PhoneBackfiller normalizes phone numbers of existing rows offline
+type PhoneBackfiller struct {
+	db          *gorm.DB
+	rc          cache.RedisCache
+	phoneRegion string
+	logger      *log.Entry
+}
+
+// BackfillReport summarizes a backfill run
+// Invalid lists customers and InvalidAddresses lists address book entries whose phone numbers are invalid
+type BackfillReport struct {
+	Scanned          int
+	Updated          int
+	Invalid          []uint64
+	Collisions       map[string][]uint64
+	AddressesScanned int
+	AddressesUpdated int
+	InvalidAddresses []uint64
+}
+
+type customerPhone struct {
+	ID          uint64
+	PhoneNumber string
+}
+
+// NewPhoneBackfiller is the factory of PhoneBackfiller
+func NewPhoneBackfiller(config *conf.Config, db *gorm.DB, rc cache.RedisCache) *PhoneBackfiller {
+	return &PhoneBackfiller{
+		db:          db,
+		rc:          rc,
+		phoneRegion: config.PhoneRegion,
+		logger:      config.Logger.ContextLogger.WithField("type", "backfill:phone"),
+	}
+}
+
+// Run normalizes customer phone numbers to E.164
+// a customer is skipped and reported if its number is invalid, or if it normalizes to the same number as
+// another customer, since phone numbers are unique; nothing is written when dryRun is set
+// the cached info of updated customers is invalidated, since the version of a customer is bumped
+func (b *PhoneBackfiller) Run(ctx context.Context, dryRun bool) (*BackfillReport, error) {
+	report := &BackfillReport{
+		Collisions: make(map[string][]uint64),
+	}
+	// normalized number -> customers owning it after normalization
+	owners := make(map[string][]customerPhone)
+
+	var lastID uint64
+	for {
+		var batch []customerPhone
+		if err := b.db.WithContext(ctx).Model(&model.Customer{}).Select("id", "phone_number").
+			Where("id > ?", lastID).Order("id").Limit(backfillBatchSize).Find(&batch).Error; err != nil {
+			return nil, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+		for _, customer := range batch {
+			report.Scanned++
+			normalized, err := contact.NormalizePhoneNumber(customer.PhoneNumber, b.phoneRegion)
+			if err != nil {
+				report.Invalid = append(report.Invalid, customer.ID)
+				continue
+			}
+			owners[normalized] = append(owners[normalized], customer)
+		}
+		lastID = batch[len(batch)-1].ID
+	}
+
+	var keys []string
+	for normalized, customers := range owners {
+		if len(customers) > 1 {
+			for _, customer := range customers {
+				report.Collisions[normalized] = append(report.Collisions[normalized], customer.ID)
+			}
+			continue
+		}
+		customer := customers[0]
+		if customer.PhoneNumber == normalized {
+			continue
+		}
+		if !dryRun {
+			if err := b.db.WithContext(ctx).Model(&model.Customer{}).Where("id = ?", customer.ID).
+				Updates(map[string]interface{}{
+					"phone_number": normalized,
+					"version":      gorm.Expr("version + 1"),
+				}).Error; err != nil {
+				return nil, err
+			}
+			keys = append(keys, conf.CustomerInfoKeys(customer.ID)...)
+		}
+		report.Updated++
+	}
+	if err := b.invalidate(ctx, keys); err != nil {
+		return nil, err
+	}
+
+	if err := b.backfillAddresses(ctx, report, dryRun); err != nil {
+		return nil, err
+	}
+
+	b.logger.WithFields(log.Fields{
+		"dry_run":           dryRun,
+		"scanned":           report.Scanned,
+		"updated":           report.Updated,
+		"invalid":           len(report.Invalid),
+		"collisions":        len(report.Collisions),
+		"addresses_scanned": report.AddressesScanned,
+		"addresses_updated": report.AddressesUpdated,
+		"addresses_invalid": len(report.InvalidAddresses),
+	}).Info("phone number backfill finished")
+	for normalized, ids := range report.Collisions {
+		b.logger.WithField("customer_ids", ids).Warn("phone number collision on " + normalized)
+	}
+	if len(report.Invalid) > 0 {
+		b.logger.WithField("customer_ids", report.Invalid).Warn("invalid phone numbers")
+	}
+	if len(report.InvalidAddresses) > 0 {
+		b.logger.WithField("address_ids", report.InvalidAddresses).Warn("invalid address phone numbers")
+	}
+	return report, nil
+}
+
+// backfillAddresses normalizes address book phone numbers in the region of each address
+// address phone numbers are not unique, so they never collide; updating the default address bumps the version
+// of its customer, as any change of the shipping info does
+func (b *PhoneBackfiller) backfillAddresses(ctx context.Context, report *BackfillReport, dryRun bool) error {
+	var lastID uint64
+	for {
+		var batch []model.Address
+		if err := b.db.WithContext(ctx).Select("id", "customer_id", "phone_number", "country", "is_default").
+			Where("id > ?", lastID).Order("id").Limit(backfillBatchSize).Find(&batch).Error; err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		var keys []string
+		for _, address := range batch {
+			report.AddressesScanned++
+			normalized, err := contact.NormalizePhoneNumber(address.PhoneNumber, address.Country)
+			if err != nil {
+				report.InvalidAddresses = append(report.InvalidAddresses, address.ID)
+				continue
+			}
+			if normalized == address.PhoneNumber {
+				continue
+			}
+			report.AddressesUpdated++
+			if dryRun {
+				continue
+			}
+			if err := b.updateAddressPhone(ctx, &address, normalized); err != nil {
+				return err
+			}
+			keys = append(keys, conf.CacheKey(conf.AddressesFamily, strconv.FormatUint(address.CustomerID, 10)))
+			if address.IsDefault {
+				keys = append(keys, conf.CustomerInfoKeys(address.CustomerID)...)
+			}
+		}
+		if err := b.invalidate(ctx, keys); err != nil {
+			return err
+		}
+		lastID = batch[len(batch)-1].ID
+	}
+}
+
+func (b *PhoneBackfiller) updateAddressPhone(ctx context.Context, address *model.Address, phoneNumber string) error {
+	return b.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.Address{}).Where("id = ?", address.ID).
+			Update("phone_number", phoneNumber).Error; err != nil {
+			return err
+		}
+		if !address.IsDefault {
+			return nil
+		}
+		return tx.Model(&model.Customer{}).Where("id = ?", address.CustomerID).
+			Update("version", gorm.Expr("version + 1")).Error
+	})
+}
+
+// invalidate invalidates cached values of updated rows in batches
+func (b *PhoneBackfiller) invalidate(ctx context.Context, keys []string) error {
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > backfillBatchSize {
+			n = backfillBatchSize
+		}
+		if err := b.rc.Invalidate(ctx, keys[:n]...); err != nil {
+			return err
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"context"
+	"io/ioutil"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	conf "github.com/minghsu0107/saga-account/config"
+	"github.com/minghsu0107/saga-account/infra/cache"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+)
+
+// invalidationRecorder records invalidated keys
+type invalidationRecorder struct {
+	cache.RedisCache
+	keys []string
+}
+
+func (r *invalidationRecorder) Invalidate(ctx context.Context, keys ...string) error {
+	r.keys = append(r.keys, keys...)
+	return nil
+}
+
+var _ = Describe("phone backfill", func() {
+	var (
+		mock       sqlmock.Sqlmock
+		rc         *invalidationRecorder
+		backfiller *PhoneBackfiller
+	)
+	BeforeEach(func() {
+		gormDB, dbMock := mockDB()
+		mock = dbMock
+		rc = &invalidationRecorder{}
+		backfiller = NewPhoneBackfiller(&conf.Config{
+			PhoneRegion: "TW",
+			Logger: &conf.Logger{
+				Writer: ioutil.Discard,
+				ContextLogger: log.WithFields(log.Fields{
+					"app": "test",
+				}),
+			},
+		}, gormDB, rc)
+	})
+	AfterEach(func() {
+		Expect(mock.ExpectationsWereMet()).To(BeNil())
+	})
+	// expectRows expects a scan of a table
+	expectRows := func(table string, rows *sqlmock.Rows) {
+		mock.ExpectQuery("SELECT .* FROM `" + table + "`").WillReturnRows(rows)
+	}
+	It("should normalize phone numbers, invalidate cached customers and report invalid numbers", func() {
+		expectRows("customers", sqlmock.NewRows([]string{"id", "phone_number"}).
+			AddRow(1, "0923-456-978").
+			AddRow(2, "+886923456979").
+			AddRow(3, "invalid"))
+		expectRows("customers", sqlmock.NewRows(nil))
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE `customers` SET").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		expectRows("addresses", sqlmock.NewRows([]string{"id", "customer_id", "phone_number", "country", "is_default"}).
+			AddRow(10, 1, "0923 456 978", "TW", true).
+			AddRow(11, 1, "+886923456978", "TW", false).
+			AddRow(12, 2, "invalid", "TW", false))
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE `addresses` SET `phone_number`").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE `customers` SET `version`").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		expectRows("addresses", sqlmock.NewRows(nil))
+
+		report, err := backfiller.Run(context.Background(), false)
+		Expect(err).To(BeNil())
+		Expect(report.Scanned).To(Equal(3))
+		Expect(report.Updated).To(Equal(1))
+		Expect(report.Invalid).To(Equal([]uint64{3}))
+		Expect(report.AddressesScanned).To(Equal(3))
+		Expect(report.AddressesUpdated).To(Equal(1))
+		Expect(report.InvalidAddresses).To(Equal([]uint64{12}))
+		Expect(rc.keys).To(Equal([]string{
+			"cuspersonalinfo:1", "cusshippinginfo:1",
+			"cusaddresses:1", "cuspersonalinfo:1", "cusshippinginfo:1",
+		}))
+	})
+	It("should neither write nor invalidate in a dry run", func() {
+		expectRows("customers", sqlmock.NewRows([]string{"id", "phone_number"}).AddRow(1, "0923-456-978"))
+		expectRows("customers", sqlmock.NewRows(nil))
+		expectRows("addresses", sqlmock.NewRows([]string{"id", "customer_id", "phone_number", "country", "is_default"}).
+			AddRow(10, 1, "0923 456 978", "TW", true))
+		expectRows("addresses", sqlmock.NewRows(nil))
+
+		report, err := backfiller.Run(context.Background(), true)
+		Expect(err).To(BeNil())
+		Expect(report.Updated).To(Equal(1))
+		Expect(report.AddressesUpdated).To(Equal(1))
+		Expect(rc.keys).To(BeEmpty())
+	})
+})
@@ -9,13 +9,28 @@ import (
 
 // NewDatabaseConnection returns the db connection instance
 func NewDatabaseConnection(config *conf.Config) (*gorm.DB, error) {
-	db, err := gorm.Open(mysql.Open(config.DBConfig.Dsn), &gorm.Config{
-		Logger:      config.Logger.DBLogger,
-		PrepareStmt: true,
+	db, err := open(config, mysql.Open(config.DBConfig.Dsn), false)
+	if err != nil {
+		return nil, err
+	}
+	config.Logger.ContextLogger.WithField("type", "setup:db").Info("successful SQL connection")
+	return db, nil
+}
+
+// open opens a connection pool with the pool settings of config
+// the pool is not connected until its first query if lazy is set
+func open(config *conf.Config, dialector gorm.Dialector, lazy bool) (*gorm.DB, error) {
+	db, err := gorm.Open(dialector, &gorm.Config{
+		Logger:               config.Logger.DBLogger,
+		PrepareStmt:          true,
+		DisableAutomaticPing: lazy,
 	})
 	if err != nil {
 		return nil, err
 	}
+	if err := db.Use(TracingPlugin{}); err != nil {
+		return nil, err
+	}
 	sqlDB, err := db.DB()
 	if err != nil {
 		return nil, err
@@ -25,6 +40,5 @@ func NewDatabaseConnection(config *conf.Config) (*gorm.DB, error) {
 
 	// SetMaxOpenConns sets the maximum number of open connections to the database.
 	sqlDB.SetMaxOpenConns(config.DBConfig.MaxOpenConns)
-	config.Logger.ContextLogger.WithField("type", "setup:db").Info("successful SQL connection")
 	return db, nil
 }
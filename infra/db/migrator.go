@@ -19,5 +19,5 @@ func NewMigrator(db *gorm.DB) *Migrator {
 
 // Migrate method migrates db schemas
 func (m *Migrator) Migrate() error {
-	return m.db.AutoMigrate(&model.Customer{})
+	return m.db.AutoMigrate(&model.Customer{}, &model.Address{}, &model.AuditLog{}, &model.OutboxEvent{}, &model.ProcessedSagaCommand{})
 }
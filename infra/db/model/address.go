@@ -0,0 +1,18 @@
+package model
+
+// Address data model
+type Address struct {
+	ID          uint64 `gorm:"primaryKey"`
+	CustomerID  uint64 `gorm:"index;not null"`
+	Recipient   string `gorm:"type:varchar(100);not null"`
+	PhoneNumber string `gorm:"type:varchar(20);not null"`
+	Line1       string `gorm:"type:varchar(255);not null"`
+	Line2       string `gorm:"type:varchar(255);not null"`
+	City        string `gorm:"type:varchar(100);not null"`
+	Region      string `gorm:"type:varchar(100);not null"`
+	PostalCode  string `gorm:"type:varchar(20);not null"`
+	Country     string `gorm:"type:char(2);not null"`
+	IsDefault   bool   `gorm:"not null;default:false"`
+	UpdatedAt   int64  `gorm:"autoUpdateTime:milli"`
+	CreatedAt   int64  `gorm:"autoCreateTime:milli"`
+}
@@ -0,0 +1,15 @@
+package model
+
+// AuditLog data model
+// rows are append-only; Changes is a JSON object of redacted before/after values
+type AuditLog struct {
+	ID         uint64 `gorm:"primaryKey"`
+	CustomerID uint64 `gorm:"index;not null"`
+	Type       string `gorm:"type:varchar(50);index;not null"`
+	Actor      string `gorm:"type:varchar(50);not null"`
+	IP         string `gorm:"type:varchar(45);not null"`
+	UserAgent  string `gorm:"type:varchar(255);not null"`
+	RequestID  string `gorm:"type:varchar(128);index;not null;default:''"`
+	Changes    string `gorm:"type:text;not null"`
+	CreatedAt  int64  `gorm:"autoCreateTime:milli"`
+}
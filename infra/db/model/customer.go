@@ -10,6 +10,7 @@ type Customer struct {
 	Address          string `gorm:"type:text;not null"`
 	PhoneNumber      string `gorm:"type:varchar(20);unique;not null"`
 	BcryptedPassword string `gorm:"type:binary(60);not null"`
+	Version          uint64 `gorm:"not null;default:1"`
 	UpdatedAt        int64  `gorm:"autoUpdateTime:milli"`
 	CreatedAt        int64  `gorm:"autoCreateTime:milli"`
 }
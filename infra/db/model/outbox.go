@@ -0,0 +1,12 @@
+package model
+
+// OutboxEvent data model
+// rows are written in the same transaction as the customer change they describe and deleted once published
+type OutboxEvent struct {
+	ID         uint64 `gorm:"primaryKey;autoIncrement"`
+	CustomerID uint64 `gorm:"not null"`
+	Type       string `gorm:"type:varchar(50);not null"`
+	RequestID  string `gorm:"type:varchar(128);not null;default:''"`
+	Payload    string `gorm:"type:text;not null"`
+	CreatedAt  int64  `gorm:"autoCreateTime:milli"`
+}
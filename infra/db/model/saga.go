@@ -0,0 +1,10 @@
+package model
+
+// ProcessedSagaCommand data model
+// it keeps the reply of every handled saga command, so that redelivered commands get the same reply
+type ProcessedSagaCommand struct {
+	SagaID      string `gorm:"type:varchar(64);primaryKey"`
+	CommandType string `gorm:"type:varchar(50);primaryKey"`
+	Reply       string `gorm:"type:text;not null"`
+	CreatedAt   int64  `gorm:"autoCreateTime:milli"`
+}
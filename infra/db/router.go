@@ -0,0 +1,448 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	conf "github.com/minghsu0107/saga-account/config"
+	"github.com/minghsu0107/saga-account/infra/cache"
+	"github.com/minghsu0107/saga-account/pkg"
+	"github.com/minghsu0107/saga-account/pkg/health"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+const (
+	primaryPool                 = "primary"
+	defaultReplicaMaxLag        = 5 * time.Second
+	defaultReplicaCheckInterval = 5 * time.Second
+)
+
+var errReplicationStopped = errors.New("replication is stopped")
+
+var (
+	replicaHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "account_db_replica_healthy",
+		Help: "Whether a database replica serves reads (1) or is ejected (0).",
+	}, []string{"pool"})
+	replicaLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "account_db_replica_lag_seconds",
+		Help: "The replication lag of a database replica at its last health check.",
+	}, []string{"pool"})
+	routedReads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "account_db_routed_reads_total",
+		Help: "The number of repository reads routed to each database pool.",
+	}, []string{"pool"})
+)
+
+// Router routes repository reads to healthy replicas and writes to the primary
+// a replica is ejected while it is unreachable, stopped replicating or lags more than the maximum lag, and
+// reads fall back to the primary if no replica is healthy. reads of keys written by any instance within
+// the read-your-writes window are routed to the primary, which bounds the staleness of a replica it observes.
+// written keys are marked in redis, so that another instance does not fill the shared cache from a lagging replica
+type Router struct {
+	primary  *gorm.DB
+	rc       cache.RedisCache
+	replicas []*replica
+	next     uint32
+	maxLag   time.Duration
+	interval time.Duration
+	window   time.Duration
+	mu       sync.Mutex
+	written  map[string]time.Time
+	now      func() time.Time
+	quit     chan struct{}
+	done     chan struct{}
+	logger   *log.Entry
+}
+
+type replica struct {
+	name    string
+	db      *gorm.DB
+	healthy int32
+}
+
+// NewRouter is the factory of Router
+// replicas are connected lazily, so that an unreachable replica is ejected instead of failing the startup.
+// the health of the primary and of the replicas is registered to registry
+func NewRouter(config *conf.Config, primary *gorm.DB, registry *health.Registry, rc cache.RedisCache) (*Router, error) {
+	var replicas []*gorm.DB
+	for _, dsn := range strings.Split(config.DBConfig.ReplicaDsns, ",") {
+		if dsn = strings.TrimSpace(dsn); dsn == "" {
+			continue
+		}
+		db, err := open(config, mysql.New(mysql.Config{
+			DSN:                       dsn,
+			SkipInitializeWithVersion: true,
+		}), true)
+		if err != nil {
+			return nil, err
+		}
+		replicas = append(replicas, db)
+	}
+	r := newRouter(config, primary, replicas, rc)
+	registry.Register(health.CheckerFunc(r.primaryHealth), r)
+	return r, nil
+}
+
+// newRouter returns a router tracking written keys in rc, or in this instance only if rc is nil
+func newRouter(config *conf.Config, primary *gorm.DB, replicas []*gorm.DB, rc cache.RedisCache) *Router {
+	dbConfig := config.DBConfig
+	r := &Router{
+		primary:  primary,
+		rc:       rc,
+		maxLag:   defaultReplicaMaxLag,
+		interval: defaultReplicaCheckInterval,
+		written:  make(map[string]time.Time),
+		now:      time.Now,
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+		logger:   config.Logger.ContextLogger.WithField("type", "db:Router"),
+	}
+	if dbConfig.ReplicaMaxLagSeconds > 0 {
+		r.maxLag = time.Duration(dbConfig.ReplicaMaxLagSeconds) * time.Second
+	}
+	if dbConfig.ReplicaCheckIntervalSeconds > 0 {
+		r.interval = time.Duration(dbConfig.ReplicaCheckIntervalSeconds) * time.Second
+	}
+	// a healthy replica lags at most the maximum lag, plus the lag gained until its next check
+	r.window = r.maxLag + r.interval
+	if dbConfig.ReadYourWritesSeconds > 0 {
+		r.window = time.Duration(dbConfig.ReadYourWritesSeconds) * time.Second
+	}
+	pools.add(primaryPool, primary)
+	for i, db := range replicas {
+		rep := &replica{
+			name: fmt.Sprintf("replica-%d", i+1),
+			db:   db,
+		}
+		// replicas serve no reads until their first health check
+		replicaHealthy.WithLabelValues(rep.name).Set(0)
+		pools.add(rep.name, db)
+		r.replicas = append(r.replicas, rep)
+	}
+	return r
+}
+
+// Reader returns the pool serving reads of the given keys
+func (r *Router) Reader(ctx context.Context, keys ...string) *gorm.DB {
+	if len(r.replicas) == 0 {
+		return r.primary
+	}
+	if !r.recentlyWritten(ctx, keys) {
+		n := uint32(len(r.replicas))
+		start := atomic.AddUint32(&r.next, 1)
+		for i := uint32(0); i < n; i++ {
+			rep := r.replicas[(start+i)%n]
+			if atomic.LoadInt32(&rep.healthy) == 1 {
+				routedReads.WithLabelValues(rep.name).Inc()
+				return rep.db
+			}
+		}
+	}
+	routedReads.WithLabelValues(primaryPool).Inc()
+	return r.primary
+}
+
+// Writer returns the pool serving writes, and reads that must observe the latest writes
+func (r *Router) Writer() *gorm.DB {
+	return r.primary
+}
+
+// MarkWritten routes reads of the given keys to the primary for the read-your-writes window
+// it should be called before the cache entries of the keys are invalidated
+func (r *Router) MarkWritten(ctx context.Context, keys ...string) {
+	if len(r.replicas) == 0 {
+		return
+	}
+	expiry := r.now().Add(r.window)
+	r.mu.Lock()
+	for _, key := range keys {
+		r.written[key] = expiry
+	}
+	r.mu.Unlock()
+	if r.rc == nil {
+		return
+	}
+	for _, key := range keys {
+		if err := r.rc.SetRaw(ctx, writtenKey(key), []byte{1}, r.window); err != nil {
+			r.logger.WithContext(ctx).Error(err.Error())
+		}
+	}
+}
+
+// recentlyWritten reports whether any of the keys was written within the read-your-writes window
+// keys are looked up in redis unless this instance wrote them; a failed lookup routes the read to the primary
+func (r *Router) recentlyWritten(ctx context.Context, keys []string) bool {
+	now := r.now()
+	r.mu.Lock()
+	for _, key := range keys {
+		if expiry, ok := r.written[key]; ok && now.Before(expiry) {
+			r.mu.Unlock()
+			return true
+		}
+	}
+	r.mu.Unlock()
+	if r.rc == nil {
+		return false
+	}
+	for _, key := range keys {
+		ok, _, _, err := r.rc.GetRaw(ctx, writtenKey(key))
+		if err != nil {
+			r.logger.WithContext(ctx).Error(err.Error())
+			return true
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func writtenKey(key string) string {
+	return pkg.Join("dbwritten:", key)
+}
+
+// Run checks the health of replicas periodically until Close is called
+func (r *Router) Run() error {
+	defer close(r.done)
+	if len(r.replicas) == 0 {
+		<-r.quit
+		return nil
+	}
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		r.CheckReplicas(context.Background())
+		r.prune()
+		select {
+		case <-r.quit:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close stops the health checks and closes the replica pools
+func (r *Router) Close() {
+	close(r.quit)
+	<-r.done
+	for _, rep := range r.replicas {
+		if sqlDB, err := rep.db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	}
+}
+
+// CheckReplicas ejects replicas that are unreachable or lag too much, and readmits those that recovered
+func (r *Router) CheckReplicas(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, rep := range r.replicas {
+		wg.Add(1)
+		go func(rep *replica) {
+			defer wg.Done()
+			r.check(ctx, rep)
+		}(rep)
+	}
+	wg.Wait()
+}
+
+func (r *Router) check(ctx context.Context, rep *replica) {
+	ctx, cancel := context.WithTimeout(ctx, r.interval)
+	defer cancel()
+	var healthy int32
+	lag, err := replicationLag(ctx, rep.db)
+	if err == nil {
+		replicaLag.WithLabelValues(rep.name).Set(lag.Seconds())
+	}
+	switch {
+	case err != nil:
+		err = fmt.Errorf("health check of %s failed: %w", rep.name, err)
+	case lag > r.maxLag:
+		err = fmt.Errorf("%s lags %s behind the primary", rep.name, lag)
+	default:
+		healthy = 1
+	}
+	replicaHealthy.WithLabelValues(rep.name).Set(float64(healthy))
+	if atomic.SwapInt32(&rep.healthy, healthy) == healthy {
+		return
+	}
+	if healthy == 1 {
+		r.logger.Infof("%s is readmitted", rep.name)
+	} else {
+		r.logger.Warnf("%s is ejected: %v", rep.name, err)
+	}
+}
+
+// prune forgets keys written before the read-your-writes window
+func (r *Router) prune() {
+	now := r.now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, expiry := range r.written {
+		if !now.Before(expiry) {
+			delete(r.written, key)
+		}
+	}
+}
+
+// primaryHealth pings the primary, without which no request can be served
+func (r *Router) primaryHealth(ctx context.Context) health.Status {
+	status := health.Status{
+		Name:  "db-primary",
+		State: "up",
+		Ready: true,
+	}
+	sqlDB, err := r.primary.DB()
+	if err == nil {
+		err = sqlDB.PingContext(ctx)
+	}
+	if err != nil {
+		status.State = "down: " + err.Error()
+		status.Ready = false
+	}
+	return status
+}
+
+// Health implements health.Checker interface
+// reads are served by the primary while replicas are ejected, so the service is degraded but ready
+func (r *Router) Health(ctx context.Context) health.Status {
+	if len(r.replicas) == 0 {
+		return health.Status{
+			Name:  "db-replicas",
+			State: "disabled",
+			Ready: true,
+		}
+	}
+	healthy := 0
+	for _, rep := range r.replicas {
+		if atomic.LoadInt32(&rep.healthy) == 1 {
+			healthy++
+		}
+	}
+	return health.Status{
+		Name:     "db-replicas",
+		State:    fmt.Sprintf("%d/%d healthy", healthy, len(r.replicas)),
+		Ready:    true,
+		Degraded: healthy < len(r.replicas),
+	}
+}
+
+// replicationLag returns how far a replica lags behind its source
+// a server without replication status, such as a standalone server, is considered up to date
+func replicationLag(ctx context.Context, db *gorm.DB) (time.Duration, error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return 0, err
+	}
+	if err := sqlDB.PingContext(ctx); err != nil {
+		return 0, err
+	}
+	rows, err := sqlDB.QueryContext(ctx, "SHOW REPLICA STATUS")
+	if err != nil {
+		// SHOW REPLICA STATUS is supported since mysql 8.0.22
+		rows, err = sqlDB.QueryContext(ctx, "SHOW SLAVE STATUS")
+		if err != nil {
+			return 0, err
+		}
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	if !rows.Next() {
+		return 0, rows.Err()
+	}
+	vals := make([]sql.RawBytes, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range vals {
+		dest[i] = &vals[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return 0, err
+	}
+	for i, column := range columns {
+		if column != "Seconds_Behind_Source" && column != "Seconds_Behind_Master" {
+			continue
+		}
+		if vals[i] == nil {
+			return 0, errReplicationStopped
+		}
+		seconds, err := strconv.ParseInt(string(vals[i]), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return 0, errors.New("replication status has no lag")
+}
+
+// poolCollector exports the connection statistics of every database pool
+type poolCollector struct {
+	mu          sync.Mutex
+	pools       map[string]*sql.DB
+	connections *prometheus.Desc
+	maxOpen     *prometheus.Desc
+	waits       *prometheus.Desc
+	waitSeconds *prometheus.Desc
+}
+
+var pools = &poolCollector{
+	pools: make(map[string]*sql.DB),
+	connections: prometheus.NewDesc("account_db_pool_connections",
+		"The number of connections of a database pool by state.", []string{"pool", "state"}, nil),
+	maxOpen: prometheus.NewDesc("account_db_pool_max_open_connections",
+		"The maximum number of open connections of a database pool.", []string{"pool"}, nil),
+	waits: prometheus.NewDesc("account_db_pool_waits_total",
+		"The number of connections waited for in a database pool.", []string{"pool"}, nil),
+	waitSeconds: prometheus.NewDesc("account_db_pool_wait_seconds_total",
+		"The total time blocked waiting for connections of a database pool.", []string{"pool"}, nil),
+}
+
+func init() {
+	prometheus.MustRegister(pools)
+}
+
+// add exports the statistics of a pool, replacing the pool previously added with the same name
+func (c *poolCollector) add(name string, db *gorm.DB) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pools[name] = sqlDB
+}
+
+// Describe implements prometheus.Collector interface
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.connections
+	ch <- c.maxOpen
+	ch <- c.waits
+	ch <- c.waitSeconds
+}
+
+// Collect implements prometheus.Collector interface
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, sqlDB := range c.pools {
+		stats := sqlDB.Stats()
+		ch <- prometheus.MustNewConstMetric(c.connections, prometheus.GaugeValue, float64(stats.InUse), name, "in_use")
+		ch <- prometheus.MustNewConstMetric(c.connections, prometheus.GaugeValue, float64(stats.Idle), name, "idle")
+		ch <- prometheus.MustNewConstMetric(c.maxOpen, prometheus.GaugeValue, float64(stats.MaxOpenConnections), name)
+		ch <- prometheus.MustNewConstMetric(c.waits, prometheus.CounterValue, float64(stats.WaitCount), name)
+		ch <- prometheus.MustNewConstMetric(c.waitSeconds, prometheus.CounterValue, stats.WaitDuration.Seconds(), name)
+	}
+}
@@ -0,0 +1,187 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	conf "github.com/minghsu0107/saga-account/config"
+	"github.com/minghsu0107/saga-account/infra/cache"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/redis/go-redis/v9"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestDB(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "db suite")
+}
+
+// mockDB returns a pool backed by a sql mock expecting pings
+func mockDB() (*gorm.DB, sqlmock.Sqlmock) {
+	sqlDB, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	Expect(err).To(BeNil())
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      sqlDB,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{
+		DisableAutomaticPing: true,
+	})
+	Expect(err).To(BeNil())
+	return db, mock
+}
+
+// expectLag expects a health check of a replica lagging lag seconds, or stopped replicating if lag is nil
+func expectLag(mock sqlmock.Sqlmock, lag interface{}) {
+	mock.ExpectPing()
+	mock.ExpectQuery("SHOW REPLICA STATUS").WillReturnRows(
+		sqlmock.NewRows([]string{"Replica_IO_State", "Seconds_Behind_Source"}).AddRow("Waiting for source to send event", lag))
+}
+
+var _ = Describe("read replica router", func() {
+	routerConfig := &conf.Config{
+		DBConfig: &conf.DBConfig{
+			ReplicaMaxLagSeconds:        5,
+			ReplicaCheckIntervalSeconds: 1,
+			ReadYourWritesSeconds:       10,
+		},
+		RedisConfig: &conf.RedisConfig{},
+		Logger: &conf.Logger{
+			Writer: ioutil.Discard,
+			ContextLogger: log.WithFields(log.Fields{
+				"app": "test",
+			}),
+		},
+	}
+	ctx := context.Background()
+	var (
+		primary  *gorm.DB
+		replicas []*gorm.DB
+		mocks    []sqlmock.Sqlmock
+		r        *Router
+	)
+	BeforeEach(func() {
+		primary, _ = mockDB()
+		replicas, mocks = nil, nil
+		for i := 0; i < 2; i++ {
+			db, mock := mockDB()
+			replicas = append(replicas, db)
+			mocks = append(mocks, mock)
+		}
+		r = newRouter(routerConfig, primary, replicas, nil)
+	})
+	AfterEach(func() {
+		for _, mock := range mocks {
+			Expect(mock.ExpectationsWereMet()).To(BeNil())
+		}
+	})
+	It("should route reads to healthy replicas once checked", func() {
+		Expect(r.Reader(ctx, "customer:1")).To(BeIdenticalTo(primary))
+		Expect(r.Health(context.Background()).Degraded).To(BeTrue())
+
+		expectLag(mocks[0], "0")
+		expectLag(mocks[1], "3")
+		r.CheckReplicas(context.Background())
+		first, second := r.Reader(ctx, "customer:1"), r.Reader(ctx, "customer:1")
+		Expect([]*gorm.DB{first, second}).To(ConsistOf(replicas[0], replicas[1]))
+		Expect(r.Writer()).To(BeIdenticalTo(primary))
+		Expect(r.Health(context.Background()).State).To(Equal("2/2 healthy"))
+		Expect(r.Health(context.Background()).Degraded).To(BeFalse())
+	})
+	It("should eject lagging, stopped and unreachable replicas", func() {
+		expectLag(mocks[0], "6")
+		expectLag(mocks[1], nil)
+		r.CheckReplicas(context.Background())
+		Expect(r.Reader(ctx, "customer:1")).To(BeIdenticalTo(primary))
+		Expect(r.Health(context.Background()).Ready).To(BeTrue())
+		Expect(r.Health(context.Background()).State).To(Equal("0/2 healthy"))
+
+		mocks[0].ExpectPing().WillReturnError(errors.New("connection refused"))
+		expectLag(mocks[1], "1")
+		r.CheckReplicas(context.Background())
+		for i := 0; i < 3; i++ {
+			Expect(r.Reader(ctx, "customer:1")).To(BeIdenticalTo(replicas[1]))
+		}
+	})
+	It("should read the lag of replicas without SHOW REPLICA STATUS", func() {
+		for _, mock := range mocks {
+			mock.ExpectPing()
+			mock.ExpectQuery("SHOW REPLICA STATUS").WillReturnError(errors.New("You have an error in your SQL syntax"))
+			mock.ExpectQuery("SHOW SLAVE STATUS").WillReturnRows(
+				sqlmock.NewRows([]string{"Slave_IO_State", "Seconds_Behind_Master"}).AddRow("Waiting for master to send event", "0"))
+		}
+		r.CheckReplicas(context.Background())
+		Expect(r.Reader(ctx, "customer:1")).NotTo(BeIdenticalTo(primary))
+	})
+	It("should route reads of recently written keys to the primary", func() {
+		expectLag(mocks[0], "0")
+		expectLag(mocks[1], "0")
+		r.CheckReplicas(context.Background())
+
+		r.MarkWritten(ctx, "customer:1", "email:ming@test.com")
+		Expect(r.Reader(ctx, "customer:1")).To(BeIdenticalTo(primary))
+		Expect(r.Reader(ctx, "email:ming@test.com")).To(BeIdenticalTo(primary))
+		Expect(r.Reader(ctx, "customer:2")).NotTo(BeIdenticalTo(primary))
+
+		now := time.Now()
+		r.now = func() time.Time {
+			return now.Add(10 * time.Second)
+		}
+		Expect(r.Reader(ctx, "customer:1")).NotTo(BeIdenticalTo(primary))
+		r.prune()
+		Expect(r.written).To(BeEmpty())
+	})
+	It("should route reads of keys written by another instance to the primary", func() {
+		mr, err := miniredis.Run()
+		Expect(err).To(BeNil())
+		defer mr.Close()
+		client := redis.NewClient(&redis.Options{
+			Addr: mr.Addr(),
+		})
+		defer client.Close()
+		rc, err := cache.NewRedisCache(routerConfig, client)
+		Expect(err).To(BeNil())
+
+		// two instances share the primary, the replicas and redis
+		writer := newRouter(routerConfig, primary, replicas, rc)
+		reader := newRouter(routerConfig, primary, replicas, rc)
+		for _, mock := range mocks {
+			expectLag(mock, "0")
+			expectLag(mock, "0")
+		}
+		writer.CheckReplicas(ctx)
+		reader.CheckReplicas(ctx)
+
+		writer.MarkWritten(ctx, "customer:1", "email:ming@test.com")
+		Expect(reader.Reader(ctx, "customer:1")).To(BeIdenticalTo(primary))
+		Expect(reader.Reader(ctx, "email:ming@test.com")).To(BeIdenticalTo(primary))
+		Expect(reader.Reader(ctx, "customer:2")).NotTo(BeIdenticalTo(primary))
+
+		mr.FastForward(10 * time.Second)
+		Expect(reader.Reader(ctx, "customer:1")).NotTo(BeIdenticalTo(primary))
+	})
+	It("should route reads to the primary if written keys cannot be looked up", func() {
+		mr, err := miniredis.Run()
+		Expect(err).To(BeNil())
+		client := redis.NewClient(&redis.Options{
+			Addr: mr.Addr(),
+		})
+		defer client.Close()
+		rc, err := cache.NewRedisCache(routerConfig, client)
+		Expect(err).To(BeNil())
+		r = newRouter(routerConfig, primary, replicas, rc)
+		expectLag(mocks[0], "0")
+		expectLag(mocks[1], "0")
+		r.CheckReplicas(ctx)
+
+		mr.Close()
+		Expect(r.Reader(ctx, "customer:1")).To(BeIdenticalTo(primary))
+	})
+})
@@ -0,0 +1,89 @@
+package db
+
+import (
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const (
+	tracerName = "github.com/minghsu0107/saga-account/infra/db"
+	spanKey    = "otel:span"
+)
+
+// TracingPlugin is a gorm plugin tracing every statement with a client span
+// a span is a child of the span in the context of the statement, which repositories set with WithContext.
+// statements are traced with their placeholders, so that values are never exported
+type TracingPlugin struct{}
+
+// Name implements gorm.Plugin interface
+func (TracingPlugin) Name() string {
+	return "otel"
+}
+
+// Initialize implements gorm.Plugin interface
+// spans start before and end after every other callback, so that they cover transactions and hooks
+func (p TracingPlugin) Initialize(db *gorm.DB) error {
+	callback := db.Callback()
+	processors := []struct {
+		operation string
+		before    func(name string, fn func(*gorm.DB)) error
+		after     func(name string, fn func(*gorm.DB)) error
+	}{
+		{"create", callback.Create().Before("*").Register, callback.Create().After("*").Register},
+		{"query", callback.Query().Before("*").Register, callback.Query().After("*").Register},
+		{"update", callback.Update().Before("*").Register, callback.Update().After("*").Register},
+		{"delete", callback.Delete().Before("*").Register, callback.Delete().After("*").Register},
+		{"row", callback.Row().Before("*").Register, callback.Row().After("*").Register},
+		{"raw", callback.Raw().Before("*").Register, callback.Raw().After("*").Register},
+	}
+	for _, processor := range processors {
+		if err := processor.before("otel:before_"+processor.operation, p.start(processor.operation)); err != nil {
+			return err
+		}
+		if err := processor.after("otel:after_"+processor.operation, p.end); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (TracingPlugin) start(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		if db.Statement.Context == nil {
+			return
+		}
+		_, span := otel.Tracer(tracerName).Start(db.Statement.Context, "gorm."+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemMySQL, semconv.DBOperationKey.String(operation)),
+		)
+		db.InstanceSet(spanKey, span)
+	}
+}
+
+func (TracingPlugin) end(db *gorm.DB) {
+	v, ok := db.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	span := v.(trace.Span)
+	defer span.End()
+
+	attrs := []attribute.KeyValue{
+		semconv.DBStatementKey.String(db.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", db.RowsAffected),
+	}
+	if db.Statement.Table != "" {
+		attrs = append(attrs, semconv.DBSQLTableKey.String(db.Statement.Table))
+	}
+	span.SetAttributes(attrs...)
+	if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+		span.RecordError(db.Error)
+		span.SetStatus(codes.Error, db.Error.Error())
+	}
+}
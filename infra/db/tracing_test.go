@@ -0,0 +1,76 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+type tracedRow struct {
+	ID   uint64
+	Name string
+}
+
+var _ = Describe("tracing plugin", func() {
+	var exporter *tracetest.InMemoryExporter
+	BeforeEach(func() {
+		exporter = tracetest.NewInMemoryExporter()
+		otel.SetTracerProvider(tracesdk.NewTracerProvider(tracesdk.WithSyncer(exporter)))
+	})
+	AfterEach(func() {
+		otel.SetTracerProvider(tracesdk.NewTracerProvider())
+	})
+	attributes := func(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+		attrs := make(map[attribute.Key]attribute.Value)
+		for _, kv := range span.Attributes {
+			attrs[kv.Key] = kv.Value
+		}
+		return attrs
+	}
+	It("should trace statements as children of the span in their context", func() {
+		db, mock := mockDB()
+		Expect(db.Use(TracingPlugin{})).To(BeNil())
+		mock.ExpectQuery("SELECT \\* FROM `traced_rows`").WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "ming"))
+
+		ctx, parent := otel.Tracer("test").Start(context.Background(), "parent")
+		var row tracedRow
+		Expect(db.WithContext(ctx).Where("id = ?", 1).Take(&row).Error).To(BeNil())
+		parent.End()
+		Expect(mock.ExpectationsWereMet()).To(BeNil())
+
+		spans := exporter.GetSpans()
+		Expect(spans).To(HaveLen(2))
+		span := spans[0]
+		Expect(span.Name).To(Equal("gorm.query"))
+		Expect(span.Parent.SpanID()).To(Equal(parent.SpanContext().SpanID()))
+		attrs := attributes(span)
+		Expect(attrs["db.system"].AsString()).To(Equal("mysql"))
+		Expect(attrs["db.sql.table"].AsString()).To(Equal("traced_rows"))
+		// values are bound to placeholders and never exported
+		Expect(attrs["db.statement"].AsString()).To(ContainSubstring("id = ?"))
+		Expect(span.Status.Code).To(Equal(codes.Unset))
+	})
+	It("should record failed statements", func() {
+		db, mock := mockDB()
+		Expect(db.Use(TracingPlugin{})).To(BeNil())
+		errQuery := errors.New("connection refused")
+		mock.ExpectQuery("SELECT \\* FROM `traced_rows`").WillReturnError(errQuery)
+
+		var rows []tracedRow
+		Expect(db.WithContext(context.Background()).Find(&rows).Error).To(Equal(errQuery))
+
+		spans := exporter.GetSpans()
+		Expect(spans).To(HaveLen(1))
+		Expect(spans[0].Status.Code).To(Equal(codes.Error))
+		Expect(spans[0].Events).To(HaveLen(1))
+	})
+})
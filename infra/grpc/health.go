@@ -0,0 +1,78 @@
+package grpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/minghsu0107/saga-account/pkg/health"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// authServiceName is the name of the auth service in health checks
+const authServiceName = "auth.AuthService"
+
+// watchInterval is the interval between checks of a health watch
+const watchInterval = 5 * time.Second
+
+// healthServer implements the grpc.health.v1 service from the health of the dependencies
+// the empty service name stands for the whole server
+type healthServer struct {
+	healthpb.UnimplementedHealthServer
+	registry *health.Registry
+}
+
+// Check returns the serving status of a service
+func (h *healthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	if !knownService(req.Service) {
+		return nil, status.Errorf(codes.NotFound, "unknown service %s", req.Service)
+	}
+	return &healthpb.HealthCheckResponse{
+		Status: h.servingStatus(ctx),
+	}, nil
+}
+
+// Watch streams the serving status of a service whenever it changes
+// the stream ends once the server shuts down, so that it does not block the graceful stop
+func (h *healthServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	if !knownService(req.Service) {
+		return stream.Send(&healthpb.HealthCheckResponse{
+			Status: healthpb.HealthCheckResponse_SERVICE_UNKNOWN,
+		})
+	}
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	last := healthpb.HealthCheckResponse_UNKNOWN
+	for {
+		current := h.servingStatus(stream.Context())
+		if current != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{
+				Status: current,
+			}); err != nil {
+				return err
+			}
+			last = current
+		}
+		if h.registry.ShuttingDown() {
+			return nil
+		}
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case <-h.registry.Done():
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *healthServer) servingStatus(ctx context.Context) healthpb.HealthCheckResponse_ServingStatus {
+	if h.registry.Check(ctx).Serving {
+		return healthpb.HealthCheckResponse_SERVING
+	}
+	return healthpb.HealthCheckResponse_NOT_SERVING
+}
+
+func knownService(service string) bool {
+	return service == "" || service == authServiceName
+}
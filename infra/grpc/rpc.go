@@ -2,9 +2,11 @@ package grpc
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/minghsu0107/saga-account/domain/model"
+	"github.com/minghsu0107/saga-account/repo"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
@@ -17,6 +19,9 @@ func (srv *Server) Auth(ctx context.Context, req *pb.AuthPayload) (*pb.AuthRespo
 		AccessToken: req.AccessToken,
 	}
 	authResponse, err := srv.jwtAuthSvc.Auth(ctx, authPayload)
+	if errors.Is(err, repo.ErrDatabaseUnavailable) {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
 	if err != nil {
 		return nil, status.Errorf(
 			codes.Internal,
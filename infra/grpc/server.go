@@ -1,6 +1,7 @@
 package grpc
 
 import (
+	"context"
 	"net"
 	"time"
 
@@ -15,9 +16,11 @@ import (
 	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
 	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
 	"github.com/minghsu0107/saga-account/config"
+	"github.com/minghsu0107/saga-account/pkg/health"
 	pb "github.com/minghsu0107/saga-pb"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
@@ -31,7 +34,8 @@ type Server struct {
 }
 
 // NewGRPCServer is the factory of grpc server
-func NewGRPCServer(config *config.Config, jwtAuthSvc auth.JWTAuthService) *Server {
+// the grpc.health.v1 service reports the health of the dependencies registered to registry
+func NewGRPCServer(config *config.Config, jwtAuthSvc auth.JWTAuthService, registry *health.Registry) *Server {
 	srv := &Server{
 		Port:       config.GRPCPort,
 		jwtAuthSvc: jwtAuthSvc,
@@ -74,6 +78,7 @@ func NewGRPCServer(config *config.Config, jwtAuthSvc auth.JWTAuthService) *Serve
 			otelgrpc.StreamServerInterceptor(),
 			grpc_ctxtags.StreamServerInterceptor(grpc_ctxtags.WithFieldExtractor(grpc_ctxtags.CodeGenRequestFieldExtractor)),
 			grpc_logrus.StreamServerInterceptor(&logrusEntry, grpcOpts...),
+			LogTraceStream(),
 			grpc_recovery.StreamServerInterceptor(recoveryOpts...),
 		)),
 		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
@@ -87,6 +92,9 @@ func NewGRPCServer(config *config.Config, jwtAuthSvc auth.JWTAuthService) *Serve
 	)
 	srv.s = grpc.NewServer(opts...)
 	pb.RegisterAuthServiceServer(srv.s, srv)
+	healthpb.RegisterHealthServer(srv.s, &healthServer{
+		registry: registry,
+	})
 
 	grpc_prometheus.Register(srv.s)
 	reflection.Register(srv.s)
@@ -101,13 +109,25 @@ func (srv *Server) Run() error {
 	if err != nil {
 		return err
 	}
-	if err := srv.s.Serve(lis); err != nil {
+	if err := srv.s.Serve(lis); err != nil && err != grpc.ErrServerStopped {
 		return err
 	}
 	return nil
 }
 
 // GracefulStop stops grpc server gracefully
-func (srv *Server) GracefulStop() {
-	srv.s.GracefulStop()
+// the rpcs still pending when ctx is done are canceled
+func (srv *Server) GracefulStop(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		srv.s.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		srv.s.Stop()
+		return ctx.Err()
+	}
 }
@@ -2,18 +2,26 @@ package grpc
 
 import (
 	"context"
+	"io"
 	"io/ioutil"
+	"net"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/minghsu0107/saga-account/config"
 	mock_svc "github.com/minghsu0107/saga-account/mock/service"
+	"github.com/minghsu0107/saga-account/pkg/health"
+	"github.com/minghsu0107/saga-account/repo"
 	"github.com/minghsu0107/saga-account/service/auth"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 
 	"github.com/minghsu0107/saga-account/domain/model"
 	pb "github.com/minghsu0107/saga-pb"
@@ -24,8 +32,19 @@ var (
 	mockJWTAuthSvc *mock_svc.MockJWTAuthService
 	server         *Server
 	client         pb.AuthServiceClient
+	healthClient   healthpb.HealthClient
+	// dependencyReady is the readiness of the dependency registered to the server
+	dependencyReady int32 = 1
 )
 
+// dependencyHealth is a dependency whose readiness is set by the specs
+func dependencyHealth(ctx context.Context) health.Status {
+	return health.Status{
+		Name:  "dependency",
+		Ready: atomic.LoadInt32(&dependencyReady) == 1,
+	}
+}
+
 func TestGRPCServer(t *testing.T) {
 	mockCtrl = gomock.NewController(t)
 	RegisterFailHandler(Fail)
@@ -48,7 +67,9 @@ var _ = BeforeSuite(func() {
 		},
 	}
 	log.SetOutput(config.Logger.Writer)
-	server = NewGRPCServer(config, mockJWTAuthSvc)
+	registry := health.NewRegistry()
+	registry.Register(health.CheckerFunc(dependencyHealth))
+	server = NewGRPCServer(config, mockJWTAuthSvc, registry)
 	go func() {
 		err := server.Run()
 		if err != nil {
@@ -68,10 +89,11 @@ var _ = BeforeSuite(func() {
 		panic(err)
 	}
 	client = pb.NewAuthServiceClient(cc)
+	healthClient = healthpb.NewHealthClient(cc)
 })
 
 var _ = AfterSuite(func() {
-	server.GracefulStop()
+	server.GracefulStop(context.Background())
 })
 
 var _ = Describe("test grpc server", func() {
@@ -106,4 +128,68 @@ var _ = Describe("test grpc server", func() {
 		})
 		Expect(err).To(HaveOccurred())
 	})
+	It("should return unavailable while the database is unavailable", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		mockJWTAuthSvc.EXPECT().
+			Auth(gomock.Any(), &authPayload).Return(nil, repo.ErrDatabaseUnavailable)
+		_, err := client.Auth(ctx, &pb.AuthPayload{
+			AccessToken: authPayload.AccessToken,
+		})
+		Expect(status.Code(err)).To(Equal(codes.Unavailable))
+	})
+	It("should report the health of dependencies", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		for _, service := range []string{"", "auth.AuthService"} {
+			res, err := healthClient.Check(ctx, &healthpb.HealthCheckRequest{
+				Service: service,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res.Status).To(Equal(healthpb.HealthCheckResponse_SERVING))
+		}
+
+		// reports are reused for a second
+		atomic.StoreInt32(&dependencyReady, 0)
+		defer atomic.StoreInt32(&dependencyReady, 1)
+		Eventually(func() healthpb.HealthCheckResponse_ServingStatus {
+			res, err := healthClient.Check(ctx, &healthpb.HealthCheckRequest{})
+			Expect(err).NotTo(HaveOccurred())
+			return res.Status
+		}, 2*time.Second).Should(Equal(healthpb.HealthCheckResponse_NOT_SERVING))
+
+		_, err := healthClient.Check(ctx, &healthpb.HealthCheckRequest{
+			Service: "unknown",
+		})
+		Expect(status.Code(err)).To(Equal(codes.NotFound))
+	})
+	It("should end health watches as not serving on shutdown", func() {
+		registry := health.NewRegistry()
+		s := grpc.NewServer()
+		healthpb.RegisterHealthServer(s, &healthServer{
+			registry: registry,
+		})
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		go s.Serve(lis)
+		defer s.Stop()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		cc, err := grpc.DialContext(ctx, lis.Addr().String(), grpc.WithInsecure())
+		Expect(err).NotTo(HaveOccurred())
+		defer cc.Close()
+		stream, err := healthpb.NewHealthClient(cc).Watch(ctx, &healthpb.HealthCheckRequest{})
+		Expect(err).NotTo(HaveOccurred())
+		res, err := stream.Recv()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res.Status).To(Equal(healthpb.HealthCheckResponse_SERVING))
+
+		registry.Shutdown()
+		res, err = stream.Recv()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res.Status).To(Equal(healthpb.HealthCheckResponse_NOT_SERVING))
+		_, err = stream.Recv()
+		Expect(err).To(Equal(io.EOF))
+	})
 })
@@ -2,18 +2,52 @@ package grpc
 
 import (
 	"context"
-	"encoding/hex"
+	"strings"
 
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
-	"go.opentelemetry.io/otel/trace"
+	"github.com/minghsu0107/saga-account/pkg/logging"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 )
 
-// LogTraceUnary logs trace id from the incoming request context
+// requestIDKey is the metadata key of the request id; metadata keys are lowercase
+var requestIDKey = strings.ToLower(logging.RequestIDHeader)
+
+// LogTraceUnary logs the trace and span ids of the incoming request context, and its request id
+// the request id is read from the x-request-id metadata, or generated, and returned in the header metadata
 func LogTraceUnary() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
-		b := trace.SpanFromContext(ctx).SpanContext().TraceID()
-		grpc_ctxtags.Extract(ctx).Set("traceID", hex.EncodeToString(b[:]))
-		return handler(ctx, req)
+		return handler(withLogFields(ctx), req)
+	}
+}
+
+// LogTraceStream is LogTraceUnary for streams
+func LogTraceStream() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		wrapped := grpc_middleware.WrapServerStream(ss)
+		wrapped.WrappedContext = withLogFields(ss.Context())
+		return handler(srv, wrapped)
+	}
+}
+
+// withLogFields tags ctx with the trace, span and request ids, and returns it carrying the request id
+func withLogFields(ctx context.Context) context.Context {
+	var requestID string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get(requestIDKey); len(vals) > 0 && logging.ValidRequestID(vals[0]) {
+			requestID = vals[0]
+		}
+	}
+	if requestID == "" {
+		requestID = logging.NewRequestID()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDKey, requestID))
+	ctx = logging.WithRequestID(ctx, requestID)
+
+	tags := grpc_ctxtags.Extract(ctx)
+	for k, v := range logging.Fields(ctx) {
+		tags.Set(k, v)
 	}
+	return ctx
 }
@@ -0,0 +1,41 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minghsu0107/saga-account/infra/http/presenter"
+	"github.com/minghsu0107/saga-account/pkg/health"
+)
+
+// HealthProbe serves the liveness and readiness of the service
+type HealthProbe struct {
+	registry *health.Registry
+}
+
+// NewHealthProbe is the factory of HealthProbe
+func NewHealthProbe(registry *health.Registry) *HealthProbe {
+	return &HealthProbe{
+		registry: registry,
+	}
+}
+
+// Live responds 200 as long as the process serves requests, regardless of its dependencies
+func (p *HealthProbe) Live(c *gin.Context) {
+	c.JSON(http.StatusOK, presenter.Liveness{
+		Status: health.StatusOK,
+	})
+}
+
+// Ready responds 200 while every dependency is ready, even if some are degraded,
+// and 503 otherwise or once the service is shutting down
+// it is served without authentication, so only the name and readiness of components are reported;
+// their states are served by the admin server
+func (p *HealthProbe) Ready(c *gin.Context) {
+	report := p.registry.Check(c.Request.Context())
+	code := http.StatusOK
+	if !report.Serving {
+		code = http.StatusServiceUnavailable
+	}
+	c.JSON(code, presenter.NewReadiness(report, false))
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minghsu0107/saga-account/config"
+	"github.com/minghsu0107/saga-account/service/audit"
+)
+
+// AdminActor is the audit actor of requests authorized by the admin token
+const AdminActor = "admin"
+
+// AdminAuth authorizes a request by comparing the bearer token in the Authorization header with the admin token
+// every request is rejected if no admin token is configured
+func AdminAuth(config *config.Config) gin.HandlerFunc {
+	var adminToken string
+	if config.AdminConfig != nil {
+		adminToken = config.AdminConfig.Token
+	}
+	return func(c *gin.Context) {
+		token := extractToken(c.Request)
+		if adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		meta := *audit.RequestMetaFromContext(c.Request.Context())
+		meta.Actor = AdminActor
+		c.Request = c.Request.WithContext(audit.WithRequestMeta(c.Request.Context(), &meta))
+		c.Next()
+	}
+}
@@ -0,0 +1,18 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/minghsu0107/saga-account/domain/model"
+	"github.com/minghsu0107/saga-account/service/audit"
+)
+
+// RequestMetaMiddleware attaches the client IP and user agent of a request to its context for auditing
+func RequestMetaMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(audit.WithRequestMeta(c.Request.Context(), &model.RequestMeta{
+			IP:        c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+		}))
+		c.Next()
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/minghsu0107/saga-account/config"
 	"github.com/minghsu0107/saga-account/domain/model"
 	"github.com/minghsu0107/saga-account/infra/http/presenter"
+	"github.com/minghsu0107/saga-account/pkg/logging"
 	"github.com/minghsu0107/saga-account/service/auth"
 
 	log "github.com/sirupsen/logrus"
@@ -35,13 +36,14 @@ func (m *JWTAuthChecker) JWTAuth() gin.HandlerFunc {
 			AccessToken: accessToken,
 		})
 		if err != nil {
-			m.logger.Error(err)
+			m.logger.WithContext(c.Request.Context()).Error(err)
 			c.AbortWithStatus(http.StatusUnauthorized)
 			return
 		}
 		if authResult.Expired {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, presenter.ErrResponse{
-				Message: auth.ErrTokenExpired.Error(),
+				Message:   auth.ErrTokenExpired.Error(),
+				RequestID: logging.RequestID(c.Request.Context()),
 			})
 			return
 		}
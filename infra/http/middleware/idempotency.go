@@ -0,0 +1,279 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redsync/redsync/v4"
+	"github.com/minghsu0107/saga-account/config"
+	"github.com/minghsu0107/saga-account/infra/cache"
+	"github.com/minghsu0107/saga-account/infra/http/presenter"
+	"github.com/minghsu0107/saga-account/pkg"
+	"github.com/minghsu0107/saga-account/pkg/logging"
+	"github.com/minghsu0107/saga-account/service/audit"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// IdempotencyKeyHeader is the request header carrying a client-generated key of a mutating request
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader marks a response replayed from a previous request with the same key
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLen = 255
+
+	sealedKey = "idempotency_sealed"
+)
+
+// errInvalidSealedBody is the error of a sealed response body that cannot be decrypted
+var errInvalidSealedBody = errors.New("invalid sealed idempotent response body")
+
+// IdempotentResponse is the response stored for an idempotency key
+// the body of a sealed response is encrypted
+type IdempotentResponse struct {
+	Fingerprint string `json:"fingerprint"`
+	Status      int    `json:"status"`
+	Sealed      bool   `json:"sealed,omitempty"`
+	ContentType string `json:"content_type"`
+	Body        []byte `json:"body"`
+}
+
+// IdempotencyChecker is the idempotency key middleware type
+type IdempotencyChecker struct {
+	rc         cache.RedisCache
+	expiration time.Duration
+	aead       cipher.AEAD
+	logger     *log.Entry
+}
+
+// NewIdempotencyChecker is the factory of IdempotencyChecker
+func NewIdempotencyChecker(config *config.Config, rc cache.RedisCache) (*IdempotencyChecker, error) {
+	aead, err := newResponseCipher(config.JWTConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &IdempotencyChecker{
+		rc:         rc,
+		expiration: time.Duration(config.IdempotencyConfig.ExpirationSeconds) * time.Second,
+		aead:       aead,
+		logger: config.Logger.ContextLogger.WithFields(log.Fields{
+			"type": "middleware:IdempotencyChecker",
+		}),
+	}, nil
+}
+
+// newResponseCipher returns the cipher of sealed response bodies, keyed by the jwt secret
+// sealed bodies carry tokens signed with the jwt secret, so the secret adds no exposure to them
+func newResponseCipher(jwtConfig *config.JWTConfig) (cipher.AEAD, error) {
+	var secret string
+	if jwtConfig != nil {
+		secret = jwtConfig.Secret
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("idempotent response"))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Idempotency replays the stored response of a mutating request carrying an Idempotency-Key header
+// keys are scoped to the authenticated customer or admin, so the middleware should be registered after authentication,
+// and to the client IP for unauthenticated requests.
+// a request reusing a key with a different method, path or body is rejected with 422,
+// and requests with the same key are serialized by a redis mutex so that only one of them is processed
+func (m *IdempotencyChecker) Idempotency() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" || isSafeMethod(c.Request.Method) {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			abortWithError(c, http.StatusBadRequest, presenter.ErrInvalidIdempotencyKey)
+			return
+		}
+		body, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, presenter.ErrInvalidParam)
+			return
+		}
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		ctx := c.Request.Context()
+		cacheKey := m.cacheKey(ctx, key)
+		fingerprint := requestFingerprint(c.Request.Method, c.Request.URL.Path, body)
+		if m.replay(c, cacheKey, fingerprint) {
+			return
+		}
+
+		// a retry waits for the request in flight and replays its response
+		// while redis is unavailable, the lock is granted at once and nothing is stored, so requests are not deduplicated
+		unlock, err := m.rc.Lock(ctx, pkg.Join("mutex:", cacheKey))
+		if err != nil {
+			if err == redsync.ErrFailed {
+				abortWithError(c, http.StatusConflict, presenter.ErrIdempotencyKeyInUse)
+				return
+			}
+			m.logger.WithContext(c.Request.Context()).Error(err.Error())
+			abortWithError(c, http.StatusInternalServerError, presenter.ErrServer)
+			return
+		}
+		defer unlock()
+		if m.replay(c, cacheKey, fingerprint) {
+			return
+		}
+
+		writer := &responseRecorder{
+			ResponseWriter: c.Writer,
+		}
+		c.Writer = writer
+		c.Next()
+
+		// server errors are not stored so that the request can be retried
+		status := writer.Status()
+		if status >= http.StatusInternalServerError {
+			return
+		}
+		res := &IdempotentResponse{
+			Fingerprint: fingerprint,
+			Status:      status,
+			ContentType: writer.Header().Get("Content-Type"),
+			Body:        writer.body.Bytes(),
+		}
+		if c.GetBool(sealedKey) {
+			sealed, err := m.seal(cacheKey, res.Body)
+			if err != nil {
+				m.logger.WithContext(c.Request.Context()).Error(err.Error())
+				return
+			}
+			res.Sealed = true
+			res.Body = sealed
+		}
+		if err := m.rc.SetWithExpiration(ctx, cacheKey, res, m.expiration); err != nil {
+			m.logger.WithContext(c.Request.Context()).Error(err.Error())
+		}
+	}
+}
+
+// replay writes the stored response of an idempotency key and returns true if it exists
+func (m *IdempotencyChecker) replay(c *gin.Context, cacheKey, fingerprint string) bool {
+	var res IdempotentResponse
+	exist, err := m.rc.Get(c.Request.Context(), cacheKey, &res)
+	if err != nil {
+		m.logger.WithContext(c.Request.Context()).Error(err.Error())
+		abortWithError(c, http.StatusInternalServerError, presenter.ErrServer)
+		return true
+	}
+	if !exist {
+		return false
+	}
+	if res.Fingerprint != fingerprint {
+		abortWithError(c, http.StatusUnprocessableEntity, presenter.ErrIdempotencyKeyReused)
+		return true
+	}
+	body := res.Body
+	if res.Sealed {
+		if body, err = m.open(cacheKey, res.Body); err != nil {
+			m.logger.WithContext(c.Request.Context()).Error(err.Error())
+			abortWithError(c, http.StatusInternalServerError, presenter.ErrServer)
+			return true
+		}
+	}
+	c.Header(IdempotentReplayedHeader, "true")
+	c.Data(res.Status, res.ContentType, body)
+	c.Abort()
+	return true
+}
+
+// seal encrypts a response body bound to its idempotency key, prefixed by the nonce
+func (m *IdempotencyChecker) seal(cacheKey string, body []byte) ([]byte, error) {
+	nonce := make([]byte, m.aead.NonceSize(), m.aead.NonceSize()+len(body)+m.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return m.aead.Seal(nonce, nonce, body, []byte(cacheKey)), nil
+}
+
+// open decrypts a response body sealed for the idempotency key
+func (m *IdempotencyChecker) open(cacheKey string, sealed []byte) ([]byte, error) {
+	if len(sealed) < m.aead.NonceSize() {
+		return nil, errInvalidSealedBody
+	}
+	nonce, ciphertext := sealed[:m.aead.NonceSize()], sealed[m.aead.NonceSize():]
+	return m.aead.Open(nil, nonce, ciphertext, []byte(cacheKey))
+}
+
+// SealIdempotentResponse encrypts the stored responses of a route, for responses carrying credentials
+// a retry still gets the original status and body, while the idempotency store only holds ciphertext
+// it should be registered after the idempotency middleware
+func SealIdempotentResponse() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(sealedKey, true)
+		c.Next()
+	}
+}
+
+func (m *IdempotencyChecker) cacheKey(ctx context.Context, key string) string {
+	var scope string
+	meta := audit.RequestMetaFromContext(ctx)
+	if customerID, ok := ctx.Value(config.CustomerKey).(uint64); ok {
+		scope = strconv.FormatUint(customerID, 10)
+	} else if meta.Actor == AdminActor {
+		scope = AdminActor
+	} else {
+		scope = pkg.Join("ip:", meta.IP)
+	}
+	return pkg.Join("idempotency:", scope, ":", key)
+}
+
+func requestFingerprint(method, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write([]byte(path))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func abortWithError(c *gin.Context, httpCode int, err error) {
+	c.AbortWithStatusJSON(httpCode, presenter.ErrResponse{
+		Message:   err.Error(),
+		RequestID: logging.RequestID(c.Request.Context()),
+	})
+}
+
+// responseRecorder keeps a copy of the response body
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,302 @@
+package middleware
+
+import (
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/minghsu0107/saga-account/config"
+	"github.com/minghsu0107/saga-account/infra/cache"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/redis/go-redis/v9"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	mr                 *miniredis.Miniredis
+	client             redis.UniversalClient
+	idempotencyChecker *IdempotencyChecker
+)
+
+func TestMiddleware(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "middleware suite")
+}
+
+var _ = BeforeSuite(func() {
+	gin.SetMode(gin.TestMode)
+	var err error
+	mr, err = miniredis.Run()
+	Expect(err).To(BeNil())
+	client = redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+	config := &config.Config{
+		RedisConfig: &config.RedisConfig{
+			ExpirationSeconds: 60,
+		},
+		JWTConfig: &config.JWTConfig{
+			Secret: "testsecret",
+		},
+		IdempotencyConfig: &config.IdempotencyConfig{
+			ExpirationSeconds: 60,
+		},
+		Logger: &config.Logger{
+			Writer: ioutil.Discard,
+			ContextLogger: log.WithFields(log.Fields{
+				"app": "test",
+			}),
+		},
+	}
+	rc, err := cache.NewRedisCache(config, client)
+	Expect(err).To(BeNil())
+	idempotencyChecker, err = NewIdempotencyChecker(config, rc)
+	Expect(err).To(BeNil())
+})
+
+var _ = AfterSuite(func() {
+	client.Close()
+	mr.Close()
+})
+
+// counter counts handled requests and echoes the request body
+type counter struct {
+	mu      sync.Mutex
+	handled int
+	status  int
+	delay   time.Duration
+}
+
+func (h *counter) handle(c *gin.Context) {
+	time.Sleep(h.delay)
+	h.mu.Lock()
+	h.handled++
+	h.mu.Unlock()
+	body, _ := ioutil.ReadAll(c.Request.Body)
+	c.Data(h.status, "application/json", body)
+}
+
+func (h *counter) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.handled
+}
+
+func newEngine(h *counter, customerID uint64) *gin.Engine {
+	engine := gin.New()
+	engine.Use(RequestMetaMiddleware())
+	engine.Use(func(c *gin.Context) {
+		if customerID != 0 {
+			c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), config.CustomerKey, customerID))
+		}
+		c.Next()
+	})
+	engine.Use(idempotencyChecker.Idempotency())
+	engine.POST("/signup", h.handle)
+	engine.POST("/credentials", SealIdempotentResponse(), h.handle)
+	engine.PUT("/person", h.handle)
+	engine.GET("/person", h.handle)
+	engine.DELETE("/addresses/:id", h.handle)
+	return engine
+}
+
+func doRequest(engine *gin.Engine, method, path, key, body string) *httptest.ResponseRecorder {
+	return doRequestFrom(engine, "192.0.2.1:1234", method, path, key, body)
+}
+
+func doRequestFrom(engine *gin.Engine, remoteAddr, method, path, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.RemoteAddr = remoteAddr
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	return w
+}
+
+var _ = Describe("idempotency middleware", func() {
+	var h *counter
+	BeforeEach(func() {
+		mr.FlushAll()
+		h = &counter{
+			status: http.StatusCreated,
+		}
+	})
+	It("should replay the original response of a retried request", func() {
+		engine := newEngine(h, 0)
+		first := doRequest(engine, http.MethodPost, "/signup", "key-1", `{"email":"ming@ming.com"}`)
+		Expect(first.Code).To(Equal(http.StatusCreated))
+		Expect(first.Header().Get(IdempotentReplayedHeader)).To(BeEmpty())
+
+		retry := doRequest(engine, http.MethodPost, "/signup", "key-1", `{"email":"ming@ming.com"}`)
+		Expect(retry.Code).To(Equal(http.StatusCreated))
+		Expect(retry.Body.String()).To(Equal(`{"email":"ming@ming.com"}`))
+		Expect(retry.Header().Get("Content-Type")).To(Equal("application/json"))
+		Expect(retry.Header().Get(IdempotentReplayedHeader)).To(Equal("true"))
+		Expect(h.count()).To(Equal(1))
+	})
+	It("should reject a key reused with a different request", func() {
+		engine := newEngine(h, 0)
+		doRequest(engine, http.MethodPost, "/signup", "key-1", `{"email":"ming@ming.com"}`)
+
+		w := doRequest(engine, http.MethodPost, "/signup", "key-1", `{"email":"other@ming.com"}`)
+		Expect(w.Code).To(Equal(http.StatusUnprocessableEntity))
+		w = doRequest(engine, http.MethodPut, "/person", "key-1", `{"email":"ming@ming.com"}`)
+		Expect(w.Code).To(Equal(http.StatusUnprocessableEntity))
+		Expect(h.count()).To(Equal(1))
+	})
+	It("should scope keys to the customer", func() {
+		doRequest(newEngine(h, 1), http.MethodPut, "/person", "key-1", `{}`)
+		doRequest(newEngine(h, 2), http.MethodPut, "/person", "key-1", `{}`)
+		Expect(h.count()).To(Equal(2))
+	})
+	It("should tell requests to different resources of a route apart", func() {
+		engine := newEngine(h, 1)
+		first := doRequest(engine, http.MethodDelete, "/addresses/1", "key-1", "")
+		Expect(first.Code).To(Equal(http.StatusCreated))
+
+		w := doRequest(engine, http.MethodDelete, "/addresses/2", "key-1", "")
+		Expect(w.Code).To(Equal(http.StatusUnprocessableEntity))
+		Expect(w.Header().Get(IdempotentReplayedHeader)).To(BeEmpty())
+		w = doRequest(engine, http.MethodDelete, "/addresses/2", "key-2", "")
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		Expect(h.count()).To(Equal(2))
+	})
+	It("should scope keys of unauthenticated requests to the client", func() {
+		engine := newEngine(h, 0)
+		doRequestFrom(engine, "192.0.2.1:1234", http.MethodPost, "/signup", "key-1", `{}`)
+		w := doRequestFrom(engine, "192.0.2.2:1234", http.MethodPost, "/signup", "key-1", `{}`)
+		Expect(w.Header().Get(IdempotentReplayedHeader)).To(BeEmpty())
+		Expect(h.count()).To(Equal(2))
+	})
+	It("should replay sealed responses without storing them in plain text", func() {
+		engine := newEngine(h, 0)
+		first := doRequest(engine, http.MethodPost, "/credentials", "key-1", `{"access_token":"secret"}`)
+		Expect(first.Code).To(Equal(http.StatusCreated))
+		Expect(first.Body.String()).To(Equal(`{"access_token":"secret"}`))
+		for _, key := range mr.Keys() {
+			val, _ := mr.Get(key)
+			Expect(val).NotTo(ContainSubstring("secret"))
+			Expect(val).NotTo(ContainSubstring(base64.StdEncoding.EncodeToString([]byte(`{"access_token":"secret"}`))))
+		}
+
+		retry := doRequest(engine, http.MethodPost, "/credentials", "key-1", `{"access_token":"secret"}`)
+		Expect(retry.Code).To(Equal(http.StatusCreated))
+		Expect(retry.Header().Get(IdempotentReplayedHeader)).To(Equal("true"))
+		Expect(retry.Body.String()).To(Equal(`{"access_token":"secret"}`))
+		Expect(h.count()).To(Equal(1))
+	})
+	It("should not open a sealed response stored for another key", func() {
+		sealed, err := idempotencyChecker.seal("idempotency:ip:192.0.2.1:key-1", []byte("body"))
+		Expect(err).To(BeNil())
+		_, err = idempotencyChecker.open("idempotency:ip:192.0.2.2:key-1", sealed)
+		Expect(err).NotTo(BeNil())
+		body, err := idempotencyChecker.open("idempotency:ip:192.0.2.1:key-1", sealed)
+		Expect(err).To(BeNil())
+		Expect(string(body)).To(Equal("body"))
+	})
+	It("should store sealed error responses", func() {
+		h.status = http.StatusBadRequest
+		engine := newEngine(h, 0)
+		doRequest(engine, http.MethodPost, "/credentials", "key-1", `{}`)
+		retry := doRequest(engine, http.MethodPost, "/credentials", "key-1", `{}`)
+		Expect(retry.Code).To(Equal(http.StatusBadRequest))
+		Expect(retry.Header().Get(IdempotentReplayedHeader)).To(Equal("true"))
+		Expect(h.count()).To(Equal(1))
+	})
+	It("should process requests without a key and safe requests every time", func() {
+		engine := newEngine(h, 1)
+		doRequest(engine, http.MethodPut, "/person", "", `{}`)
+		doRequest(engine, http.MethodPut, "/person", "", `{}`)
+		doRequest(engine, http.MethodGet, "/person", "key-1", "")
+		doRequest(engine, http.MethodGet, "/person", "key-1", "")
+		Expect(h.count()).To(Equal(4))
+	})
+	It("should not store server errors", func() {
+		h.status = http.StatusInternalServerError
+		engine := newEngine(h, 1)
+		doRequest(engine, http.MethodPut, "/person", "key-1", `{}`)
+		h.status = http.StatusOK
+		w := doRequest(engine, http.MethodPut, "/person", "key-1", `{}`)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(h.count()).To(Equal(2))
+	})
+	It("should process concurrent requests with the same key once", func() {
+		h.delay = 100 * time.Millisecond
+		engine := newEngine(h, 1)
+		var wg sync.WaitGroup
+		codes := make([]int, 3)
+		for i := range codes {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				defer GinkgoRecover()
+				codes[i] = doRequest(engine, http.MethodPut, "/person", "key-1", `{}`).Code
+			}(i)
+		}
+		wg.Wait()
+		Expect(codes).To(Equal([]int{http.StatusCreated, http.StatusCreated, http.StatusCreated}))
+		Expect(h.count()).To(Equal(1))
+	})
+	It("should process requests without deduplication while redis is unavailable", func() {
+		down, err := miniredis.Run()
+		Expect(err).To(BeNil())
+		addr := down.Addr()
+		down.Close()
+		downClient := redis.NewClient(&redis.Options{
+			Addr:       addr,
+			MaxRetries: -1,
+		})
+		defer downClient.Close()
+		downConfig := &config.Config{
+			LocalCacheConfig: &config.LocalCacheConfig{
+				ExpirationSeconds: 60,
+			},
+			RedisConfig: &config.RedisConfig{
+				ExpirationSeconds:       60,
+				BreakerFailureThreshold: 1,
+				BreakerOpenSeconds:      60,
+			},
+			IdempotencyConfig: &config.IdempotencyConfig{
+				ExpirationSeconds: 60,
+			},
+			Logger: &config.Logger{
+				Writer: ioutil.Discard,
+				ContextLogger: log.WithFields(log.Fields{
+					"app": "test",
+				}),
+			},
+		}
+		lc, err := cache.NewLocalCache(downConfig)
+		Expect(err).To(BeNil())
+		rc, err := cache.NewBreakerRedisCache(downConfig, downClient, lc, nil)
+		Expect(err).To(BeNil())
+		checker, err := NewIdempotencyChecker(downConfig, rc)
+		Expect(err).To(BeNil())
+		engine := gin.New()
+		engine.Use(RequestMetaMiddleware(), checker.Idempotency())
+		engine.PUT("/person", h.handle)
+
+		for i := 0; i < 2; i++ {
+			w := doRequest(engine, http.MethodPut, "/person", "key-1", `{}`)
+			Expect(w.Code).To(Equal(http.StatusCreated))
+			Expect(w.Header().Get(IdempotentReplayedHeader)).To(BeEmpty())
+		}
+		Expect(h.count()).To(Equal(2))
+	})
+	It("should reject a key that is too long", func() {
+		w := doRequest(newEngine(h, 1), http.MethodPut, "/person", strings.Repeat("k", maxIdempotencyKeyLen+1), `{}`)
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+		Expect(h.count()).To(Equal(0))
+	})
+})
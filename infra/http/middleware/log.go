@@ -1,18 +1,29 @@
 package middleware
 
 import (
-	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/minghsu0107/saga-account/pkg/logging"
 	log "github.com/sirupsen/logrus"
 )
 
-const (
-	JaegerHeader = "Uber-Trace-Id"
-)
+// RequestIDMiddleware identifies every request by the id in its X-Request-Id header, or by a new id
+// the id is returned in the response header and logged with every entry in the context of the request
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(logging.RequestIDHeader)
+		if !logging.ValidRequestID(requestID) {
+			requestID = logging.NewRequestID()
+		}
+		c.Header(logging.RequestIDHeader, requestID)
+		c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), requestID))
+		c.Next()
+	}
+}
 
 // LogMiddleware is the logging middleware
+// entries carry the trace and span ids of the active span and the request id
 func LogMiddleware(logger *log.Entry) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Start timer
@@ -24,14 +35,13 @@ func LogMiddleware(logger *log.Entry) gin.HandlerFunc {
 		// Stop timer
 		duration := GetDurationInMillseconds(start)
 
-		entry := logger.WithFields(log.Fields{
+		entry := logger.WithContext(c.Request.Context()).WithFields(log.Fields{
 			"type":        "router",
 			"duration_ms": duration,
 			"method":      c.Request.Method,
 			"path":        c.Request.RequestURI,
 			"status":      c.Writer.Status(),
 			"referrer":    c.Request.Referer(),
-			"traceID":     GetTraceID(c),
 		})
 
 		if c.Writer.Status() >= 500 {
@@ -42,15 +52,6 @@ func LogMiddleware(logger *log.Entry) gin.HandlerFunc {
 	}
 }
 
-func GetTraceID(c *gin.Context) string {
-	identifier := c.Request.Header.Get(JaegerHeader)
-	vals := strings.Split(identifier, ":")
-	if len(vals) == 4 {
-		return vals[0]
-	}
-	return ""
-}
-
 // GetDurationInMillseconds takes a start time and returns a duration in milliseconds
 func GetDurationInMillseconds(start time.Time) float64 {
 	end := time.Now()
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minghsu0107/saga-account/pkg/logging"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("request id middleware", func() {
+	var engine *gin.Engine
+	var requestID string
+	BeforeEach(func() {
+		engine = gin.New()
+		engine.Use(RequestIDMiddleware())
+		engine.GET("/", func(c *gin.Context) {
+			requestID = logging.RequestID(c.Request.Context())
+			c.Status(http.StatusOK)
+		})
+	})
+	It("should keep the request id given by the client", func() {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(logging.RequestIDHeader, "req-1")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		Expect(requestID).To(Equal("req-1"))
+		Expect(w.Header().Get(logging.RequestIDHeader)).To(Equal("req-1"))
+	})
+	It("should generate a request id if the given one is invalid", func() {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(logging.RequestIDHeader, "req 1")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		Expect(requestID).NotTo(Equal("req 1"))
+		Expect(logging.ValidRequestID(requestID)).To(BeTrue())
+		Expect(w.Header().Get(logging.RequestIDHeader)).To(Equal(requestID))
+	})
+})
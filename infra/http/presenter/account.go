@@ -7,8 +7,31 @@ type CustomerPersonalInfo struct {
 	Email     string `json:"email" binding:"required,email"`
 }
 
-// CustomerShippingInfo request/response payload
+// CustomerShippingInfo response payload
+// the address is formatted as a single line
 type CustomerShippingInfo struct {
-	Address     string `json:"address" binding:"required"`
-	PhoneNumber string `json:"phone_number" binding:"required"`
+	Address     string `json:"address"`
+	PhoneNumber string `json:"phone_number"`
+}
+
+// CustomerShippingInfoUpdate request payload
+type CustomerShippingInfoUpdate struct {
+	Address     *PostalAddress `json:"address" binding:"required"`
+	PhoneNumber string         `json:"phone_number" binding:"required"`
+}
+
+// CustomerPersonalInfoPatch request payload
+// every attribute is required, so members cannot be null or empty
+type CustomerPersonalInfoPatch struct {
+	FirstName *string `json:"firstname" binding:"omitempty,min=1,max=50"`
+	LastName  *string `json:"lastname" binding:"omitempty,min=1,max=50"`
+	Email     *string `json:"email" binding:"omitempty,email"`
+}
+
+// CustomerShippingInfoPatch request payload
+// every attribute is required, so members cannot be null or empty; the address is stored formatted,
+// so it is replaced as a whole rather than merged
+type CustomerShippingInfoPatch struct {
+	Address     *PostalAddress `json:"address"`
+	PhoneNumber *string        `json:"phone_number" binding:"omitempty,min=1,max=20"`
 }
@@ -0,0 +1,25 @@
+package presenter
+
+// PostalAddress request/response payload
+type PostalAddress struct {
+	Line1      string `json:"line1" binding:"required,max=255"`
+	Line2      string `json:"line2" binding:"max=255"`
+	City       string `json:"city" binding:"required,max=100"`
+	Region     string `json:"region" binding:"max=100"`
+	PostalCode string `json:"postal_code" binding:"max=20"`
+	Country    string `json:"country" binding:"required,len=2"`
+}
+
+// Address request payload
+type Address struct {
+	Recipient   string `json:"recipient" binding:"required,max=100"`
+	PhoneNumber string `json:"phone_number" binding:"required,max=20"`
+	PostalAddress
+	Default bool `json:"default"`
+}
+
+// AddressResponse response payload
+type AddressResponse struct {
+	ID uint64 `json:"id,string"`
+	Address
+}
@@ -0,0 +1,47 @@
+package presenter
+
+import "time"
+
+// AuditEvent response payload
+type AuditEvent struct {
+	ID         uint64                 `json:"id,string"`
+	CustomerID uint64                 `json:"customer_id,string"`
+	Type       string                 `json:"type"`
+	Actor      string                 `json:"actor"`
+	IP         string                 `json:"ip"`
+	UserAgent  string                 `json:"user_agent"`
+	RequestID  string                 `json:"request_id,omitempty"`
+	Changes    map[string]AuditChange `json:"changes"`
+	CreatedAt  time.Time              `json:"created_at"`
+}
+
+// AuditChange response payload
+type AuditChange struct {
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// ActivityPage response payload
+// NextCursor is passed as the before parameter to fetch the next page
+type ActivityPage struct {
+	Events     []AuditEvent `json:"events"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+// ActivityQuery request parameters
+type ActivityQuery struct {
+	Before uint64 `form:"before"`
+	Limit  int    `form:"limit" binding:"omitempty,min=1,max=100"`
+}
+
+// AdminActivityQuery request parameters
+type AdminActivityQuery struct {
+	ActivityQuery
+	CustomerID uint64   `form:"customer_id"`
+	Types      []string `form:"type"`
+}
+
+// CustomerStatus request payload
+type CustomerStatus struct {
+	Active *bool `json:"active" binding:"required"`
+}
@@ -2,12 +2,12 @@ package presenter
 
 // SignUpCustomer request payload
 type SignUpCustomer struct {
-	Password    string `json:"password" binding:"required,min=8,max=128"`
-	FirstName   string `json:"firstname" binding:"required"`
-	LastName    string `json:"lastname" binding:"required"`
-	Email       string `json:"email" binding:"required,email"`
-	Address     string `json:"address" binding:"required"`
-	PhoneNumber string `json:"phone_number" binding:"required"`
+	Password    string         `json:"password" binding:"required,min=8,max=128"`
+	FirstName   string         `json:"firstname" binding:"required"`
+	LastName    string         `json:"lastname" binding:"required"`
+	Email       string         `json:"email" binding:"required,email"`
+	Address     *PostalAddress `json:"address" binding:"required"`
+	PhoneNumber string         `json:"phone_number" binding:"required"`
 }
 
 // LoginCustomer request payload
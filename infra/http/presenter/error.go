@@ -9,11 +9,23 @@ var (
 	ErrInvalidParam = errors.New("invalid parameter")
 	// ErrUnauthorized is unauthorized error
 	ErrUnauthorized = errors.New("unauthorized")
+	// ErrPreconditionRequired is missing If-Match header error
+	ErrPreconditionRequired = errors.New("if-match header required")
+	// ErrInvalidIdempotencyKey is malformed Idempotency-Key header error
+	ErrInvalidIdempotencyKey = errors.New("invalid idempotency key")
+	// ErrIdempotencyKeyInUse is the error of a request whose idempotency key is used by a request in flight
+	ErrIdempotencyKeyInUse = errors.New("a request with the same idempotency key is in progress")
+	// ErrIdempotencyKeyReused is the error of reusing an idempotency key with a different request
+	ErrIdempotencyKeyReused = errors.New("idempotency key reused with a different request")
+	// ErrServiceUnavailable is the error of a request failed by a temporarily unavailable dependency
+	ErrServiceUnavailable = errors.New("service temporarily unavailable")
 	// ErrServer is server error
 	ErrServer = errors.New("server error")
 )
 
 // ErrResponse is the error response type
+// RequestID identifies the request in logs and audit records
 type ErrResponse struct {
-	Message string `json:"msg"`
+	Message   string `json:"msg"`
+	RequestID string `json:"request_id,omitempty"`
 }
@@ -0,0 +1,41 @@
+package presenter
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/minghsu0107/saga-account/pkg"
+)
+
+const (
+	// ETagHeader is the response header carrying the resource version
+	ETagHeader = "ETag"
+	// IfMatchHeader is the request header carrying the expected resource version
+	IfMatchHeader = "If-Match"
+)
+
+var errInvalidETag = errors.New("invalid etag")
+
+// NewETag formats a customer version as a strong entity tag
+func NewETag(version uint64) string {
+	return pkg.Join(`"`, strconv.FormatUint(version, 10), `"`)
+}
+
+// ParseETag parses the customer version from an If-Match header value
+// the wildcard "*" matches any version and is returned as zero
+func ParseETag(etag string) (uint64, error) {
+	etag = strings.TrimSpace(etag)
+	if etag == "*" {
+		return 0, nil
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	if len(etag) < 2 || !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) {
+		return 0, errInvalidETag
+	}
+	version, err := strconv.ParseUint(etag[1:len(etag)-1], 10, 64)
+	if err != nil || version == 0 {
+		return 0, errInvalidETag
+	}
+	return version, nil
+}
@@ -0,0 +1,44 @@
+package presenter
+
+import "github.com/minghsu0107/saga-account/pkg/health"
+
+// Liveness response payload
+type Liveness struct {
+	Status string `json:"status"`
+}
+
+// Readiness response payload
+// Status is ok, degraded if a dependency is bypassed, unavailable if the service cannot serve requests,
+// or shutting_down once it stops
+type Readiness struct {
+	Status     string            `json:"status"`
+	Components []ComponentHealth `json:"components"`
+}
+
+// ComponentHealth is the health of a dependency
+// State may hold internal addresses and errors, so it is only reported to admins
+type ComponentHealth struct {
+	Name     string `json:"name"`
+	State    string `json:"state,omitempty"`
+	Ready    bool   `json:"ready"`
+	Degraded bool   `json:"degraded"`
+}
+
+// NewReadiness returns the readiness payload of a health report, with the state of each component if detailed
+func NewReadiness(report health.Report, detailed bool) *Readiness {
+	resp := &Readiness{
+		Status:     report.Status,
+		Components: make([]ComponentHealth, len(report.Components)),
+	}
+	for i, status := range report.Components {
+		resp.Components[i] = ComponentHealth{
+			Name:     status.Name,
+			Ready:    status.Ready,
+			Degraded: status.Degraded,
+		}
+		if detailed {
+			resp.Components[i].State = status.State
+		}
+	}
+	return resp
+}
@@ -0,0 +1,66 @@
+package presenter
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+)
+
+// MIMEMergePatch is the media type of a JSON merge patch document (RFC 7396)
+const MIMEMergePatch = "application/merge-patch+json"
+
+var (
+	// ErrUnknownPatchMember is unknown merge patch member error
+	ErrUnknownPatchMember = errors.New("unknown patch member")
+	// ErrUnsupportedMediaType is unsupported media type error
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+)
+
+// BindMergePatch decodes a JSON merge patch document into dst, a pointer to a struct of pointer fields
+// members absent from the document are left nil, while members set to null are bound to zero values
+// so that they clear the corresponding attributes; only the supplied members are validated, so fields of
+// required attributes should reject zero values, such as by min=1, to keep null from clearing them
+func BindMergePatch(body []byte, dst interface{}) error {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(body, &members); err != nil {
+		return err
+	}
+
+	val := reflect.ValueOf(dst).Elem()
+	fields := patchFields(val.Type())
+	for name, raw := range members {
+		idx, ok := fields[name]
+		if !ok {
+			return ErrUnknownPatchMember
+		}
+		field := val.Field(idx)
+		if string(raw) == "null" {
+			field.Set(reflect.New(field.Type().Elem()))
+			continue
+		}
+		if err := json.Unmarshal(raw, field.Addr().Interface()); err != nil {
+			return err
+		}
+	}
+	return binding.Validator.ValidateStruct(dst)
+}
+
+// IsMergePatchContentType checks whether a content type can carry a JSON merge patch
+func IsMergePatchContentType(contentType string) bool {
+	return contentType == MIMEMergePatch || contentType == binding.MIMEJSON
+}
+
+func patchFields(typ reflect.Type) map[string]int {
+	fields := make(map[string]int, typ.NumField())
+	for i := 0; i < typ.NumField(); i++ {
+		name := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[name] = i
+	}
+	return fields
+}
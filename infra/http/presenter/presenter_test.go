@@ -0,0 +1,72 @@
+package presenter
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/minghsu0107/saga-account/pkg/health"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestPresenter(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "presenter suite")
+}
+
+var _ = Describe("merge patch", func() {
+	It("should bind only the supplied members", func() {
+		var patch CustomerPersonalInfoPatch
+		Expect(BindMergePatch([]byte(`{"firstname":"ming"}`), &patch)).To(BeNil())
+		Expect(*patch.FirstName).To(Equal("ming"))
+		Expect(patch.LastName).To(BeNil())
+		Expect(patch.Email).To(BeNil())
+	})
+	It("should not clear required attributes", func() {
+		for _, body := range []string{
+			`{"firstname":null}`, `{"firstname":""}`, `{"lastname":null}`, `{"email":null}`, `{"email":""}`,
+		} {
+			var patch CustomerPersonalInfoPatch
+			Expect(BindMergePatch([]byte(body), &patch)).NotTo(BeNil(), body)
+		}
+		for _, body := range []string{
+			`{"address":null}`, `{"address":""}`, `{"address":{}}`, `{"address":{"city":"Taipei"}}`,
+			`{"phone_number":null}`, `{"phone_number":""}`,
+		} {
+			var patch CustomerShippingInfoPatch
+			Expect(BindMergePatch([]byte(body), &patch)).NotTo(BeNil(), body)
+		}
+	})
+	It("should replace the whole address", func() {
+		var patch CustomerShippingInfoPatch
+		Expect(BindMergePatch([]byte(`{"address":{"line1":"first street","city":"Taipei","country":"TW"}}`), &patch)).To(BeNil())
+		Expect(patch.Address).To(Equal(&PostalAddress{
+			Line1:   "first street",
+			City:    "Taipei",
+			Country: "TW",
+		}))
+		Expect(patch.PhoneNumber).To(BeNil())
+	})
+	It("should reject unknown members", func() {
+		var patch CustomerShippingInfoPatch
+		Expect(BindMergePatch([]byte(`{"zip":"100"}`), &patch)).To(Equal(ErrUnknownPatchMember))
+	})
+})
+
+var _ = Describe("readiness", func() {
+	report := health.Report{
+		Status: health.StatusDegraded,
+		Components: []health.Status{
+			{Name: "redis-server", State: "down: dial tcp 10.0.0.2:6379: connection refused", Ready: true, Degraded: true},
+		},
+	}
+	It("should report only the readiness of components publicly", func() {
+		body, err := json.Marshal(NewReadiness(report, false))
+		Expect(err).To(BeNil())
+		Expect(string(body)).To(Equal(`{"status":"degraded","components":[{"name":"redis-server","ready":true,"degraded":true}]}`))
+	})
+	It("should report the state of components in detail", func() {
+		readiness := NewReadiness(report, true)
+		Expect(readiness.Components[0].State).To(Equal("down: dial tcp 10.0.0.2:6379: connection refused"))
+	})
+})
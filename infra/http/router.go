@@ -1,14 +1,20 @@
 package http
 
 import (
+	"errors"
+	"io/ioutil"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/minghsu0107/saga-account/config"
 	domain_model "github.com/minghsu0107/saga-account/domain/model"
 	"github.com/minghsu0107/saga-account/infra/http/presenter"
+	"github.com/minghsu0107/saga-account/pkg/contact"
+	"github.com/minghsu0107/saga-account/pkg/logging"
 	"github.com/minghsu0107/saga-account/repo"
 	"github.com/minghsu0107/saga-account/service/account"
+	"github.com/minghsu0107/saga-account/service/audit"
 	"github.com/minghsu0107/saga-account/service/auth"
 )
 
@@ -16,13 +22,17 @@ import (
 type Router struct {
 	authSvc     auth.JWTAuthService
 	customerSvc account.CustomerService
+	addressSvc  account.AddressService
+	auditSvc    audit.AuditService
 }
 
 // NewRouter is a factory for router instance
-func NewRouter(authSvc auth.JWTAuthService, customerSvc account.CustomerService) *Router {
+func NewRouter(authSvc auth.JWTAuthService, customerSvc account.CustomerService, addressSvc account.AddressService, auditSvc audit.AuditService) *Router {
 	return &Router{
 		authSvc:     authSvc,
 		customerSvc: customerSvc,
+		addressSvc:  addressSvc,
+		auditSvc:    auditSvc,
 	}
 }
 
@@ -41,20 +51,22 @@ func (r *Router) SignUp(c *gin.Context) {
 			Email:     customer.Email,
 		},
 		ShippingInfo: &domain_model.CustomerShippingInfo{
-			Address:     customer.Address,
-			PhoneNumber: customer.PhoneNumber,
+			PostalAddress: newPostalAddress(customer.Address),
+			PhoneNumber:   customer.PhoneNumber,
 		},
 	})
-	switch err {
-	case repo.ErrDuplicateEntry:
+	switch {
+	case err == repo.ErrDuplicateEntry:
 		response(c, http.StatusBadRequest, repo.ErrDuplicateEntry)
-	case nil:
+	case contact.IsValidationError(err):
+		response(c, http.StatusBadRequest, err)
+	case err == nil:
 		c.JSON(http.StatusCreated, &presenter.TokenPair{
 			RefreshToken: refreshToken,
 			AccessToken:  accessToken,
 		})
 	default:
-		response(c, http.StatusInternalServerError, presenter.ErrServer)
+		serverError(c, err)
 		return
 	}
 }
@@ -80,7 +92,7 @@ func (r *Router) Login(c *gin.Context) {
 			AccessToken:  accessToken,
 		})
 	default:
-		response(c, http.StatusInternalServerError, presenter.ErrServer)
+		serverError(c, err)
 		return
 	}
 }
@@ -108,7 +120,7 @@ func (r *Router) RefreshToken(c *gin.Context) {
 			AccessToken:  newAccessToken,
 		})
 	default:
-		response(c, http.StatusInternalServerError, presenter.ErrServer)
+		serverError(c, err)
 		return
 	}
 }
@@ -125,6 +137,7 @@ func (r *Router) GetCustomerPersonalInfo(c *gin.Context) {
 	case repo.ErrCustomerNotFound:
 		response(c, http.StatusNotFound, repo.ErrCustomerNotFound)
 	case nil:
+		c.Header(presenter.ETagHeader, presenter.NewETag(personalInfo.Version))
 		c.JSON(http.StatusOK, &presenter.CustomerPersonalInfo{
 			FirstName: personalInfo.FirstName,
 			LastName:  personalInfo.LastName,
@@ -132,7 +145,7 @@ func (r *Router) GetCustomerPersonalInfo(c *gin.Context) {
 		})
 		return
 	default:
-		response(c, http.StatusInternalServerError, presenter.ErrServer)
+		serverError(c, err)
 		return
 	}
 }
@@ -149,13 +162,14 @@ func (r *Router) GetCustomerShippingInfo(c *gin.Context) {
 	case repo.ErrCustomerNotFound:
 		response(c, http.StatusNotFound, repo.ErrCustomerNotFound)
 	case nil:
+		c.Header(presenter.ETagHeader, presenter.NewETag(shippingInfo.Version))
 		c.JSON(http.StatusOK, &presenter.CustomerShippingInfo{
 			Address:     shippingInfo.Address,
 			PhoneNumber: shippingInfo.PhoneNumber,
 		})
 		return
 	default:
-		response(c, http.StatusInternalServerError, presenter.ErrServer)
+		serverError(c, err)
 		return
 	}
 }
@@ -172,25 +186,209 @@ func (r *Router) UpdateCustomerPersonalInfo(c *gin.Context) {
 		response(c, http.StatusUnauthorized, presenter.ErrUnauthorized)
 		return
 	}
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
 	err := r.customerSvc.UpdateCustomerPersonalInfo(c.Request.Context(), customerID, &domain_model.CustomerPersonalInfo{
 		FirstName: personalInfo.FirstName,
 		LastName:  personalInfo.LastName,
 		Email:     personalInfo.Email,
+		Version:   version,
+	})
+	updateResponse(c, err)
+}
+
+// UpdateCustomerShippingInfo updates customer shipping info
+func (r *Router) UpdateCustomerShippingInfo(c *gin.Context) {
+	var shippingInfo presenter.CustomerShippingInfoUpdate
+	if err := c.ShouldBindJSON(&shippingInfo); err != nil {
+		response(c, http.StatusBadRequest, presenter.ErrInvalidParam)
+		return
+	}
+	customerID, ok := c.Request.Context().Value(config.CustomerKey).(uint64)
+	if !ok {
+		response(c, http.StatusUnauthorized, presenter.ErrUnauthorized)
+		return
+	}
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+	err := r.customerSvc.UpdateCustomerShippingInfo(c.Request.Context(), customerID, &domain_model.CustomerShippingInfo{
+		PostalAddress: newPostalAddress(shippingInfo.Address),
+		PhoneNumber:   shippingInfo.PhoneNumber,
+		Version:       version,
+	})
+	updateResponse(c, err)
+}
+
+// PatchCustomerPersonalInfo partially updates customer personal info with a JSON merge patch
+func (r *Router) PatchCustomerPersonalInfo(c *gin.Context) {
+	var patch presenter.CustomerPersonalInfoPatch
+	if !bindMergePatch(c, &patch) {
+		return
+	}
+	customerID, ok := c.Request.Context().Value(config.CustomerKey).(uint64)
+	if !ok {
+		response(c, http.StatusUnauthorized, presenter.ErrUnauthorized)
+		return
+	}
+	version, ok := optionalIfMatchVersion(c)
+	if !ok {
+		return
+	}
+	err := r.customerSvc.PatchCustomerPersonalInfo(c.Request.Context(), customerID, &domain_model.CustomerPersonalInfoPatch{
+		FirstName: patch.FirstName,
+		LastName:  patch.LastName,
+		Email:     patch.Email,
+		Version:   version,
+	})
+	updateResponse(c, err)
+}
+
+// PatchCustomerShippingInfo partially updates customer shipping info with a JSON merge patch
+func (r *Router) PatchCustomerShippingInfo(c *gin.Context) {
+	var patch presenter.CustomerShippingInfoPatch
+	if !bindMergePatch(c, &patch) {
+		return
+	}
+	customerID, ok := c.Request.Context().Value(config.CustomerKey).(uint64)
+	if !ok {
+		response(c, http.StatusUnauthorized, presenter.ErrUnauthorized)
+		return
+	}
+	version, ok := optionalIfMatchVersion(c)
+	if !ok {
+		return
+	}
+	err := r.customerSvc.PatchCustomerShippingInfo(c.Request.Context(), customerID, &domain_model.CustomerShippingInfoPatch{
+		PostalAddress: newPostalAddress(patch.Address),
+		PhoneNumber:   patch.PhoneNumber,
+		Version:       version,
 	})
+	updateResponse(c, err)
+}
+
+// ListAddresses lists all addresses in the customer address book
+func (r *Router) ListAddresses(c *gin.Context) {
+	customerID, ok := c.Request.Context().Value(config.CustomerKey).(uint64)
+	if !ok {
+		response(c, http.StatusUnauthorized, presenter.ErrUnauthorized)
+		return
+	}
+	addresses, err := r.addressSvc.ListAddresses(c.Request.Context(), customerID)
+	if err != nil {
+		serverError(c, err)
+		return
+	}
+	res := make([]presenter.AddressResponse, len(addresses))
+	for i := range addresses {
+		res[i] = *newAddressResponse(&addresses[i])
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// GetAddress gets an address in the customer address book
+func (r *Router) GetAddress(c *gin.Context) {
+	customerID, ok := c.Request.Context().Value(config.CustomerKey).(uint64)
+	if !ok {
+		response(c, http.StatusUnauthorized, presenter.ErrUnauthorized)
+		return
+	}
+	addressID, ok := addressIDParam(c)
+	if !ok {
+		return
+	}
+	address, err := r.addressSvc.GetAddress(c.Request.Context(), customerID, addressID)
 	switch err {
+	case repo.ErrAddressNotFound:
+		response(c, http.StatusNotFound, repo.ErrAddressNotFound)
 	case nil:
-		c.JSON(http.StatusOK, presenter.OkMsg)
+		c.JSON(http.StatusOK, newAddressResponse(address))
+	default:
+		serverError(c, err)
+	}
+}
+
+// CreateAddress adds an address to the customer address book
+func (r *Router) CreateAddress(c *gin.Context) {
+	var req presenter.Address
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response(c, http.StatusBadRequest, presenter.ErrInvalidParam)
+		return
+	}
+	customerID, ok := c.Request.Context().Value(config.CustomerKey).(uint64)
+	if !ok {
+		response(c, http.StatusUnauthorized, presenter.ErrUnauthorized)
 		return
+	}
+	address := newDomainAddress(customerID, 0, &req)
+	err := r.addressSvc.CreateAddress(c.Request.Context(), address)
+	switch {
+	case contact.IsValidationError(err):
+		response(c, http.StatusBadRequest, err)
+	case err == nil:
+		c.JSON(http.StatusCreated, newAddressResponse(address))
 	default:
-		response(c, http.StatusInternalServerError, presenter.ErrServer)
+		serverError(c, err)
+	}
+}
+
+// UpdateAddress updates an address in the customer address book
+func (r *Router) UpdateAddress(c *gin.Context) {
+	var req presenter.Address
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response(c, http.StatusBadRequest, presenter.ErrInvalidParam)
+		return
+	}
+	customerID, ok := c.Request.Context().Value(config.CustomerKey).(uint64)
+	if !ok {
+		response(c, http.StatusUnauthorized, presenter.ErrUnauthorized)
+		return
+	}
+	addressID, ok := addressIDParam(c)
+	if !ok {
 		return
 	}
+	err := r.addressSvc.UpdateAddress(c.Request.Context(), newDomainAddress(customerID, addressID, &req))
+	addressResponse(c, err)
 }
 
-//  UpdateCustomerShippingInfo updates customer shipping info
-func (r *Router) UpdateCustomerShippingInfo(c *gin.Context) {
-	var shippingInfo presenter.CustomerShippingInfo
-	if err := c.ShouldBindJSON(&shippingInfo); err != nil {
+// DeleteAddress removes an address from the customer address book
+func (r *Router) DeleteAddress(c *gin.Context) {
+	customerID, ok := c.Request.Context().Value(config.CustomerKey).(uint64)
+	if !ok {
+		response(c, http.StatusUnauthorized, presenter.ErrUnauthorized)
+		return
+	}
+	addressID, ok := addressIDParam(c)
+	if !ok {
+		return
+	}
+	err := r.addressSvc.DeleteAddress(c.Request.Context(), customerID, addressID)
+	addressResponse(c, err)
+}
+
+// SetDefaultAddress marks an address as the default shipping address
+func (r *Router) SetDefaultAddress(c *gin.Context) {
+	customerID, ok := c.Request.Context().Value(config.CustomerKey).(uint64)
+	if !ok {
+		response(c, http.StatusUnauthorized, presenter.ErrUnauthorized)
+		return
+	}
+	addressID, ok := addressIDParam(c)
+	if !ok {
+		return
+	}
+	err := r.addressSvc.SetDefaultAddress(c.Request.Context(), customerID, addressID)
+	addressResponse(c, err)
+}
+
+// ListActivity lists the audit events of the customer, latest first
+func (r *Router) ListActivity(c *gin.Context) {
+	var query presenter.ActivityQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
 		response(c, http.StatusBadRequest, presenter.ErrInvalidParam)
 		return
 	}
@@ -199,23 +397,212 @@ func (r *Router) UpdateCustomerShippingInfo(c *gin.Context) {
 		response(c, http.StatusUnauthorized, presenter.ErrUnauthorized)
 		return
 	}
-	err := r.customerSvc.UpdateCustomerShippingInfo(c.Request.Context(), customerID, &domain_model.CustomerShippingInfo{
-		Address:     shippingInfo.Address,
-		PhoneNumber: shippingInfo.PhoneNumber,
+	r.listActivity(c, &domain_model.AuditQuery{
+		CustomerID: customerID,
+		Before:     query.Before,
+		Limit:      query.Limit,
 	})
-	switch err {
-	case nil:
-		c.JSON(http.StatusOK, presenter.OkMsg)
+}
+
+// ListAdminActivity lists audit events of any customer, optionally filtered by customer and event types
+func (r *Router) ListAdminActivity(c *gin.Context) {
+	var query presenter.AdminActivityQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		response(c, http.StatusBadRequest, presenter.ErrInvalidParam)
+		return
+	}
+	types := make([]domain_model.AuditEventType, len(query.Types))
+	for i, t := range query.Types {
+		types[i] = domain_model.AuditEventType(t)
+	}
+	r.listActivity(c, &domain_model.AuditQuery{
+		CustomerID: query.CustomerID,
+		Types:      types,
+		Before:     query.Before,
+		Limit:      query.Limit,
+	})
+}
+
+// UpdateCustomerStatus activates or deactivates a customer
+func (r *Router) UpdateCustomerStatus(c *gin.Context) {
+	var status presenter.CustomerStatus
+	if err := c.ShouldBindJSON(&status); err != nil {
+		response(c, http.StatusBadRequest, presenter.ErrInvalidParam)
+		return
+	}
+	customerID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		response(c, http.StatusBadRequest, presenter.ErrInvalidParam)
+		return
+	}
+	err = r.customerSvc.UpdateCustomerStatus(c.Request.Context(), customerID, *status.Active)
+	updateResponse(c, err)
+}
+
+func (r *Router) listActivity(c *gin.Context, query *domain_model.AuditQuery) {
+	page, err := r.auditSvc.ListActivity(c.Request.Context(), query)
+	if err != nil {
+		serverError(c, err)
 		return
+	}
+	res := &presenter.ActivityPage{
+		Events: make([]presenter.AuditEvent, len(page.Events)),
+	}
+	for i, event := range page.Events {
+		changes := make(map[string]presenter.AuditChange, len(event.Changes))
+		for name, change := range event.Changes {
+			changes[name] = presenter.AuditChange{
+				Before: change.Before,
+				After:  change.After,
+			}
+		}
+		res.Events[i] = presenter.AuditEvent{
+			ID:         event.ID,
+			CustomerID: event.CustomerID,
+			Type:       string(event.Type),
+			Actor:      event.Actor,
+			IP:         event.IP,
+			UserAgent:  event.UserAgent,
+			RequestID:  event.RequestID,
+			Changes:    changes,
+			CreatedAt:  event.CreatedAt,
+		}
+	}
+	if page.NextCursor != 0 {
+		res.NextCursor = strconv.FormatUint(page.NextCursor, 10)
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func addressIDParam(c *gin.Context) (uint64, bool) {
+	addressID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		response(c, http.StatusBadRequest, presenter.ErrInvalidParam)
+		return 0, false
+	}
+	return addressID, true
+}
+
+func addressResponse(c *gin.Context, err error) {
+	switch {
+	case err == repo.ErrAddressNotFound:
+		response(c, http.StatusNotFound, repo.ErrAddressNotFound)
+	case contact.IsValidationError(err):
+		response(c, http.StatusBadRequest, err)
+	case err == nil:
+		c.JSON(http.StatusOK, presenter.OkMsg)
 	default:
-		response(c, http.StatusInternalServerError, presenter.ErrServer)
+		serverError(c, err)
+	}
+}
+
+func newPostalAddress(address *presenter.PostalAddress) *domain_model.PostalAddress {
+	if address == nil {
+		return nil
+	}
+	postalAddress := domain_model.PostalAddress(*address)
+	return &postalAddress
+}
+
+func newDomainAddress(customerID, addressID uint64, req *presenter.Address) *domain_model.Address {
+	return &domain_model.Address{
+		ID:            addressID,
+		CustomerID:    customerID,
+		Recipient:     req.Recipient,
+		PhoneNumber:   req.PhoneNumber,
+		PostalAddress: domain_model.PostalAddress(req.PostalAddress),
+		Default:       req.Default,
+	}
+}
+
+func newAddressResponse(address *domain_model.Address) *presenter.AddressResponse {
+	return &presenter.AddressResponse{
+		ID: address.ID,
+		Address: presenter.Address{
+			Recipient:     address.Recipient,
+			PhoneNumber:   address.PhoneNumber,
+			PostalAddress: presenter.PostalAddress(address.PostalAddress),
+			Default:       address.Default,
+		},
+	}
+}
+
+// bindMergePatch binds a JSON merge patch request body
+// it writes an error response and returns false if the body is not a valid patch
+func bindMergePatch(c *gin.Context, patch interface{}) bool {
+	if !presenter.IsMergePatchContentType(c.ContentType()) {
+		response(c, http.StatusUnsupportedMediaType, presenter.ErrUnsupportedMediaType)
+		return false
+	}
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		response(c, http.StatusBadRequest, presenter.ErrInvalidParam)
+		return false
+	}
+	if err := presenter.BindMergePatch(body, patch); err != nil {
+		response(c, http.StatusBadRequest, presenter.ErrInvalidParam)
+		return false
+	}
+	return true
+}
+
+func updateResponse(c *gin.Context, err error) {
+	switch {
+	case err == repo.ErrCustomerNotFound:
+		response(c, http.StatusNotFound, repo.ErrCustomerNotFound)
+	case err == repo.ErrVersionConflict:
+		response(c, http.StatusPreconditionFailed, repo.ErrVersionConflict)
+	case err == repo.ErrDuplicateEntry:
+		response(c, http.StatusConflict, repo.ErrDuplicateEntry)
+	case err == repo.ErrShippingInfoInAddressBook:
+		response(c, http.StatusConflict, repo.ErrShippingInfoInAddressBook)
+	case contact.IsValidationError(err):
+		response(c, http.StatusBadRequest, err)
+	case err == nil:
+		c.JSON(http.StatusOK, presenter.OkMsg)
+	default:
+		serverError(c, err)
+	}
+}
+
+// optionalIfMatchVersion is like ifMatchVersion but treats a missing If-Match header as an unconditional update
+func optionalIfMatchVersion(c *gin.Context) (uint64, bool) {
+	if c.GetHeader(presenter.IfMatchHeader) == "" {
+		return 0, true
+	}
+	return ifMatchVersion(c)
+}
+
+// ifMatchVersion extracts the expected customer version from the If-Match header
+// it writes an error response and returns false if the header is missing or malformed
+func ifMatchVersion(c *gin.Context) (uint64, bool) {
+	ifMatch := c.GetHeader(presenter.IfMatchHeader)
+	if ifMatch == "" {
+		response(c, http.StatusPreconditionRequired, presenter.ErrPreconditionRequired)
+		return 0, false
+	}
+	version, err := presenter.ParseETag(ifMatch)
+	if err != nil {
+		response(c, http.StatusBadRequest, presenter.ErrInvalidParam)
+		return 0, false
+	}
+	return version, true
+}
+
+// serverError responds 503 to an operation rejected while the database is unavailable, which can be retried later,
+// and 500 to other errors
+func serverError(c *gin.Context, err error) {
+	if errors.Is(err, repo.ErrDatabaseUnavailable) {
+		response(c, http.StatusServiceUnavailable, presenter.ErrServiceUnavailable)
 		return
 	}
+	response(c, http.StatusInternalServerError, presenter.ErrServer)
 }
 
 func response(c *gin.Context, httpCode int, err error) {
 	message := err.Error()
 	c.JSON(httpCode, presenter.ErrResponse{
-		Message: message,
+		Message:   message,
+		RequestID: logging.RequestID(c.Request.Context()),
 	})
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"io"
 	"net/http"
+	"sync"
 
 	"github.com/gin-gonic/gin"
 	conf "github.com/minghsu0107/saga-account/config"
@@ -22,25 +23,27 @@ type Server struct {
 	Port           string
 	Engine         *gin.Engine
 	Router         *Router
+	mu             sync.Mutex
 	svr            *http.Server
+	stopped        bool
 	jwtAuthChecker *middleware.JWTAuthChecker
+	idempotency    gin.HandlerFunc
+	adminAuth      gin.HandlerFunc
+	healthProbe    *HealthProbe
 }
 
 // NewEngine is a factory for gin engine instance
 // Global Middlewares and api log configurations are registered here
 func NewEngine(config *conf.Config) *gin.Engine {
 	gin.SetMode(config.GinMode)
-	if config.GinMode == "release" {
-		log.SetLevel(log.InfoLevel)
-	} else {
-		log.SetLevel(log.DebugLevel)
-	}
 	gin.DefaultWriter = io.Writer(config.Logger.Writer)
 
 	engine := gin.New()
 	engine.Use(gin.Recovery())
+	engine.Use(middleware.RequestIDMiddleware())
 	engine.Use(middleware.LogMiddleware(config.Logger.ContextLogger))
 	engine.Use(middleware.CORSMiddleware())
+	engine.Use(middleware.RequestMetaMiddleware())
 
 	mdlw := prommiddleware.New(prommiddleware.Config{
 		Recorder: metrics.NewRecorder(metrics.Config{
@@ -52,33 +55,55 @@ func NewEngine(config *conf.Config) *gin.Engine {
 }
 
 // NewServer is the factory for server instance
-func NewServer(config *conf.Config, engine *gin.Engine, router *Router, jwtAuthChecker *middleware.JWTAuthChecker) *Server {
+func NewServer(config *conf.Config, engine *gin.Engine, router *Router, jwtAuthChecker *middleware.JWTAuthChecker, idempotencyChecker *middleware.IdempotencyChecker, healthProbe *HealthProbe) *Server {
 	return &Server{
 		App:            config.App,
 		Port:           config.HTTPPort,
 		Engine:         engine,
 		Router:         router,
 		jwtAuthChecker: jwtAuthChecker,
+		idempotency:    idempotencyChecker.Idempotency(),
+		adminAuth:      middleware.AdminAuth(config),
+		healthProbe:    healthProbe,
 	}
 }
 
 // RegisterRoutes method register all endpoints
 func (s *Server) RegisterRoutes() {
+	s.Engine.GET("/healthz", s.healthProbe.Live)
+	s.Engine.GET("/readyz", s.healthProbe.Ready)
 	apiGroup := s.Engine.Group("/api/account")
 	{
 		authGroup := apiGroup.Group("/auth")
 		{
-			authGroup.POST("/signup", s.Router.SignUp)
+			authGroup.POST("/signup", s.idempotency, middleware.SealIdempotentResponse(), s.Router.SignUp)
 			authGroup.POST("/login", s.Router.Login)
 			authGroup.POST("/refresh", s.Router.RefreshToken)
 		}
 		withJWT := apiGroup.Group("/info")
-		withJWT.Use(s.jwtAuthChecker.JWTAuth())
+		withJWT.Use(s.jwtAuthChecker.JWTAuth(), s.idempotency)
 		{
 			withJWT.GET("/person", s.Router.GetCustomerPersonalInfo)
 			withJWT.GET("/shipping", s.Router.GetCustomerShippingInfo)
 			withJWT.PUT("/person", s.Router.UpdateCustomerPersonalInfo)
 			withJWT.PUT("/shipping", s.Router.UpdateCustomerShippingInfo)
+			withJWT.PATCH("/person", s.Router.PatchCustomerPersonalInfo)
+			withJWT.PATCH("/shipping", s.Router.PatchCustomerShippingInfo)
+
+			withJWT.GET("/addresses", s.Router.ListAddresses)
+			withJWT.POST("/addresses", s.Router.CreateAddress)
+			withJWT.GET("/addresses/:id", s.Router.GetAddress)
+			withJWT.PUT("/addresses/:id", s.Router.UpdateAddress)
+			withJWT.DELETE("/addresses/:id", s.Router.DeleteAddress)
+			withJWT.PUT("/addresses/:id/default", s.Router.SetDefaultAddress)
+
+			withJWT.GET("/activity", s.Router.ListActivity)
+		}
+		withAdminToken := apiGroup.Group("/admin")
+		withAdminToken.Use(s.adminAuth, s.idempotency)
+		{
+			withAdminToken.GET("/activity", s.Router.ListAdminActivity)
+			withAdminToken.PUT("/customers/:id/status", s.Router.UpdateCustomerStatus)
 		}
 	}
 }
@@ -87,10 +112,16 @@ func (s *Server) RegisterRoutes() {
 func (s *Server) Run() error {
 	s.RegisterRoutes()
 	addr := ":" + s.Port
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return nil
+	}
 	s.svr = &http.Server{
 		Addr:    addr,
 		Handler: newOtelHandler(s.Engine, s.App+"_http"),
 	}
+	s.mu.Unlock()
 	log.Infoln("http server listening on ", addr)
 	err := s.svr.ListenAndServe()
 	if err != nil && err != http.ErrServerClosed {
@@ -100,8 +131,20 @@ func (s *Server) Run() error {
 }
 
 // GracefulStop the server
+// connections still active when ctx is done are closed; the server does not start if it is not running yet
 func (s *Server) GracefulStop(ctx context.Context) error {
-	return s.svr.Shutdown(ctx)
+	s.mu.Lock()
+	s.stopped = true
+	svr := s.svr
+	s.mu.Unlock()
+	if svr == nil {
+		return nil
+	}
+	if err := svr.Shutdown(ctx); err != nil {
+		svr.Close()
+		return err
+	}
+	return nil
 }
 
 func newOtelHandler(h http.Handler, operation string) http.Handler {
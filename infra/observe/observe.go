@@ -1,6 +1,8 @@
 package pkg
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -9,19 +11,49 @@ import (
 	log "github.com/sirupsen/logrus"
 	propjaeger "go.opentelemetry.io/contrib/propagators/jaeger"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/exporters/jaeger"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
 	tracesdk "go.opentelemetry.io/otel/sdk/trace"
-	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+)
+
+// span exporters
+const (
+	ExporterJaeger   = "jaeger"
+	ExporterOTLPGRPC = "otlpgrpc"
+	ExporterOTLPHTTP = "otlphttp"
+)
+
+// samplers
+const (
+	SamplerAlwaysOn                = "always_on"
+	SamplerAlwaysOff               = "always_off"
+	SamplerTraceIDRatio            = "traceidratio"
+	SamplerParentBasedAlwaysOn     = "parentbased_always_on"
+	SamplerParentBasedAlwaysOff    = "parentbased_always_off"
+	SamplerParentBasedTraceIDRatio = "parentbased_traceidratio"
+)
+
+var (
+	// ErrUnknownExporter is unknown span exporter error
+	ErrUnknownExporter = errors.New("unknown span exporter; supports only jaeger, otlpgrpc and otlphttp")
+	// ErrUnknownSampler is unknown sampler error
+	ErrUnknownSampler = errors.New("unknown sampler")
 )
 
 var TracerProvider *tracesdk.TracerProvider
 
 type ObservabilityInjector struct {
-	promPort  string
-	jaegerUrl string
-	app       string
+	promPort      string
+	jaegerUrl     string
+	app           string
+	tracing       conf.TracingConfig
+	metricsMux    *http.ServeMux
+	metricsServer *http.Server
 }
 
 func NewObservabilityInjector(config *conf.Config) (*ObservabilityInjector, error) {
@@ -33,47 +65,149 @@ func NewObservabilityInjector(config *conf.Config) (*ObservabilityInjector, erro
 		return nil, fmt.Errorf("app name should not be empty")
 	}
 
-	return &ObservabilityInjector{
-		promPort:  promPort,
-		jaegerUrl: jaegerUrl,
-		app:       app,
-	}, nil
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("/", promhttp.Handler())
+	injector := &ObservabilityInjector{
+		promPort:   promPort,
+		jaegerUrl:  jaegerUrl,
+		app:        app,
+		metricsMux: metricsMux,
+		metricsServer: &http.Server{
+			Addr:    fmt.Sprintf(":%s", promPort),
+			Handler: metricsMux,
+		},
+	}
+	if config.TracingConfig != nil {
+		injector.tracing = *config.TracingConfig
+	}
+	// the jaeger exporter is used if only the jaeger url is configured
+	if injector.tracing.Exporter == "" && jaegerUrl != "" {
+		injector.tracing.Exporter = ExporterJaeger
+	}
+	return injector, nil
 }
 
+// Register sets the global tracer provider exporting spans to the configured exporter
+// spans are propagated in W3C trace context and jaeger headers, together with W3C baggage
 func (injector *ObservabilityInjector) Register() error {
-	if injector.jaegerUrl != "" {
-		err := initTracerProvider(injector.jaegerUrl, injector.app)
-		if err != nil {
-			return err
-		}
-		otel.SetTracerProvider(TracerProvider)
-		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propjaeger.Jaeger{}, propagation.Baggage{}))
+	if injector.tracing.Exporter == "" {
+		return nil
 	}
-	if injector.promPort != "" {
-		go func() {
-			log.Infof("starting prom metrics on PROM_PORT=[%s]", injector.promPort)
-			err := http.ListenAndServe(fmt.Sprintf(":%s", injector.promPort), promhttp.Handler())
-			if err != nil {
-				log.Fatal(err)
-			}
-		}()
+	ctx := context.Background()
+	exp, err := newExporter(ctx, injector.tracing, injector.jaegerUrl)
+	if err != nil {
+		return err
 	}
-	return nil
-}
-
-func initTracerProvider(jaegerUrl, serviceName string) error {
-	exp, err := jaeger.New(jaeger.WithCollectorEndpoint(jaeger.WithEndpoint(jaegerUrl)))
+	sampler, err := NewSampler(injector.tracing)
+	if err != nil {
+		return err
+	}
+	res, err := NewResource(ctx, injector.app, injector.tracing.ResourceAttributes)
 	if err != nil {
 		return err
 	}
 	TracerProvider = tracesdk.NewTracerProvider(
 		// Always be sure to batch in production.
 		tracesdk.WithBatcher(exp),
-		// Record information about this application in a Resource.
-		tracesdk.WithResource(resource.NewWithAttributes(
-			semconv.SchemaURL,
-			semconv.ServiceNameKey.String(serviceName),
-		)),
+		tracesdk.WithSampler(sampler),
+		tracesdk.WithResource(res),
 	)
+	otel.SetTracerProvider(TracerProvider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propjaeger.Jaeger{}, propagation.Baggage{},
+	))
+	log.Infof("exporting spans with %s", injector.tracing.Exporter)
+	return nil
+}
+
+// MetricsEnabled reports whether prometheus metrics are served
+func (injector *ObservabilityInjector) MetricsEnabled() bool {
+	return injector.promPort != ""
+}
+
+// PromPort returns the port serving prometheus metrics
+func (injector *ObservabilityInjector) PromPort() string {
+	return injector.promPort
+}
+
+// Handle serves handler for pattern next to prometheus metrics, which are served on every other path
+func (injector *ObservabilityInjector) Handle(pattern string, handler http.Handler) {
+	injector.metricsMux.Handle(pattern, handler)
+}
+
+// ServeMetrics serves prometheus metrics until StopMetrics is called
+func (injector *ObservabilityInjector) ServeMetrics() error {
+	log.Infof("starting prom metrics on PROM_PORT=[%s]", injector.promPort)
+	err := injector.metricsServer.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		return err
+	}
 	return nil
 }
+
+// StopMetrics stops serving prometheus metrics
+func (injector *ObservabilityInjector) StopMetrics(ctx context.Context) error {
+	return injector.metricsServer.Shutdown(ctx)
+}
+
+// Shutdown flushes the spans not exported yet
+func (injector *ObservabilityInjector) Shutdown(ctx context.Context) error {
+	if TracerProvider == nil {
+		return nil
+	}
+	return TracerProvider.Shutdown(ctx)
+}
+
+func newExporter(ctx context.Context, config conf.TracingConfig, jaegerUrl string) (tracesdk.SpanExporter, error) {
+	switch config.Exporter {
+	case ExporterJaeger:
+		return jaeger.New(jaeger.WithCollectorEndpoint(jaeger.WithEndpoint(jaegerUrl)))
+	case ExporterOTLPGRPC:
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(config.Endpoint)}
+		if config.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		return otlptracegrpc.New(ctx, opts...)
+	case ExporterOTLPHTTP:
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(config.Endpoint)}
+		if config.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.New(ctx, opts...)
+	}
+	return nil, ErrUnknownExporter
+}
+
+// NewSampler returns the sampler of config; it samples every trace unless its parent is not sampled by default
+func NewSampler(config conf.TracingConfig) (tracesdk.Sampler, error) {
+	switch config.Sampler {
+	case SamplerAlwaysOn:
+		return tracesdk.AlwaysSample(), nil
+	case SamplerAlwaysOff:
+		return tracesdk.NeverSample(), nil
+	case SamplerTraceIDRatio:
+		return tracesdk.TraceIDRatioBased(config.SampleRatio), nil
+	case "", SamplerParentBasedAlwaysOn:
+		return tracesdk.ParentBased(tracesdk.AlwaysSample()), nil
+	case SamplerParentBasedAlwaysOff:
+		return tracesdk.ParentBased(tracesdk.NeverSample()), nil
+	case SamplerParentBasedTraceIDRatio:
+		return tracesdk.ParentBased(tracesdk.TraceIDRatioBased(config.SampleRatio)), nil
+	}
+	return nil, ErrUnknownSampler
+}
+
+// NewResource returns the resource of the service named app, with the host name, the attributes
+// in OTEL_RESOURCE_ATTRIBUTES and attrs, which take precedence
+func NewResource(ctx context.Context, app string, attrs map[string]string) (*resource.Resource, error) {
+	kvs := []attribute.KeyValue{semconv.ServiceNameKey.String(app)}
+	for k, v := range attrs {
+		kvs = append(kvs, attribute.String(k, v))
+	}
+	return resource.New(ctx,
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithHost(),
+		resource.WithFromEnv(),
+		resource.WithAttributes(kvs...),
+	)
+}
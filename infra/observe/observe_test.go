@@ -0,0 +1,102 @@
+package pkg
+
+import (
+	"context"
+	"testing"
+
+	conf "github.com/minghsu0107/saga-account/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel/attribute"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestObserve(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "observe suite")
+}
+
+var _ = Describe("tracing", func() {
+	// spans returns the spans exported by a provider sampling with sampler, after a root span
+	// and a child of a sampled remote parent are started
+	spans := func(sampler tracesdk.Sampler) []string {
+		exporter := tracetest.NewInMemoryExporter()
+		tracer := tracesdk.NewTracerProvider(tracesdk.WithSyncer(exporter), tracesdk.WithSampler(sampler)).Tracer("test")
+
+		_, root := tracer.Start(context.Background(), "root")
+		root.End()
+		parent := trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    trace.TraceID{1},
+			SpanID:     trace.SpanID{1},
+			TraceFlags: trace.FlagsSampled,
+			Remote:     true,
+		})
+		_, child := tracer.Start(trace.ContextWithRemoteSpanContext(context.Background(), parent), "child")
+		child.End()
+
+		var names []string
+		for _, span := range exporter.GetSpans() {
+			names = append(names, span.Name)
+		}
+		return names
+	}
+	It("should sample by ratio unless the parent is sampled", func() {
+		sampler, err := NewSampler(conf.TracingConfig{
+			Sampler:     SamplerParentBasedTraceIDRatio,
+			SampleRatio: 0,
+		})
+		Expect(err).To(BeNil())
+		Expect(spans(sampler)).To(Equal([]string{"child"}))
+
+		sampler, err = NewSampler(conf.TracingConfig{
+			Sampler:     SamplerTraceIDRatio,
+			SampleRatio: 0,
+		})
+		Expect(err).To(BeNil())
+		Expect(spans(sampler)).To(BeEmpty())
+	})
+	It("should sample every trace by default", func() {
+		sampler, err := NewSampler(conf.TracingConfig{})
+		Expect(err).To(BeNil())
+		Expect(spans(sampler)).To(Equal([]string{"root", "child"}))
+	})
+	It("should reject unknown samplers and exporters", func() {
+		_, err := NewSampler(conf.TracingConfig{
+			Sampler: "sometimes",
+		})
+		Expect(err).To(Equal(ErrUnknownSampler))
+		_, err = newExporter(context.Background(), conf.TracingConfig{
+			Exporter: "zipkin",
+		}, "")
+		Expect(err).To(Equal(ErrUnknownExporter))
+	})
+	It("should describe the service in the resource", func() {
+		res, err := NewResource(context.Background(), "account", map[string]string{
+			"deployment.environment": "test",
+		})
+		Expect(err).To(BeNil())
+		attrs := res.Set()
+		name, _ := attrs.Value("service.name")
+		Expect(name).To(Equal(attribute.StringValue("account")))
+		env, _ := attrs.Value("deployment.environment")
+		Expect(env).To(Equal(attribute.StringValue("test")))
+		Expect(attrs.HasValue("host.name")).To(BeTrue())
+	})
+	It("should export to jaeger if only the jaeger url is configured", func() {
+		injector, err := NewObservabilityInjector(&conf.Config{
+			App:       "account",
+			JaegerUrl: "http://jaeger:14268/api/traces",
+		})
+		Expect(err).To(BeNil())
+		Expect(injector.tracing.Exporter).To(Equal(ExporterJaeger))
+
+		injector, err = NewObservabilityInjector(&conf.Config{
+			App: "account",
+		})
+		Expect(err).To(BeNil())
+		Expect(injector.Register()).To(BeNil())
+		Expect(TracerProvider).To(BeNil())
+	})
+})
@@ -0,0 +1,138 @@
+package outbox
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redsync/redsync/v4"
+	conf "github.com/minghsu0107/saga-account/config"
+	"github.com/minghsu0107/saga-account/infra/broker"
+	"github.com/minghsu0107/saga-account/infra/cache"
+	"github.com/minghsu0107/saga-account/repo"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	relayMutexName = "mutex:outbox_relay"
+	// relayLockExpiry exceeds the worst case of each step of a batch; the mutex is extended between steps
+	relayLockExpiry = 30 * time.Second
+)
+
+// Relay publishes outbox events to the broker
+// delivery is at-least-once: an event is deleted from the outbox only after it is published,
+// so events are published again if the relay fails in between
+type Relay struct {
+	outboxRepo repo.OutboxRepository
+	publisher  broker.Publisher
+	rc         cache.RedisCache
+	topic      string
+	batchSize  int
+	interval   time.Duration
+	quit       chan struct{}
+	done       chan struct{}
+	logger     *log.Entry
+}
+
+// NewRelay is the factory of outbox Relay
+func NewRelay(config *conf.Config, outboxRepo repo.OutboxRepository, publisher broker.Publisher, rc cache.RedisCache) *Relay {
+	return &Relay{
+		outboxRepo: outboxRepo,
+		publisher:  publisher,
+		rc:         rc,
+		topic:      config.OutboxConfig.Topic,
+		batchSize:  config.OutboxConfig.BatchSize,
+		interval:   time.Duration(config.OutboxConfig.PollIntervalMillis) * time.Millisecond,
+		quit:       make(chan struct{}),
+		done:       make(chan struct{}),
+		logger:     config.Logger.ContextLogger.WithField("type", "outbox:Relay"),
+	}
+}
+
+// Run polls the outbox and relays pending events until Close is called
+func (r *Relay) Run() error {
+	defer close(r.done)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.quit:
+			return nil
+		case <-ticker.C:
+			r.drain(context.Background())
+		}
+	}
+}
+
+// Close stops the relay and waits for the batch in flight
+func (r *Relay) Close() {
+	close(r.quit)
+	<-r.done
+}
+
+// drain relays batches until the outbox is empty or relaying fails
+func (r *Relay) drain(ctx context.Context) {
+	for {
+		select {
+		case <-r.quit:
+			return
+		default:
+		}
+		n, err := r.RelayOnce(ctx)
+		if err != nil {
+			r.logger.Error(err.Error())
+			return
+		}
+		if n < r.batchSize {
+			return
+		}
+	}
+}
+
+// RelayOnce publishes a batch of the oldest pending events in commit order and returns the number of events published
+// batches are serialized across instances by a redis mutex so that events of a customer are never reordered
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	mutex := r.rc.GetMutex(relayMutexName, redsync.WithExpiry(relayLockExpiry))
+	if err := mutex.LockContext(ctx); err != nil {
+		if err == redsync.ErrFailed {
+			// another instance is relaying
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer mutex.UnlockContext(ctx)
+
+	events, err := r.outboxRepo.ListPendingEvents(ctx, r.batchSize)
+	if err != nil {
+		return 0, err
+	}
+	if len(events) == 0 {
+		return 0, nil
+	}
+	msgs := make([]*broker.Message, len(events))
+	ids := make([]uint64, len(events))
+	for i, event := range events {
+		msgs[i] = &broker.Message{
+			ID:        strconv.FormatUint(event.ID, 10),
+			Key:       strconv.FormatUint(event.CustomerID, 10),
+			Type:      event.Type,
+			RequestID: event.RequestID,
+			Payload:   []byte(event.Payload),
+		}
+		ids[i] = event.ID
+	}
+	// a relay that lost the mutex stops, since another instance may be relaying the same events
+	if _, err := mutex.ExtendContext(ctx); err != nil {
+		return 0, err
+	}
+	if err := r.publisher.Publish(ctx, r.topic, msgs...); err != nil {
+		return 0, err
+	}
+	if _, err := mutex.ExtendContext(ctx); err != nil {
+		return 0, err
+	}
+	if err := r.outboxRepo.DeleteEvents(ctx, ids); err != nil {
+		return 0, err
+	}
+	return len(events), nil
+}
@@ -0,0 +1,163 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/golang/mock/gomock"
+	conf "github.com/minghsu0107/saga-account/config"
+	"github.com/minghsu0107/saga-account/infra/broker"
+	"github.com/minghsu0107/saga-account/infra/cache"
+	mock_repo "github.com/minghsu0107/saga-account/mock/repo"
+	"github.com/minghsu0107/saga-account/repo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/redis/go-redis/v9"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	mockCtrl       *gomock.Controller
+	mockOutboxRepo *mock_repo.MockOutboxRepository
+	mr             *miniredis.Miniredis
+	client         redis.UniversalClient
+	relay          *Relay
+	testTopic      = "test:customer_events"
+)
+
+type failingPublisher struct{}
+
+func (failingPublisher) Publish(ctx context.Context, topic string, msgs ...*broker.Message) error {
+	return errors.New("broker unavailable")
+}
+
+func TestOutbox(t *testing.T) {
+	mockCtrl = gomock.NewController(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "outbox suite")
+}
+
+func newTestConfig() *conf.Config {
+	return &conf.Config{
+		RedisConfig: &conf.RedisConfig{
+			ExpirationSeconds: 60,
+			StreamMaxLen:      1000,
+		},
+		OutboxConfig: &conf.OutboxConfig{
+			Topic:              testTopic,
+			BatchSize:          2,
+			PollIntervalMillis: 10,
+		},
+		Logger: &conf.Logger{
+			Writer: ioutil.Discard,
+			ContextLogger: log.WithFields(log.Fields{
+				"app": "test",
+			}),
+		},
+	}
+}
+
+var _ = BeforeSuite(func() {
+	mockOutboxRepo = mock_repo.NewMockOutboxRepository(mockCtrl)
+	var err error
+	mr, err = miniredis.Run()
+	if err != nil {
+		panic(err)
+	}
+	client = redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs: []string{mr.Addr()},
+	})
+	config := newTestConfig()
+	rc, err := cache.NewRedisCache(config, client)
+	Expect(err).To(BeNil())
+	relay = NewRelay(config, mockOutboxRepo, broker.NewRedisStreamPublisher(config, client), rc)
+})
+
+var _ = AfterSuite(func() {
+	mockCtrl.Finish()
+	client.Close()
+})
+
+var _ = Describe("outbox relay", func() {
+	BeforeEach(func() {
+		Expect(client.Del(context.Background(), testTopic).Err()).To(BeNil())
+	})
+	It("should publish pending events in order and delete them", func() {
+		mockOutboxRepo.EXPECT().ListPendingEvents(gomock.Any(), 2).Return([]repo.OutboxEvent{
+			{ID: 1, CustomerID: 10, Type: "customer.signed_up", Payload: `{"type":"customer.signed_up"}`},
+			{ID: 2, CustomerID: 10, Type: "customer.email_changed", RequestID: "req-1", Payload: `{"type":"customer.email_changed"}`},
+		}, nil)
+		mockOutboxRepo.EXPECT().DeleteEvents(gomock.Any(), []uint64{1, 2}).Return(nil)
+
+		n, err := relay.RelayOnce(context.Background())
+		Expect(err).To(BeNil())
+		Expect(n).To(Equal(2))
+
+		entries, err := client.XRange(context.Background(), testTopic, "-", "+").Result()
+		Expect(err).To(BeNil())
+		Expect(entries).To(HaveLen(2))
+		Expect(entries[0].Values).To(Equal(map[string]interface{}{
+			"id":      "1",
+			"key":     "10",
+			"type":    "customer.signed_up",
+			"payload": `{"type":"customer.signed_up"}`,
+		}))
+		Expect(entries[1].Values["id"]).To(Equal("2"))
+		Expect(entries[1].Values["request_id"]).To(Equal("req-1"))
+	})
+	It("should do nothing when the outbox is empty", func() {
+		mockOutboxRepo.EXPECT().ListPendingEvents(gomock.Any(), 2).Return([]repo.OutboxEvent{}, nil)
+		n, err := relay.RelayOnce(context.Background())
+		Expect(err).To(BeNil())
+		Expect(n).To(Equal(0))
+	})
+	It("should not publish events after losing the mutex", func() {
+		mockOutboxRepo.EXPECT().ListPendingEvents(gomock.Any(), 2).DoAndReturn(func(ctx context.Context, limit int) ([]repo.OutboxEvent, error) {
+			// the listing outlives the mutex
+			mr.FastForward(relayLockExpiry + time.Second)
+			return []repo.OutboxEvent{
+				{ID: 4, CustomerID: 10, Type: "customer.deactivated", Payload: `{}`},
+			}, nil
+		})
+		// DeleteEvents must not be called
+		_, err := relay.RelayOnce(context.Background())
+		Expect(err).NotTo(BeNil())
+		Expect(client.XLen(context.Background(), testTopic).Val()).To(Equal(int64(0)))
+	})
+	It("should keep events in the outbox when publishing fails", func() {
+		config := newTestConfig()
+		rc, err := cache.NewRedisCache(config, client)
+		Expect(err).To(BeNil())
+		failingRelay := NewRelay(config, mockOutboxRepo, failingPublisher{}, rc)
+		mockOutboxRepo.EXPECT().ListPendingEvents(gomock.Any(), 2).Return([]repo.OutboxEvent{
+			{ID: 3, CustomerID: 10, Type: "customer.deactivated", Payload: `{}`},
+		}, nil)
+		// DeleteEvents must not be called
+		_, err = failingRelay.RelayOnce(context.Background())
+		Expect(err).NotTo(BeNil())
+	})
+	It("should drain the outbox in batches until closed", func() {
+		gomock.InOrder(
+			mockOutboxRepo.EXPECT().ListPendingEvents(gomock.Any(), 2).Return([]repo.OutboxEvent{
+				{ID: 4, CustomerID: 11, Type: "customer.signed_up", Payload: `{}`},
+				{ID: 5, CustomerID: 12, Type: "customer.signed_up", Payload: `{}`},
+			}, nil),
+			mockOutboxRepo.EXPECT().DeleteEvents(gomock.Any(), []uint64{4, 5}).Return(nil),
+			mockOutboxRepo.EXPECT().ListPendingEvents(gomock.Any(), 2).Return([]repo.OutboxEvent{
+				{ID: 6, CustomerID: 11, Type: "customer.address_changed", Payload: `{}`},
+			}, nil),
+			mockOutboxRepo.EXPECT().DeleteEvents(gomock.Any(), []uint64{6}).Return(nil),
+		)
+		mockOutboxRepo.EXPECT().ListPendingEvents(gomock.Any(), 2).Return([]repo.OutboxEvent{}, nil).AnyTimes()
+
+		go relay.Run()
+		Eventually(func() int64 {
+			return client.XLen(context.Background(), testTopic).Val()
+		}).Should(Equal(int64(3)))
+		relay.Close()
+	})
+})
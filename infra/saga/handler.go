@@ -0,0 +1,99 @@
+package saga
+
+import (
+	"context"
+	"encoding/json"
+
+	conf "github.com/minghsu0107/saga-account/config"
+	"github.com/minghsu0107/saga-account/domain/model"
+	"github.com/minghsu0107/saga-account/infra/broker"
+	"github.com/minghsu0107/saga-account/pkg/logging"
+	"github.com/minghsu0107/saga-account/service/saga"
+	log "github.com/sirupsen/logrus"
+)
+
+// CommandHandler consumes saga commands from the broker and publishes the replies
+type CommandHandler struct {
+	sagaSvc      saga.SagaService
+	subscriber   broker.Subscriber
+	publisher    broker.Publisher
+	commandTopic string
+	replyTopic   string
+	group        string
+	ctx          context.Context
+	cancel       context.CancelFunc
+	done         chan struct{}
+	logger       *log.Entry
+}
+
+// NewCommandHandler is the factory of saga CommandHandler
+func NewCommandHandler(config *conf.Config, sagaSvc saga.SagaService, subscriber broker.Subscriber, publisher broker.Publisher) *CommandHandler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &CommandHandler{
+		sagaSvc:      sagaSvc,
+		subscriber:   subscriber,
+		publisher:    publisher,
+		commandTopic: config.SagaConfig.CommandTopic,
+		replyTopic:   config.SagaConfig.ReplyTopic,
+		group:        config.SagaConfig.ConsumerGroup,
+		ctx:          ctx,
+		cancel:       cancel,
+		done:         make(chan struct{}),
+		logger:       config.Logger.ContextLogger.WithField("type", "saga:CommandHandler"),
+	}
+}
+
+// Run handles saga commands until Close is called
+func (h *CommandHandler) Run() error {
+	defer close(h.done)
+	return h.subscriber.Subscribe(h.ctx, h.commandTopic, h.group, h.Handle)
+}
+
+// Close stops consuming and waits for the command in flight
+func (h *CommandHandler) Close() {
+	h.cancel()
+	<-h.done
+}
+
+// Handle handles a saga command message and publishes its reply
+// the command is handled under the request id of the message, or a new one, which is forwarded in the reply.
+// malformed and unknown commands are dropped, since redelivering them would never succeed
+func (h *CommandHandler) Handle(ctx context.Context, msg *broker.Message) error {
+	requestID := msg.RequestID
+	if !logging.ValidRequestID(requestID) {
+		requestID = logging.NewRequestID()
+	}
+	ctx = logging.WithRequestID(ctx, requestID)
+
+	var cmd model.SagaCommand
+	if err := json.Unmarshal(msg.Payload, &cmd); err != nil {
+		h.logger.WithContext(ctx).WithField("message_id", msg.ID).Error(err.Error())
+		return nil
+	}
+	reply, err := h.sagaSvc.HandleCommand(ctx, &cmd)
+	switch err {
+	case nil:
+	case saga.ErrInvalidCommand, saga.ErrUnknownCommand:
+		h.logger.WithContext(ctx).WithFields(log.Fields{
+			"message_id": msg.ID,
+			"saga_id":    cmd.SagaID,
+			"command":    cmd.Type,
+		}).Error(err.Error())
+		return nil
+	default:
+		return err
+	}
+
+	payload, err := json.Marshal(reply)
+	if err != nil {
+		return err
+	}
+	// replies are deterministic, so the orchestrator can discard duplicates by ID
+	return h.publisher.Publish(ctx, h.replyTopic, &broker.Message{
+		ID:        reply.SagaID + ":" + string(reply.Type),
+		Key:       reply.SagaID,
+		Type:      string(reply.Type),
+		RequestID: requestID,
+		Payload:   payload,
+	})
+}
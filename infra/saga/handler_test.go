@@ -0,0 +1,170 @@
+package saga
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	conf "github.com/minghsu0107/saga-account/config"
+	"github.com/minghsu0107/saga-account/domain/model"
+	"github.com/minghsu0107/saga-account/infra/broker"
+	mock_service "github.com/minghsu0107/saga-account/mock/service"
+	"github.com/minghsu0107/saga-account/pkg/logging"
+	"github.com/minghsu0107/saga-account/service/saga"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	mockCtrl    *gomock.Controller
+	mockSagaSvc *mock_service.MockSagaService
+	testConfig  *conf.Config
+)
+
+func TestSaga(t *testing.T) {
+	mockCtrl = gomock.NewController(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "saga handler suite")
+}
+
+var _ = BeforeSuite(func() {
+	mockSagaSvc = mock_service.NewMockSagaService(mockCtrl)
+	testConfig = &conf.Config{
+		SagaConfig: &conf.SagaConfig{
+			CommandTopic:  "account:saga_commands",
+			ReplyTopic:    "account:saga_replies",
+			ConsumerGroup: "account",
+		},
+		Logger: &conf.Logger{
+			Writer: ioutil.Discard,
+			ContextLogger: log.WithFields(log.Fields{
+				"app": "test",
+			}),
+		},
+	}
+})
+
+var _ = AfterSuite(func() {
+	mockCtrl.Finish()
+})
+
+func newCommandMessage(cmd *model.SagaCommand) *broker.Message {
+	payload, _ := json.Marshal(cmd)
+	return &broker.Message{
+		ID:      cmd.SagaID + ":" + string(cmd.Type),
+		Key:     cmd.SagaID,
+		Type:    string(cmd.Type),
+		Payload: payload,
+	}
+}
+
+var _ = Describe("saga command handler", func() {
+	var mb *broker.MemoryBroker
+	var handler *CommandHandler
+	var cmd *model.SagaCommand
+	BeforeEach(func() {
+		mb = broker.NewMemoryBroker()
+		handler = NewCommandHandler(testConfig, mockSagaSvc, mb, mb)
+		cmd = &model.SagaCommand{
+			SagaID:     "saga-1",
+			Type:       model.SagaReserveCustomer,
+			CustomerID: 1,
+			OrderID:    2,
+		}
+		go handler.Run()
+	})
+	AfterEach(func() {
+		handler.Close()
+	})
+	It("should publish the reply of a command", func() {
+		reply := &model.SagaReply{
+			SagaID:     "saga-1",
+			Type:       model.SagaCustomerReserved,
+			CustomerID: 1,
+			OrderID:    2,
+		}
+		mockSagaSvc.EXPECT().HandleCommand(gomock.Any(), cmd).Return(reply, nil)
+		mb.Publish(context.Background(), testConfig.SagaConfig.CommandTopic, newCommandMessage(cmd))
+
+		Eventually(func() int {
+			return len(mb.Messages(testConfig.SagaConfig.ReplyTopic))
+		}, time.Second).Should(Equal(1))
+		msg := mb.Messages(testConfig.SagaConfig.ReplyTopic)[0]
+		Expect(msg.ID).To(Equal("saga-1:customer.reserved"))
+		Expect(msg.Key).To(Equal("saga-1"))
+		Expect(msg.Type).To(Equal(string(model.SagaCustomerReserved)))
+		var published model.SagaReply
+		Expect(json.Unmarshal(msg.Payload, &published)).To(BeNil())
+		Expect(&published).To(Equal(reply))
+	})
+	It("should handle a command under its request id and forward it in the reply", func() {
+		reply := &model.SagaReply{
+			SagaID:     "saga-1",
+			Type:       model.SagaCustomerReserved,
+			CustomerID: 1,
+			OrderID:    2,
+		}
+		var requestID string
+		mockSagaSvc.EXPECT().HandleCommand(gomock.Any(), cmd).DoAndReturn(func(ctx context.Context, cmd *model.SagaCommand) (*model.SagaReply, error) {
+			requestID = logging.RequestID(ctx)
+			return reply, nil
+		})
+		msg := newCommandMessage(cmd)
+		msg.RequestID = "req-1"
+		mb.Publish(context.Background(), testConfig.SagaConfig.CommandTopic, msg)
+
+		Eventually(func() int {
+			return len(mb.Messages(testConfig.SagaConfig.ReplyTopic))
+		}, time.Second).Should(Equal(1))
+		Expect(requestID).To(Equal("req-1"))
+		Expect(mb.Messages(testConfig.SagaConfig.ReplyTopic)[0].RequestID).To(Equal("req-1"))
+	})
+	It("should redeliver a command failing transiently", func() {
+		reply := &model.SagaReply{
+			SagaID:     "saga-1",
+			Type:       model.SagaCustomerReserved,
+			CustomerID: 1,
+			OrderID:    2,
+		}
+		gomock.InOrder(
+			mockSagaSvc.EXPECT().HandleCommand(gomock.Any(), cmd).Return(nil, errors.New("db down")),
+			mockSagaSvc.EXPECT().HandleCommand(gomock.Any(), cmd).Return(reply, nil),
+		)
+		mb.Publish(context.Background(), testConfig.SagaConfig.CommandTopic, newCommandMessage(cmd))
+
+		Eventually(func() int {
+			return len(mb.Messages(testConfig.SagaConfig.ReplyTopic))
+		}, time.Second).Should(Equal(1))
+	})
+	It("should drop malformed and unknown commands", func() {
+		unknown := &model.SagaCommand{
+			SagaID: "saga-2",
+			Type:   "customer.unknown",
+		}
+		reply := &model.SagaReply{
+			SagaID: "saga-3",
+			Type:   model.SagaCustomerReleased,
+		}
+		next := &model.SagaCommand{
+			SagaID: "saga-3",
+			Type:   model.SagaReleaseCustomer,
+		}
+		mockSagaSvc.EXPECT().HandleCommand(gomock.Any(), unknown).Return(nil, saga.ErrUnknownCommand)
+		mockSagaSvc.EXPECT().HandleCommand(gomock.Any(), next).Return(reply, nil)
+		mb.Publish(context.Background(), testConfig.SagaConfig.CommandTopic,
+			&broker.Message{ID: "malformed", Payload: []byte("{")},
+			newCommandMessage(unknown),
+			newCommandMessage(next),
+		)
+
+		Eventually(func() int {
+			return len(mb.Messages(testConfig.SagaConfig.ReplyTopic))
+		}, time.Second).Should(Equal(1))
+		Expect(mb.Messages(testConfig.SagaConfig.ReplyTopic)[0].ID).To(Equal("saga-3:customer.released"))
+	})
+})
@@ -2,79 +2,169 @@ package infra
 
 import (
 	"context"
+	"time"
 
+	conf "github.com/minghsu0107/saga-account/config"
+	infra_admin "github.com/minghsu0107/saga-account/infra/admin"
 	infra_cache "github.com/minghsu0107/saga-account/infra/cache"
+	infra_db "github.com/minghsu0107/saga-account/infra/db"
 	infra_grpc "github.com/minghsu0107/saga-account/infra/grpc"
 	infra_http "github.com/minghsu0107/saga-account/infra/http"
 	infra_observe "github.com/minghsu0107/saga-account/infra/observe"
+	infra_outbox "github.com/minghsu0107/saga-account/infra/outbox"
+	infra_saga "github.com/minghsu0107/saga-account/infra/saga"
+	"github.com/minghsu0107/saga-account/pkg/health"
+	"github.com/minghsu0107/saga-account/pkg/lifecycle"
+	"github.com/redis/go-redis/v9"
 	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
 // Server wraps http and grpc server
 type Server struct {
-	HTTPServer   *infra_http.Server
-	GRPCServer   *infra_grpc.Server
-	ObsInjector  *infra_observe.ObservabilityInjector
-	CacheCleaner infra_cache.LocalCacheCleaner
+	HTTPServer     *infra_http.Server
+	GRPCServer     *infra_grpc.Server
+	ObsInjector    *infra_observe.ObservabilityInjector
+	AdminServer    *infra_admin.Server
+	CacheCleaner   infra_cache.LocalCacheCleaner
+	PolicyReloader *infra_cache.PolicyReloader
+	OutboxRelay    *infra_outbox.Relay
+	SagaHandler    *infra_saga.CommandHandler
+	DBRouter       *infra_db.Router
+	Health         *health.Registry
+	DB             *gorm.DB
+	RedisClient    redis.UniversalClient
+	manager        *lifecycle.Manager
+	drain          time.Duration
 }
 
-func NewServer(httpServer *infra_http.Server, grpcServer *infra_grpc.Server, obsInjector *infra_observe.ObservabilityInjector, cacheCleaner infra_cache.LocalCacheCleaner) *Server {
-	return &Server{
-		HTTPServer:   httpServer,
-		GRPCServer:   grpcServer,
-		ObsInjector:  obsInjector,
-		CacheCleaner: cacheCleaner,
+func NewServer(config *conf.Config, httpServer *infra_http.Server, grpcServer *infra_grpc.Server, obsInjector *infra_observe.ObservabilityInjector, adminServer *infra_admin.Server, cacheCleaner infra_cache.LocalCacheCleaner, policyReloader *infra_cache.PolicyReloader, outboxRelay *infra_outbox.Relay, sagaHandler *infra_saga.CommandHandler, dbRouter *infra_db.Router, registry *health.Registry, db *gorm.DB, redisClient redis.UniversalClient) *Server {
+	opts := lifecycle.Options{
+		StopTimeouts: make(map[string]time.Duration),
+		Logger:       config.Logger.ContextLogger.WithField("type", "lifecycle:Manager"),
 	}
-}
-
-// Run server
-func (s *Server) Run() error {
-	if err := s.ObsInjector.Register(); err != nil {
-		return err
+	s := &Server{
+		HTTPServer:     httpServer,
+		GRPCServer:     grpcServer,
+		ObsInjector:    obsInjector,
+		AdminServer:    adminServer,
+		CacheCleaner:   cacheCleaner,
+		PolicyReloader: policyReloader,
+		OutboxRelay:    outboxRelay,
+		SagaHandler:    sagaHandler,
+		DBRouter:       dbRouter,
+		Health:         registry,
+		DB:             db,
+		RedisClient:    redisClient,
 	}
-	go func() {
-		err := s.HTTPServer.Run()
-		if err != nil {
-			log.Fatal(err)
-		}
-	}()
-	go func() {
-		err := s.GRPCServer.Run()
-		if err != nil {
-			log.Fatal(err)
+	if shutdownConfig := config.ShutdownConfig; shutdownConfig != nil {
+		opts.Timeout = time.Duration(shutdownConfig.TimeoutSeconds) * time.Second
+		for name, seconds := range shutdownConfig.ComponentTimeoutsSeconds {
+			opts.StopTimeouts[name] = time.Duration(seconds) * time.Second
 		}
-	}()
-	go func() {
-		err := s.CacheCleaner.SubscribeInvalidationEvent()
-		if err != nil {
-			log.Fatal(err)
-		}
-	}()
-	return nil
-}
-
-// GracefulStop server
-func (s *Server) GracefulStop(ctx context.Context, done chan bool) {
-	err := s.HTTPServer.GracefulStop(ctx)
-	if err != nil {
-		log.Error(err)
+		s.drain = time.Duration(shutdownConfig.DrainSeconds) * time.Second
 	}
-	s.GRPCServer.GracefulStop()
-	s.CacheCleaner.Close()
+	s.manager = lifecycle.New(opts)
+	s.register()
+	return s
+}
 
-	if infra_observe.TracerProvider != nil {
-		err = infra_observe.TracerProvider.Shutdown(ctx)
-		if err != nil {
-			log.Error(err)
-		}
+// register appends the components in dependency order, so that a component starts after and stops before
+// the components it depends on
+func (s *Server) register() {
+	s.manager.Append(lifecycle.Hook{
+		Name: "observability",
+		OnStart: func(ctx context.Context) error {
+			return s.ObsInjector.Register()
+		},
+		OnStop: s.ObsInjector.Shutdown,
+	})
+	if s.ObsInjector.MetricsEnabled() {
+		s.manager.Append(lifecycle.Hook{
+			Name:   "metrics",
+			Run:    s.ObsInjector.ServeMetrics,
+			OnStop: s.ObsInjector.StopMetrics,
+		})
 	}
-	if err = infra_cache.RedisClient.Close(); err != nil {
-		log.Error(err)
+	if s.AdminServer.Listening() {
+		s.manager.Append(lifecycle.Hook{
+			Name:   "admin",
+			Run:    s.AdminServer.Run,
+			OnStop: s.AdminServer.GracefulStop,
+		})
 	}
+	s.manager.Append(lifecycle.Hook{
+		Name: "db",
+		OnStop: func(ctx context.Context) error {
+			sqlDB, err := s.DB.DB()
+			if err != nil {
+				return err
+			}
+			return sqlDB.Close()
+		},
+	}, lifecycle.Hook{
+		Name: "redis",
+		OnStop: func(ctx context.Context) error {
+			return s.RedisClient.Close()
+		},
+	}, lifecycle.Hook{
+		Name:   "db-router",
+		Run:    s.DBRouter.Run,
+		OnStop: closer(s.DBRouter.Close),
+	}, lifecycle.Hook{
+		Name:   "cache-cleaner",
+		Run:    s.CacheCleaner.SubscribeInvalidationEvent,
+		OnStop: closer(s.CacheCleaner.Close),
+	}, lifecycle.Hook{
+		Name:   "policy-reloader",
+		Run:    s.PolicyReloader.Run,
+		OnStop: closer(s.PolicyReloader.Close),
+	}, lifecycle.Hook{
+		Name:   "outbox-relay",
+		Run:    s.OutboxRelay.Run,
+		OnStop: closer(s.OutboxRelay.Close),
+	}, lifecycle.Hook{
+		Name:   "saga-handler",
+		Run:    s.SagaHandler.Run,
+		OnStop: closer(s.SagaHandler.Close),
+	}, lifecycle.Hook{
+		Name:   "grpc",
+		Run:    s.GRPCServer.Run,
+		OnStop: s.GRPCServer.GracefulStop,
+	}, lifecycle.Hook{
+		Name:   "http",
+		Run:    s.HTTPServer.Run,
+		OnStop: s.HTTPServer.GracefulStop,
+	}, lifecycle.Hook{
+		// the service reports not serving for the drain period before the servers stop accepting requests
+		Name: "health",
+		OnStop: func(ctx context.Context) error {
+			s.Health.Shutdown()
+			timer := time.NewTimer(s.drain)
+			defer timer.Stop()
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-timer.C:
+				return nil
+			}
+		},
+	})
+}
 
-	log.Info("gracefully shutdowned")
-	done <- true
+// Run starts the server and blocks until ctx is done or a component fails, then stops it gracefully
+func (s *Server) Run(ctx context.Context) error {
+	err := s.manager.Run(ctx)
+	if err == nil {
+		log.Info("gracefully shutdowned")
+	}
+	return err
 }
 
-func (s *Server) Close(ctx context.Context) {
+// closer adapts a Close method to a stop hook
+func closer(close func()) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		close()
+		return nil
+	}
 }
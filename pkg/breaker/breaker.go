@@ -0,0 +1,164 @@
+package breaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ErrOpen is returned by Allow while the breaker rejects calls
+var ErrOpen = errors.New("circuit breaker is open")
+
+var breakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "account_breaker_state",
+	Help: "The state of a circuit breaker: 0 closed, 1 half-open, 2 open.",
+}, []string{"name"})
+
+// State is the state of a breaker
+type State int32
+
+const (
+	// Closed lets every call through
+	Closed State = iota
+	// HalfOpen lets a single probe through, whose result closes or reopens the breaker
+	HalfOpen
+	// Open rejects every call until the open timeout passes
+	Open
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case HalfOpen:
+		return "half-open"
+	case Open:
+		return "open"
+	}
+	return "unknown"
+}
+
+// Options configures a Breaker
+type Options struct {
+	// Name labels the state metric of the breaker
+	Name string
+	// FailureThreshold is the number of consecutive failures that opens the breaker
+	FailureThreshold int
+	// OpenTimeout is how long the breaker stays open before it lets a probe through
+	OpenTimeout time.Duration
+	// OnStateChange is called after every state change, outside the lock of the breaker
+	OnStateChange func(from, to State)
+}
+
+// Breaker is a circuit breaker counting consecutive failures
+// it opens after FailureThreshold consecutive failures and rejects calls for OpenTimeout,
+// then lets a single probe through; the probe closes the breaker if it succeeds and reopens it otherwise
+type Breaker struct {
+	opts     Options
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	probing  bool
+	now      func() time.Time
+}
+
+// New is the factory of Breaker
+func New(opts Options) *Breaker {
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = 1
+	}
+	breakerState.WithLabelValues(opts.Name).Set(float64(Closed))
+	return &Breaker{
+		opts: opts,
+		now:  time.Now,
+	}
+}
+
+// Allow returns ErrOpen if a call is rejected; the result of every allowed call must be passed to Record
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	from := b.state
+	err := b.allow()
+	to := b.state
+	b.mu.Unlock()
+	b.changed(from, to)
+	return err
+}
+
+func (b *Breaker) allow() error {
+	switch b.state {
+	case Open:
+		if b.now().Sub(b.openedAt) < b.opts.OpenTimeout {
+			return ErrOpen
+		}
+		b.state = HalfOpen
+		b.probing = false
+	case Closed:
+		return nil
+	}
+	if b.probing {
+		return ErrOpen
+	}
+	b.probing = true
+	return nil
+}
+
+// Record records the result of an allowed call
+func (b *Breaker) Record(success bool) {
+	b.mu.Lock()
+	from := b.state
+	b.record(success)
+	to := b.state
+	b.mu.Unlock()
+	b.changed(from, to)
+}
+
+func (b *Breaker) record(success bool) {
+	switch b.state {
+	case Closed:
+		if success {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.opts.FailureThreshold {
+			b.open()
+		}
+	case HalfOpen:
+		if success {
+			b.state = Closed
+			b.failures = 0
+			b.probing = false
+			return
+		}
+		b.open()
+	}
+	// calls allowed before the breaker opened do not affect it
+}
+
+func (b *Breaker) open() {
+	b.state = Open
+	b.openedAt = b.now()
+	b.probing = false
+}
+
+// State returns the current state
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *Breaker) changed(from, to State) {
+	if from == to {
+		return
+	}
+	breakerState.WithLabelValues(b.opts.Name).Set(float64(to))
+	if b.opts.OnStateChange != nil {
+		b.opts.OnStateChange(from, to)
+	}
+}
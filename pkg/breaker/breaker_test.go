@@ -0,0 +1,78 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestBreaker(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "breaker suite")
+}
+
+var _ = Describe("circuit breaker", func() {
+	var b *Breaker
+	var now time.Time
+	var changes []State
+	BeforeEach(func() {
+		now = time.Now()
+		changes = nil
+		b = New(Options{
+			Name:             "test",
+			FailureThreshold: 3,
+			OpenTimeout:      time.Second,
+			OnStateChange: func(from, to State) {
+				changes = append(changes, to)
+			},
+		})
+		b.now = func() time.Time {
+			return now
+		}
+	})
+	fail := func(n int) {
+		for i := 0; i < n; i++ {
+			Expect(b.Allow()).To(BeNil())
+			b.Record(false)
+		}
+	}
+	It("should open after consecutive failures", func() {
+		fail(2)
+		Expect(b.Allow()).To(BeNil())
+		b.Record(true)
+		fail(2)
+		Expect(b.State()).To(Equal(Closed))
+		fail(1)
+		Expect(b.State()).To(Equal(Open))
+		Expect(b.Allow()).To(Equal(ErrOpen))
+		Expect(changes).To(Equal([]State{Open}))
+	})
+	It("should let a single probe through after the open timeout", func() {
+		fail(3)
+		now = now.Add(time.Second)
+		Expect(b.Allow()).To(BeNil())
+		Expect(b.State()).To(Equal(HalfOpen))
+		Expect(b.Allow()).To(Equal(ErrOpen))
+		b.Record(true)
+		Expect(b.State()).To(Equal(Closed))
+		Expect(b.Allow()).To(BeNil())
+		Expect(changes).To(Equal([]State{Open, HalfOpen, Closed}))
+	})
+	It("should reopen if the probe fails", func() {
+		fail(3)
+		now = now.Add(time.Second)
+		Expect(b.Allow()).To(BeNil())
+		b.Record(false)
+		Expect(b.State()).To(Equal(Open))
+		Expect(b.Allow()).To(Equal(ErrOpen))
+		now = now.Add(time.Second)
+		Expect(b.Allow()).To(BeNil())
+	})
+	It("should ignore calls allowed before it opened", func() {
+		fail(3)
+		b.Record(true)
+		Expect(b.State()).To(Equal(Open))
+	})
+})
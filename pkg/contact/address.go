@@ -0,0 +1,109 @@
+package contact
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/minghsu0107/saga-account/domain/model"
+)
+
+var (
+	// ErrInvalidCountry is invalid country code error
+	ErrInvalidCountry = errors.New("invalid country code")
+	// ErrInvalidPostalCode is invalid postal code error
+	ErrInvalidPostalCode = errors.New("invalid postal code")
+	// ErrRegionRequired is missing region error
+	ErrRegionRequired = errors.New("region required")
+	// ErrAddressLineRequired is missing address line error
+	ErrAddressLineRequired = errors.New("address line required")
+	// ErrCityRequired is missing city error
+	ErrCityRequired = errors.New("city required")
+)
+
+// addressFormat holds the address rules of a country
+type addressFormat struct {
+	postalCode     *regexp.Regexp
+	regionRequired bool
+}
+
+// addressFormats lists countries with known postal rules
+// countries absent from the table only require a valid country code, a line and a city
+var addressFormats = map[string]addressFormat{
+	"AU": {postalCode: regexp.MustCompile(`^\d{4}$`), regionRequired: true},
+	"CA": {postalCode: regexp.MustCompile(`^[A-Z]\d[A-Z] \d[A-Z]\d$`), regionRequired: true},
+	"CN": {postalCode: regexp.MustCompile(`^\d{6}$`), regionRequired: true},
+	"DE": {postalCode: regexp.MustCompile(`^\d{5}$`)},
+	"FR": {postalCode: regexp.MustCompile(`^\d{5}$`)},
+	"GB": {postalCode: regexp.MustCompile(`^[A-Z]{1,2}\d[A-Z\d]? \d[A-Z]{2}$`)},
+	"HK": {},
+	"IN": {postalCode: regexp.MustCompile(`^\d{6}$`), regionRequired: true},
+	"JP": {postalCode: regexp.MustCompile(`^\d{3}-\d{4}$`), regionRequired: true},
+	"KR": {postalCode: regexp.MustCompile(`^\d{5}$`)},
+	"SG": {postalCode: regexp.MustCompile(`^\d{6}$`)},
+	"TW": {postalCode: regexp.MustCompile(`^\d{3}(\d{2,3})?$`)},
+	"US": {postalCode: regexp.MustCompile(`^\d{5}(-\d{4})?$`), regionRequired: true},
+}
+
+// NormalizeAddress canonicalizes an address in place and validates it against the rules of its country
+func NormalizeAddress(address *model.PostalAddress) error {
+	address.Line1 = collapseSpaces(address.Line1)
+	address.Line2 = collapseSpaces(address.Line2)
+	address.City = collapseSpaces(address.City)
+	address.Region = collapseSpaces(address.Region)
+	address.Country = strings.ToUpper(strings.TrimSpace(address.Country))
+	address.PostalCode = normalizePostalCode(address.PostalCode, address.Country)
+
+	if !IsSupportedRegion(address.Country) {
+		return ErrInvalidCountry
+	}
+	if address.Line1 == "" {
+		return ErrAddressLineRequired
+	}
+	if address.City == "" {
+		return ErrCityRequired
+	}
+	format, ok := addressFormats[address.Country]
+	if !ok {
+		return nil
+	}
+	if format.regionRequired && address.Region == "" {
+		return ErrRegionRequired
+	}
+	if format.postalCode != nil && !format.postalCode.MatchString(address.PostalCode) {
+		return ErrInvalidPostalCode
+	}
+	return nil
+}
+
+func normalizePostalCode(postalCode, country string) string {
+	postalCode = strings.ToUpper(collapseSpaces(postalCode))
+	switch country {
+	case "CA", "GB":
+		// the inward code always consists of the last three characters
+		compact := strings.ReplaceAll(postalCode, " ", "")
+		if len(compact) > 3 {
+			return compact[:len(compact)-3] + " " + compact[len(compact)-3:]
+		}
+		return compact
+	case "JP":
+		if len(postalCode) == 7 && !strings.Contains(postalCode, "-") {
+			return postalCode[:3] + "-" + postalCode[3:]
+		}
+	}
+	return postalCode
+}
+
+func collapseSpaces(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// IsValidationError checks whether an error is raised by phone number or address validation
+func IsValidationError(err error) bool {
+	switch err {
+	case ErrInvalidPhoneNumber, ErrInvalidCountry, ErrInvalidPostalCode,
+		ErrRegionRequired, ErrAddressLineRequired, ErrCityRequired:
+		return true
+	}
+	return false
+}
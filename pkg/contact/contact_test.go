@@ -0,0 +1,82 @@
+package contact
+
+import (
+	"testing"
+
+	"github.com/minghsu0107/saga-account/domain/model"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestContact(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "contact suite")
+}
+
+var _ = Describe("phone number", func() {
+	It("should format numbers in E.164", func() {
+		for raw, expected := range map[string]string{
+			"+886 923-456-978":  "+886923456978",
+			"0923456978":        "+886923456978",
+			"+1 (650) 253-0000": "+16502530000",
+		} {
+			normalized, err := NormalizePhoneNumber(raw, "TW")
+			Expect(err).To(BeNil())
+			Expect(normalized).To(Equal(expected))
+		}
+	})
+	It("should interpret national numbers in the default region", func() {
+		normalized, err := NormalizePhoneNumber("(650) 253-0000", "us")
+		Expect(err).To(BeNil())
+		Expect(normalized).To(Equal("+16502530000"))
+	})
+	It("should reject invalid numbers", func() {
+		for _, raw := range []string{"", "notanumber", "+1 555-0100", "12"} {
+			_, err := NormalizePhoneNumber(raw, "TW")
+			Expect(err).To(Equal(ErrInvalidPhoneNumber))
+		}
+	})
+})
+
+var _ = Describe("address", func() {
+	var address model.PostalAddress
+	BeforeEach(func() {
+		address = model.PostalAddress{
+			Line1:      " 1600  Amphitheatre Pkwy ",
+			City:       "Mountain View",
+			Region:     "CA",
+			PostalCode: "94043",
+			Country:    "us",
+		}
+	})
+	It("should normalize a valid address", func() {
+		Expect(NormalizeAddress(&address)).To(BeNil())
+		Expect(address.Line1).To(Equal("1600 Amphitheatre Pkwy"))
+		Expect(address.Country).To(Equal("US"))
+	})
+	It("should canonicalize postal codes", func() {
+		address = model.PostalAddress{Line1: "10 Downing St", City: "London", PostalCode: "sw1a2aa", Country: "GB"}
+		Expect(NormalizeAddress(&address)).To(BeNil())
+		Expect(address.PostalCode).To(Equal("SW1A 2AA"))
+
+		address = model.PostalAddress{Line1: "1-1 Chiyoda", City: "Chiyoda-ku", Region: "Tokyo", PostalCode: "1000001", Country: "JP"}
+		Expect(NormalizeAddress(&address)).To(BeNil())
+		Expect(address.PostalCode).To(Equal("100-0001"))
+	})
+	It("should validate per-country rules", func() {
+		address.PostalCode = "9404"
+		Expect(NormalizeAddress(&address)).To(Equal(ErrInvalidPostalCode))
+
+		address.PostalCode = "94043"
+		address.Region = ""
+		Expect(NormalizeAddress(&address)).To(Equal(ErrRegionRequired))
+	})
+	It("should reject unknown countries", func() {
+		address.Country = "XX"
+		Expect(NormalizeAddress(&address)).To(Equal(ErrInvalidCountry))
+	})
+	It("should accept countries without postal rules", func() {
+		address = model.PostalAddress{Line1: "Rua Augusta 1", City: "Lisboa", Country: "PT"}
+		Expect(NormalizeAddress(&address)).To(BeNil())
+	})
+})
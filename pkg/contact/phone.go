@@ -0,0 +1,30 @@
+package contact
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/ttacon/libphonenumber"
+)
+
+// ErrInvalidPhoneNumber is invalid phone number error
+var ErrInvalidPhoneNumber = errors.New("invalid phone number")
+
+// NormalizePhoneNumber validates a phone number and formats it in E.164
+// numbers without an international prefix are interpreted as national numbers of defaultRegion
+func NormalizePhoneNumber(phoneNumber, defaultRegion string) (string, error) {
+	num, err := libphonenumber.Parse(strings.TrimSpace(phoneNumber), strings.ToUpper(defaultRegion))
+	if err != nil {
+		return "", ErrInvalidPhoneNumber
+	}
+	if !libphonenumber.IsValidNumber(num) {
+		return "", ErrInvalidPhoneNumber
+	}
+	return libphonenumber.Format(num, libphonenumber.E164), nil
+}
+
+// IsSupportedRegion checks whether a region is a known ISO 3166-1 alpha-2 code
+func IsSupportedRegion(region string) bool {
+	_, ok := libphonenumber.GetSupportedRegions()[region]
+	return ok
+}
@@ -0,0 +1,34 @@
+package pkg
+
+import (
+	"context"
+	"time"
+)
+
+// Detach returns a context with the values of ctx that is never canceled
+// work shared by several callers runs under it, so that it outlives the caller that started it
+func Detach(ctx context.Context) context.Context {
+	return detachedContext{
+		parent: ctx,
+	}
+}
+
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
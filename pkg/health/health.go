@@ -0,0 +1,162 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/minghsu0107/saga-account/pkg"
+)
+
+// statuses of the service
+const (
+	StatusOK           = "ok"
+	StatusDegraded     = "degraded"
+	StatusUnavailable  = "unavailable"
+	StatusShuttingDown = "shutting_down"
+)
+
+// checkTimeout bounds the checks of a report, so that an unresponsive dependency is reported as not ready
+const checkTimeout = 2 * time.Second
+
+// reportMaxAge is how long a report is reused, so that frequent probes do not load the dependencies
+const reportMaxAge = time.Second
+
+// Status is the health of a dependency
+// a degraded dependency is bypassed or served by a fallback, so the service is still ready
+type Status struct {
+	Name     string
+	State    string
+	Ready    bool
+	Degraded bool
+}
+
+// Checker reports the health of a dependency
+type Checker interface {
+	Health(ctx context.Context) Status
+}
+
+// CheckerFunc is an adapter to use a function as a Checker
+type CheckerFunc func(ctx context.Context) Status
+
+// Health implements Checker interface
+func (f CheckerFunc) Health(ctx context.Context) Status {
+	return f(ctx)
+}
+
+// Report is the health of the service and of each dependency
+// the service is serving while every dependency is ready, even if some are degraded, until it shuts down
+type Report struct {
+	Status     string
+	Serving    bool
+	Components []Status
+}
+
+// Registry reports the health of the service from the checkers that dependencies register on creation
+type Registry struct {
+	mu       sync.RWMutex
+	checkers []Checker
+	once     sync.Once
+	shutdown chan struct{}
+
+	// reportMu serializes checks, so that concurrent probes share a report
+	reportMu sync.Mutex
+	report   Report
+	reportAt time.Time
+	maxAge   time.Duration
+}
+
+// NewRegistry is the factory of Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		shutdown: make(chan struct{}),
+		maxAge:   reportMaxAge,
+	}
+}
+
+// Register adds checkers to the report
+// it is a no-op on a nil registry, so that dependencies can be created without one
+func (r *Registry) Register(checkers ...Checker) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.checkers = append(r.checkers, checkers...)
+	r.mu.Unlock()
+	r.reportMu.Lock()
+	r.reportAt = time.Time{}
+	r.reportMu.Unlock()
+}
+
+// Check runs every checker concurrently and reports the health of the service
+// components are reported in the order of registration. a report is reused for reportMaxAge,
+// and checks run detached from ctx, so that a caller giving up does not fail the report of the others
+func (r *Registry) Check(ctx context.Context) Report {
+	if r.ShuttingDown() {
+		return Report{
+			Status:     StatusShuttingDown,
+			Components: []Status{},
+		}
+	}
+	r.reportMu.Lock()
+	defer r.reportMu.Unlock()
+	if !r.reportAt.IsZero() && time.Since(r.reportAt) < r.maxAge {
+		return r.report
+	}
+	r.mu.RLock()
+	checkers := r.checkers
+	r.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(pkg.Detach(ctx), checkTimeout)
+	defer cancel()
+	components := make([]Status, len(checkers))
+	var wg sync.WaitGroup
+	for i, checker := range checkers {
+		wg.Add(1)
+		go func(i int, checker Checker) {
+			defer wg.Done()
+			components[i] = checker.Health(ctx)
+		}(i, checker)
+	}
+	wg.Wait()
+
+	report := Report{
+		Status:     StatusOK,
+		Serving:    true,
+		Components: components,
+	}
+	for _, status := range components {
+		switch {
+		case !status.Ready:
+			report.Status = StatusUnavailable
+			report.Serving = false
+		case status.Degraded && report.Status == StatusOK:
+			report.Status = StatusDegraded
+		}
+	}
+	r.report = report
+	r.reportAt = time.Now()
+	return report
+}
+
+// Shutdown reports the service as not serving from now on, so that traffic is drained before it stops
+func (r *Registry) Shutdown() {
+	r.once.Do(func() {
+		close(r.shutdown)
+	})
+}
+
+// ShuttingDown reports whether Shutdown has been called
+func (r *Registry) ShuttingDown() bool {
+	select {
+	case <-r.shutdown:
+		return true
+	default:
+		return false
+	}
+}
+
+// Done returns a channel closed on Shutdown
+func (r *Registry) Done() <-chan struct{} {
+	return r.shutdown
+}
@@ -0,0 +1,109 @@
+package health
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestHealth(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "health suite")
+}
+
+// fixed returns a checker reporting a fixed status
+func fixed(name string, ready, degraded bool) Checker {
+	return CheckerFunc(func(ctx context.Context) Status {
+		return Status{
+			Name:     name,
+			Ready:    ready,
+			Degraded: degraded,
+		}
+	})
+}
+
+var _ = Describe("health registry", func() {
+	var r *Registry
+	BeforeEach(func() {
+		r = NewRegistry()
+	})
+	It("should report ok without checkers", func() {
+		report := r.Check(context.Background())
+		Expect(report.Status).To(Equal(StatusOK))
+		Expect(report.Serving).To(BeTrue())
+		Expect(report.Components).To(BeEmpty())
+	})
+	It("should report degraded dependencies as serving", func() {
+		r.Register(fixed("db", true, false), fixed("redis", true, true))
+		report := r.Check(context.Background())
+		Expect(report.Status).To(Equal(StatusDegraded))
+		Expect(report.Serving).To(BeTrue())
+		Expect(report.Components).To(HaveLen(2))
+		Expect(report.Components[0].Name).To(Equal("db"))
+		Expect(report.Components[1].Name).To(Equal("redis"))
+	})
+	It("should report unavailable if any dependency is not ready", func() {
+		r.Register(fixed("redis", true, true), fixed("db", false, false))
+		report := r.Check(context.Background())
+		Expect(report.Status).To(Equal(StatusUnavailable))
+		Expect(report.Serving).To(BeFalse())
+	})
+	It("should bound the checks with a deadline", func() {
+		r.Register(CheckerFunc(func(ctx context.Context) Status {
+			_, ok := ctx.Deadline()
+			return Status{
+				Name:  "db",
+				Ready: ok,
+			}
+		}))
+		Expect(r.Check(context.Background()).Serving).To(BeTrue())
+	})
+	It("should reuse a recent report", func() {
+		var checks int32
+		r.Register(CheckerFunc(func(ctx context.Context) Status {
+			atomic.AddInt32(&checks, 1)
+			return Status{
+				Name:  "db",
+				Ready: true,
+			}
+		}))
+		r.Check(context.Background())
+		r.Check(context.Background())
+		Expect(atomic.LoadInt32(&checks)).To(Equal(int32(1)))
+
+		r.maxAge = 0
+		r.Check(context.Background())
+		Expect(atomic.LoadInt32(&checks)).To(Equal(int32(2)))
+	})
+	It("should not fail the checks of a caller that gave up", func() {
+		r.Register(CheckerFunc(func(ctx context.Context) Status {
+			return Status{
+				Name:  "db",
+				Ready: ctx.Err() == nil,
+			}
+		}))
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		Expect(r.Check(ctx).Serving).To(BeTrue())
+	})
+	It("should report not serving once shutting down", func() {
+		r.Register(fixed("db", true, false))
+		r.Shutdown()
+		r.Shutdown()
+		Expect(r.ShuttingDown()).To(BeTrue())
+		Eventually(r.Done(), time.Second).Should(BeClosed())
+		report := r.Check(context.Background())
+		Expect(report.Status).To(Equal(StatusShuttingDown))
+		Expect(report.Serving).To(BeFalse())
+	})
+	It("should ignore registrations without a registry", func() {
+		var nilRegistry *Registry
+		Expect(func() {
+			nilRegistry.Register(fixed("db", true, false))
+		}).NotTo(Panic())
+	})
+})
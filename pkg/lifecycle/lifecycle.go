@@ -0,0 +1,166 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+const defaultTimeout = 5 * time.Second
+
+var (
+	// ErrStoppedUnexpectedly is returned when a component stops serving before it is stopped
+	ErrStoppedUnexpectedly = errors.New("stopped unexpectedly")
+	// ErrStopTimeout is returned when a component does not stop within its timeout
+	ErrStopTimeout = errors.New("stop timed out")
+)
+
+// Hook is the lifecycle of a component; every function is optional
+type Hook struct {
+	Name string
+	// OnStart prepares the component, and returns before the next component starts
+	OnStart func(ctx context.Context) error
+	// Run serves until the component is stopped by OnStop, and then returns nil
+	Run func() error
+	// OnStop stops the component; it is abandoned if it does not return within the stop timeout
+	OnStop func(ctx context.Context) error
+}
+
+// Options configures a Manager
+// Timeout bounds the whole shutdown, and StopTimeouts bounds the stop of a component by its name
+type Options struct {
+	Timeout      time.Duration
+	StopTimeouts map[string]time.Duration
+	Logger       *log.Entry
+}
+
+// Manager starts components in the order they are appended and stops them in reverse order,
+// so that a component is stopped before the components it depends on
+// the first error of a component stops every component
+type Manager struct {
+	hooks        []Hook
+	timeout      time.Duration
+	stopTimeouts map[string]time.Duration
+	stopping     int32
+	logger       *log.Entry
+}
+
+// New is the factory of Manager
+func New(opts Options) *Manager {
+	m := &Manager{
+		timeout:      opts.Timeout,
+		stopTimeouts: opts.StopTimeouts,
+		logger:       opts.Logger,
+	}
+	if m.timeout <= 0 {
+		m.timeout = defaultTimeout
+	}
+	if m.logger == nil {
+		m.logger = log.WithField("type", "lifecycle:Manager")
+	}
+	return m
+}
+
+// Append adds components after the ones already appended
+func (m *Manager) Append(hooks ...Hook) {
+	m.hooks = append(m.hooks, hooks...)
+}
+
+// Run starts every component and blocks until ctx is done or a component fails, then stops
+// the started components. it returns the error of the failed component and of the stops, if any
+func (m *Manager) Run(ctx context.Context) error {
+	g, gctx := errgroup.WithContext(ctx)
+	var startErr error
+	started := 0
+	for _, h := range m.hooks {
+		if h.OnStart != nil {
+			m.logger.Infof("starting %s", h.Name)
+			if err := h.OnStart(ctx); err != nil {
+				startErr = fmt.Errorf("start %s: %w", h.Name, err)
+				break
+			}
+		}
+		started++
+		if h.Run != nil {
+			h := h
+			g.Go(func() error {
+				err := h.Run()
+				if err == nil && atomic.LoadInt32(&m.stopping) == 0 {
+					err = ErrStoppedUnexpectedly
+				}
+				if err != nil {
+					return fmt.Errorf("run %s: %w", h.Name, err)
+				}
+				return nil
+			})
+		}
+	}
+	if startErr == nil {
+		<-gctx.Done()
+	}
+	if startErr != nil || ctx.Err() == nil {
+		m.logger.Error("stopping after a failure")
+	}
+
+	atomic.StoreInt32(&m.stopping, 1)
+	stopCtx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+	var stopErrs []error
+	for i := started - 1; i >= 0; i-- {
+		if err := m.stop(stopCtx, m.hooks[i]); err != nil {
+			m.logger.Error(err.Error())
+			stopErrs = append(stopErrs, err)
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- g.Wait()
+	}()
+	var runErr error
+	select {
+	case runErr = <-done:
+	case <-stopCtx.Done():
+		runErr = fmt.Errorf("wait for components: %w", ErrStopTimeout)
+	}
+	// the failure that caused the shutdown comes first
+	var result *multierror.Error
+	for _, err := range append([]error{runErr, startErr}, stopErrs...) {
+		if err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	return result.ErrorOrNil()
+}
+
+// stop runs OnStop of a component within its stop timeout and the deadline of the shutdown
+func (m *Manager) stop(ctx context.Context, h Hook) error {
+	if h.OnStop == nil {
+		return nil
+	}
+	m.logger.Infof("stopping %s", h.Name)
+	if timeout, ok := m.stopTimeouts[h.Name]; ok && timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- h.OnStop(ctx)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("stop %s: %w", h.Name, err)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("stop %s: %w", h.Name, ErrStopTimeout)
+	}
+}
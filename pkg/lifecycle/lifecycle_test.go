@@ -0,0 +1,168 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestLifecycle(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "lifecycle suite")
+}
+
+// recorder records the lifecycle events of components in order
+type recorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recorder) record(event string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recorder) recorded() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string{}, r.events...)
+}
+
+// server returns a component serving until it is stopped, or failing with runErr if failed is closed
+func (r *recorder) server(name string, failed chan struct{}, runErr error) Hook {
+	quit := make(chan struct{})
+	return Hook{
+		Name: name,
+		OnStart: func(ctx context.Context) error {
+			r.record("start " + name)
+			return nil
+		},
+		Run: func() error {
+			select {
+			case <-quit:
+				return nil
+			case <-failed:
+				return runErr
+			}
+		},
+		OnStop: func(ctx context.Context) error {
+			r.record("stop " + name)
+			close(quit)
+			return nil
+		},
+	}
+}
+
+var _ = Describe("lifecycle manager", func() {
+	var (
+		rec *recorder
+		m   *Manager
+	)
+	errFailed := errors.New("connection refused")
+	BeforeEach(func() {
+		rec = &recorder{}
+		logger := log.New()
+		logger.Out = ioutil.Discard
+		m = New(Options{
+			Timeout: time.Second,
+			StopTimeouts: map[string]time.Duration{
+				"slow": 50 * time.Millisecond,
+			},
+			Logger: log.NewEntry(logger),
+		})
+	})
+	It("should start in order and stop in reverse order once canceled", func() {
+		m.Append(rec.server("db", nil, nil), rec.server("cache", nil, nil), rec.server("http", nil, nil))
+		ctx, cancel := context.WithCancel(context.Background())
+		errs := make(chan error, 1)
+		go func() {
+			errs <- m.Run(ctx)
+		}()
+		Eventually(rec.recorded).Should(HaveLen(3))
+		cancel()
+		Eventually(errs, time.Second).Should(Receive(BeNil()))
+		Expect(rec.recorded()).To(Equal([]string{
+			"start db", "start cache", "start http",
+			"stop http", "stop cache", "stop db",
+		}))
+	})
+	It("should stop every component when a component fails", func() {
+		failed := make(chan struct{})
+		m.Append(rec.server("db", nil, nil), rec.server("cache", failed, errFailed), rec.server("http", nil, nil))
+		errs := make(chan error, 1)
+		go func() {
+			errs <- m.Run(context.Background())
+		}()
+		Eventually(rec.recorded).Should(HaveLen(3))
+		close(failed)
+
+		var err error
+		Eventually(errs, time.Second).Should(Receive(&err))
+		Expect(errors.Is(err, errFailed)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("run cache"))
+		Expect(rec.recorded()[3:]).To(Equal([]string{"stop http", "stop cache", "stop db"}))
+	})
+	It("should fail a component that stops serving on its own", func() {
+		failed := make(chan struct{})
+		m.Append(rec.server("db", nil, nil), rec.server("cache", failed, nil))
+		close(failed)
+		err := m.Run(context.Background())
+		Expect(errors.Is(err, ErrStoppedUnexpectedly)).To(BeTrue())
+		Expect(rec.recorded()[2:]).To(Equal([]string{"stop cache", "stop db"}))
+	})
+	It("should stop the started components when a component fails to start", func() {
+		m.Append(rec.server("db", nil, nil), Hook{
+			Name: "cache",
+			OnStart: func(ctx context.Context) error {
+				return errFailed
+			},
+			OnStop: func(ctx context.Context) error {
+				rec.record("stop cache")
+				return nil
+			},
+		}, rec.server("http", nil, nil))
+		err := m.Run(context.Background())
+		Expect(errors.Is(err, errFailed)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("start cache"))
+		Expect(rec.recorded()).To(Equal([]string{"start db", "stop db"}))
+	})
+	It("should abandon components that do not stop in time", func() {
+		block := make(chan struct{})
+		defer close(block)
+		m.Append(rec.server("db", nil, nil), Hook{
+			Name: "slow",
+			Run: func() error {
+				<-block
+				return nil
+			},
+			OnStop: func(ctx context.Context) error {
+				<-block
+				return nil
+			},
+		}, Hook{
+			Name: "http",
+			OnStop: func(ctx context.Context) error {
+				return errFailed
+			},
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		start := time.Now()
+		err := m.Run(ctx)
+		Expect(time.Since(start)).To(BeNumerically("<", 1500*time.Millisecond))
+
+		Expect(errors.Is(err, ErrStopTimeout)).To(BeTrue())
+		Expect(errors.Is(err, errFailed)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("stop slow"))
+		// db is stopped after the stop of slow times out
+		Expect(rec.recorded()).To(Equal([]string{"start db", "stop db"}))
+	})
+})
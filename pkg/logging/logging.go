@@ -0,0 +1,144 @@
+// Package logging correlates log entries with requests and traces, and keeps personal information out of logs
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/minghsu0107/saga-account/pkg/redact"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// fields correlating log entries
+const (
+	TraceIDField   = "trace_id"
+	SpanIDField    = "span_id"
+	RequestIDField = "request_id"
+)
+
+// RequestIDHeader is the header, or the grpc metadata key, carrying the id of a request
+const RequestIDHeader = "X-Request-Id"
+
+// maxRequestIDLen bounds request ids given by clients
+const maxRequestIDLen = 128
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the id of a request
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestID returns the id of the request in ctx, or an empty string if it has none
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// NewRequestID returns a random request id
+func NewRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// ValidRequestID reports whether a request id given by a client can be logged as is
+func ValidRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLen {
+		return false
+	}
+	for _, r := range requestID {
+		if r < '!' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// Fields returns the trace, span and request ids in ctx
+func Fields(ctx context.Context) log.Fields {
+	fields := log.Fields{}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		fields[TraceIDField] = spanContext.TraceID().String()
+		fields[SpanIDField] = spanContext.SpanID().String()
+	}
+	if requestID := RequestID(ctx); requestID != "" {
+		fields[RequestIDField] = requestID
+	}
+	return fields
+}
+
+// ContextHook adds the trace, span and request ids in the context of an entry, set by WithContext
+type ContextHook struct{}
+
+// Levels implements log.Hook interface
+func (ContextHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire implements log.Hook interface
+func (ContextHook) Fire(entry *log.Entry) error {
+	if entry.Context == nil {
+		return nil
+	}
+	for k, v := range Fields(entry.Context) {
+		if _, ok := entry.Data[k]; !ok {
+			entry.Data[k] = v
+		}
+	}
+	return nil
+}
+
+// RedactionHook masks personal information and secrets in the message and fields of an entry
+// fields are masked by their names, such as email, phone_number and password, and other string
+// fields are masked like messages. it runs after the other hooks if it is added last
+type RedactionHook struct{}
+
+// Levels implements log.Hook interface
+func (RedactionHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire implements log.Hook interface
+func (RedactionHook) Fire(entry *log.Entry) error {
+	entry.Message = redact.Message(entry.Message)
+	for k, v := range entry.Data {
+		entry.Data[k] = redactField(k, v)
+	}
+	return nil
+}
+
+var secretFields = []string{"password", "passwd", "secret", "token", "authorization", "cookie"}
+
+func redactField(key string, value interface{}) interface{} {
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case error:
+		s = v.Error()
+	case fmt.Stringer:
+		s = v.String()
+	default:
+		return value
+	}
+	key = strings.ToLower(key)
+	for _, secret := range secretFields {
+		if strings.Contains(key, secret) {
+			return redact.Secret(s)
+		}
+	}
+	switch {
+	case strings.Contains(key, "email"):
+		return redact.Email(s)
+	case strings.Contains(key, "phone"):
+		return redact.PhoneNumber(s)
+	}
+	return redact.Message(s)
+}
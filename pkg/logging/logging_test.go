@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestLogging(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "logging suite")
+}
+
+var _ = Describe("logging", func() {
+	var buf *bytes.Buffer
+	var logger *log.Logger
+	BeforeEach(func() {
+		buf = &bytes.Buffer{}
+		logger = log.New()
+		logger.Out = buf
+		logger.Formatter = &log.JSONFormatter{}
+		logger.AddHook(ContextHook{})
+		logger.AddHook(RedactionHook{})
+	})
+	// entry returns the fields of the last logged entry
+	entry := func() map[string]interface{} {
+		var fields map[string]interface{}
+		Expect(json.Unmarshal(buf.Bytes(), &fields)).To(BeNil())
+		return fields
+	}
+	It("should log the trace, span and request ids in the context", func() {
+		tracer := tracesdk.NewTracerProvider().Tracer("test")
+		ctx, span := tracer.Start(context.Background(), "test")
+		defer span.End()
+		ctx = WithRequestID(ctx, "req-1")
+
+		logger.WithContext(ctx).Info("hello")
+		fields := entry()
+		Expect(fields[TraceIDField]).To(Equal(span.SpanContext().TraceID().String()))
+		Expect(fields[SpanIDField]).To(Equal(span.SpanContext().SpanID().String()))
+		Expect(fields[RequestIDField]).To(Equal("req-1"))
+	})
+	It("should log no ids without a span or request id", func() {
+		logger.WithContext(context.Background()).Info("hello")
+		fields := entry()
+		Expect(fields).NotTo(HaveKey(TraceIDField))
+		Expect(fields).NotTo(HaveKey(RequestIDField))
+	})
+	It("should mask personal information and secrets in fields and messages", func() {
+		logger.WithFields(log.Fields{
+			"email":        "ming@ming.com",
+			"phone_number": "+886923456978",
+			"password":     "pass",
+			"accessToken":  "abc.def.ghi",
+			"error":        errors.New("customer ming@ming.com not found"),
+			"status":       200,
+		}).Info("login of ming@ming.com failed")
+		fields := entry()
+		Expect(fields["msg"]).To(Equal("login of m***@ming.com failed"))
+		Expect(fields["email"]).To(Equal("m***@ming.com"))
+		Expect(fields["phone_number"]).To(Equal("+88*******978"))
+		Expect(fields["password"]).To(Equal("***"))
+		Expect(fields["accessToken"]).To(Equal("***"))
+		Expect(fields["error"]).To(Equal("customer m***@ming.com not found"))
+		Expect(fields["status"]).To(BeEquivalentTo(200))
+	})
+	It("should accept only short printable request ids", func() {
+		Expect(ValidRequestID("req-1")).To(BeTrue())
+		Expect(ValidRequestID("")).To(BeFalse())
+		Expect(ValidRequestID("req 1")).To(BeFalse())
+		Expect(ValidRequestID("req\n1")).To(BeFalse())
+		Expect(ValidRequestID(strings.Repeat("a", 129))).To(BeFalse())
+		Expect(ValidRequestID(NewRequestID())).To(BeTrue())
+	})
+})
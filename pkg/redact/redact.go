@@ -0,0 +1,70 @@
+// Package redact masks personally identifiable information
+// empty values stay empty, so that a missing value remains distinguishable from a masked one
+package redact
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const mask = "***"
+
+// Email masks the local part of an email address except for its first character
+func Email(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 {
+		return Text(email)
+	}
+	return firstRune(email[:at]) + mask + email[at:]
+}
+
+// PhoneNumber masks all but the leading sign, first and last three digits of a phone number
+func PhoneNumber(phoneNumber string) string {
+	if phoneNumber == "" {
+		return ""
+	}
+	if len(phoneNumber) <= 6 {
+		return mask
+	}
+	return phoneNumber[:3] + strings.Repeat("*", len(phoneNumber)-6) + phoneNumber[len(phoneNumber)-3:]
+}
+
+// Text masks free text such as names and addresses except for its first character
+func Text(text string) string {
+	if text == "" {
+		return ""
+	}
+	return firstRune(text) + mask
+}
+
+func firstRune(s string) string {
+	r, _ := utf8.DecodeRuneInString(s)
+	return string(r)
+}
+
+var (
+	jwtPattern         = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	bearerPattern      = regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]+`)
+	secretPattern      = regexp.MustCompile(`(?i)((?:password|passwd|secret|token|authorization)"?\s*[:=]\s*"?(?:bearer\s+)?)[^\s"',&}]+`)
+	emailPattern       = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	phoneNumberPattern = regexp.MustCompile(`\+[1-9][0-9]{7,14}\b`)
+)
+
+// Secret masks a secret such as a password or a token entirely
+func Secret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return mask
+}
+
+// Message masks the tokens, secrets of key-value pairs, emails and E.164 phone numbers in free text,
+// such as log messages and errors
+func Message(msg string) string {
+	msg = jwtPattern.ReplaceAllString(msg, mask)
+	msg = bearerPattern.ReplaceAllString(msg, "${1}"+mask)
+	msg = secretPattern.ReplaceAllString(msg, "${1}"+mask)
+	msg = emailPattern.ReplaceAllStringFunc(msg, Email)
+	return phoneNumberPattern.ReplaceAllStringFunc(msg, PhoneNumber)
+}
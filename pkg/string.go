@@ -10,3 +10,15 @@ func Join(strs ...string) string {
 	}
 	return sb.String()
 }
+
+// Truncate returns the longest prefix of s with at most n runes
+func Truncate(s string, n int) string {
+	i := 0
+	for pos := range s {
+		if i == n {
+			return s[:pos]
+		}
+		i++
+	}
+	return s
+}
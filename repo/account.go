@@ -3,23 +3,30 @@ package repo
 import (
 	"context"
 	"errors"
+	"strconv"
 
+	"github.com/go-sql-driver/mysql"
 	domain_model "github.com/minghsu0107/saga-account/domain/model"
+	infra_db "github.com/minghsu0107/saga-account/infra/db"
 	"github.com/minghsu0107/saga-account/infra/db/model"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // CustomerRepository is the customer repository interface
 type CustomerRepository interface {
 	GetCustomerPersonalInfo(ctx context.Context, customerID uint64) (*CustomerPersonalInfo, error)
 	GetCustomerShippingInfo(ctx context.Context, customerID uint64) (*CustomerShippingInfo, error)
-	UpdateCustomerPersonalInfo(ctx context.Context, customerID uint64, personalInfo *domain_model.CustomerPersonalInfo) error
-	UpdateCustomerShippingInfo(ctx context.Context, customerID uint64, shippingInfo *domain_model.CustomerShippingInfo) error
+	UpdateCustomerPersonalInfo(ctx context.Context, customerID uint64, personalInfo *domain_model.CustomerPersonalInfo) (*CustomerPersonalInfo, error)
+	UpdateCustomerShippingInfo(ctx context.Context, customerID uint64, shippingInfo *domain_model.CustomerShippingInfo) (*CustomerShippingInfo, error)
+	PatchCustomerPersonalInfo(ctx context.Context, customerID uint64, patch *domain_model.CustomerPersonalInfoPatch) (*CustomerPersonalInfo, error)
+	PatchCustomerShippingInfo(ctx context.Context, customerID uint64, patch *domain_model.CustomerShippingInfoPatch) (*CustomerShippingInfo, error)
+	UpdateCustomerStatus(ctx context.Context, customerID uint64, active bool) (bool, error)
 }
 
 // CustomerRepositoryImpl implements CustomerRepository interface
 type CustomerRepositoryImpl struct {
-	db *gorm.DB
+	router *infra_db.Router
 }
 
 // CustomerPersonalInfo os customer personal info type
@@ -27,26 +34,73 @@ type CustomerPersonalInfo struct {
 	FirstName string
 	LastName  string
 	Email     string
+	Version   uint64
 }
 
 // CustomerShippingInfo os customer shipping info type
 type CustomerShippingInfo struct {
 	Address     string
 	PhoneNumber string
+	Version     uint64
+}
+
+// CacheSchemaVersion implements cache.Versioned interface; bump it whenever the fields change
+func (CustomerPersonalInfo) CacheSchemaVersion() uint16 {
+	return 1
+}
+
+// CacheSchemaVersion implements cache.Versioned interface; bump it whenever the fields change
+func (CustomerShippingInfo) CacheSchemaVersion() uint16 {
+	return 1
+}
+
+// customerSnapshot holds the columns of a locked customer row
+type customerSnapshot struct {
+	Active      bool
+	FirstName   string
+	LastName    string
+	Email       string
+	Address     string
+	PhoneNumber string
+}
+
+func (s *customerSnapshot) personalInfo() *CustomerPersonalInfo {
+	return &CustomerPersonalInfo{
+		FirstName: s.FirstName,
+		LastName:  s.LastName,
+		Email:     s.Email,
+	}
+}
+
+func (s *customerSnapshot) shippingInfo() *CustomerShippingInfo {
+	return &CustomerShippingInfo{
+		Address:     s.Address,
+		PhoneNumber: s.PhoneNumber,
+	}
 }
 
 // NewCustomerRepository is the factory of CustomerRepository
-func NewCustomerRepository(db *gorm.DB) CustomerRepository {
+func NewCustomerRepository(router *infra_db.Router) CustomerRepository {
 	return &CustomerRepositoryImpl{
-		db: db,
+		router: router,
 	}
 }
 
+// customerKey is the routing key of reads by customer id
+func customerKey(customerID uint64) string {
+	return "customer:" + strconv.FormatUint(customerID, 10)
+}
+
+// emailKey is the routing key of reads by email
+func emailKey(email string) string {
+	return "email:" + email
+}
+
 // GetCustomerPersonalInfo queries customer personal info by customer id
 func (repo *CustomerRepositoryImpl) GetCustomerPersonalInfo(ctx context.Context, customerID uint64) (*CustomerPersonalInfo, error) {
 	var info CustomerPersonalInfo
-	if err := repo.db.Model(&model.Customer{}).Select("first_name", "last_name", "email").
-		Where("id = ?", customerID).First(&info).WithContext(ctx).Error; err != nil {
+	if err := repo.router.Reader(ctx, customerKey(customerID)).WithContext(ctx).Model(&model.Customer{}).Select("first_name", "last_name", "email", "version").
+		Where("id = ?", customerID).First(&info).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, ErrCustomerNotFound
 		}
@@ -58,8 +112,8 @@ func (repo *CustomerRepositoryImpl) GetCustomerPersonalInfo(ctx context.Context,
 // GetCustomerShippingInfo queries customer shipping info by customer id
 func (repo *CustomerRepositoryImpl) GetCustomerShippingInfo(ctx context.Context, customerID uint64) (*CustomerShippingInfo, error) {
 	var info CustomerShippingInfo
-	if err := repo.db.Model(&model.Customer{}).Select("address", "phone_number").
-		Where("id = ?", customerID).First(&info).WithContext(ctx).Error; err != nil {
+	if err := repo.router.Reader(ctx, customerKey(customerID)).WithContext(ctx).Model(&model.Customer{}).Select("address", "phone_number", "version").
+		Where("id = ?", customerID).First(&info).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, ErrCustomerNotFound
 		}
@@ -68,27 +122,169 @@ func (repo *CustomerRepositoryImpl) GetCustomerShippingInfo(ctx context.Context,
 	return &info, nil
 }
 
-// UpdateCustomerInfo updates a customer's personal info
-func (repo *CustomerRepositoryImpl) UpdateCustomerPersonalInfo(ctx context.Context, customerID uint64, personalInfo *domain_model.CustomerPersonalInfo) error {
-	if err := repo.db.Model(&model.Customer{}).Where("id = ?", customerID).
-		Updates(model.Customer{
-			FirstName: personalInfo.FirstName,
-			LastName:  personalInfo.LastName,
-			Email:     personalInfo.Email,
-		}).WithContext(ctx).Error; err != nil {
-		return err
+// UpdateCustomerPersonalInfo updates a customer's personal info and returns the personal info before the update
+// the update is applied only if personalInfo.Version matches the stored version, unless it is zero
+func (repo *CustomerRepositoryImpl) UpdateCustomerPersonalInfo(ctx context.Context, customerID uint64, personalInfo *domain_model.CustomerPersonalInfo) (*CustomerPersonalInfo, error) {
+	before, err := repo.updateCustomer(ctx, customerID, personalInfo.Version, map[string]interface{}{
+		"first_name": personalInfo.FirstName,
+		"last_name":  personalInfo.LastName,
+		"email":      personalInfo.Email,
+	})
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return before.personalInfo(), nil
 }
 
-// UpdateCustomerInfo updates a customer's shipping info
-func (repo *CustomerRepositoryImpl) UpdateCustomerShippingInfo(ctx context.Context, customerID uint64, shippingInfo *domain_model.CustomerShippingInfo) error {
-	if err := repo.db.Model(&model.Customer{}).Where("id = ?", customerID).
-		Updates(model.Customer{
-			Address:     shippingInfo.Address,
-			PhoneNumber: shippingInfo.PhoneNumber,
-		}).WithContext(ctx).Error; err != nil {
+// UpdateCustomerShippingInfo updates a customer's shipping info and returns the shipping info before the update
+// the update is applied only if shippingInfo.Version matches the stored version, unless it is zero
+func (repo *CustomerRepositoryImpl) UpdateCustomerShippingInfo(ctx context.Context, customerID uint64, shippingInfo *domain_model.CustomerShippingInfo) (*CustomerShippingInfo, error) {
+	before, err := repo.updateCustomer(ctx, customerID, shippingInfo.Version, map[string]interface{}{
+		"address":      shippingInfo.Address,
+		"phone_number": shippingInfo.PhoneNumber,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return before.shippingInfo(), nil
+}
+
+// PatchCustomerPersonalInfo updates the supplied columns of a customer's personal info
+// and returns the personal info before the update
+func (repo *CustomerRepositoryImpl) PatchCustomerPersonalInfo(ctx context.Context, customerID uint64, patch *domain_model.CustomerPersonalInfoPatch) (*CustomerPersonalInfo, error) {
+	columns := make(map[string]interface{})
+	setColumn(columns, "first_name", patch.FirstName)
+	setColumn(columns, "last_name", patch.LastName)
+	setColumn(columns, "email", patch.Email)
+	before, err := repo.updateCustomer(ctx, customerID, patch.Version, columns)
+	if err != nil {
+		return nil, err
+	}
+	return before.personalInfo(), nil
+}
+
+// PatchCustomerShippingInfo updates the supplied columns of a customer's shipping info
+// and returns the shipping info before the update
+func (repo *CustomerRepositoryImpl) PatchCustomerShippingInfo(ctx context.Context, customerID uint64, patch *domain_model.CustomerShippingInfoPatch) (*CustomerShippingInfo, error) {
+	columns := make(map[string]interface{})
+	setColumn(columns, "address", patch.Address)
+	setColumn(columns, "phone_number", patch.PhoneNumber)
+	before, err := repo.updateCustomer(ctx, customerID, patch.Version, columns)
+	if err != nil {
+		return nil, err
+	}
+	return before.shippingInfo(), nil
+}
+
+// UpdateCustomerStatus activates or deactivates a customer and returns the previous status
+// an activated or deactivated event is appended to the outbox if the status changes
+func (repo *CustomerRepositoryImpl) UpdateCustomerStatus(ctx context.Context, customerID uint64, active bool) (bool, error) {
+	var status customerSnapshot
+	err := repo.router.Writer().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.Customer{}).Clauses(clause.Locking{Strength: "UPDATE"}).Select("active", "email").
+			Where("id = ?", customerID).First(&status).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrCustomerNotFound
+			}
+			return err
+		}
+		if status.Active == active {
+			return nil
+		}
+		if err := tx.Model(&model.Customer{}).Where("id = ?", customerID).Update("active", active).Error; err != nil {
+			return err
+		}
+		eventType := domain_model.CustomerDeactivated
+		if active {
+			eventType = domain_model.CustomerActivated
+		}
+		return appendCustomerEvent(tx, customerID, eventType, "")
+	})
+	if err != nil {
+		return false, err
+	}
+	repo.router.MarkWritten(ctx, customerKey(customerID), emailKey(status.Email))
+	return status.Active, nil
+}
+
+func setColumn(columns map[string]interface{}, column string, val *string) {
+	if val != nil {
+		columns[column] = *val
+	}
+}
+
+// checkNoAddressBook rejects shipping columns of a customer whose shipping info is the default address in the address book
+// the customer row must be locked, since address book writes lock it as well
+func checkNoAddressBook(tx *gorm.DB, customerID uint64, columns map[string]interface{}) error {
+	_, address := columns["address"]
+	_, phoneNumber := columns["phone_number"]
+	if !address && !phoneNumber {
+		return nil
+	}
+	var count int64
+	if err := tx.Model(&model.Address{}).Where("customer_id = ?", customerID).Count(&count).Error; err != nil {
 		return err
 	}
+	if count > 0 {
+		return ErrShippingInfoInAddressBook
+	}
 	return nil
 }
+
+// updateCustomer updates the given columns of a customer and appends the resulting events to the outbox
+// it returns the customer locked before the update
+func (repo *CustomerRepositoryImpl) updateCustomer(ctx context.Context, customerID, version uint64, columns map[string]interface{}) (*customerSnapshot, error) {
+	columns["version"] = gorm.Expr("version + 1")
+	var current customerSnapshot
+	err := repo.router.Writer().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.Customer{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("first_name", "last_name", "email", "address", "phone_number").Where("id = ?", customerID).
+			First(&current).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrCustomerNotFound
+			}
+			return err
+		}
+		if err := checkNoAddressBook(tx, customerID, columns); err != nil {
+			return err
+		}
+
+		update := tx.Model(&model.Customer{}).Where("id = ?", customerID)
+		if version != 0 {
+			update = update.Where("version = ?", version)
+		}
+		result := update.Updates(columns)
+		if err := result.Error; err != nil {
+			return err
+		}
+		// the row exists and is locked, so no row is updated only if the version is outdated
+		if result.RowsAffected == 0 {
+			return ErrVersionConflict
+		}
+
+		if email, ok := columns["email"]; ok && email != current.Email {
+			if err := appendCustomerEvent(tx, customerID, domain_model.CustomerEmailChanged, email.(string)); err != nil {
+				return err
+			}
+		}
+		if address, ok := columns["address"]; ok && address != current.Address {
+			if err := appendCustomerEvent(tx, customerID, domain_model.CustomerAddressChanged, ""); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		keys := []string{customerKey(customerID)}
+		if email, ok := columns["email"]; ok && email != current.Email {
+			keys = append(keys, emailKey(current.Email), emailKey(email.(string)))
+		}
+		repo.router.MarkWritten(ctx, keys...)
+		return &current, nil
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+		return nil, ErrDuplicateEntry
+	}
+	return nil, err
+}
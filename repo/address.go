@@ -0,0 +1,229 @@
+package repo
+
+import (
+	"context"
+	"errors"
+
+	domain_model "github.com/minghsu0107/saga-account/domain/model"
+	infra_db "github.com/minghsu0107/saga-account/infra/db"
+	"github.com/minghsu0107/saga-account/infra/db/model"
+	"gorm.io/gorm"
+)
+
+// AddressRepository is the address book repository interface
+type AddressRepository interface {
+	ListAddresses(ctx context.Context, customerID uint64) ([]CustomerAddress, error)
+	CreateAddress(ctx context.Context, address *domain_model.Address) error
+	UpdateAddress(ctx context.Context, address *domain_model.Address) error
+	DeleteAddress(ctx context.Context, customerID, addressID uint64) error
+	SetDefaultAddress(ctx context.Context, customerID, addressID uint64) error
+}
+
+// AddressRepositoryImpl implements AddressRepository interface
+type AddressRepositoryImpl struct {
+	router *infra_db.Router
+}
+
+// CustomerAddress is customer address type
+type CustomerAddress struct {
+	ID          uint64
+	Recipient   string
+	PhoneNumber string
+	Line1       string
+	Line2       string
+	City        string
+	Region      string
+	PostalCode  string
+	Country     string
+	IsDefault   bool
+}
+
+// CacheSchemaVersion implements cache.Versioned interface; bump it whenever the fields change
+func (CustomerAddress) CacheSchemaVersion() uint16 {
+	return 1
+}
+
+// NewAddressRepository is the factory of AddressRepository
+func NewAddressRepository(router *infra_db.Router) AddressRepository {
+	return &AddressRepositoryImpl{
+		router: router,
+	}
+}
+
+// ListAddresses queries all addresses of a customer in creation order
+func (repo *AddressRepositoryImpl) ListAddresses(ctx context.Context, customerID uint64) ([]CustomerAddress, error) {
+	addresses := []CustomerAddress{}
+	if err := repo.router.Reader(ctx, customerKey(customerID)).WithContext(ctx).Model(&model.Address{}).
+		Select("id", "recipient", "phone_number", "line1", "line2", "city", "region", "postal_code", "country", "is_default").
+		Where("customer_id = ?", customerID).Order("created_at, id").Find(&addresses).Error; err != nil {
+		return nil, err
+	}
+	return addresses, nil
+}
+
+// CreateAddress creates a new address
+// the first address of a customer always becomes the default one
+func (repo *AddressRepositoryImpl) CreateAddress(ctx context.Context, address *domain_model.Address) error {
+	return repo.write(ctx, address.CustomerID, func(tx *gorm.DB) error {
+		if err := lockCustomer(tx, address.CustomerID); err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Model(&model.Address{}).Where("customer_id = ?", address.CustomerID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			address.Default = true
+		} else if address.Default {
+			if err := tx.Model(&model.Address{}).Where("customer_id = ?", address.CustomerID).
+				Update("is_default", false).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(&model.Address{
+			ID:          address.ID,
+			CustomerID:  address.CustomerID,
+			Recipient:   address.Recipient,
+			PhoneNumber: address.PhoneNumber,
+			Line1:       address.Line1,
+			Line2:       address.Line2,
+			City:        address.City,
+			Region:      address.Region,
+			PostalCode:  address.PostalCode,
+			Country:     address.Country,
+			IsDefault:   address.Default,
+		}).Error; err != nil {
+			return err
+		}
+		if address.Default {
+			return appendAddressChangedEvent(tx, address.CustomerID)
+		}
+		return nil
+	})
+}
+
+// UpdateAddress updates an address of a customer
+// an address can be promoted to default but not demoted; use SetDefaultAddress on another address instead
+func (repo *AddressRepositoryImpl) UpdateAddress(ctx context.Context, address *domain_model.Address) error {
+	return repo.write(ctx, address.CustomerID, func(tx *gorm.DB) error {
+		if err := lockCustomer(tx, address.CustomerID); err != nil {
+			return err
+		}
+		isDefault, err := getAddressDefault(tx, address.CustomerID, address.ID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&model.Address{}).Where("id = ? AND customer_id = ?", address.ID, address.CustomerID).
+			Updates(map[string]interface{}{
+				"recipient":    address.Recipient,
+				"phone_number": address.PhoneNumber,
+				"line1":        address.Line1,
+				"line2":        address.Line2,
+				"city":         address.City,
+				"region":       address.Region,
+				"postal_code":  address.PostalCode,
+				"country":      address.Country,
+			}).Error; err != nil {
+			return err
+		}
+		if address.Default && !isDefault {
+			if err := setDefaultAddress(tx, address.CustomerID, address.ID); err != nil {
+				return err
+			}
+		}
+		if address.Default || isDefault {
+			return appendAddressChangedEvent(tx, address.CustomerID)
+		}
+		return nil
+	})
+}
+
+// DeleteAddress deletes an address of a customer
+// if the default address is deleted, the earliest remaining address becomes the default one
+func (repo *AddressRepositoryImpl) DeleteAddress(ctx context.Context, customerID, addressID uint64) error {
+	return repo.write(ctx, customerID, func(tx *gorm.DB) error {
+		if err := lockCustomer(tx, customerID); err != nil {
+			return err
+		}
+		isDefault, err := getAddressDefault(tx, customerID, addressID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(&model.Address{}, addressID).Error; err != nil {
+			return err
+		}
+		if !isDefault {
+			return nil
+		}
+		if err := appendAddressChangedEvent(tx, customerID); err != nil {
+			return err
+		}
+
+		var next model.Address
+		if err := tx.Select("id").Where("customer_id = ?", customerID).Order("created_at, id").
+			First(&next).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+		return setDefaultAddress(tx, customerID, next.ID)
+	})
+}
+
+// SetDefaultAddress marks an address as the default one of a customer
+func (repo *AddressRepositoryImpl) SetDefaultAddress(ctx context.Context, customerID, addressID uint64) error {
+	return repo.write(ctx, customerID, func(tx *gorm.DB) error {
+		if err := lockCustomer(tx, customerID); err != nil {
+			return err
+		}
+		isDefault, err := getAddressDefault(tx, customerID, addressID)
+		if err != nil {
+			return err
+		}
+		if isDefault {
+			return nil
+		}
+		if err := setDefaultAddress(tx, customerID, addressID); err != nil {
+			return err
+		}
+		return appendAddressChangedEvent(tx, customerID)
+	})
+}
+
+// write runs fn in a transaction on the primary and routes the next reads of the customer to the primary
+func (repo *AddressRepositoryImpl) write(ctx context.Context, customerID uint64, fn func(tx *gorm.DB) error) error {
+	if err := repo.router.Writer().WithContext(ctx).Transaction(fn); err != nil {
+		return err
+	}
+	repo.router.MarkWritten(ctx, customerKey(customerID))
+	return nil
+}
+
+// getAddressDefault returns whether an address of a customer is the default one
+func getAddressDefault(tx *gorm.DB, customerID, addressID uint64) (bool, error) {
+	var address model.Address
+	if err := tx.Select("id", "is_default").Where("id = ? AND customer_id = ?", addressID, customerID).
+		First(&address).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, ErrAddressNotFound
+		}
+		return false, err
+	}
+	return address.IsDefault, nil
+}
+
+// appendAddressChangedEvent appends an address changed event since the default address is the shipping address
+// it also bumps the customer version, so that the shipping info ETag covers the default address
+func appendAddressChangedEvent(tx *gorm.DB, customerID uint64) error {
+	if err := tx.Model(&model.Customer{}).Where("id = ?", customerID).
+		Update("version", gorm.Expr("version + 1")).Error; err != nil {
+		return err
+	}
+	return appendCustomerEvent(tx, customerID, domain_model.CustomerAddressChanged, "")
+}
+
+func setDefaultAddress(tx *gorm.DB, customerID, addressID uint64) error {
+	return tx.Model(&model.Address{}).Where("customer_id = ?", customerID).
+		Update("is_default", gorm.Expr("id = ?", addressID)).Error
+}
@@ -0,0 +1,91 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	domain_model "github.com/minghsu0107/saga-account/domain/model"
+	infra_db "github.com/minghsu0107/saga-account/infra/db"
+	"github.com/minghsu0107/saga-account/infra/db/model"
+)
+
+// AuditRepository is the audit log repository interface
+// audit events can only be appended and queried
+type AuditRepository interface {
+	AppendAuditEvent(ctx context.Context, event *domain_model.AuditEvent) error
+	ListAuditEvents(ctx context.Context, query *domain_model.AuditQuery) ([]domain_model.AuditEvent, error)
+}
+
+// AuditRepositoryImpl implements AuditRepository interface
+type AuditRepositoryImpl struct {
+	router *infra_db.Router
+}
+
+// NewAuditRepository is the factory of AuditRepository
+func NewAuditRepository(router *infra_db.Router) AuditRepository {
+	return &AuditRepositoryImpl{
+		router: router,
+	}
+}
+
+// AppendAuditEvent appends an event to the audit log
+func (repo *AuditRepositoryImpl) AppendAuditEvent(ctx context.Context, event *domain_model.AuditEvent) error {
+	changes, err := json.Marshal(event.Changes)
+	if err != nil {
+		return err
+	}
+	return repo.router.Writer().WithContext(ctx).Create(&model.AuditLog{
+		ID:         event.ID,
+		CustomerID: event.CustomerID,
+		Type:       string(event.Type),
+		Actor:      event.Actor,
+		IP:         event.IP,
+		UserAgent:  event.UserAgent,
+		RequestID:  event.RequestID,
+		Changes:    string(changes),
+		CreatedAt:  event.CreatedAt.UnixNano() / int64(time.Millisecond),
+	}).Error
+}
+
+// ListAuditEvents queries audit events matching the query, latest first
+func (repo *AuditRepositoryImpl) ListAuditEvents(ctx context.Context, query *domain_model.AuditQuery) ([]domain_model.AuditEvent, error) {
+	var keys []string
+	if query.CustomerID != 0 {
+		keys = append(keys, customerKey(query.CustomerID))
+	}
+	tx := repo.router.Reader(ctx, keys...).WithContext(ctx).Model(&model.AuditLog{})
+	if query.CustomerID != 0 {
+		tx = tx.Where("customer_id = ?", query.CustomerID)
+	}
+	if len(query.Types) > 0 {
+		tx = tx.Where("type IN ?", query.Types)
+	}
+	if query.Before != 0 {
+		tx = tx.Where("id < ?", query.Before)
+	}
+	var logs []model.AuditLog
+	if err := tx.Order("id DESC").Limit(query.Limit).Find(&logs).Error; err != nil {
+		return nil, err
+	}
+
+	events := make([]domain_model.AuditEvent, len(logs))
+	for i, log := range logs {
+		var changes map[string]domain_model.AuditChange
+		if err := json.Unmarshal([]byte(log.Changes), &changes); err != nil {
+			return nil, err
+		}
+		events[i] = domain_model.AuditEvent{
+			ID:         log.ID,
+			CustomerID: log.CustomerID,
+			Type:       domain_model.AuditEventType(log.Type),
+			Actor:      log.Actor,
+			IP:         log.IP,
+			UserAgent:  log.UserAgent,
+			RequestID:  log.RequestID,
+			Changes:    changes,
+			CreatedAt:  time.Unix(0, log.CreatedAt*int64(time.Millisecond)),
+		}
+	}
+	return events, nil
+}
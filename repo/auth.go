@@ -8,6 +8,7 @@ import (
 
 	"github.com/go-sql-driver/mysql"
 	domain_model "github.com/minghsu0107/saga-account/domain/model"
+	infra_db "github.com/minghsu0107/saga-account/infra/db"
 	"github.com/minghsu0107/saga-account/infra/db/model"
 	"gorm.io/gorm"
 )
@@ -21,7 +22,7 @@ type JWTAuthRepository interface {
 
 // JWTAuthRepositoryImpl implements JWTAuthRepository interface
 type JWTAuthRepositoryImpl struct {
-	db *gorm.DB
+	router *infra_db.Router
 }
 
 // CustomerCredentials encapsulates customer credentials
@@ -36,17 +37,17 @@ type customerCheckStatus struct {
 }
 
 // NewJWTAuthRepository is the factory of JWTAuthRepository
-func NewJWTAuthRepository(db *gorm.DB) JWTAuthRepository {
+func NewJWTAuthRepository(router *infra_db.Router) JWTAuthRepository {
 	return &JWTAuthRepositoryImpl{
-		db: db,
+		router: router,
 	}
 }
 
 // CheckCustomer checks whether a customer exists and is active
 func (repo *JWTAuthRepositoryImpl) CheckCustomer(ctx context.Context, customerID uint64) (bool, bool, error) {
 	var status customerCheckStatus
-	if err := repo.db.Model(&model.Customer{}).Select("active").
-		Where("id = ?", customerID).First(&status).WithContext(ctx).Error; err != nil {
+	if err := repo.router.Reader(ctx, customerKey(customerID)).WithContext(ctx).Model(&model.Customer{}).Select("active").
+		Where("id = ?", customerID).First(&status).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return false, false, nil
 		}
@@ -55,37 +56,44 @@ func (repo *JWTAuthRepositoryImpl) CheckCustomer(ctx context.Context, customerID
 	return true, status.Active, nil
 }
 
-// CreateCustomer creates a new customer
+// CreateCustomer creates a new customer and appends a signed up event to the outbox
 // it returns error if ID, email, or phone number duplicates
 func (repo *JWTAuthRepositoryImpl) CreateCustomer(ctx context.Context, customer *domain_model.Customer) error {
 	bcryptedPassword, err := pkg.HashPassword(customer.Password)
 	if err != nil {
 		return err
 	}
-	if err := repo.db.Create(&model.Customer{
-		ID:               customer.ID,
-		Active:           customer.Active,
-		FirstName:        customer.PersonalInfo.FirstName,
-		LastName:         customer.PersonalInfo.LastName,
-		Email:            customer.PersonalInfo.Email,
-		Address:          customer.ShippingInfo.Address,
-		PhoneNumber:      customer.ShippingInfo.PhoneNumber,
-		BcryptedPassword: bcryptedPassword,
-	}).WithContext(ctx).Error; err != nil {
+	err = repo.router.Writer().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&model.Customer{
+			ID:               customer.ID,
+			Active:           customer.Active,
+			FirstName:        customer.PersonalInfo.FirstName,
+			LastName:         customer.PersonalInfo.LastName,
+			Email:            customer.PersonalInfo.Email,
+			Address:          customer.ShippingInfo.Address,
+			PhoneNumber:      customer.ShippingInfo.PhoneNumber,
+			BcryptedPassword: bcryptedPassword,
+		}).Error; err != nil {
+			return err
+		}
+		return appendCustomerEvent(tx, customer.ID, domain_model.CustomerSignedUp, customer.PersonalInfo.Email)
+	})
+	if err != nil {
 		var mysqlErr *mysql.MySQLError
 		if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
 			return ErrDuplicateEntry
 		}
 		return err
 	}
+	repo.router.MarkWritten(ctx, customerKey(customer.ID), emailKey(customer.PersonalInfo.Email))
 	return nil
 }
 
 // GetCustomerCredentials finds customer credentials by customer id
 func (repo *JWTAuthRepositoryImpl) GetCustomerCredentials(ctx context.Context, email string) (bool, *CustomerCredentials, error) {
 	var credentials CustomerCredentials
-	if err := repo.db.Model(&model.Customer{}).Select("id", "active", "bcrypted_password").
-		Where("email = ?", email).First(&credentials).WithContext(ctx).Error; err != nil {
+	if err := repo.router.Reader(ctx, emailKey(email)).WithContext(ctx).Model(&model.Customer{}).Select("id", "active", "bcrypted_password").
+		Where("email = ?", email).First(&credentials).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return false, nil, nil
 		}
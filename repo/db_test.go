@@ -13,7 +13,10 @@ import (
 	"gorm.io/gorm"
 )
 
-var db *gorm.DB
+var (
+	db     *gorm.DB
+	router *infra_db.Router
+)
 
 func InitDB() {
 	//writer := os.Stderr
@@ -49,4 +52,8 @@ func InitDB() {
 	if err != nil {
 		panic(err)
 	}
+	router, err = infra_db.NewRouter(config, db, nil, nil)
+	if err != nil {
+		panic(err)
+	}
 }
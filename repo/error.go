@@ -7,4 +7,14 @@ var (
 	ErrDuplicateEntry = errors.New("duplicate entry")
 	// ErrCustomerNotFound is customer not found error
 	ErrCustomerNotFound = errors.New("customer not found")
+	// ErrAddressNotFound is address not found error
+	ErrAddressNotFound = errors.New("address not found")
+	// ErrVersionConflict is customer version conflict error
+	ErrVersionConflict = errors.New("customer version conflict")
+	// ErrShippingInfoInAddressBook is the error of updating the shipping info of a customer with an address book,
+	// whose default address is the shipping info instead
+	ErrShippingInfoInAddressBook = errors.New("shipping info is managed by the address book")
+	// ErrDatabaseUnavailable is the error of an operation rejected while the database is unavailable,
+	// or left unretried after a transient error because the retry budget is spent
+	ErrDatabaseUnavailable = errors.New("database unavailable")
 )
@@ -0,0 +1,89 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	domain_model "github.com/minghsu0107/saga-account/domain/model"
+	"github.com/minghsu0107/saga-account/infra/db/model"
+	"github.com/minghsu0107/saga-account/pkg/logging"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OutboxRepository is the transactional outbox repository interface
+// events are appended by other repositories within their own transactions
+type OutboxRepository interface {
+	ListPendingEvents(ctx context.Context, limit int) ([]OutboxEvent, error)
+	DeleteEvents(ctx context.Context, ids []uint64) error
+}
+
+// OutboxRepositoryImpl implements OutboxRepository interface
+type OutboxRepositoryImpl struct {
+	db *gorm.DB
+}
+
+// OutboxEvent is an event pending in the outbox
+type OutboxEvent struct {
+	ID         uint64
+	CustomerID uint64
+	Type       string
+	RequestID  string
+	Payload    string
+}
+
+// NewOutboxRepository is the factory of OutboxRepository
+func NewOutboxRepository(db *gorm.DB) OutboxRepository {
+	return &OutboxRepositoryImpl{
+		db: db,
+	}
+}
+
+// ListPendingEvents queries the oldest pending events in commit order
+func (repo *OutboxRepositoryImpl) ListPendingEvents(ctx context.Context, limit int) ([]OutboxEvent, error) {
+	events := []OutboxEvent{}
+	if err := repo.db.WithContext(ctx).Model(&model.OutboxEvent{}).
+		Select("id", "customer_id", "type", "request_id", "payload").
+		Order("id").Limit(limit).Find(&events).Error; err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// DeleteEvents deletes published events
+func (repo *OutboxRepositoryImpl) DeleteEvents(ctx context.Context, ids []uint64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return repo.db.WithContext(ctx).Delete(&model.OutboxEvent{}, ids).Error
+}
+
+// appendCustomerEvent appends a customer event to the outbox within tx
+// the event carries the id of the request in the context of tx
+func appendCustomerEvent(tx *gorm.DB, customerID uint64, eventType domain_model.CustomerEventType, email string) error {
+	payload, err := json.Marshal(&domain_model.CustomerEvent{
+		Type:       eventType,
+		CustomerID: customerID,
+		Email:      email,
+		OccurredAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Create(&model.OutboxEvent{
+		CustomerID: customerID,
+		Type:       string(eventType),
+		RequestID:  logging.RequestID(tx.Statement.Context),
+		Payload:    string(payload),
+	}).Error
+}
+
+// lockCustomer locks the customer row until tx ends
+// every transaction appending events of a customer takes this lock first,
+// so that outbox IDs of the same customer follow commit order
+func lockCustomer(tx *gorm.DB, customerID uint64) error {
+	var ids []uint64
+	return tx.Model(&model.Customer{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ?", customerID).Pluck("id", &ids).Error
+}
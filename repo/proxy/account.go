@@ -7,146 +7,111 @@ import (
 	conf "github.com/minghsu0107/saga-account/config"
 	"github.com/minghsu0107/saga-account/domain/model"
 	"github.com/minghsu0107/saga-account/infra/cache"
-	"github.com/minghsu0107/saga-account/pkg"
 	"github.com/minghsu0107/saga-account/repo"
-	"github.com/sirupsen/logrus"
 )
 
 // CustomerRepoCache is the customer repo cache interface
 type CustomerRepoCache interface {
 	GetCustomerPersonalInfo(ctx context.Context, customerID uint64) (*repo.CustomerPersonalInfo, error)
 	GetCustomerShippingInfo(ctx context.Context, customerID uint64) (*repo.CustomerShippingInfo, error)
-	UpdateCustomerPersonalInfo(ctx context.Context, customerID uint64, personalInfo *model.CustomerPersonalInfo) error
-	UpdateCustomerShippingInfo(ctx context.Context, customerID uint64, shippingInfo *model.CustomerShippingInfo) error
+	UpdateCustomerPersonalInfo(ctx context.Context, customerID uint64, personalInfo *model.CustomerPersonalInfo) (*repo.CustomerPersonalInfo, error)
+	UpdateCustomerShippingInfo(ctx context.Context, customerID uint64, shippingInfo *model.CustomerShippingInfo) (*repo.CustomerShippingInfo, error)
+	PatchCustomerPersonalInfo(ctx context.Context, customerID uint64, patch *model.CustomerPersonalInfoPatch) (*repo.CustomerPersonalInfo, error)
+	PatchCustomerShippingInfo(ctx context.Context, customerID uint64, patch *model.CustomerShippingInfoPatch) (*repo.CustomerShippingInfo, error)
+	UpdateCustomerStatus(ctx context.Context, customerID uint64, active bool) (bool, error)
 }
 
 // CustomerRepoCacheImpl is the customer repo cache proxy
 type CustomerRepoCacheImpl struct {
-	repo   repo.CustomerRepository
-	lc     cache.LocalCache
-	rc     cache.RedisCache
-	logger *logrus.Entry
+	repo         repo.CustomerRepository
+	rc           cache.RedisCache
+	personalInfo *ReadThrough[uint64, *repo.CustomerPersonalInfo]
+	shippingInfo *ReadThrough[uint64, *repo.CustomerShippingInfo]
 }
 
-func NewCustomerRepoCache(config *conf.Config, repo repo.CustomerRepository, lc cache.LocalCache, rc cache.RedisCache) CustomerRepoCache {
+func NewCustomerRepoCache(config *conf.Config, customerRepo repo.CustomerRepository, lc cache.LocalCache, rc cache.RedisCache) CustomerRepoCache {
 	return &CustomerRepoCacheImpl{
-		repo:   repo,
-		lc:     lc,
-		rc:     rc,
-		logger: config.Logger.ContextLogger.WithField("type", "cache:CustomerRepoCache"),
+		repo: customerRepo,
+		rc:   rc,
+		personalInfo: NewReadThrough(config, lc, rc,
+			newReadThroughOptions(config, conf.PersonalInfoFamily, customerRepo.GetCustomerPersonalInfo, repo.ErrCustomerNotFound)),
+		shippingInfo: NewReadThrough(config, lc, rc,
+			newReadThroughOptions(config, conf.ShippingInfoFamily, customerRepo.GetCustomerShippingInfo, repo.ErrCustomerNotFound)),
 	}
 }
 
 func (c *CustomerRepoCacheImpl) GetCustomerPersonalInfo(ctx context.Context, customerID uint64) (*repo.CustomerPersonalInfo, error) {
-	info := &repo.CustomerPersonalInfo{}
-	key := pkg.Join("cuspersonalinfo:", strconv.FormatUint(customerID, 10))
-
-	ok, err := c.lc.Get(key, info)
-	if ok && err == nil {
-		return info, nil
-	}
+	return c.personalInfo.Get(ctx, customerID)
+}
 
-	ok, err = c.rc.Get(ctx, key, info)
-	if ok && err == nil {
-		// may set stale data here unless we acquire lock at expense of lower throughput
-		c.logError(c.lc.Set(key, info))
-		return info, nil
-	}
+func (c *CustomerRepoCacheImpl) GetCustomerShippingInfo(ctx context.Context, customerID uint64) (*repo.CustomerShippingInfo, error) {
+	return c.shippingInfo.Get(ctx, customerID)
+}
 
-	// get lock (request coalescing)
-	mutex := c.rc.GetMutex(pkg.Join("mutex:", key))
-	if err := mutex.Lock(); err != nil {
+func (c *CustomerRepoCacheImpl) UpdateCustomerPersonalInfo(ctx context.Context, customerID uint64, personalInfo *model.CustomerPersonalInfo) (*repo.CustomerPersonalInfo, error) {
+	before, err := c.repo.UpdateCustomerPersonalInfo(ctx, customerID, personalInfo)
+	if err != nil {
 		return nil, err
 	}
-	defer mutex.Unlock()
-
-	ok, err = c.rc.Get(ctx, key, info)
-	if ok && err == nil {
-		c.logError(c.lc.Set(key, info))
-		return info, nil
-	}
+	return before, c.invalidate(ctx, append(conf.CustomerInfoKeys(customerID), credentialsKeys(before.Email, &personalInfo.Email)...))
+}
 
-	info, err = c.repo.GetCustomerPersonalInfo(ctx, customerID)
+func (c *CustomerRepoCacheImpl) UpdateCustomerShippingInfo(ctx context.Context, customerID uint64, shippingInfo *model.CustomerShippingInfo) (*repo.CustomerShippingInfo, error) {
+	before, err := c.repo.UpdateCustomerShippingInfo(ctx, customerID, shippingInfo)
 	if err != nil {
 		return nil, err
 	}
-
-	c.logError(c.rc.Set(ctx, key, info))
-	return info, nil
+	return before, c.invalidate(ctx, conf.CustomerInfoKeys(customerID))
 }
 
-func (c *CustomerRepoCacheImpl) GetCustomerShippingInfo(ctx context.Context, customerID uint64) (*repo.CustomerShippingInfo, error) {
-	info := &repo.CustomerShippingInfo{}
-	key := pkg.Join("cusshippinginfo:", strconv.FormatUint(customerID, 10))
-
-	ok, err := c.lc.Get(key, info)
-	if ok && err == nil {
-		return info, nil
-	}
-
-	ok, err = c.rc.Get(ctx, key, info)
-	if ok && err == nil {
-		c.logError(c.lc.Set(key, info))
-		return info, nil
-	}
-
-	// get lock (request coalescing)
-	mutex := c.rc.GetMutex(pkg.Join("mutex:", key))
-	if err := mutex.Lock(); err != nil {
+func (c *CustomerRepoCacheImpl) PatchCustomerPersonalInfo(ctx context.Context, customerID uint64, patch *model.CustomerPersonalInfoPatch) (*repo.CustomerPersonalInfo, error) {
+	before, err := c.repo.PatchCustomerPersonalInfo(ctx, customerID, patch)
+	if err != nil {
 		return nil, err
 	}
-	defer mutex.Unlock()
-
-	ok, err = c.rc.Get(ctx, key, info)
-	if ok && err == nil {
-		c.logError(c.lc.Set(key, info))
-		return info, nil
-	}
+	return before, c.invalidate(ctx, append(conf.CustomerInfoKeys(customerID), credentialsKeys(before.Email, patch.Email)...))
+}
 
-	info, err = c.repo.GetCustomerShippingInfo(ctx, customerID)
+func (c *CustomerRepoCacheImpl) PatchCustomerShippingInfo(ctx context.Context, customerID uint64, patch *model.CustomerShippingInfoPatch) (*repo.CustomerShippingInfo, error) {
+	before, err := c.repo.PatchCustomerShippingInfo(ctx, customerID, patch)
 	if err != nil {
 		return nil, err
 	}
-
-	c.logError(c.rc.Set(ctx, key, info))
-	return info, nil
+	return before, c.invalidate(ctx, conf.CustomerInfoKeys(customerID))
 }
 
-func (c *CustomerRepoCacheImpl) UpdateCustomerPersonalInfo(ctx context.Context, customerID uint64, personalInfo *model.CustomerPersonalInfo) error {
-	personalInfoKey := pkg.Join("cuspersonalinfo:", strconv.FormatUint(customerID, 10))
-	err := c.repo.UpdateCustomerPersonalInfo(ctx, customerID, personalInfo)
+// UpdateCustomerStatus invalidates the cached customer check and credentials, both of which carry the status
+func (c *CustomerRepoCacheImpl) UpdateCustomerStatus(ctx context.Context, customerID uint64, active bool) (bool, error) {
+	info, err := c.repo.GetCustomerPersonalInfo(ctx, customerID)
 	if err != nil {
-		return err
+		return false, err
 	}
-
-	if err := c.rc.Delete(ctx, personalInfoKey); err != nil {
-		return err
+	previous, err := c.repo.UpdateCustomerStatus(ctx, customerID, active)
+	if err != nil {
+		return false, err
 	}
-	if err := c.rc.Publish(ctx, conf.InvalidationTopic, &[]string{personalInfoKey}); err != nil {
-		return err
+	if previous == active {
+		return previous, nil
 	}
-	return nil
+	return previous, c.invalidate(ctx, []string{
+		conf.CacheKey(conf.CustomerCheckFamily, strconv.FormatUint(customerID, 10)),
+		conf.CacheKey(conf.CredentialsFamily, info.Email),
+	})
 }
 
-func (c *CustomerRepoCacheImpl) UpdateCustomerShippingInfo(ctx context.Context, customerID uint64, shippingInfo *model.CustomerShippingInfo) error {
-	shippingInfoKey := pkg.Join("cusshippinginfo:", strconv.FormatUint(customerID, 10))
-	err := c.repo.UpdateCustomerShippingInfo(ctx, customerID, shippingInfo)
-	if err != nil {
-		return err
+// credentialsKeys returns the credentials keys affected by an email change,
+// covering the cached credentials of the old email and the cached miss of the new one
+func credentialsKeys(oldEmail string, newEmail *string) []string {
+	if newEmail == nil || oldEmail == *newEmail {
+		return nil
 	}
-
-	if err := c.rc.Delete(ctx, shippingInfoKey); err != nil {
-		return err
-	}
-	if err := c.rc.Publish(ctx, conf.InvalidationTopic, &[]string{shippingInfoKey}); err != nil {
-		return err
+	return []string{
+		conf.CacheKey(conf.CredentialsFamily, oldEmail),
+		conf.CacheKey(conf.CredentialsFamily, *newEmail),
 	}
-	return nil
 }
 
-func (c *CustomerRepoCacheImpl) logError(err error) {
-	if err == nil {
-		return
-	}
-	c.logger.Error(err.Error())
+// invalidate deletes the given keys from redis and notifies every local cache
+func (c *CustomerRepoCacheImpl) invalidate(ctx context.Context, keys []string) error {
+	return c.rc.Invalidate(ctx, keys...)
 }
@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"context"
+
+	conf "github.com/minghsu0107/saga-account/config"
+	"github.com/minghsu0107/saga-account/domain/model"
+	"github.com/minghsu0107/saga-account/infra/cache"
+	"github.com/minghsu0107/saga-account/repo"
+)
+
+// AddressRepoCache is the address book repo cache interface
+type AddressRepoCache interface {
+	ListAddresses(ctx context.Context, customerID uint64) ([]repo.CustomerAddress, error)
+	CreateAddress(ctx context.Context, address *model.Address) error
+	UpdateAddress(ctx context.Context, address *model.Address) error
+	DeleteAddress(ctx context.Context, customerID, addressID uint64) error
+	SetDefaultAddress(ctx context.Context, customerID, addressID uint64) error
+}
+
+// AddressRepoCacheImpl is the address book repo cache proxy
+type AddressRepoCacheImpl struct {
+	repo      repo.AddressRepository
+	rc        cache.RedisCache
+	addresses *ReadThrough[uint64, []repo.CustomerAddress]
+}
+
+func NewAddressRepoCache(config *conf.Config, addressRepo repo.AddressRepository, lc cache.LocalCache, rc cache.RedisCache) AddressRepoCache {
+	return &AddressRepoCacheImpl{
+		repo: addressRepo,
+		rc:   rc,
+		addresses: NewReadThrough(config, lc, rc,
+			newReadThroughOptions(config, conf.AddressesFamily, addressRepo.ListAddresses, nil)),
+	}
+}
+
+func (c *AddressRepoCacheImpl) ListAddresses(ctx context.Context, customerID uint64) ([]repo.CustomerAddress, error) {
+	return c.addresses.Get(ctx, customerID)
+}
+
+func (c *AddressRepoCacheImpl) CreateAddress(ctx context.Context, address *model.Address) error {
+	if err := c.repo.CreateAddress(ctx, address); err != nil {
+		return err
+	}
+	return c.invalidate(ctx, address.CustomerID)
+}
+
+func (c *AddressRepoCacheImpl) UpdateAddress(ctx context.Context, address *model.Address) error {
+	if err := c.repo.UpdateAddress(ctx, address); err != nil {
+		return err
+	}
+	return c.invalidate(ctx, address.CustomerID)
+}
+
+func (c *AddressRepoCacheImpl) DeleteAddress(ctx context.Context, customerID, addressID uint64) error {
+	if err := c.repo.DeleteAddress(ctx, customerID, addressID); err != nil {
+		return err
+	}
+	return c.invalidate(ctx, customerID)
+}
+
+func (c *AddressRepoCacheImpl) SetDefaultAddress(ctx context.Context, customerID, addressID uint64) error {
+	if err := c.repo.SetDefaultAddress(ctx, customerID, addressID); err != nil {
+		return err
+	}
+	return c.invalidate(ctx, customerID)
+}
+
+// invalidate invalidates the address book and the customer info, whose version is bumped by default address changes
+func (c *AddressRepoCacheImpl) invalidate(ctx context.Context, customerID uint64) error {
+	return c.rc.Invalidate(ctx, append(conf.CustomerInfoKeys(customerID), c.addresses.Key(customerID))...)
+}
@@ -2,14 +2,12 @@ package proxy
 
 import (
 	"context"
-	"strconv"
+	"errors"
 
 	conf "github.com/minghsu0107/saga-account/config"
 	domain_model "github.com/minghsu0107/saga-account/domain/model"
 	"github.com/minghsu0107/saga-account/infra/cache"
-	"github.com/minghsu0107/saga-account/pkg"
 	"github.com/minghsu0107/saga-account/repo"
-	"github.com/sirupsen/logrus"
 )
 
 // JWTAuthRepoCache is the JWT Auth repo cache interface
@@ -21,13 +19,14 @@ type JWTAuthRepoCache interface {
 
 // JWTAuthRepoCacheImpl is the JWT Auth repo cache proxy
 type JWTAuthRepoCacheImpl struct {
-	repo   repo.JWTAuthRepository
-	lc     cache.LocalCache
-	rc     cache.RedisCache
-	logger *logrus.Entry
+	repo        repo.JWTAuthRepository
+	rc          cache.RedisCache
+	check       *ReadThrough[uint64, *RedisCustomerCheck]
+	credentials *ReadThrough[string, *RedisCustomerCredentials]
 }
 
 // RedisCustomerCheck it the customer auth structure stored in redis
+// Exist is kept for entries cached before misses were stored as negative entries
 type RedisCustomerCheck struct {
 	Exist  bool `redis:"exist"`
 	Active bool `redis:"active"`
@@ -41,109 +40,84 @@ type RedisCustomerCredentials struct {
 	BcryptedPassword string `redis:"bcrypted_password"`
 }
 
-func NewJWTAuthRepoCache(config *conf.Config, repo repo.JWTAuthRepository, lc cache.LocalCache, rc cache.RedisCache) JWTAuthRepoCache {
-	return &JWTAuthRepoCacheImpl{
-		repo:   repo,
-		lc:     lc,
-		rc:     rc,
-		logger: config.Logger.ContextLogger.WithField("type", "cache:JWTAuthRepoCache"),
-	}
+// CacheSchemaVersion implements cache.Versioned interface; bump it whenever the fields change
+func (RedisCustomerCheck) CacheSchemaVersion() uint16 {
+	return 1
 }
 
-func (c *JWTAuthRepoCacheImpl) CheckCustomer(ctx context.Context, customerID uint64) (bool, bool, error) {
-	check := &RedisCustomerCheck{}
-	key := pkg.Join("cuscheck:", strconv.FormatUint(customerID, 10))
-
-	ok, err := c.lc.Get(key, check)
-	if ok && err == nil {
-		return check.Exist, check.Active, nil
-	}
+// CacheSchemaVersion implements cache.Versioned interface; bump it whenever the fields change
+func (RedisCustomerCredentials) CacheSchemaVersion() uint16 {
+	return 1
+}
 
-	ok, err = c.rc.Get(ctx, key, check)
-	if ok && err == nil {
-		c.logError(c.lc.Set(key, check))
-		return check.Exist, check.Active, nil
-	}
+// errCustomerNotExist marks a nonexistent customer so that it is negatively cached
+var errCustomerNotExist = errors.New("customer not exist")
 
-	// get lock (request coalescing)
-	mutex := c.rc.GetMutex(pkg.Join("mutex:", key))
-	if err := mutex.Lock(); err != nil {
-		return false, false, err
+func NewJWTAuthRepoCache(config *conf.Config, authRepo repo.JWTAuthRepository, lc cache.LocalCache, rc cache.RedisCache) JWTAuthRepoCache {
+	return &JWTAuthRepoCacheImpl{
+		repo: authRepo,
+		rc:   rc,
+		check: NewReadThrough(config, lc, rc,
+			newReadThroughOptions(config, conf.CustomerCheckFamily, func(ctx context.Context, customerID uint64) (*RedisCustomerCheck, error) {
+				exist, active, err := authRepo.CheckCustomer(ctx, customerID)
+				if err != nil {
+					return nil, err
+				}
+				if !exist {
+					return nil, errCustomerNotExist
+				}
+				return &RedisCustomerCheck{
+					Exist:  true,
+					Active: active,
+				}, nil
+			}, errCustomerNotExist)),
+		credentials: NewReadThrough(config, lc, rc,
+			newReadThroughOptions(config, conf.CredentialsFamily, func(ctx context.Context, email string) (*RedisCustomerCredentials, error) {
+				exist, credentials, err := authRepo.GetCustomerCredentials(ctx, email)
+				if err != nil {
+					return nil, err
+				}
+				if !exist {
+					return nil, errCustomerNotExist
+				}
+				return &RedisCustomerCredentials{
+					Exist:            true,
+					ID:               credentials.ID,
+					Active:           credentials.Active,
+					BcryptedPassword: credentials.BcryptedPassword,
+				}, nil
+			}, errCustomerNotExist)),
 	}
-	defer mutex.Unlock()
+}
 
-	ok, err = c.rc.Get(ctx, key, check)
-	if ok && err == nil {
-		c.logError(c.lc.Set(key, check))
-		return check.Exist, check.Active, nil
+func (c *JWTAuthRepoCacheImpl) CheckCustomer(ctx context.Context, customerID uint64) (bool, bool, error) {
+	check, err := c.check.Get(ctx, customerID)
+	if err == errCustomerNotExist {
+		return false, false, nil
 	}
-	exist, active, err := c.repo.CheckCustomer(ctx, customerID)
 	if err != nil {
 		return false, false, err
 	}
-
-	c.logError(c.rc.Set(ctx, key, &RedisCustomerCheck{
-		Exist:  exist,
-		Active: active,
-	}))
-	return exist, active, nil
+	return check.Exist, check.Active, nil
 }
 
 func (c *JWTAuthRepoCacheImpl) GetCustomerCredentials(ctx context.Context, email string) (bool, *repo.CustomerCredentials, error) {
-	credentials := &RedisCustomerCredentials{}
-	key := pkg.Join("cuscred:", email)
-
-	ok, err := c.lc.Get(key, credentials)
-	if ok && err == nil {
-		return credentials.Exist, mapCredentials(credentials), nil
-	}
-
-	ok, err = c.rc.Get(ctx, key, credentials)
-	if ok && err == nil {
-		c.logError(c.lc.Set(key, credentials))
-		return credentials.Exist, mapCredentials(credentials), nil
-	}
-
-	// get lock (request coalescing)
-	mutex := c.rc.GetMutex(pkg.Join("mutex:", key))
-	if err := mutex.Lock(); err != nil {
-		return false, nil, err
+	credentials, err := c.credentials.Get(ctx, email)
+	if err == errCustomerNotExist {
+		return false, &repo.CustomerCredentials{}, nil
 	}
-	defer mutex.Unlock()
-
-	ok, err = c.rc.Get(ctx, key, credentials)
-	if ok && err == nil {
-		c.logError(c.lc.Set(key, credentials))
-		return credentials.Exist, mapCredentials(credentials), nil
-	}
-
-	exist, repoCredentials, err := c.repo.GetCustomerCredentials(ctx, email)
 	if err != nil {
 		return false, nil, err
 	}
-
-	if !exist {
-		repoCredentials = &repo.CustomerCredentials{}
-	}
-
-	c.logError(c.rc.Set(ctx, key, &RedisCustomerCredentials{
-		Exist:            exist,
-		ID:               repoCredentials.ID,
-		Active:           repoCredentials.Active,
-		BcryptedPassword: repoCredentials.BcryptedPassword,
-	}))
-	return exist, repoCredentials, nil
-}
-
-func (c *JWTAuthRepoCacheImpl) logError(err error) {
-	if err == nil {
-		return
-	}
-	c.logger.Error(err.Error())
+	return credentials.Exist, mapCredentials(credentials), nil
 }
 
+// CreateCustomer invalidates the cached miss of the credentials of the new email
 func (c *JWTAuthRepoCacheImpl) CreateCustomer(ctx context.Context, customer *domain_model.Customer) error {
-	return c.repo.CreateCustomer(ctx, customer)
+	if err := c.repo.CreateCustomer(ctx, customer); err != nil {
+		return err
+	}
+	return c.rc.Invalidate(ctx, c.credentials.Key(customer.PersonalInfo.Email))
 }
 
 func mapCredentials(credentials *RedisCustomerCredentials) *repo.CustomerCredentials {
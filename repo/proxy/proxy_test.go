@@ -27,6 +27,8 @@ var (
 	customerRepoCache CustomerRepoCache
 	mockJWTAuthRepo   *mock_repo.MockJWTAuthRepository
 	jwtAuthRepoCache  JWTAuthRepoCache
+	mockAddressRepo   *mock_repo.MockAddressRepository
+	addressRepoCache  AddressRepoCache
 	lc                cache.LocalCache
 	rc                cache.RedisCache
 	cleaner           cache.LocalCacheCleaner
@@ -41,6 +43,7 @@ func TestProxy(t *testing.T) {
 func InitMocks() {
 	mockCustomerRepo = mock_repo.NewMockCustomerRepository(mockCtrl)
 	mockJWTAuthRepo = mock_repo.NewMockJWTAuthRepository(mockCtrl)
+	mockAddressRepo = mock_repo.NewMockAddressRepository(mockCtrl)
 }
 
 func NewMiniRedis() *miniredis.Miniredis {
@@ -60,6 +63,11 @@ var _ = BeforeSuite(func() {
 		RedisConfig: &config.RedisConfig{
 			ExpirationSeconds: 60,
 		},
+		CacheConfig: &config.CacheConfig{
+			Default: config.CachePolicy{
+				NegativeExpirationSeconds: 60,
+			},
+		},
 		Logger: &config.Logger{
 			Writer: ioutil.Discard,
 			ContextLogger: log.WithFields(log.Fields{
@@ -71,11 +79,18 @@ var _ = BeforeSuite(func() {
 	cache.RedisClient = redis.NewClusterClient(&redis.ClusterOptions{
 		Addrs: []string{s.Addr()},
 	})
-	lc, _ = cache.NewLocalCache(config)
-	rc = cache.NewRedisCache(config, cache.RedisClient)
+	var err error
+	lc, err = cache.NewLocalCache(config)
+	Expect(err).To(BeNil())
+	rc, err = cache.NewRedisCache(config, cache.RedisClient)
+	Expect(err).To(BeNil())
 	customerRepoCache = NewCustomerRepoCache(config, mockCustomerRepo, lc, rc)
 	jwtAuthRepoCache = NewJWTAuthRepoCache(config, mockJWTAuthRepo, lc, rc)
-	cleaner = cache.NewLocalCacheCleaner(cache.RedisClient, lc)
+	addressRepoCache = NewAddressRepoCache(config, mockAddressRepo, lc, rc)
+	// miniredis fails to read a missing stream instead of blocking
+	Expect(rc.Invalidate(context.Background(), "cleaner:init")).To(BeNil())
+	cleaner, err = cache.NewLocalCacheCleaner(config, cache.RedisClient, lc, nil)
+	Expect(err).To(BeNil())
 	go func() {
 		err := cleaner.SubscribeInvalidationEvent()
 		if err != nil {
@@ -125,7 +140,7 @@ var _ = Describe("test cache proxy", func() {
 					Expect(err).To(BeNil())
 
 					mockCustomerRepo.EXPECT().
-						GetCustomerPersonalInfo(context.Background(), customer.ID).
+						GetCustomerPersonalInfo(gomock.Any(), customer.ID).
 						Return(personalInfo, nil).Times(1)
 
 					curInfo, err = customerRepoCache.GetCustomerPersonalInfo(context.Background(), customer.ID)
@@ -141,11 +156,12 @@ var _ = Describe("test cache proxy", func() {
 					Expect(curInfo).To(Equal(personalInfo))
 
 					ok, err = lc.Get(key, curInfo)
-					Expect(ok).To(BeFalse())
+					Expect(ok).To(BeTrue())
 					Expect(err).To(BeNil())
+					Expect(lc.Delete(key)).To(BeNil())
 
 					mockCustomerRepo.EXPECT().
-						GetCustomerPersonalInfo(context.Background(), customer.ID).
+						GetCustomerPersonalInfo(gomock.Any(), customer.ID).
 						Return(personalInfo, nil).Times(0)
 
 					curInfo, err = customerRepoCache.GetCustomerPersonalInfo(context.Background(), customer.ID)
@@ -166,7 +182,7 @@ var _ = Describe("test cache proxy", func() {
 					Expect(curInfo).To(Equal(personalInfo))
 
 					mockCustomerRepo.EXPECT().
-						GetCustomerPersonalInfo(context.Background(), customer.ID).
+						GetCustomerPersonalInfo(gomock.Any(), customer.ID).
 						Return(personalInfo, nil).Times(0)
 
 					curInfo, err = customerRepoCache.GetCustomerPersonalInfo(context.Background(), customer.ID)
@@ -176,10 +192,16 @@ var _ = Describe("test cache proxy", func() {
 				By("shoud return customer not found error", func() {
 					var nonExistCustomerID uint64 = 999
 					mockCustomerRepo.EXPECT().
-						GetCustomerPersonalInfo(context.Background(), nonExistCustomerID).
+						GetCustomerPersonalInfo(gomock.Any(), nonExistCustomerID).
 						Return(nil, repo.ErrCustomerNotFound)
 					_, err := customerRepoCache.GetCustomerPersonalInfo(context.Background(), nonExistCustomerID)
 					Expect(err).To(Equal(repo.ErrCustomerNotFound))
+
+					mockCustomerRepo.EXPECT().
+						GetCustomerPersonalInfo(gomock.Any(), nonExistCustomerID).
+						Times(0)
+					_, err = customerRepoCache.GetCustomerPersonalInfo(context.Background(), nonExistCustomerID)
+					Expect(err).To(Equal(repo.ErrCustomerNotFound))
 				})
 			})
 		})
@@ -205,9 +227,10 @@ var _ = Describe("test cache proxy", func() {
 				}
 				mockCustomerRepo.EXPECT().
 					UpdateCustomerPersonalInfo(context.Background(), customer.ID, domainPersonalInfo).
-					Return(nil)
-				err = customerRepoCache.UpdateCustomerPersonalInfo(context.Background(), customer.ID, domainPersonalInfo)
+					Return(personalInfo, nil)
+				before, err := customerRepoCache.UpdateCustomerPersonalInfo(context.Background(), customer.ID, domainPersonalInfo)
 				Expect(err).To(BeNil())
+				Expect(before).To(Equal(personalInfo))
 
 				time.Sleep(time.Duration(5 * time.Millisecond))
 
@@ -220,6 +243,51 @@ var _ = Describe("test cache proxy", func() {
 				Expect(err).To(BeNil())
 			})
 		})
+		Describe("patch personal info with cache", func() {
+			It("should invalidate info and credentials cache when patching email", func() {
+				newEmail := "patched@ming.com"
+				oldCredKey := pkg.Join("cuscred:", personalInfo.Email)
+				newCredKey := pkg.Join("cuscred:", newEmail)
+				Expect(rc.Set(context.Background(), oldCredKey, &RedisCustomerCredentials{Exist: true, ID: customer.ID})).To(BeNil())
+				Expect(rc.Set(context.Background(), newCredKey, &RedisCustomerCredentials{Exist: false})).To(BeNil())
+
+				patch := &domain_model.CustomerPersonalInfoPatch{
+					Email: &newEmail,
+				}
+				mockCustomerRepo.EXPECT().
+					PatchCustomerPersonalInfo(context.Background(), customer.ID, patch).
+					Return(personalInfo, nil)
+				_, err := customerRepoCache.PatchCustomerPersonalInfo(context.Background(), customer.ID, patch)
+				Expect(err).To(BeNil())
+
+				credentials := &RedisCustomerCredentials{}
+				ok, err := rc.Get(context.Background(), oldCredKey, credentials)
+				Expect(ok).To(BeFalse())
+				Expect(err).To(BeNil())
+				ok, err = rc.Get(context.Background(), newCredKey, credentials)
+				Expect(ok).To(BeFalse())
+				Expect(err).To(BeNil())
+			})
+			It("should not invalidate credentials when email is not patched", func() {
+				credKey := pkg.Join("cuscred:", personalInfo.Email)
+				Expect(rc.Set(context.Background(), credKey, &RedisCustomerCredentials{Exist: true, ID: customer.ID})).To(BeNil())
+
+				firstName := "patched"
+				patch := &domain_model.CustomerPersonalInfoPatch{
+					FirstName: &firstName,
+				}
+				mockCustomerRepo.EXPECT().
+					PatchCustomerPersonalInfo(context.Background(), customer.ID, patch).
+					Return(personalInfo, nil)
+				_, err := customerRepoCache.PatchCustomerPersonalInfo(context.Background(), customer.ID, patch)
+				Expect(err).To(BeNil())
+
+				credentials := &RedisCustomerCredentials{}
+				ok, err := rc.Get(context.Background(), credKey, credentials)
+				Expect(ok).To(BeTrue())
+				Expect(err).To(BeNil())
+			})
+		})
 	})
 	Describe("shipping info", func() {
 		shippingInfo := &repo.CustomerShippingInfo{
@@ -241,7 +309,7 @@ var _ = Describe("test cache proxy", func() {
 					Expect(err).To(BeNil())
 
 					mockCustomerRepo.EXPECT().
-						GetCustomerShippingInfo(context.Background(), customer.ID).
+						GetCustomerShippingInfo(gomock.Any(), customer.ID).
 						Return(shippingInfo, nil).Times(1)
 
 					curInfo, err = customerRepoCache.GetCustomerShippingInfo(context.Background(), customer.ID)
@@ -257,11 +325,12 @@ var _ = Describe("test cache proxy", func() {
 					Expect(curInfo).To(Equal(shippingInfo))
 
 					ok, err = lc.Get(key, curInfo)
-					Expect(ok).To(BeFalse())
+					Expect(ok).To(BeTrue())
 					Expect(err).To(BeNil())
+					Expect(lc.Delete(key)).To(BeNil())
 
 					mockCustomerRepo.EXPECT().
-						GetCustomerShippingInfo(context.Background(), customer.ID).
+						GetCustomerShippingInfo(gomock.Any(), customer.ID).
 						Return(shippingInfo, nil).Times(0)
 
 					curInfo, err = customerRepoCache.GetCustomerShippingInfo(context.Background(), customer.ID)
@@ -282,7 +351,7 @@ var _ = Describe("test cache proxy", func() {
 					Expect(curInfo).To(Equal(shippingInfo))
 
 					mockCustomerRepo.EXPECT().
-						GetCustomerShippingInfo(context.Background(), customer.ID).
+						GetCustomerShippingInfo(gomock.Any(), customer.ID).
 						Return(shippingInfo, nil).Times(0)
 
 					curInfo, err = customerRepoCache.GetCustomerShippingInfo(context.Background(), customer.ID)
@@ -292,7 +361,7 @@ var _ = Describe("test cache proxy", func() {
 				By("shoud return customer not found error", func() {
 					var nonExistCustomerID uint64 = 999
 					mockCustomerRepo.EXPECT().
-						GetCustomerShippingInfo(context.Background(), nonExistCustomerID).
+						GetCustomerShippingInfo(gomock.Any(), nonExistCustomerID).
 						Return(nil, repo.ErrCustomerNotFound)
 					_, err := customerRepoCache.GetCustomerShippingInfo(context.Background(), nonExistCustomerID)
 					Expect(err).To(Equal(repo.ErrCustomerNotFound))
@@ -320,9 +389,10 @@ var _ = Describe("test cache proxy", func() {
 				}
 				mockCustomerRepo.EXPECT().
 					UpdateCustomerShippingInfo(context.Background(), customer.ID, domainShippingInfo).
-					Return(nil)
-				err = customerRepoCache.UpdateCustomerShippingInfo(context.Background(), customer.ID, domainShippingInfo)
+					Return(shippingInfo, nil)
+				before, err := customerRepoCache.UpdateCustomerShippingInfo(context.Background(), customer.ID, domainShippingInfo)
 				Expect(err).To(BeNil())
+				Expect(before).To(Equal(shippingInfo))
 
 				time.Sleep(time.Duration(5 * time.Millisecond))
 
@@ -336,6 +406,31 @@ var _ = Describe("test cache proxy", func() {
 			})
 		})
 	})
+	Describe("customer status", func() {
+		It("should invalidate customer check and credentials cache when status changes", func() {
+			checkKey := pkg.Join("cuscheck:", strconv.FormatUint(customer.ID, 10))
+			credKey := pkg.Join("cuscred:", customer.PersonalInfo.Email)
+			Expect(rc.Set(context.Background(), checkKey, &RedisCustomerCheck{Exist: true, Active: true})).To(BeNil())
+			Expect(rc.Set(context.Background(), credKey, &RedisCustomerCredentials{Exist: true, ID: customer.ID, Active: true})).To(BeNil())
+
+			mockCustomerRepo.EXPECT().
+				GetCustomerPersonalInfo(gomock.Any(), customer.ID).
+				Return(&repo.CustomerPersonalInfo{Email: customer.PersonalInfo.Email}, nil)
+			mockCustomerRepo.EXPECT().
+				UpdateCustomerStatus(context.Background(), customer.ID, false).
+				Return(true, nil)
+			previous, err := customerRepoCache.UpdateCustomerStatus(context.Background(), customer.ID, false)
+			Expect(err).To(BeNil())
+			Expect(previous).To(BeTrue())
+
+			ok, err := rc.Get(context.Background(), checkKey, &RedisCustomerCheck{})
+			Expect(ok).To(BeFalse())
+			Expect(err).To(BeNil())
+			ok, err = rc.Get(context.Background(), credKey, &RedisCustomerCredentials{})
+			Expect(ok).To(BeFalse())
+			Expect(err).To(BeNil())
+		})
+	})
 	var _ = Describe("auth", func() {
 		Describe("check customer with cache", func() {
 			redisCheck := &RedisCustomerCheck{
@@ -356,7 +451,7 @@ var _ = Describe("test cache proxy", func() {
 					Expect(err).To(BeNil())
 
 					mockJWTAuthRepo.EXPECT().
-						CheckCustomer(context.Background(), customer.ID).
+						CheckCustomer(gomock.Any(), customer.ID).
 						Return(redisCheck.Exist, redisCheck.Active, nil).Times(1)
 
 					exist, active, err := jwtAuthRepoCache.CheckCustomer(context.Background(), customer.ID)
@@ -374,11 +469,12 @@ var _ = Describe("test cache proxy", func() {
 					Expect(curCheck).To(Equal(redisCheck))
 
 					ok, err = lc.Get(key, curCheck)
-					Expect(ok).To(BeFalse())
+					Expect(ok).To(BeTrue())
 					Expect(err).To(BeNil())
+					Expect(lc.Delete(key)).To(BeNil())
 
 					mockJWTAuthRepo.EXPECT().
-						CheckCustomer(context.Background(), customer.ID).
+						CheckCustomer(gomock.Any(), customer.ID).
 						Return(redisCheck.Exist, redisCheck.Active, nil).Times(0)
 
 					exist, active, err := jwtAuthRepoCache.CheckCustomer(context.Background(), customer.ID)
@@ -400,7 +496,7 @@ var _ = Describe("test cache proxy", func() {
 					Expect(curCheck).To(Equal(redisCheck))
 
 					mockJWTAuthRepo.EXPECT().
-						CheckCustomer(context.Background(), customer.ID).
+						CheckCustomer(gomock.Any(), customer.ID).
 						Return(redisCheck.Exist, redisCheck.Active, nil).Times(0)
 
 					exist, active, err := jwtAuthRepoCache.CheckCustomer(context.Background(), customer.ID)
@@ -422,7 +518,7 @@ var _ = Describe("test cache proxy", func() {
 					Expect(err).To(BeNil())
 
 					mockJWTAuthRepo.EXPECT().
-						CheckCustomer(context.Background(), nonExistCustomerID).
+						CheckCustomer(gomock.Any(), nonExistCustomerID).
 						Return(false, false, nil).Times(1)
 
 					exist, active, err := jwtAuthRepoCache.CheckCustomer(context.Background(), nonExistCustomerID)
@@ -430,17 +526,22 @@ var _ = Describe("test cache proxy", func() {
 					Expect(exist).To(BeFalse())
 					Expect(active).To(BeFalse())
 
-					ok, err = rc.Get(context.Background(), key, curCheck)
+					ok, data, _, err := rc.GetRaw(context.Background(), key)
 					Expect(ok).To(BeTrue())
 					Expect(err).To(BeNil())
-					Expect(curCheck).To(Equal(&RedisCustomerCheck{
-						Exist:  false,
-						Active: false,
-					}))
+					Expect(data).To(BeEmpty())
 
 					ok, err = lc.Get(key, curCheck)
 					Expect(ok).To(BeFalse())
 					Expect(err).To(BeNil())
+
+					mockJWTAuthRepo.EXPECT().
+						CheckCustomer(gomock.Any(), nonExistCustomerID).
+						Return(false, false, nil).Times(0)
+
+					exist, _, err = jwtAuthRepoCache.CheckCustomer(context.Background(), nonExistCustomerID)
+					Expect(err).To(BeNil())
+					Expect(exist).To(BeFalse())
 				})
 			})
 		})
@@ -470,7 +571,7 @@ var _ = Describe("test cache proxy", func() {
 					Expect(err).To(BeNil())
 
 					mockJWTAuthRepo.EXPECT().
-						GetCustomerCredentials(context.Background(), customer.PersonalInfo.Email).
+						GetCustomerCredentials(gomock.Any(), customer.PersonalInfo.Email).
 						Return(redisCredentials.Exist, repoCredentials, nil).Times(1)
 
 					exist, curRepoCredentials, err := jwtAuthRepoCache.GetCustomerCredentials(context.Background(), customer.PersonalInfo.Email)
@@ -488,11 +589,12 @@ var _ = Describe("test cache proxy", func() {
 					Expect(curRedisCredentials).To(Equal(redisCredentials))
 
 					ok, err = lc.Get(key, curRedisCredentials)
-					Expect(ok).To(BeFalse())
+					Expect(ok).To(BeTrue())
 					Expect(err).To(BeNil())
+					Expect(lc.Delete(key)).To(BeNil())
 
 					mockJWTAuthRepo.EXPECT().
-						GetCustomerCredentials(context.Background(), customer.PersonalInfo.Email).
+						GetCustomerCredentials(gomock.Any(), customer.PersonalInfo.Email).
 						Return(redisCredentials.Exist, repoCredentials, nil).Times(0)
 
 					exist, curRepoCredentials, err := jwtAuthRepoCache.GetCustomerCredentials(context.Background(), customer.PersonalInfo.Email)
@@ -514,7 +616,7 @@ var _ = Describe("test cache proxy", func() {
 					Expect(curRedisCredentials).To(Equal(redisCredentials))
 
 					mockJWTAuthRepo.EXPECT().
-						GetCustomerCredentials(context.Background(), customer.PersonalInfo.Email).
+						GetCustomerCredentials(gomock.Any(), customer.PersonalInfo.Email).
 						Return(redisCredentials.Exist, repoCredentials, nil).Times(0)
 
 					exist, curRepoCredentials, err := jwtAuthRepoCache.GetCustomerCredentials(context.Background(), customer.PersonalInfo.Email)
@@ -536,24 +638,119 @@ var _ = Describe("test cache proxy", func() {
 					Expect(err).To(BeNil())
 
 					mockJWTAuthRepo.EXPECT().
-						GetCustomerCredentials(context.Background(), nonExistCustomerEmail).
+						GetCustomerCredentials(gomock.Any(), nonExistCustomerEmail).
 						Return(false, nil, nil)
 
 					exist, _, err := jwtAuthRepoCache.GetCustomerCredentials(context.Background(), nonExistCustomerEmail)
 					Expect(err).To(BeNil())
 					Expect(exist).To(BeFalse())
 
-					ok, err = rc.Get(context.Background(), key, curRedisCredentials)
+					ok, data, _, err := rc.GetRaw(context.Background(), key)
 					Expect(ok).To(BeTrue())
 					Expect(err).To(BeNil())
-					Expect(curRedisCredentials).To(Equal(&RedisCustomerCredentials{
-						Exist: false,
-					}))
+					Expect(data).To(BeEmpty())
 
 					ok, err = lc.Get(key, curRedisCredentials)
 					Expect(ok).To(BeFalse())
 					Expect(err).To(BeNil())
 				})
+				By("should invalidate the cached miss when the customer signs up", func() {
+					nonExistCustomerEmail := "nonexist@ming.com"
+					newCustomer := &domain_model.Customer{
+						PersonalInfo: &domain_model.CustomerPersonalInfo{
+							Email: nonExistCustomerEmail,
+						},
+					}
+					mockJWTAuthRepo.EXPECT().
+						CreateCustomer(context.Background(), newCustomer).
+						Return(nil)
+					Expect(jwtAuthRepoCache.CreateCustomer(context.Background(), newCustomer)).To(BeNil())
+
+					ok, _, _, err := rc.GetRaw(context.Background(), pkg.Join("cuscred:", nonExistCustomerEmail))
+					Expect(ok).To(BeFalse())
+					Expect(err).To(BeNil())
+				})
+			})
+		})
+	})
+	var _ = Describe("address book", func() {
+		addresses := []repo.CustomerAddress{
+			{
+				ID:          10,
+				Recipient:   "ming hsu",
+				PhoneNumber: customer.ShippingInfo.PhoneNumber,
+				Line1:       "first street",
+				City:        "Taipei",
+				Country:     "TW",
+				IsDefault:   true,
+			},
+		}
+		key := pkg.Join("cusaddresses:", strconv.FormatUint(customer.ID, 10))
+		It("should cache addresses and invalidate them on write", func() {
+			By("should hit database when addresses not in cache", func() {
+				mockAddressRepo.EXPECT().
+					ListAddresses(gomock.Any(), customer.ID).
+					Return(addresses, nil).Times(1)
+
+				curAddresses, err := addressRepoCache.ListAddresses(context.Background(), customer.ID)
+				Expect(err).To(BeNil())
+				Expect(curAddresses).To(Equal(addresses))
+			})
+			By("should hit redis cache", func() {
+				Expect(lc.Delete(key)).To(BeNil())
+				mockAddressRepo.EXPECT().
+					ListAddresses(gomock.Any(), customer.ID).
+					Return(addresses, nil).Times(0)
+
+				curAddresses, err := addressRepoCache.ListAddresses(context.Background(), customer.ID)
+				Expect(err).To(BeNil())
+				Expect(curAddresses).To(Equal(addresses))
+			})
+			By("should invalidate cache when setting default address", func() {
+				mockAddressRepo.EXPECT().
+					SetDefaultAddress(context.Background(), customer.ID, addresses[0].ID).
+					Return(nil)
+				err := addressRepoCache.SetDefaultAddress(context.Background(), customer.ID, addresses[0].ID)
+				Expect(err).To(BeNil())
+
+				time.Sleep(time.Duration(5 * time.Millisecond))
+
+				curAddresses := []repo.CustomerAddress{}
+				ok, err := rc.Get(context.Background(), key, &curAddresses)
+				Expect(ok).To(BeFalse())
+				Expect(err).To(BeNil())
+
+				ok, err = lc.Get(key, &curAddresses)
+				Expect(ok).To(BeFalse())
+				Expect(err).To(BeNil())
+			})
+			By("should invalidate shipping info when the default address changes", func() {
+				shippingInfoKey := pkg.Join("cusshippinginfo:", strconv.FormatUint(customer.ID, 10))
+				mockCustomerRepo.EXPECT().
+					GetCustomerShippingInfo(gomock.Any(), customer.ID).
+					Return(&repo.CustomerShippingInfo{
+						Address:     customer.ShippingInfo.Address,
+						PhoneNumber: customer.ShippingInfo.PhoneNumber,
+					}, nil).Times(1)
+				_, err := customerRepoCache.GetCustomerShippingInfo(context.Background(), customer.ID)
+				Expect(err).To(BeNil())
+
+				mockAddressRepo.EXPECT().
+					SetDefaultAddress(context.Background(), customer.ID, addresses[0].ID).
+					Return(nil)
+				err = addressRepoCache.SetDefaultAddress(context.Background(), customer.ID, addresses[0].ID)
+				Expect(err).To(BeNil())
+
+				time.Sleep(time.Duration(5 * time.Millisecond))
+
+				curInfo := &repo.CustomerShippingInfo{}
+				ok, err := rc.Get(context.Background(), shippingInfoKey, curInfo)
+				Expect(ok).To(BeFalse())
+				Expect(err).To(BeNil())
+
+				ok, err = lc.Get(shippingInfoKey, curInfo)
+				Expect(ok).To(BeFalse())
+				Expect(err).To(BeNil())
 			})
 		})
 	})
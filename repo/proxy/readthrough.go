@@ -0,0 +1,432 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"sync"
+	"time"
+
+	conf "github.com/minghsu0107/saga-account/config"
+	"github.com/minghsu0107/saga-account/infra/cache"
+	"github.com/minghsu0107/saga-account/pkg"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// xfetchBeta scales how early values are refreshed before they expire; values above 1 favor earlier refreshes
+	xfetchBeta = 1.0
+	// deltaWeight is the weight of the latest load duration in the moving average of a key family
+	deltaWeight = 0.2
+
+	// fetchTimeout bounds a load shared by coalesced callers, which runs detached from their contexts
+	fetchTimeout   = 5 * time.Second
+	refreshTimeout = 5 * time.Second
+
+	tracerName = "github.com/minghsu0107/saga-account/repo/proxy"
+)
+
+// outcomes of a cache read
+const (
+	outcomeLocalHit     = "local_hit"
+	outcomeHit          = "hit"
+	outcomeNegativeHit  = "negative_hit"
+	outcomeStale        = "stale"
+	outcomeEarlyRefresh = "early_refresh"
+	outcomeMiss         = "miss"
+	outcomeCoalesced    = "coalesced"
+	outcomeError        = "error"
+	outcomeRefreshError = "refresh_error"
+	outcomeDecodeError  = "decode_error"
+	outcomeBypass       = "bypass"
+)
+
+// caches looked up by a read and the results of the lookups
+const (
+	cacheLocal = "local"
+	cacheRedis = "redis"
+
+	lookupHit   = "hit"
+	lookupMiss  = "miss"
+	lookupError = "error"
+)
+
+var (
+	cacheReads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "account_cache_reads_total",
+		Help: "The number of cache reads by key family and outcome.",
+	}, []string{"family", "outcome"})
+	cacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "account_cache_lookups_total",
+		Help: "The number of lookups of the local and redis caches by key family and result.",
+	}, []string{"family", "cache", "result"})
+)
+
+// Codec encodes cached values
+// a value must never be encoded as empty bytes, which mark a cached miss
+type Codec[V any] interface {
+	Encode(val V) ([]byte, error)
+	Decode(data []byte) (V, error)
+}
+
+// EnvelopeCodec seals values in versioned envelopes of a cache codec
+type EnvelopeCodec[V any] struct {
+	Codec cache.Codec
+}
+
+// Encode implements Codec interface
+func (c EnvelopeCodec[V]) Encode(val V) ([]byte, error) {
+	return cache.Seal(c.Codec, val)
+}
+
+// Decode implements Codec interface
+// a pointer value is allocated first, so that its schema version is found
+func (c EnvelopeCodec[V]) Decode(data []byte) (V, error) {
+	var val V
+	if t := reflect.TypeOf(val); t != nil && t.Kind() == reflect.Ptr {
+		val = reflect.New(t.Elem()).Interface().(V)
+		err := cache.Open(data, val)
+		return val, err
+	}
+	err := cache.Open(data, &val)
+	return val, err
+}
+
+// ReadThroughOptions configures a ReadThrough
+type ReadThroughOptions[K comparable, V any] struct {
+	// Family is the key prefix; the key of id is Family:id
+	Family string
+	// Load loads a value from the repository on a cache miss
+	Load func(ctx context.Context, id K) (V, error)
+	// NotFound is the error Load returns for a missing value; misses are not cached if it is nil
+	NotFound error
+	// Codec encodes values in the local and redis caches; it defaults to EnvelopeCodec of the redis cache codec
+	Codec Codec[V]
+	// Policy returns the current cache policy of the family; it defaults to the policy in config
+	Policy func() conf.CachePolicy
+	// Stale is how long a value is served after it expires while it is refreshed in the background
+	Stale time.Duration
+}
+
+// ReadThrough is a read-through cache of a key family backed by local and redis caches
+// concurrent misses of a key are coalesced in-process before a redis mutex coalesces them across instances.
+// a value is served stale for a while after it expires and is refreshed in the background,
+// and it may be refreshed before it expires with probabilistic early expiration (XFetch).
+// misses are cached in redis only, so that they expire after the negative expiration of the family.
+// values are written with the version of their key read before they were loaded,
+// so that a value loaded before an invalidation does not overwrite it
+type ReadThrough[K comparable, V any] struct {
+	opts  ReadThroughOptions[K, V]
+	lc    cache.LocalCache
+	rc    cache.RedisCache
+	group singleflight.Group
+	// delta is the average load duration
+	delta  movingAverage
+	logger *logrus.Entry
+}
+
+// NewReadThrough is the factory of ReadThrough
+func NewReadThrough[K comparable, V any](config *conf.Config, lc cache.LocalCache, rc cache.RedisCache, opts ReadThroughOptions[K, V]) *ReadThrough[K, V] {
+	if opts.Codec == nil {
+		opts.Codec = EnvelopeCodec[V]{
+			Codec: rc.Codec(),
+		}
+	}
+	if opts.Policy == nil {
+		family := opts.Family
+		opts.Policy = func() conf.CachePolicy {
+			return config.CachePolicy(family)
+		}
+	}
+	return &ReadThrough[K, V]{
+		opts:   opts,
+		lc:     lc,
+		rc:     rc,
+		logger: config.Logger.ContextLogger.WithField("type", pkg.Join("cache:ReadThrough:", opts.Family)),
+	}
+}
+
+// newReadThroughOptions returns the options of a key family with the stale period from config
+func newReadThroughOptions[K comparable, V any](config *conf.Config, family string, load func(ctx context.Context, id K) (V, error), notFound error) ReadThroughOptions[K, V] {
+	return ReadThroughOptions[K, V]{
+		Family:   family,
+		Load:     load,
+		NotFound: notFound,
+		Stale:    time.Duration(config.RedisConfig.StaleSeconds) * time.Second,
+	}
+}
+
+// Key returns the cache key of id
+func (r *ReadThrough[K, V]) Key(id K) string {
+	return conf.CacheKey(r.opts.Family, fmt.Sprint(id))
+}
+
+// Get reads the value of id, loading it on a miss
+// it returns the NotFound error if the value is missing
+func (r *ReadThrough[K, V]) Get(ctx context.Context, id K) (V, error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "ReadThrough.Get",
+		trace.WithAttributes(attribute.String("cache.family", r.opts.Family)))
+	defer span.End()
+
+	var zero V
+	key := r.Key(id)
+	// readAt versions local entries; it precedes every read, so an invalidation after it discards them
+	readAt := time.Now().UnixNano()
+
+	if r.opts.Policy().IsDisabled() {
+		r.count(ctx, outcomeBypass)
+		return r.opts.Load(ctx, id)
+	}
+
+	ok, data, err := r.lc.GetRaw(key)
+	r.lookup(ctx, cacheLocal, ok, err)
+	if ok && err == nil {
+		val, err := r.opts.Codec.Decode(data)
+		if err == nil {
+			r.count(ctx, outcomeLocalHit)
+			return val, nil
+		}
+		// an outdated or corrupted entry is a miss
+		r.count(ctx, outcomeDecodeError)
+		r.logError(r.lc.Delete(key))
+	}
+
+	ok, data, ttl, err := r.rc.GetRaw(ctx, key)
+	r.lookup(ctx, cacheRedis, ok, err)
+	if err != nil {
+		r.logError(err)
+	}
+	if ok && err == nil {
+		if len(data) == 0 {
+			r.count(ctx, outcomeNegativeHit)
+			return zero, r.opts.NotFound
+		}
+		val, err := r.opts.Codec.Decode(data)
+		if err == nil {
+			fresh := ttl - r.opts.Stale
+			switch {
+			case ttl < 0:
+				// the key never expires
+				r.count(ctx, outcomeHit)
+				r.logError(r.lc.SetRaw(key, data, readAt))
+			case fresh <= 0:
+				r.count(ctx, outcomeStale)
+				r.refresh(id, ttl)
+			case r.expiresEarly(fresh):
+				r.count(ctx, outcomeEarlyRefresh)
+				r.refresh(id, ttl)
+			default:
+				r.count(ctx, outcomeHit)
+				r.logError(r.lc.SetRaw(key, data, readAt))
+			}
+			return val, nil
+		}
+		r.count(ctx, outcomeDecodeError)
+		r.logError(err)
+		r.logError(r.rc.Delete(ctx, key))
+	}
+
+	// the fetch outlives the caller that started it, and each caller stops waiting when its own context is done
+	ch := r.group.DoChan(key, func() (interface{}, error) {
+		fetchCtx, cancel := context.WithTimeout(pkg.Detach(ctx), fetchTimeout)
+		defer cancel()
+		return r.fetch(fetchCtx, id)
+	})
+	var res singleflight.Result
+	select {
+	case res = <-ch:
+	case <-ctx.Done():
+		res.Err = ctx.Err()
+	}
+	if res.Err != nil {
+		r.count(ctx, outcomeError)
+		span.RecordError(res.Err)
+		span.SetStatus(codes.Error, res.Err.Error())
+		return zero, res.Err
+	}
+	if res.Shared {
+		r.count(ctx, outcomeCoalesced)
+	} else {
+		r.count(ctx, outcomeMiss)
+	}
+	result := res.Val.(fetchResult)
+	if len(result.data) == 0 {
+		return zero, r.opts.NotFound
+	}
+	// every caller decodes its own copy of the shared value
+	val, err := r.opts.Codec.Decode(result.data)
+	if err != nil {
+		return zero, err
+	}
+	r.logError(r.lc.SetRaw(key, result.data, result.readAt))
+	return val, nil
+}
+
+// fetchResult is an encoded value and when it was read
+// coalesced callers share the time of the read, which may precede their own
+type fetchResult struct {
+	data   []byte
+	readAt int64
+}
+
+// fetch loads a missing value under the redis mutex of the key and returns it encoded
+func (r *ReadThrough[K, V]) fetch(ctx context.Context, id K) (fetchResult, error) {
+	key := r.Key(id)
+	// get lock (request coalescing)
+	unlock, err := r.rc.Lock(ctx, pkg.Join("mutex:", key))
+	if err != nil {
+		return fetchResult{}, err
+	}
+	defer unlock()
+
+	readAt := time.Now().UnixNano()
+	ok, data, _, err := r.rc.GetRaw(ctx, key)
+	if ok && err == nil {
+		return fetchResult{data: data, readAt: readAt}, nil
+	}
+	version, err := r.rc.Version(ctx, key)
+	if err != nil {
+		return fetchResult{}, err
+	}
+	data, err = r.loadAndSet(ctx, id, version)
+	if err != nil {
+		return fetchResult{}, err
+	}
+	return fetchResult{data: data, readAt: readAt}, nil
+}
+
+// refresh reloads a value in the background unless it is being refreshed by this or another instance
+// observedTTL is the ttl of the value that triggered the refresh; a longer ttl means it has been refreshed since
+func (r *ReadThrough[K, V]) refresh(id K, observedTTL time.Duration) {
+	key := r.Key(id)
+	go r.group.Do(pkg.Join("refresh:", key), func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+		defer cancel()
+
+		unlock, err := r.rc.Lock(ctx, pkg.Join("mutex:", key))
+		if err != nil {
+			r.count(ctx, outcomeRefreshError)
+			r.logError(err)
+			return nil, err
+		}
+		defer unlock()
+
+		ok, _, ttl, err := r.rc.GetRaw(ctx, key)
+		if ok && err == nil && ttl > observedTTL {
+			return nil, nil
+		}
+		version, err := r.rc.Version(ctx, key)
+		if err != nil {
+			r.count(ctx, outcomeRefreshError)
+			r.logError(err)
+			return nil, err
+		}
+		if _, err := r.loadAndSet(ctx, id, version); err != nil {
+			r.count(ctx, outcomeRefreshError)
+			r.logError(err)
+			return nil, err
+		}
+		return nil, nil
+	})
+}
+
+// loadAndSet loads a value from the repository and stores it in redis, keeping it for the stale period after it expires
+// a miss is stored as empty bytes if misses are cached. nothing is stored if the key is invalidated after version is read
+func (r *ReadThrough[K, V]) loadAndSet(ctx context.Context, id K, version int64) ([]byte, error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "ReadThrough.Load",
+		trace.WithAttributes(attribute.String("cache.family", r.opts.Family)))
+	defer span.End()
+
+	key := r.Key(id)
+	start := time.Now()
+	val, err := r.opts.Load(ctx, id)
+	if err != nil && (r.opts.NotFound == nil || err != r.opts.NotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	r.delta.observe(time.Since(start))
+
+	policy := r.opts.Policy()
+	if err != nil {
+		if policy.NegativeExpirationSeconds > 0 {
+			_, err := r.rc.SetRawIfVersion(ctx, key, []byte{}, policy.NegativeExpiration(), version)
+			r.logError(err)
+		}
+		return []byte{}, nil
+	}
+	data, err := r.opts.Codec.Encode(val)
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.rc.SetRawIfVersion(ctx, key, data, policy.RedisExpiration()+r.opts.Stale, version)
+	r.logError(err)
+	return data, nil
+}
+
+// expiresEarly decides whether a value is refreshed before it expires
+// the probability grows as the remaining fresh time approaches the time it takes to load the value
+func (r *ReadThrough[K, V]) expiresEarly(fresh time.Duration) bool {
+	delta := r.delta.get()
+	if delta == 0 {
+		return false
+	}
+	return -float64(delta)*xfetchBeta*math.Log(rand.Float64()) >= float64(fresh)
+}
+
+// count counts the outcome of a read and tags the span of the read with it
+func (r *ReadThrough[K, V]) count(ctx context.Context, outcome string) {
+	cacheReads.WithLabelValues(r.opts.Family, outcome).Inc()
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("cache.outcome", outcome))
+}
+
+// lookup counts the result of a lookup of a cache and tags the span of the read with it; a cached miss is a hit
+func (r *ReadThrough[K, V]) lookup(ctx context.Context, layer string, ok bool, err error) {
+	result := lookupMiss
+	switch {
+	case err != nil:
+		result = lookupError
+	case ok:
+		result = lookupHit
+	}
+	cacheLookups.WithLabelValues(r.opts.Family, layer, result).Inc()
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String(pkg.Join("cache.", layer), result))
+}
+
+func (r *ReadThrough[K, V]) logError(err error) {
+	if err == nil {
+		return
+	}
+	r.logger.Error(err.Error())
+}
+
+// movingAverage is an exponentially weighted moving average of durations
+type movingAverage struct {
+	mu  sync.Mutex
+	avg time.Duration
+}
+
+func (a *movingAverage) observe(d time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.avg == 0 {
+		a.avg = d
+		return
+	}
+	a.avg = time.Duration(deltaWeight*float64(d) + (1-deltaWeight)*float64(a.avg))
+}
+
+func (a *movingAverage) get() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.avg
+}
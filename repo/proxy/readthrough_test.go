@@ -0,0 +1,436 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/minghsu0107/saga-account/config"
+	"github.com/minghsu0107/saga-account/infra/cache"
+	pb "github.com/minghsu0107/saga-pb"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/redis/go-redis/v9"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type readThroughValue struct {
+	Version int `json:"version"`
+}
+
+// versionCodec encodes a value as its decimal version
+type versionCodec struct{}
+
+func (versionCodec) Encode(val *readThroughValue) ([]byte, error) {
+	return []byte(strconv.Itoa(val.Version)), nil
+}
+
+func (versionCodec) Decode(data []byte) (*readThroughValue, error) {
+	version, err := strconv.Atoi(string(data))
+	if err != nil {
+		return nil, err
+	}
+	return &readThroughValue{
+		Version: version,
+	}, nil
+}
+
+var errValueNotFound = errors.New("value not found")
+
+var _ = Describe("test read-through cache", func() {
+	const family = "readthroughtest"
+	var loads int32
+	disabled := true
+	readThroughConfig := &config.Config{
+		RedisConfig: &config.RedisConfig{
+			ExpirationSeconds: 60,
+			StaleSeconds:      30,
+		},
+		CacheConfig: &config.CacheConfig{
+			Default: config.CachePolicy{
+				JitterPercent:             10,
+				NegativeExpirationSeconds: 5,
+			},
+			Families: map[string]config.CachePolicy{
+				"readthroughshort": {
+					RedisExpirationSeconds: 10,
+				},
+				"readthroughdisabled": {
+					Disabled: &disabled,
+				},
+			},
+		},
+		Logger: &config.Logger{
+			Writer: ioutil.Discard,
+			ContextLogger: log.WithFields(log.Fields{
+				"app": "test",
+			}),
+		},
+	}
+	load := func(delay time.Duration) func(ctx context.Context, id string) (*readThroughValue, error) {
+		return func(ctx context.Context, id string) (*readThroughValue, error) {
+			time.Sleep(delay)
+			version := atomic.AddInt32(&loads, 1)
+			return &readThroughValue{
+				Version: int(version),
+			}, nil
+		}
+	}
+	newReadThrough := func(load func(ctx context.Context, id string) (*readThroughValue, error)) *ReadThrough[string, *readThroughValue] {
+		return NewReadThrough(readThroughConfig, lc, rc,
+			newReadThroughOptions(readThroughConfig, family, load, errValueNotFound))
+	}
+	ttl := func(key string) time.Duration {
+		_, _, ttl, err := rc.GetRaw(context.Background(), key)
+		Expect(err).To(BeNil())
+		return ttl
+	}
+	setRaw := func(key string, val *readThroughValue, expiration time.Duration) {
+		data, err := cache.Seal(cache.JSONCodec{}, val)
+		Expect(err).To(BeNil())
+		Expect(rc.SetRaw(context.Background(), key, data, expiration)).To(BeNil())
+	}
+	BeforeEach(func() {
+		atomic.StoreInt32(&loads, 0)
+	})
+	It("should coalesce concurrent misses", func() {
+		r := newReadThrough(load(50 * time.Millisecond))
+		key := r.Key("coalesce")
+		before := testutil.ToFloat64(cacheReads.WithLabelValues(family, outcomeMiss)) +
+			testutil.ToFloat64(cacheReads.WithLabelValues(family, outcomeCoalesced))
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer GinkgoRecover()
+				val, err := r.Get(context.Background(), "coalesce")
+				Expect(err).To(BeNil())
+				Expect(val.Version).To(Equal(1))
+			}()
+		}
+		wg.Wait()
+		Expect(atomic.LoadInt32(&loads)).To(Equal(int32(1)))
+		Expect(ttl(key)).To(BeNumerically(">", 60*time.Second))
+
+		after := testutil.ToFloat64(cacheReads.WithLabelValues(family, outcomeMiss)) +
+			testutil.ToFloat64(cacheReads.WithLabelValues(family, outcomeCoalesced))
+		Expect(after - before).To(Equal(float64(10)))
+	})
+	It("should not fail coalesced misses when the first caller gives up", func() {
+		release := make(chan struct{})
+		started := make(chan struct{})
+		r := newReadThrough(func(ctx context.Context, id string) (*readThroughValue, error) {
+			close(started)
+			<-release
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			return &readThroughValue{
+				Version: int(atomic.AddInt32(&loads, 1)),
+			}, nil
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		firstErr := make(chan error, 1)
+		go func() {
+			_, err := r.Get(ctx, "giveup")
+			firstErr <- err
+		}()
+		<-started
+		second := make(chan *readThroughValue, 1)
+		go func() {
+			defer GinkgoRecover()
+			val, err := r.Get(context.Background(), "giveup")
+			Expect(err).To(BeNil())
+			second <- val
+		}()
+
+		cancel()
+		Eventually(firstErr).Should(Receive(Equal(context.Canceled)))
+		close(release)
+		var val *readThroughValue
+		Eventually(second).Should(Receive(&val))
+		Expect(val.Version).To(Equal(1))
+		Expect(atomic.LoadInt32(&loads)).To(Equal(int32(1)))
+	})
+	It("should serve a stale value and refresh it in the background", func() {
+		r := newReadThrough(load(0))
+		key := r.Key("stale")
+		setRaw(key, &readThroughValue{Version: 0}, 10*time.Second)
+
+		val, err := r.Get(context.Background(), "stale")
+		Expect(err).To(BeNil())
+		Expect(val.Version).To(Equal(0))
+
+		Eventually(func() time.Duration {
+			return ttl(key)
+		}, time.Second).Should(BeNumerically(">", 60*time.Second))
+		Expect(atomic.LoadInt32(&loads)).To(Equal(int32(1)))
+	})
+	It("should refresh a value early when it takes long to load", func() {
+		r := newReadThrough(load(0))
+		r.delta.observe(1000 * time.Hour)
+		setRaw(r.Key("early"), &readThroughValue{Version: 0}, 31*time.Second)
+
+		val, err := r.Get(context.Background(), "early")
+		Expect(err).To(BeNil())
+		Expect(val.Version).To(Equal(0))
+		Eventually(func() int32 {
+			return atomic.LoadInt32(&loads)
+		}, time.Second).Should(Equal(int32(1)))
+	})
+	It("should not refresh a fresh value", func() {
+		r := newReadThrough(load(0))
+		setRaw(r.Key("fresh"), &readThroughValue{Version: 0}, 90*time.Second)
+
+		val, err := r.Get(context.Background(), "fresh")
+		Expect(err).To(BeNil())
+		Expect(val.Version).To(Equal(0))
+		Consistently(func() int32 {
+			return atomic.LoadInt32(&loads)
+		}, 100*time.Millisecond).Should(Equal(int32(0)))
+	})
+	It("should not cache load errors", func() {
+		errLoad := errors.New("db down")
+		r := newReadThrough(func(ctx context.Context, id string) (*readThroughValue, error) {
+			return nil, errLoad
+		})
+		_, err := r.Get(context.Background(), "error")
+		Expect(err).To(Equal(errLoad))
+		ok, _, _, err := rc.GetRaw(context.Background(), r.Key("error"))
+		Expect(ok).To(BeFalse())
+		Expect(err).To(BeNil())
+	})
+	It("should reload outdated entries", func() {
+		r := newReadThrough(load(0))
+		key := r.Key("legacy")
+		Expect(rc.SetRaw(context.Background(), key, []byte(`{"version":0}`), time.Minute)).To(BeNil())
+
+		val, err := r.Get(context.Background(), "legacy")
+		Expect(err).To(BeNil())
+		Expect(val.Version).To(Equal(1))
+		ok, data, _, err := rc.GetRaw(context.Background(), key)
+		Expect(ok).To(BeTrue())
+		Expect(err).To(BeNil())
+		Expect(data[0]).To(Equal(cache.JSONCodecID))
+	})
+	It("should count lookups of each cache", func() {
+		r := newReadThrough(load(0))
+		lookups := func(layer, result string) float64 {
+			return testutil.ToFloat64(cacheLookups.WithLabelValues(family, layer, result))
+		}
+		localMisses, localHits, redisMisses := lookups(cacheLocal, lookupMiss), lookups(cacheLocal, lookupHit), lookups(cacheRedis, lookupMiss)
+		for i := 0; i < 2; i++ {
+			_, err := r.Get(context.Background(), "lookup")
+			Expect(err).To(BeNil())
+		}
+		// the second read is served by the local cache
+		Expect(lookups(cacheLocal, lookupMiss) - localMisses).To(Equal(float64(1)))
+		Expect(lookups(cacheLocal, lookupHit) - localHits).To(Equal(float64(1)))
+		Expect(lookups(cacheRedis, lookupMiss) - redisMisses).To(Equal(float64(1)))
+	})
+	It("should trace reads tagged with the result of each cache", func() {
+		exporter := tracetest.NewInMemoryExporter()
+		otel.SetTracerProvider(tracesdk.NewTracerProvider(tracesdk.WithSyncer(exporter)))
+		defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+		r := newReadThrough(load(0))
+		attributes := func(span tracetest.SpanStub) map[attribute.Key]string {
+			attrs := make(map[attribute.Key]string)
+			for _, kv := range span.Attributes {
+				attrs[kv.Key] = kv.Value.AsString()
+			}
+			return attrs
+		}
+
+		_, err := r.Get(context.Background(), "traced")
+		Expect(err).To(BeNil())
+		spans := exporter.GetSpans()
+		Expect(spans).To(HaveLen(2))
+		// the load ends before the read
+		Expect(spans[0].Name).To(Equal("ReadThrough.Load"))
+		Expect(spans[0].Parent.SpanID()).To(Equal(spans[1].SpanContext.SpanID()))
+		Expect(spans[1].Name).To(Equal("ReadThrough.Get"))
+		Expect(attributes(spans[1])).To(Equal(map[attribute.Key]string{
+			"cache.family":  family,
+			"cache.local":   lookupMiss,
+			"cache.redis":   lookupMiss,
+			"cache.outcome": outcomeMiss,
+		}))
+
+		exporter.Reset()
+		_, err = r.Get(context.Background(), "traced")
+		Expect(err).To(BeNil())
+		spans = exporter.GetSpans()
+		Expect(spans).To(HaveLen(1))
+		Expect(attributes(spans[0])).To(Equal(map[attribute.Key]string{
+			"cache.family":  family,
+			"cache.local":   lookupHit,
+			"cache.outcome": outcomeLocalHit,
+		}))
+	})
+	It("should cache misses for the negative expiration", func() {
+		r := newReadThrough(func(ctx context.Context, id string) (*readThroughValue, error) {
+			atomic.AddInt32(&loads, 1)
+			return nil, errValueNotFound
+		})
+		before := testutil.ToFloat64(cacheReads.WithLabelValues(family, outcomeNegativeHit))
+		for i := 0; i < 3; i++ {
+			_, err := r.Get(context.Background(), "negative")
+			Expect(err).To(Equal(errValueNotFound))
+		}
+		Expect(atomic.LoadInt32(&loads)).To(Equal(int32(1)))
+		Expect(testutil.ToFloat64(cacheReads.WithLabelValues(family, outcomeNegativeHit)) - before).To(Equal(float64(2)))
+
+		ttl := ttl(r.Key("negative"))
+		Expect(ttl).To(BeNumerically(">", 0))
+		Expect(ttl).To(BeNumerically("<=", 5*time.Second))
+		ok, _, err := lc.GetRaw(r.Key("negative"))
+		Expect(ok).To(BeFalse())
+		Expect(err).To(BeNil())
+	})
+	It("should not cache misses without a not found error", func() {
+		r := NewReadThrough(readThroughConfig, lc, rc, ReadThroughOptions[string, *readThroughValue]{
+			Family: family,
+			Load: func(ctx context.Context, id string) (*readThroughValue, error) {
+				return nil, errValueNotFound
+			},
+			Policy: func() config.CachePolicy {
+				return config.CachePolicy{
+					RedisExpirationSeconds:    60,
+					NegativeExpirationSeconds: 60,
+				}
+			},
+		})
+		_, err := r.Get(context.Background(), "nonotfound")
+		Expect(err).To(Equal(errValueNotFound))
+		ok, _, _, err := rc.GetRaw(context.Background(), r.Key("nonotfound"))
+		Expect(ok).To(BeFalse())
+		Expect(err).To(BeNil())
+	})
+	It("should encode values with a custom codec", func() {
+		opts := newReadThroughOptions(readThroughConfig, family, load(0), errValueNotFound)
+		opts.Codec = versionCodec{}
+		r := NewReadThrough(readThroughConfig, lc, rc, opts)
+
+		val, err := r.Get(context.Background(), "codec")
+		Expect(err).To(BeNil())
+		Expect(val.Version).To(Equal(1))
+
+		ok, data, _, err := rc.GetRaw(context.Background(), r.Key("codec"))
+		Expect(ok).To(BeTrue())
+		Expect(err).To(BeNil())
+		Expect(string(data)).To(Equal("1"))
+		ok, data, err = lc.GetRaw(r.Key("codec"))
+		Expect(ok).To(BeTrue())
+		Expect(err).To(BeNil())
+		Expect(string(data)).To(Equal("1"))
+	})
+	It("should cache protobuf messages with the protobuf codec", func() {
+		opts := newReadThroughOptions(readThroughConfig, "readthroughproto", func(ctx context.Context, id uint64) (*pb.AuthResponse, error) {
+			atomic.AddInt32(&loads, 1)
+			return &pb.AuthResponse{CustomerId: id, Expired: true}, nil
+		}, errValueNotFound)
+		opts.Codec = EnvelopeCodec[*pb.AuthResponse]{
+			Codec: cache.ProtobufCodec{},
+		}
+		r := NewReadThrough(readThroughConfig, lc, rc, opts)
+
+		val, err := r.Get(context.Background(), 1)
+		Expect(err).To(BeNil())
+		Expect(val.CustomerId).To(Equal(uint64(1)))
+		ok, data, _, err := rc.GetRaw(context.Background(), r.Key(1))
+		Expect(ok).To(BeTrue())
+		Expect(err).To(BeNil())
+		Expect(data[0]).To(Equal(cache.ProtobufCodecID))
+
+		Expect(lc.Delete(r.Key(1))).To(BeNil())
+		val, err = r.Get(context.Background(), 1)
+		Expect(err).To(BeNil())
+		Expect(val.Expired).To(BeTrue())
+		Expect(atomic.LoadInt32(&loads)).To(Equal(int32(1)))
+	})
+	It("should expire values with the family expiration", func() {
+		r := NewReadThrough(readThroughConfig, lc, rc,
+			newReadThroughOptions(readThroughConfig, "readthroughshort", load(0), errValueNotFound))
+		_, err := r.Get(context.Background(), "ttl")
+		Expect(err).To(BeNil())
+
+		ttl := ttl(r.Key("ttl"))
+		Expect(ttl).To(BeNumerically(">", 39*time.Second))
+		Expect(ttl).To(BeNumerically("<=", 41*time.Second))
+	})
+	It("should bypass caches of a disabled family", func() {
+		r := NewReadThrough(readThroughConfig, lc, rc,
+			newReadThroughOptions(readThroughConfig, "readthroughdisabled", load(0), errValueNotFound))
+		for i := 1; i <= 2; i++ {
+			val, err := r.Get(context.Background(), "bypass")
+			Expect(err).To(BeNil())
+			Expect(val.Version).To(Equal(i))
+		}
+		ok, _, _, err := rc.GetRaw(context.Background(), r.Key("bypass"))
+		Expect(ok).To(BeFalse())
+		Expect(err).To(BeNil())
+	})
+	It("should discard values loaded before an invalidation", func() {
+		var r *ReadThrough[string, *readThroughValue]
+		r = newReadThrough(func(ctx context.Context, id string) (*readThroughValue, error) {
+			// the value is updated while it is loaded
+			Expect(rc.Invalidate(ctx, r.Key(id))).To(BeNil())
+			return load(0)(ctx, id)
+		})
+		val, err := r.Get(context.Background(), "late")
+		Expect(err).To(BeNil())
+		Expect(val.Version).To(Equal(1))
+
+		ok, _, _, err := rc.GetRaw(context.Background(), r.Key("late"))
+		Expect(ok).To(BeFalse())
+		Expect(err).To(BeNil())
+		Eventually(func() bool {
+			ok, _, _ := lc.GetRaw(r.Key("late"))
+			return ok
+		}, 2*time.Second).Should(BeFalse())
+	})
+	It("should load values from the repository while redis is unavailable", func() {
+		s := NewMiniRedis()
+		client := redis.NewClient(&redis.Options{
+			Addr:       s.Addr(),
+			MaxRetries: -1,
+		})
+		defer client.Close()
+		s.Close()
+		breakerConfig := *readThroughConfig
+		redisConfig := *readThroughConfig.RedisConfig
+		redisConfig.BreakerFailureThreshold = 1
+		breakerConfig.RedisConfig = &redisConfig
+		downRC, err := cache.NewBreakerRedisCache(&breakerConfig, client, lc, nil)
+		Expect(err).To(BeNil())
+		r := NewReadThrough(&breakerConfig, lc, downRC,
+			newReadThroughOptions(&breakerConfig, "readthroughdown", load(50*time.Millisecond), errValueNotFound))
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer GinkgoRecover()
+				val, err := r.Get(context.Background(), "down")
+				Expect(err).To(BeNil())
+				Expect(val.Version).To(Equal(1))
+			}()
+		}
+		wg.Wait()
+		Expect(atomic.LoadInt32(&loads)).To(Equal(int32(1)))
+	})
+})
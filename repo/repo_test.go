@@ -3,6 +3,7 @@ package repo
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/minghsu0107/saga-account/pkg"
 
@@ -16,6 +17,10 @@ import (
 var (
 	customerRepo CustomerRepository
 	authRepo     JWTAuthRepository
+	addressRepo  AddressRepository
+	auditRepo    AuditRepository
+	outboxRepo   OutboxRepository
+	sagaRepo     SagaRepository
 	sf           pkg.IDGenerator
 )
 
@@ -26,14 +31,18 @@ func TestRepo(t *testing.T) {
 
 var _ = BeforeSuite(func() {
 	InitDB()
-	customerRepo = NewCustomerRepository(db)
-	authRepo = NewJWTAuthRepository(db)
-	db.Migrator().DropTable(&model.Customer{})
-	db.AutoMigrate(&model.Customer{})
+	customerRepo = NewCustomerRepository(router)
+	authRepo = NewJWTAuthRepository(router)
+	addressRepo = NewAddressRepository(router)
+	auditRepo = NewAuditRepository(router)
+	outboxRepo = NewOutboxRepository(db)
+	sagaRepo = NewSagaRepository(db)
+	db.Migrator().DropTable(&model.Customer{}, &model.Address{}, &model.AuditLog{}, &model.OutboxEvent{}, &model.ProcessedSagaCommand{})
+	db.AutoMigrate(&model.Customer{}, &model.Address{}, &model.AuditLog{}, &model.OutboxEvent{}, &model.ProcessedSagaCommand{})
 })
 
 var _ = AfterSuite(func() {
-	db.Migrator().DropTable(&model.Customer{})
+	db.Migrator().DropTable(&model.Customer{}, &model.Address{}, &model.AuditLog{}, &model.OutboxEvent{}, &model.ProcessedSagaCommand{})
 	sqlDB, err := db.DB()
 	if err != nil {
 		panic(err)
@@ -154,8 +163,13 @@ var _ = Describe("test repo", func() {
 					LastName:  "dummy",
 					Email:     "dummy@ming.com",
 				}
-				err := customerRepo.UpdateCustomerPersonalInfo(context.Background(), customer.ID, &personalInfo)
+				before, err := customerRepo.UpdateCustomerPersonalInfo(context.Background(), customer.ID, &personalInfo)
 				Expect(err).To(BeNil())
+				Expect(before).To(Equal(&CustomerPersonalInfo{
+					FirstName: customer.PersonalInfo.FirstName,
+					LastName:  customer.PersonalInfo.LastName,
+					Email:     customer.PersonalInfo.Email,
+				}))
 
 				curPersonalInfo, _ := customerRepo.GetCustomerPersonalInfo(context.Background(), customer.ID)
 				Expect(&personalInfo).To(Equal(&domain_model.CustomerPersonalInfo{
@@ -170,13 +184,79 @@ var _ = Describe("test repo", func() {
 					PhoneNumber: originalShippingInfo.PhoneNumber,
 				}))
 			})
+			By("should update customer personal info with matching version", func() {
+				curPersonalInfo, err := customerRepo.GetCustomerPersonalInfo(context.Background(), customer.ID)
+				Expect(err).To(BeNil())
+				personalInfo := domain_model.CustomerPersonalInfo{
+					FirstName: "versioned",
+					LastName:  "versioned",
+					Email:     "versioned@ming.com",
+					Version:   curPersonalInfo.Version,
+				}
+				_, err = customerRepo.UpdateCustomerPersonalInfo(context.Background(), customer.ID, &personalInfo)
+				Expect(err).To(BeNil())
+
+				newPersonalInfo, _ := customerRepo.GetCustomerPersonalInfo(context.Background(), customer.ID)
+				Expect(newPersonalInfo.Version).To(Equal(curPersonalInfo.Version + 1))
+			})
+			By("should return version conflict error when updating with outdated version", func() {
+				curPersonalInfo, err := customerRepo.GetCustomerPersonalInfo(context.Background(), customer.ID)
+				Expect(err).To(BeNil())
+				personalInfo := domain_model.CustomerPersonalInfo{
+					FirstName: "outdated",
+					LastName:  "outdated",
+					Email:     "outdated@ming.com",
+					Version:   curPersonalInfo.Version - 1,
+				}
+				_, err = customerRepo.UpdateCustomerPersonalInfo(context.Background(), customer.ID, &personalInfo)
+				Expect(err).To(Equal(ErrVersionConflict))
+			})
+			By("should return not found error when updating non-existent customer", func() {
+				nonExistID, err := sf.NextID()
+				if err != nil {
+					panic(err)
+				}
+				_, err = customerRepo.UpdateCustomerShippingInfo(context.Background(), nonExistID, &domain_model.CustomerShippingInfo{
+					Address:     "dummy adress",
+					PhoneNumber: "dummy phone number",
+				})
+				Expect(err).To(Equal(ErrCustomerNotFound))
+			})
+			By("should patch only supplied columns of customer personal info", func() {
+				before, err := customerRepo.GetCustomerPersonalInfo(context.Background(), customer.ID)
+				Expect(err).To(BeNil())
+				firstName := "patched"
+				_, err = customerRepo.PatchCustomerPersonalInfo(context.Background(), customer.ID, &domain_model.CustomerPersonalInfoPatch{
+					FirstName: &firstName,
+				})
+				Expect(err).To(BeNil())
+
+				after, _ := customerRepo.GetCustomerPersonalInfo(context.Background(), customer.ID)
+				Expect(after.FirstName).To(Equal(firstName))
+				Expect(after.LastName).To(Equal(before.LastName))
+				Expect(after.Email).To(Equal(before.Email))
+			})
+			By("should clear patched columns set to empty value", func() {
+				lastName := ""
+				_, err := customerRepo.PatchCustomerPersonalInfo(context.Background(), customer.ID, &domain_model.CustomerPersonalInfoPatch{
+					LastName: &lastName,
+				})
+				Expect(err).To(BeNil())
+
+				after, _ := customerRepo.GetCustomerPersonalInfo(context.Background(), customer.ID)
+				Expect(after.LastName).To(BeEmpty())
+			})
 			By("should update customer shipping info", func() {
 				shippingInfo := domain_model.CustomerShippingInfo{
 					Address:     "dummy adress",
 					PhoneNumber: "dummy phone number",
 				}
-				err := customerRepo.UpdateCustomerShippingInfo(context.Background(), customer.ID, &shippingInfo)
+				before, err := customerRepo.UpdateCustomerShippingInfo(context.Background(), customer.ID, &shippingInfo)
 				Expect(err).To(BeNil())
+				Expect(before).To(Equal(&CustomerShippingInfo{
+					Address:     customer.ShippingInfo.Address,
+					PhoneNumber: customer.ShippingInfo.PhoneNumber,
+				}))
 
 				curShippingInfo, _ := customerRepo.GetCustomerShippingInfo(context.Background(), customer.ID)
 				Expect(&shippingInfo).To(Equal(&domain_model.CustomerShippingInfo{
@@ -184,6 +264,238 @@ var _ = Describe("test repo", func() {
 					PhoneNumber: curShippingInfo.PhoneNumber,
 				}))
 			})
+			By("should update customer status", func() {
+				previous, err := customerRepo.UpdateCustomerStatus(context.Background(), customer.ID, false)
+				Expect(err).To(BeNil())
+				Expect(previous).To(BeTrue())
+
+				_, active, _ := authRepo.CheckCustomer(context.Background(), customer.ID)
+				Expect(active).To(BeFalse())
+
+				previous, err = customerRepo.UpdateCustomerStatus(context.Background(), customer.ID, true)
+				Expect(err).To(BeNil())
+				Expect(previous).To(BeFalse())
+
+				_, err = customerRepo.UpdateCustomerStatus(context.Background(), customer.ID+1, true)
+				Expect(err).To(Equal(ErrCustomerNotFound))
+			})
+		})
+	})
+	var _ = Describe("audit repo", func() {
+		var _ = It("should test audit dao", func() {
+			var ids []uint64
+			By("should append audit events", func() {
+				for _, eventType := range []domain_model.AuditEventType{
+					domain_model.AuditSignUp, domain_model.AuditLoginFailure, domain_model.AuditLoginSuccess,
+				} {
+					id, err := sf.NextID()
+					if err != nil {
+						panic(err)
+					}
+					ids = append(ids, id)
+					err = auditRepo.AppendAuditEvent(context.Background(), &domain_model.AuditEvent{
+						ID:         id,
+						CustomerID: customer.ID,
+						Type:       eventType,
+						Actor:      "customer",
+						IP:         "127.0.0.1",
+						UserAgent:  "test",
+						RequestID:  "req-1",
+						Changes: map[string]domain_model.AuditChange{
+							"email": {Before: "", After: "t***@ming.com"},
+						},
+						CreatedAt: time.Now(),
+					})
+					Expect(err).To(BeNil())
+				}
+			})
+			By("should list audit events latest first", func() {
+				events, err := auditRepo.ListAuditEvents(context.Background(), &domain_model.AuditQuery{
+					CustomerID: customer.ID,
+					Limit:      2,
+				})
+				Expect(err).To(BeNil())
+				Expect(events).To(HaveLen(2))
+				Expect(events[0].ID).To(Equal(ids[2]))
+				Expect(events[1].ID).To(Equal(ids[1]))
+				Expect(events[0].Changes["email"].After).To(Equal("t***@ming.com"))
+				Expect(events[0].RequestID).To(Equal("req-1"))
+
+				events, err = auditRepo.ListAuditEvents(context.Background(), &domain_model.AuditQuery{
+					CustomerID: customer.ID,
+					Before:     ids[1],
+					Limit:      2,
+				})
+				Expect(err).To(BeNil())
+				Expect(events).To(HaveLen(1))
+				Expect(events[0].Type).To(Equal(domain_model.AuditSignUp))
+			})
+			By("should filter audit events by type", func() {
+				events, err := auditRepo.ListAuditEvents(context.Background(), &domain_model.AuditQuery{
+					Types: []domain_model.AuditEventType{domain_model.AuditLoginFailure},
+					Limit: 10,
+				})
+				Expect(err).To(BeNil())
+				Expect(events).To(HaveLen(1))
+				Expect(events[0].ID).To(Equal(ids[1]))
+			})
+		})
+	})
+	var _ = Describe("address repo", func() {
+		var _ = It("should test address dao", func() {
+			newAddress := func(line1 string, isDefault bool) *domain_model.Address {
+				id, err := sf.NextID()
+				if err != nil {
+					panic(err)
+				}
+				return &domain_model.Address{
+					ID:          id,
+					CustomerID:  customer.ID,
+					Recipient:   "ming hsu",
+					PhoneNumber: "+886923456978",
+					PostalAddress: domain_model.PostalAddress{
+						Line1:      line1,
+						City:       "Taipei",
+						PostalCode: "100",
+						Country:    "TW",
+					},
+					Default: isDefault,
+				}
+			}
+			first := newAddress("first street", false)
+			second := newAddress("second street", false)
+
+			By("should make the first address default", func() {
+				err := addressRepo.CreateAddress(context.Background(), first)
+				Expect(err).To(BeNil())
+				err = addressRepo.CreateAddress(context.Background(), second)
+				Expect(err).To(BeNil())
+
+				addresses, err := addressRepo.ListAddresses(context.Background(), customer.ID)
+				Expect(err).To(BeNil())
+				Expect(addresses).To(HaveLen(2))
+				Expect(addresses[0].ID).To(Equal(first.ID))
+				Expect(addresses[0].IsDefault).To(BeTrue())
+				Expect(addresses[1].IsDefault).To(BeFalse())
+			})
+			By("should reject shipping info updates once the customer has addresses", func() {
+				address := "dummy address"
+				_, err := customerRepo.PatchCustomerShippingInfo(context.Background(), customer.ID, &domain_model.CustomerShippingInfoPatch{
+					Address: &address,
+				})
+				Expect(err).To(Equal(ErrShippingInfoInAddressBook))
+			})
+			By("should set default address", func() {
+				before, err := customerRepo.GetCustomerShippingInfo(context.Background(), customer.ID)
+				Expect(err).To(BeNil())
+				err = addressRepo.SetDefaultAddress(context.Background(), customer.ID, second.ID)
+				Expect(err).To(BeNil())
+
+				after, err := customerRepo.GetCustomerShippingInfo(context.Background(), customer.ID)
+				Expect(err).To(BeNil())
+				Expect(after.Version).To(Equal(before.Version + 1))
+
+				addresses, _ := addressRepo.ListAddresses(context.Background(), customer.ID)
+				Expect(addresses[0].IsDefault).To(BeFalse())
+				Expect(addresses[1].IsDefault).To(BeTrue())
+			})
+			By("should update address", func() {
+				second.Line1 = "updated street"
+				err := addressRepo.UpdateAddress(context.Background(), second)
+				Expect(err).To(BeNil())
+
+				addresses, _ := addressRepo.ListAddresses(context.Background(), customer.ID)
+				Expect(addresses[1].Line1).To(Equal(second.Line1))
+				Expect(addresses[1].IsDefault).To(BeTrue())
+			})
+			By("should promote remaining address when deleting default address", func() {
+				err := addressRepo.DeleteAddress(context.Background(), customer.ID, second.ID)
+				Expect(err).To(BeNil())
+
+				addresses, _ := addressRepo.ListAddresses(context.Background(), customer.ID)
+				Expect(addresses).To(HaveLen(1))
+				Expect(addresses[0].ID).To(Equal(first.ID))
+				Expect(addresses[0].IsDefault).To(BeTrue())
+			})
+			By("should return not found error for address of another customer", func() {
+				otherCustomerID, err := sf.NextID()
+				if err != nil {
+					panic(err)
+				}
+				err = addressRepo.SetDefaultAddress(context.Background(), otherCustomerID, first.ID)
+				Expect(err).To(Equal(ErrAddressNotFound))
+				err = addressRepo.DeleteAddress(context.Background(), otherCustomerID, first.ID)
+				Expect(err).To(Equal(ErrAddressNotFound))
+			})
+		})
+	})
+	var _ = Describe("outbox repo", func() {
+		var _ = It("should test outbox dao", func() {
+			var events []OutboxEvent
+			By("should append events in the same transaction as customer writes", func() {
+				var err error
+				events, err = outboxRepo.ListPendingEvents(context.Background(), 100)
+				Expect(err).To(BeNil())
+
+				var types []string
+				for _, event := range events {
+					if event.CustomerID == customer.ID {
+						types = append(types, event.Type)
+					}
+				}
+				Expect(types).To(ContainElements(
+					string(domain_model.CustomerSignedUp),
+					string(domain_model.CustomerEmailChanged),
+					string(domain_model.CustomerAddressChanged),
+					string(domain_model.CustomerDeactivated),
+					string(domain_model.CustomerActivated),
+				))
+				Expect(types[0]).To(Equal(string(domain_model.CustomerSignedUp)))
+			})
+			By("should delete published events", func() {
+				ids := make([]uint64, len(events))
+				for i, event := range events {
+					ids[i] = event.ID
+				}
+				err := outboxRepo.DeleteEvents(context.Background(), ids)
+				Expect(err).To(BeNil())
+
+				remaining, err := outboxRepo.ListPendingEvents(context.Background(), 100)
+				Expect(err).To(BeNil())
+				Expect(remaining).To(BeEmpty())
+			})
+		})
+	})
+	var _ = Describe("saga repo", func() {
+		var _ = It("should test saga dao", func() {
+			reply := &domain_model.SagaReply{
+				SagaID:     "saga-1",
+				Type:       domain_model.SagaCustomerReserved,
+				CustomerID: customer.ID,
+				OrderID:    1,
+				ShippingSnapshot: &domain_model.ShippingSnapshot{
+					FirstName: "ming",
+					Address:   "Taipei, Taiwan",
+				},
+			}
+			By("should not find an unprocessed command", func() {
+				processed, _, err := sagaRepo.GetSagaReply(context.Background(), reply.SagaID, domain_model.SagaReserveCustomer)
+				Expect(err).To(BeNil())
+				Expect(processed).To(BeFalse())
+			})
+			By("should save and get the reply of a processed command", func() {
+				err := sagaRepo.SaveSagaReply(context.Background(), domain_model.SagaReserveCustomer, reply)
+				Expect(err).To(BeNil())
+
+				processed, stored, err := sagaRepo.GetSagaReply(context.Background(), reply.SagaID, domain_model.SagaReserveCustomer)
+				Expect(err).To(BeNil())
+				Expect(processed).To(BeTrue())
+				Expect(stored).To(Equal(reply))
+			})
+			By("should reject processing the same command twice", func() {
+				err := sagaRepo.SaveSagaReply(context.Background(), domain_model.SagaReserveCustomer, reply)
+				Expect(err).To(Equal(ErrDuplicateEntry))
+			})
 		})
 	})
 })
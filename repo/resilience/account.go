@@ -0,0 +1,102 @@
+package resilience
+
+import (
+	"context"
+
+	domain_model "github.com/minghsu0107/saga-account/domain/model"
+	infra_db "github.com/minghsu0107/saga-account/infra/db"
+	"github.com/minghsu0107/saga-account/repo"
+)
+
+// CustomerRepository runs the operations of a customer repository with the resilience policy
+type CustomerRepository struct {
+	repo   repo.CustomerRepository
+	policy *Policy
+}
+
+// NewCustomerRepository is the factory of the customer repository guarded by the resilience policy
+func NewCustomerRepository(router *infra_db.Router, policy *Policy) repo.CustomerRepository {
+	return &CustomerRepository{
+		repo:   repo.NewCustomerRepository(router),
+		policy: policy,
+	}
+}
+
+// GetCustomerPersonalInfo queries customer personal info by customer id
+func (r *CustomerRepository) GetCustomerPersonalInfo(ctx context.Context, customerID uint64) (*repo.CustomerPersonalInfo, error) {
+	var info *repo.CustomerPersonalInfo
+	err := r.policy.Read(ctx, "GetCustomerPersonalInfo", func(ctx context.Context) error {
+		var err error
+		info, err = r.repo.GetCustomerPersonalInfo(ctx, customerID)
+		return err
+	})
+	return info, err
+}
+
+// GetCustomerShippingInfo queries customer shipping info by customer id
+func (r *CustomerRepository) GetCustomerShippingInfo(ctx context.Context, customerID uint64) (*repo.CustomerShippingInfo, error) {
+	var info *repo.CustomerShippingInfo
+	err := r.policy.Read(ctx, "GetCustomerShippingInfo", func(ctx context.Context) error {
+		var err error
+		info, err = r.repo.GetCustomerShippingInfo(ctx, customerID)
+		return err
+	})
+	return info, err
+}
+
+// UpdateCustomerPersonalInfo updates a customer's personal info and returns the personal info before the update
+func (r *CustomerRepository) UpdateCustomerPersonalInfo(ctx context.Context, customerID uint64, personalInfo *domain_model.CustomerPersonalInfo) (*repo.CustomerPersonalInfo, error) {
+	var before *repo.CustomerPersonalInfo
+	err := r.policy.Write(ctx, "UpdateCustomerPersonalInfo", func(ctx context.Context) error {
+		var err error
+		before, err = r.repo.UpdateCustomerPersonalInfo(ctx, customerID, personalInfo)
+		return err
+	})
+	return before, err
+}
+
+// UpdateCustomerShippingInfo updates a customer's shipping info and returns the shipping info before the update
+func (r *CustomerRepository) UpdateCustomerShippingInfo(ctx context.Context, customerID uint64, shippingInfo *domain_model.CustomerShippingInfo) (*repo.CustomerShippingInfo, error) {
+	var before *repo.CustomerShippingInfo
+	err := r.policy.Write(ctx, "UpdateCustomerShippingInfo", func(ctx context.Context) error {
+		var err error
+		before, err = r.repo.UpdateCustomerShippingInfo(ctx, customerID, shippingInfo)
+		return err
+	})
+	return before, err
+}
+
+// PatchCustomerPersonalInfo updates the supplied columns of a customer's personal info
+// and returns the personal info before the update
+func (r *CustomerRepository) PatchCustomerPersonalInfo(ctx context.Context, customerID uint64, patch *domain_model.CustomerPersonalInfoPatch) (*repo.CustomerPersonalInfo, error) {
+	var before *repo.CustomerPersonalInfo
+	err := r.policy.Write(ctx, "PatchCustomerPersonalInfo", func(ctx context.Context) error {
+		var err error
+		before, err = r.repo.PatchCustomerPersonalInfo(ctx, customerID, patch)
+		return err
+	})
+	return before, err
+}
+
+// PatchCustomerShippingInfo updates the supplied columns of a customer's shipping info
+// and returns the shipping info before the update
+func (r *CustomerRepository) PatchCustomerShippingInfo(ctx context.Context, customerID uint64, patch *domain_model.CustomerShippingInfoPatch) (*repo.CustomerShippingInfo, error) {
+	var before *repo.CustomerShippingInfo
+	err := r.policy.Write(ctx, "PatchCustomerShippingInfo", func(ctx context.Context) error {
+		var err error
+		before, err = r.repo.PatchCustomerShippingInfo(ctx, customerID, patch)
+		return err
+	})
+	return before, err
+}
+
+// UpdateCustomerStatus activates or deactivates a customer and returns the previous status
+func (r *CustomerRepository) UpdateCustomerStatus(ctx context.Context, customerID uint64, active bool) (bool, error) {
+	var previous bool
+	err := r.policy.Write(ctx, "UpdateCustomerStatus", func(ctx context.Context) error {
+		var err error
+		previous, err = r.repo.UpdateCustomerStatus(ctx, customerID, active)
+		return err
+	})
+	return previous, err
+}
@@ -0,0 +1,62 @@
+package resilience
+
+import (
+	"context"
+
+	domain_model "github.com/minghsu0107/saga-account/domain/model"
+	infra_db "github.com/minghsu0107/saga-account/infra/db"
+	"github.com/minghsu0107/saga-account/repo"
+)
+
+// AddressRepository runs the operations of an address repository with the resilience policy
+type AddressRepository struct {
+	repo   repo.AddressRepository
+	policy *Policy
+}
+
+// NewAddressRepository is the factory of the address repository guarded by the resilience policy
+func NewAddressRepository(router *infra_db.Router, policy *Policy) repo.AddressRepository {
+	return &AddressRepository{
+		repo:   repo.NewAddressRepository(router),
+		policy: policy,
+	}
+}
+
+// ListAddresses lists the addresses of a customer
+func (r *AddressRepository) ListAddresses(ctx context.Context, customerID uint64) ([]repo.CustomerAddress, error) {
+	var addresses []repo.CustomerAddress
+	err := r.policy.Read(ctx, "ListAddresses", func(ctx context.Context) error {
+		var err error
+		addresses, err = r.repo.ListAddresses(ctx, customerID)
+		return err
+	})
+	return addresses, err
+}
+
+// CreateAddress creates an address
+func (r *AddressRepository) CreateAddress(ctx context.Context, address *domain_model.Address) error {
+	return r.policy.Write(ctx, "CreateAddress", func(ctx context.Context) error {
+		return r.repo.CreateAddress(ctx, address)
+	})
+}
+
+// UpdateAddress updates an address
+func (r *AddressRepository) UpdateAddress(ctx context.Context, address *domain_model.Address) error {
+	return r.policy.Write(ctx, "UpdateAddress", func(ctx context.Context) error {
+		return r.repo.UpdateAddress(ctx, address)
+	})
+}
+
+// DeleteAddress deletes an address
+func (r *AddressRepository) DeleteAddress(ctx context.Context, customerID, addressID uint64) error {
+	return r.policy.Write(ctx, "DeleteAddress", func(ctx context.Context) error {
+		return r.repo.DeleteAddress(ctx, customerID, addressID)
+	})
+}
+
+// SetDefaultAddress sets the default address of a customer
+func (r *AddressRepository) SetDefaultAddress(ctx context.Context, customerID, addressID uint64) error {
+	return r.policy.Write(ctx, "SetDefaultAddress", func(ctx context.Context) error {
+		return r.repo.SetDefaultAddress(ctx, customerID, addressID)
+	})
+}
@@ -0,0 +1,41 @@
+package resilience
+
+import (
+	"context"
+
+	domain_model "github.com/minghsu0107/saga-account/domain/model"
+	infra_db "github.com/minghsu0107/saga-account/infra/db"
+	"github.com/minghsu0107/saga-account/repo"
+)
+
+// AuditRepository runs the operations of an audit repository with the resilience policy
+type AuditRepository struct {
+	repo   repo.AuditRepository
+	policy *Policy
+}
+
+// NewAuditRepository is the factory of the audit repository guarded by the resilience policy
+func NewAuditRepository(router *infra_db.Router, policy *Policy) repo.AuditRepository {
+	return &AuditRepository{
+		repo:   repo.NewAuditRepository(router),
+		policy: policy,
+	}
+}
+
+// AppendAuditEvent appends an audit event
+func (r *AuditRepository) AppendAuditEvent(ctx context.Context, event *domain_model.AuditEvent) error {
+	return r.policy.Write(ctx, "AppendAuditEvent", func(ctx context.Context) error {
+		return r.repo.AppendAuditEvent(ctx, event)
+	})
+}
+
+// ListAuditEvents lists the audit events matching a query
+func (r *AuditRepository) ListAuditEvents(ctx context.Context, query *domain_model.AuditQuery) ([]domain_model.AuditEvent, error) {
+	var events []domain_model.AuditEvent
+	err := r.policy.Read(ctx, "ListAuditEvents", func(ctx context.Context) error {
+		var err error
+		events, err = r.repo.ListAuditEvents(ctx, query)
+		return err
+	})
+	return events, err
+}
@@ -0,0 +1,53 @@
+package resilience
+
+import (
+	"context"
+
+	domain_model "github.com/minghsu0107/saga-account/domain/model"
+	infra_db "github.com/minghsu0107/saga-account/infra/db"
+	"github.com/minghsu0107/saga-account/repo"
+)
+
+// JWTAuthRepository runs the operations of a JWTAuth repository with the resilience policy
+type JWTAuthRepository struct {
+	repo   repo.JWTAuthRepository
+	policy *Policy
+}
+
+// NewJWTAuthRepository is the factory of the JWTAuth repository guarded by the resilience policy
+func NewJWTAuthRepository(router *infra_db.Router, policy *Policy) repo.JWTAuthRepository {
+	return &JWTAuthRepository{
+		repo:   repo.NewJWTAuthRepository(router),
+		policy: policy,
+	}
+}
+
+// CheckCustomer checks whether a customer exists and is active
+func (r *JWTAuthRepository) CheckCustomer(ctx context.Context, customerID uint64) (bool, bool, error) {
+	var exist, active bool
+	err := r.policy.Read(ctx, "CheckCustomer", func(ctx context.Context) error {
+		var err error
+		exist, active, err = r.repo.CheckCustomer(ctx, customerID)
+		return err
+	})
+	return exist, active, err
+}
+
+// CreateCustomer creates a new customer
+func (r *JWTAuthRepository) CreateCustomer(ctx context.Context, customer *domain_model.Customer) error {
+	return r.policy.Write(ctx, "CreateCustomer", func(ctx context.Context) error {
+		return r.repo.CreateCustomer(ctx, customer)
+	})
+}
+
+// GetCustomerCredentials finds customer credentials by email
+func (r *JWTAuthRepository) GetCustomerCredentials(ctx context.Context, email string) (bool, *repo.CustomerCredentials, error) {
+	var exist bool
+	var credentials *repo.CustomerCredentials
+	err := r.policy.Read(ctx, "GetCustomerCredentials", func(ctx context.Context) error {
+		var err error
+		exist, credentials, err = r.repo.GetCustomerCredentials(ctx, email)
+		return err
+	})
+	return exist, credentials, err
+}
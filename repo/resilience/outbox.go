@@ -0,0 +1,40 @@
+package resilience
+
+import (
+	"context"
+
+	"github.com/minghsu0107/saga-account/repo"
+	"gorm.io/gorm"
+)
+
+// OutboxRepository runs the operations of an outbox repository with the resilience policy
+type OutboxRepository struct {
+	repo   repo.OutboxRepository
+	policy *Policy
+}
+
+// NewOutboxRepository is the factory of the outbox repository guarded by the resilience policy
+func NewOutboxRepository(db *gorm.DB, policy *Policy) repo.OutboxRepository {
+	return &OutboxRepository{
+		repo:   repo.NewOutboxRepository(db),
+		policy: policy,
+	}
+}
+
+// ListPendingEvents lists the oldest pending events
+func (r *OutboxRepository) ListPendingEvents(ctx context.Context, limit int) ([]repo.OutboxEvent, error) {
+	var events []repo.OutboxEvent
+	err := r.policy.Read(ctx, "ListPendingEvents", func(ctx context.Context) error {
+		var err error
+		events, err = r.repo.ListPendingEvents(ctx, limit)
+		return err
+	})
+	return events, err
+}
+
+// DeleteEvents deletes published events
+func (r *OutboxRepository) DeleteEvents(ctx context.Context, ids []uint64) error {
+	return r.policy.Write(ctx, "DeleteEvents", func(ctx context.Context) error {
+		return r.repo.DeleteEvents(ctx, ids)
+	})
+}
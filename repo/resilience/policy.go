@@ -0,0 +1,271 @@
+package resilience
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	conf "github.com/minghsu0107/saga-account/config"
+	"github.com/minghsu0107/saga-account/pkg/breaker"
+	"github.com/minghsu0107/saga-account/pkg/health"
+	"github.com/minghsu0107/saga-account/repo"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+// mysql error numbers of a transaction rolled back by the server
+const (
+	mysqlLockWaitTimeout = 1205
+	mysqlDeadlock        = 1213
+)
+
+const (
+	defaultRetryBaseDelay = 20 * time.Millisecond
+	defaultRetryMaxDelay  = 200 * time.Millisecond
+	defaultRetryBudget    = 10
+	defaultBreakerOpen    = 10 * time.Second
+	// maxRetryTokens is the number of retries the budget holds when full, which bounds bursts of retries
+	maxRetryTokens = 10
+)
+
+// outcomes of a retry decision
+const (
+	retryOutcomeRetried         = "retried"
+	retryOutcomeBudgetExhausted = "budget_exhausted"
+)
+
+var dbRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "account_db_retries_total",
+	Help: "The number of retries of failed database operations by operation and outcome.",
+}, []string{"operation", "outcome"})
+
+// Policy runs repository operations with a deadline, retries transient errors with jittered backoff
+// and fails fast with a circuit breaker while the database is unavailable
+// retries are limited by a budget refilled by a ratio of the operations, so that a failing database does
+// not receive a multiple of its usual load. only connection failures and deadlines count towards the breaker
+type Policy struct {
+	timeout    time.Duration
+	timeouts   map[string]time.Duration
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+	budget     *retryBudget
+	breaker    *breaker.Breaker
+	logger     *log.Entry
+}
+
+// NewPolicy is the factory of Policy
+// the state of its circuit breaker is registered to registry
+func NewPolicy(config *conf.Config, registry *health.Registry) *Policy {
+	dbConfig := config.DBConfig
+	p := &Policy{
+		timeout:    time.Duration(dbConfig.QueryTimeoutMillis) * time.Millisecond,
+		timeouts:   make(map[string]time.Duration),
+		maxRetries: dbConfig.MaxRetries,
+		baseDelay:  defaultRetryBaseDelay,
+		maxDelay:   defaultRetryMaxDelay,
+		logger:     config.Logger.ContextLogger.WithField("type", "repo:Policy"),
+	}
+	for op, ms := range dbConfig.OperationTimeoutsMillis {
+		p.timeouts[op] = time.Duration(ms) * time.Millisecond
+	}
+	if dbConfig.RetryBaseDelayMillis > 0 {
+		p.baseDelay = time.Duration(dbConfig.RetryBaseDelayMillis) * time.Millisecond
+	}
+	if dbConfig.RetryMaxDelayMillis > 0 {
+		p.maxDelay = time.Duration(dbConfig.RetryMaxDelayMillis) * time.Millisecond
+	}
+	budgetPercent := dbConfig.RetryBudgetPercent
+	if budgetPercent <= 0 {
+		budgetPercent = defaultRetryBudget
+	}
+	p.budget = newRetryBudget(budgetPercent / 100)
+	if dbConfig.BreakerFailureThreshold > 0 {
+		openTimeout := defaultBreakerOpen
+		if dbConfig.BreakerOpenSeconds > 0 {
+			openTimeout = time.Duration(dbConfig.BreakerOpenSeconds) * time.Second
+		}
+		p.breaker = breaker.New(breaker.Options{
+			Name:             "db",
+			FailureThreshold: dbConfig.BreakerFailureThreshold,
+			OpenTimeout:      openTimeout,
+			OnStateChange: func(from, to breaker.State) {
+				p.logger.Warnf("database circuit breaker %s -> %s", from, to)
+			},
+		})
+	}
+	registry.Register(p)
+	return p
+}
+
+// Read runs an operation without side effects, retrying every transient error
+func (p *Policy) Read(ctx context.Context, op string, fn func(ctx context.Context) error) error {
+	return p.do(ctx, op, true, fn)
+}
+
+// Write runs an operation with side effects, retrying only errors after which it certainly took no effect,
+// since a connection lost during a commit leaves its outcome unknown
+func (p *Policy) Write(ctx context.Context, op string, fn func(ctx context.Context) error) error {
+	return p.do(ctx, op, false, fn)
+}
+
+// Health implements health.Checker interface
+// the service is not ready while the breaker rejects every operation
+func (p *Policy) Health(ctx context.Context) health.Status {
+	state := breaker.Closed
+	if p.breaker != nil {
+		state = p.breaker.State()
+	}
+	return health.Status{
+		Name:     "db",
+		State:    state.String(),
+		Ready:    state != breaker.Open,
+		Degraded: state == breaker.HalfOpen,
+	}
+}
+
+func (p *Policy) do(ctx context.Context, op string, idempotent bool, fn func(ctx context.Context) error) error {
+	if p.breaker != nil {
+		if err := p.breaker.Allow(); err != nil {
+			return repo.ErrDatabaseUnavailable
+		}
+	}
+	p.budget.deposit()
+	var err error
+	exhausted := false
+	for attempt := 0; ; attempt++ {
+		err = p.attempt(ctx, op, fn)
+		if err == nil || attempt >= p.maxRetries || ctx.Err() != nil || !retryable(err, idempotent) {
+			break
+		}
+		if !p.budget.withdraw() {
+			dbRetries.WithLabelValues(op, retryOutcomeBudgetExhausted).Inc()
+			exhausted = true
+			break
+		}
+		dbRetries.WithLabelValues(op, retryOutcomeRetried).Inc()
+		if !sleep(ctx, p.backoff(attempt)) {
+			break
+		}
+	}
+	if p.breaker != nil {
+		p.breaker.Record(!unavailable(ctx, err))
+	}
+	// a transient error left unretried by the budget is reported like an open breaker
+	if exhausted {
+		return repo.ErrDatabaseUnavailable
+	}
+	return err
+}
+
+// attempt runs fn once under the deadline of the operation
+func (p *Policy) attempt(ctx context.Context, op string, fn func(ctx context.Context) error) error {
+	timeout, ok := p.timeouts[op]
+	if !ok {
+		timeout = p.timeout
+	}
+	if timeout <= 0 {
+		return fn(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return fn(ctx)
+}
+
+// backoff returns a random delay up to the exponential backoff of an attempt (full jitter)
+func (p *Policy) backoff(attempt int) time.Duration {
+	d := p.baseDelay << attempt
+	if d <= 0 || d > p.maxDelay {
+		d = p.maxDelay
+	}
+	return time.Duration(rand.Int63n(int64(d)) + 1)
+}
+
+// retryBudget is a token bucket of retries; every operation deposits ratio tokens and every retry withdraws one
+type retryBudget struct {
+	mu     sync.Mutex
+	tokens float64
+	ratio  float64
+}
+
+func newRetryBudget(ratio float64) *retryBudget {
+	return &retryBudget{
+		tokens: maxRetryTokens,
+		ratio:  ratio,
+	}
+}
+
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens += b.ratio
+	if b.tokens > maxRetryTokens {
+		b.tokens = maxRetryTokens
+	}
+}
+
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// retryable reports whether an operation may be retried after err
+// a transaction rolled back by a deadlock or a lock wait timeout, and a connection that failed before
+// the operation was sent, are retried for every operation; a lost connection only for idempotent ones
+func retryable(err error, idempotent bool) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlDeadlock || mysqlErr.Number == mysqlLockWaitTimeout
+	}
+	if errors.Is(err, driver.ErrBadConn) || dialFailed(err) {
+		return true
+	}
+	return idempotent && connectionLost(err)
+}
+
+// unavailable reports whether err means that the database cannot serve operations,
+// which is a connection failure or a deadline of the operation rather than of the caller
+func unavailable(ctx context.Context, err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ctx.Err() == nil
+	}
+	return errors.Is(err, driver.ErrBadConn) || dialFailed(err) || connectionLost(err)
+}
+
+func dialFailed(err error) bool {
+	var opErr *net.OpError
+	return (errors.As(err, &opErr) && opErr.Op == "dial") || errors.Is(err, syscall.ECONNREFUSED)
+}
+
+func connectionLost(err error) bool {
+	return errors.Is(err, mysql.ErrInvalidConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+// sleep waits for d and returns false if ctx is done first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,229 @@
+package resilience
+
+import (
+	"context"
+	"database/sql/driver"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	conf "github.com/minghsu0107/saga-account/config"
+	domain_model "github.com/minghsu0107/saga-account/domain/model"
+	"github.com/minghsu0107/saga-account/repo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestResilience(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "resilience suite")
+}
+
+type ctxKey struct{}
+
+// faultyCustomerRepository is a fake repository returning injected faults to successive calls
+// a call blocks until its context is done if block is set
+type faultyCustomerRepository struct {
+	repo.CustomerRepository
+	mu     sync.Mutex
+	faults []error
+	block  bool
+	calls  int
+	ctxs   []context.Context
+}
+
+func (f *faultyCustomerRepository) call(ctx context.Context) error {
+	f.mu.Lock()
+	f.calls++
+	f.ctxs = append(f.ctxs, ctx)
+	var err error
+	if len(f.faults) > 0 {
+		err = f.faults[0]
+		f.faults = f.faults[1:]
+	}
+	block := f.block
+	f.mu.Unlock()
+	if block {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return err
+}
+
+func (f *faultyCustomerRepository) GetCustomerPersonalInfo(ctx context.Context, customerID uint64) (*repo.CustomerPersonalInfo, error) {
+	if err := f.call(ctx); err != nil {
+		return nil, err
+	}
+	return &repo.CustomerPersonalInfo{
+		FirstName: "ming",
+	}, nil
+}
+
+func (f *faultyCustomerRepository) UpdateCustomerPersonalInfo(ctx context.Context, customerID uint64, personalInfo *domain_model.CustomerPersonalInfo) (*repo.CustomerPersonalInfo, error) {
+	if err := f.call(ctx); err != nil {
+		return nil, err
+	}
+	return &repo.CustomerPersonalInfo{
+		FirstName: "ming",
+	}, nil
+}
+
+func (f *faultyCustomerRepository) numCalls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+var _ = Describe("repository resilience", func() {
+	var (
+		dbConfig *conf.DBConfig
+		fake     *faultyCustomerRepository
+		r        *CustomerRepository
+		policy   *Policy
+	)
+	deadlock := &mysql.MySQLError{Number: mysqlDeadlock, Message: "Deadlock found when trying to get lock"}
+	BeforeEach(func() {
+		dbConfig = &conf.DBConfig{
+			QueryTimeoutMillis: 50,
+			OperationTimeoutsMillis: map[string]int64{
+				"UpdateCustomerPersonalInfo": 100,
+			},
+			MaxRetries:              2,
+			RetryBaseDelayMillis:    1,
+			RetryMaxDelayMillis:     5,
+			BreakerFailureThreshold: 3,
+			BreakerOpenSeconds:      60,
+		}
+		fake = &faultyCustomerRepository{}
+	})
+	JustBeforeEach(func() {
+		policy = NewPolicy(&conf.Config{
+			DBConfig: dbConfig,
+			Logger: &conf.Logger{
+				Writer: ioutil.Discard,
+				ContextLogger: log.WithFields(log.Fields{
+					"app": "test",
+				}),
+			},
+		}, nil)
+		r = &CustomerRepository{
+			repo:   fake,
+			policy: policy,
+		}
+	})
+	It("should pass the request context with the deadline of each operation", func() {
+		ctx := context.WithValue(context.Background(), ctxKey{}, "request")
+		_, err := r.GetCustomerPersonalInfo(ctx, 1)
+		Expect(err).To(BeNil())
+		_, err = r.UpdateCustomerPersonalInfo(ctx, 1, &domain_model.CustomerPersonalInfo{})
+		Expect(err).To(BeNil())
+
+		Expect(fake.ctxs).To(HaveLen(2))
+		for i, timeout := range []time.Duration{50 * time.Millisecond, 100 * time.Millisecond} {
+			Expect(fake.ctxs[i].Value(ctxKey{})).To(Equal("request"))
+			deadline, ok := fake.ctxs[i].Deadline()
+			Expect(ok).To(BeTrue())
+			Expect(time.Until(deadline)).To(BeNumerically("~", timeout, 20*time.Millisecond))
+		}
+	})
+	It("should cancel operations exceeding their deadline", func() {
+		fake.block = true
+		start := time.Now()
+		_, err := r.GetCustomerPersonalInfo(context.Background(), 1)
+		Expect(err).To(Equal(context.DeadlineExceeded))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		Expect(fake.numCalls()).To(Equal(1))
+	})
+	It("should retry transient errors", func() {
+		fake.faults = []error{deadlock, driver.ErrBadConn}
+		before, err := r.UpdateCustomerPersonalInfo(context.Background(), 1, &domain_model.CustomerPersonalInfo{})
+		Expect(err).To(BeNil())
+		Expect(before.FirstName).To(Equal("ming"))
+		Expect(fake.numCalls()).To(Equal(3))
+	})
+	It("should retry a lost connection of reads only", func() {
+		fake.faults = []error{mysql.ErrInvalidConn}
+		_, err := r.GetCustomerPersonalInfo(context.Background(), 1)
+		Expect(err).To(BeNil())
+		Expect(fake.numCalls()).To(Equal(2))
+
+		fake.faults = []error{mysql.ErrInvalidConn}
+		_, err = r.UpdateCustomerPersonalInfo(context.Background(), 1, &domain_model.CustomerPersonalInfo{})
+		Expect(err).To(Equal(mysql.ErrInvalidConn))
+		Expect(fake.numCalls()).To(Equal(3))
+	})
+	It("should not retry other errors", func() {
+		fake.faults = []error{repo.ErrVersionConflict}
+		_, err := r.UpdateCustomerPersonalInfo(context.Background(), 1, &domain_model.CustomerPersonalInfo{})
+		Expect(err).To(Equal(repo.ErrVersionConflict))
+		Expect(fake.numCalls()).To(Equal(1))
+	})
+	It("should give up after the maximum retries", func() {
+		fake.faults = []error{deadlock, deadlock, deadlock, deadlock}
+		_, err := r.UpdateCustomerPersonalInfo(context.Background(), 1, &domain_model.CustomerPersonalInfo{})
+		Expect(err).To(Equal(deadlock))
+		Expect(fake.numCalls()).To(Equal(3))
+	})
+	It("should stop retrying when the retry budget is spent", func() {
+		for i := 0; i < 20; i++ {
+			fake.faults = append(fake.faults, deadlock)
+		}
+		var err error
+		for i := 0; i < 6; i++ {
+			_, err = r.UpdateCustomerPersonalInfo(context.Background(), 1, &domain_model.CustomerPersonalInfo{})
+		}
+		// the full budget holds 10 retries, and the deposits of 6 operations add less than one
+		Expect(fake.numCalls()).To(Equal(16))
+		Expect(err).To(Equal(repo.ErrDatabaseUnavailable))
+	})
+	It("should fail fast after consecutive connection failures", func() {
+		for i := 0; i < 9; i++ {
+			fake.faults = append(fake.faults, mysql.ErrInvalidConn)
+		}
+		for i := 0; i < 3; i++ {
+			_, err := r.GetCustomerPersonalInfo(context.Background(), 1)
+			Expect(err).To(Equal(mysql.ErrInvalidConn))
+		}
+		Expect(policy.Health(context.Background()).Ready).To(BeFalse())
+
+		calls := fake.numCalls()
+		_, err := r.GetCustomerPersonalInfo(context.Background(), 1)
+		Expect(err).To(Equal(repo.ErrDatabaseUnavailable))
+		Expect(fake.numCalls()).To(Equal(calls))
+	})
+	It("should not open the breaker on errors of healthy databases", func() {
+		for i := 0; i < 5; i++ {
+			fake.faults = []error{repo.ErrCustomerNotFound}
+			_, err := r.GetCustomerPersonalInfo(context.Background(), 1)
+			Expect(err).To(Equal(repo.ErrCustomerNotFound))
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		fake.block = true
+		for i := 0; i < 5; i++ {
+			_, err := r.GetCustomerPersonalInfo(ctx, 1)
+			Expect(err).To(Equal(context.Canceled))
+		}
+		Expect(policy.Health(context.Background()).State).To(Equal("closed"))
+	})
+	Context("without a breaker and deadlines", func() {
+		BeforeEach(func() {
+			dbConfig.QueryTimeoutMillis = 0
+			dbConfig.OperationTimeoutsMillis = nil
+			dbConfig.BreakerFailureThreshold = 0
+		})
+		It("should run operations with the request context", func() {
+			for i := 0; i < 5; i++ {
+				fake.faults = []error{mysql.ErrInvalidConn, mysql.ErrInvalidConn, mysql.ErrInvalidConn}
+				_, err := r.GetCustomerPersonalInfo(context.Background(), 1)
+				Expect(err).To(Equal(mysql.ErrInvalidConn))
+			}
+			_, ok := fake.ctxs[0].Deadline()
+			Expect(ok).To(BeFalse())
+			Expect(policy.Health(context.Background()).Ready).To(BeTrue())
+		})
+	})
+})
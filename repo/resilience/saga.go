@@ -0,0 +1,42 @@
+package resilience
+
+import (
+	"context"
+
+	domain_model "github.com/minghsu0107/saga-account/domain/model"
+	"github.com/minghsu0107/saga-account/repo"
+	"gorm.io/gorm"
+)
+
+// SagaRepository runs the operations of a saga repository with the resilience policy
+type SagaRepository struct {
+	repo   repo.SagaRepository
+	policy *Policy
+}
+
+// NewSagaRepository is the factory of the saga repository guarded by the resilience policy
+func NewSagaRepository(db *gorm.DB, policy *Policy) repo.SagaRepository {
+	return &SagaRepository{
+		repo:   repo.NewSagaRepository(db),
+		policy: policy,
+	}
+}
+
+// GetSagaReply returns the reply of a processed saga command
+func (r *SagaRepository) GetSagaReply(ctx context.Context, sagaID string, commandType domain_model.SagaCommandType) (bool, *domain_model.SagaReply, error) {
+	var exist bool
+	var reply *domain_model.SagaReply
+	err := r.policy.Read(ctx, "GetSagaReply", func(ctx context.Context) error {
+		var err error
+		exist, reply, err = r.repo.GetSagaReply(ctx, sagaID, commandType)
+		return err
+	})
+	return exist, reply, err
+}
+
+// SaveSagaReply saves the reply of a processed saga command
+func (r *SagaRepository) SaveSagaReply(ctx context.Context, commandType domain_model.SagaCommandType, reply *domain_model.SagaReply) error {
+	return r.policy.Write(ctx, "SaveSagaReply", func(ctx context.Context) error {
+		return r.repo.SaveSagaReply(ctx, commandType, reply)
+	})
+}
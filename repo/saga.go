@@ -0,0 +1,68 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+	domain_model "github.com/minghsu0107/saga-account/domain/model"
+	"github.com/minghsu0107/saga-account/infra/db/model"
+	"gorm.io/gorm"
+)
+
+// SagaRepository is the processed saga command repository interface
+type SagaRepository interface {
+	GetSagaReply(ctx context.Context, sagaID string, commandType domain_model.SagaCommandType) (bool, *domain_model.SagaReply, error)
+	SaveSagaReply(ctx context.Context, commandType domain_model.SagaCommandType, reply *domain_model.SagaReply) error
+}
+
+// SagaRepositoryImpl implements SagaRepository interface
+type SagaRepositoryImpl struct {
+	db *gorm.DB
+}
+
+// NewSagaRepository is the factory of SagaRepository
+func NewSagaRepository(db *gorm.DB) SagaRepository {
+	return &SagaRepositoryImpl{
+		db: db,
+	}
+}
+
+// GetSagaReply queries the reply of a processed saga command
+func (repo *SagaRepositoryImpl) GetSagaReply(ctx context.Context, sagaID string, commandType domain_model.SagaCommandType) (bool, *domain_model.SagaReply, error) {
+	var processed model.ProcessedSagaCommand
+	if err := repo.db.WithContext(ctx).Select("reply").
+		Where("saga_id = ? AND command_type = ?", sagaID, string(commandType)).First(&processed).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil, nil
+		}
+		return false, nil, err
+	}
+	var reply domain_model.SagaReply
+	if err := json.Unmarshal([]byte(processed.Reply), &reply); err != nil {
+		return false, nil, err
+	}
+	return true, &reply, nil
+}
+
+// SaveSagaReply marks a saga command as processed with its reply
+// it returns ErrDuplicateEntry if the command has already been processed
+func (repo *SagaRepositoryImpl) SaveSagaReply(ctx context.Context, commandType domain_model.SagaCommandType, reply *domain_model.SagaReply) error {
+	payload, err := json.Marshal(reply)
+	if err != nil {
+		return err
+	}
+	if err := repo.db.WithContext(ctx).Create(&model.ProcessedSagaCommand{
+		SagaID:      reply.SagaID,
+		CommandType: string(commandType),
+		Reply:       string(payload),
+	}).Error; err != nil {
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+			return ErrDuplicateEntry
+		}
+		return err
+	}
+	return nil
+}
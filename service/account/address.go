@@ -0,0 +1,152 @@
+package account
+
+import (
+	"context"
+
+	conf "github.com/minghsu0107/saga-account/config"
+	"github.com/minghsu0107/saga-account/domain/model"
+	"github.com/minghsu0107/saga-account/pkg"
+	"github.com/minghsu0107/saga-account/pkg/contact"
+	"github.com/minghsu0107/saga-account/repo"
+	"github.com/minghsu0107/saga-account/repo/proxy"
+	log "github.com/sirupsen/logrus"
+)
+
+// AddressServiceImpl implements AddressService interface
+type AddressServiceImpl struct {
+	addressRepo proxy.AddressRepoCache
+	sf          pkg.IDGenerator
+	logger      *log.Entry
+}
+
+// NewAddressService is the factory of AddressService
+func NewAddressService(config *conf.Config, addressRepo proxy.AddressRepoCache, sf pkg.IDGenerator) AddressService {
+	return &AddressServiceImpl{
+		addressRepo: addressRepo,
+		sf:          sf,
+		logger: config.Logger.ContextLogger.WithFields(log.Fields{
+			"type": "service:AddressService",
+		}),
+	}
+}
+
+// ListAddresses lists all addresses of a customer
+func (svc *AddressServiceImpl) ListAddresses(ctx context.Context, customerID uint64) ([]model.Address, error) {
+	addresses, err := svc.addressRepo.ListAddresses(ctx, customerID)
+	if err != nil {
+		svc.logger.WithContext(ctx).Error(err.Error())
+		return nil, err
+	}
+	result := make([]model.Address, len(addresses))
+	for i := range addresses {
+		result[i] = *mapAddress(customerID, &addresses[i])
+	}
+	return result, nil
+}
+
+// GetAddress gets an address of a customer
+func (svc *AddressServiceImpl) GetAddress(ctx context.Context, customerID, addressID uint64) (*model.Address, error) {
+	addresses, err := svc.ListAddresses(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range addresses {
+		if addresses[i].ID == addressID {
+			return &addresses[i], nil
+		}
+	}
+	return nil, repo.ErrAddressNotFound
+}
+
+// GetDefaultAddress gets the default address of a customer
+// it returns ErrAddressNotFound if the address book is empty
+func (svc *AddressServiceImpl) GetDefaultAddress(ctx context.Context, customerID uint64) (*model.Address, error) {
+	return getDefaultAddress(ctx, svc.addressRepo, customerID)
+}
+
+// CreateAddress creates a new address and assigns its ID
+func (svc *AddressServiceImpl) CreateAddress(ctx context.Context, address *model.Address) error {
+	if err := normalizeAddress(address); err != nil {
+		return err
+	}
+	sonyflakeID, err := svc.sf.NextID()
+	if err != nil {
+		return err
+	}
+	address.ID = sonyflakeID
+	if err := svc.addressRepo.CreateAddress(ctx, address); err != nil {
+		svc.logger.WithContext(ctx).Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+// UpdateAddress updates an address of a customer
+func (svc *AddressServiceImpl) UpdateAddress(ctx context.Context, address *model.Address) error {
+	if err := normalizeAddress(address); err != nil {
+		return err
+	}
+	return svc.logError(ctx, svc.addressRepo.UpdateAddress(ctx, address))
+}
+
+// DeleteAddress deletes an address of a customer
+func (svc *AddressServiceImpl) DeleteAddress(ctx context.Context, customerID, addressID uint64) error {
+	return svc.logError(ctx, svc.addressRepo.DeleteAddress(ctx, customerID, addressID))
+}
+
+// SetDefaultAddress sets the default address of a customer
+func (svc *AddressServiceImpl) SetDefaultAddress(ctx context.Context, customerID, addressID uint64) error {
+	return svc.logError(ctx, svc.addressRepo.SetDefaultAddress(ctx, customerID, addressID))
+}
+
+func (svc *AddressServiceImpl) logError(ctx context.Context, err error) error {
+	if err != nil && err != repo.ErrAddressNotFound {
+		svc.logger.WithContext(ctx).Error(err.Error())
+	}
+	return err
+}
+
+// normalizeAddress validates an address against the rules of its country and canonicalizes it in place
+// the phone number is interpreted in the address country unless it has an international prefix
+func normalizeAddress(address *model.Address) error {
+	if err := contact.NormalizeAddress(&address.PostalAddress); err != nil {
+		return err
+	}
+	phoneNumber, err := contact.NormalizePhoneNumber(address.PhoneNumber, address.Country)
+	if err != nil {
+		return err
+	}
+	address.PhoneNumber = phoneNumber
+	return nil
+}
+
+func getDefaultAddress(ctx context.Context, addressRepo proxy.AddressRepoCache, customerID uint64) (*model.Address, error) {
+	addresses, err := addressRepo.ListAddresses(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range addresses {
+		if addresses[i].IsDefault {
+			return mapAddress(customerID, &addresses[i]), nil
+		}
+	}
+	return nil, repo.ErrAddressNotFound
+}
+
+func mapAddress(customerID uint64, address *repo.CustomerAddress) *model.Address {
+	return &model.Address{
+		ID:          address.ID,
+		CustomerID:  customerID,
+		Recipient:   address.Recipient,
+		PhoneNumber: address.PhoneNumber,
+		PostalAddress: model.PostalAddress{
+			Line1:      address.Line1,
+			Line2:      address.Line2,
+			City:       address.City,
+			Region:     address.Region,
+			PostalCode: address.PostalCode,
+			Country:    address.Country,
+		},
+		Default: address.IsDefault,
+	}
+}
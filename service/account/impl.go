@@ -2,24 +2,33 @@ package account
 
 import (
 	"context"
+	"strconv"
 
 	conf "github.com/minghsu0107/saga-account/config"
 	"github.com/minghsu0107/saga-account/domain/model"
+	"github.com/minghsu0107/saga-account/pkg/contact"
 	"github.com/minghsu0107/saga-account/repo"
 	"github.com/minghsu0107/saga-account/repo/proxy"
+	"github.com/minghsu0107/saga-account/service/audit"
 	log "github.com/sirupsen/logrus"
 )
 
 // CustomerServiceImpl implements CustomerService interface
 type CustomerServiceImpl struct {
 	customerRepo proxy.CustomerRepoCache
+	addressRepo  proxy.AddressRepoCache
+	auditSvc     audit.AuditService
+	phoneRegion  string
 	logger       *log.Entry
 }
 
 // NewCustomerService is the factory of CustomerService
-func NewCustomerService(config *conf.Config, customerRepo proxy.CustomerRepoCache) CustomerService {
+func NewCustomerService(config *conf.Config, customerRepo proxy.CustomerRepoCache, addressRepo proxy.AddressRepoCache, auditSvc audit.AuditService) CustomerService {
 	return &CustomerServiceImpl{
 		customerRepo: customerRepo,
+		addressRepo:  addressRepo,
+		auditSvc:     auditSvc,
+		phoneRegion:  config.PhoneRegion,
 		logger: config.Logger.ContextLogger.WithFields(log.Fields{
 			"type": "service:CustomerService",
 		}),
@@ -31,7 +40,7 @@ func (svc *CustomerServiceImpl) GetCustomerPersonalInfo(ctx context.Context, cus
 	info, err := svc.customerRepo.GetCustomerPersonalInfo(ctx, customerID)
 	if err != nil {
 		if err != repo.ErrCustomerNotFound {
-			svc.logger.Error(err.Error())
+			svc.logger.WithContext(ctx).Error(err.Error())
 		}
 		return nil, err
 	}
@@ -39,30 +48,179 @@ func (svc *CustomerServiceImpl) GetCustomerPersonalInfo(ctx context.Context, cus
 		FirstName: info.FirstName,
 		LastName:  info.LastName,
 		Email:     info.Email,
+		Version:   info.Version,
 	}, nil
 }
 
 // GetCustomerShippingInfo gets customer shipping info
+// the default address in the address book takes precedence over the address stored with the customer,
+// which can no longer be updated once the customer has an address book
 func (svc *CustomerServiceImpl) GetCustomerShippingInfo(ctx context.Context, customerID uint64) (*model.CustomerShippingInfo, error) {
 	info, err := svc.customerRepo.GetCustomerShippingInfo(ctx, customerID)
 	if err != nil {
 		if err != repo.ErrCustomerNotFound {
-			svc.logger.Error(err.Error())
+			svc.logger.WithContext(ctx).Error(err.Error())
 		}
 		return nil, err
 	}
-	return &model.CustomerShippingInfo{
+	shippingInfo := &model.CustomerShippingInfo{
 		Address:     info.Address,
 		PhoneNumber: info.PhoneNumber,
-	}, nil
+		Version:     info.Version,
+	}
+
+	address, err := getDefaultAddress(ctx, svc.addressRepo, customerID)
+	switch err {
+	case nil:
+		shippingInfo.Address = address.Format()
+		if address.PhoneNumber != "" {
+			shippingInfo.PhoneNumber = address.PhoneNumber
+		}
+	case repo.ErrAddressNotFound:
+	default:
+		svc.logger.WithContext(ctx).Error(err.Error())
+		return nil, err
+	}
+	return shippingInfo, nil
 }
 
 // UpdateCustomerPersonalInfo updates customer's personal info
 func (svc *CustomerServiceImpl) UpdateCustomerPersonalInfo(ctx context.Context, customerID uint64, personalInfo *model.CustomerPersonalInfo) error {
-	return svc.customerRepo.UpdateCustomerPersonalInfo(ctx, customerID, personalInfo)
+	before, err := svc.customerRepo.UpdateCustomerPersonalInfo(ctx, customerID, personalInfo)
+	if err != nil {
+		svc.logUpdateError(ctx, err)
+		return err
+	}
+	svc.recordPersonalInfoChange(ctx, customerID, before, &repo.CustomerPersonalInfo{
+		FirstName: personalInfo.FirstName,
+		LastName:  personalInfo.LastName,
+		Email:     personalInfo.Email,
+	})
+	return nil
 }
 
 // UpdateCustomerShippingInfo updates customer's shipping info
+// the postal address is validated and stored formatted, and the phone number defaults to the region of its country
 func (svc *CustomerServiceImpl) UpdateCustomerShippingInfo(ctx context.Context, customerID uint64, shippingInfo *model.CustomerShippingInfo) error {
-	return svc.customerRepo.UpdateCustomerShippingInfo(ctx, customerID, shippingInfo)
+	if shippingInfo.PostalAddress == nil {
+		return contact.ErrAddressLineRequired
+	}
+	if err := contact.NormalizeAddress(shippingInfo.PostalAddress); err != nil {
+		return err
+	}
+	shippingInfo.Address = shippingInfo.PostalAddress.Format()
+	phoneNumber, err := contact.NormalizePhoneNumber(shippingInfo.PhoneNumber, shippingInfo.PostalAddress.Country)
+	if err != nil {
+		return err
+	}
+	shippingInfo.PhoneNumber = phoneNumber
+	before, err := svc.customerRepo.UpdateCustomerShippingInfo(ctx, customerID, shippingInfo)
+	if err != nil {
+		svc.logUpdateError(ctx, err)
+		return err
+	}
+	svc.recordShippingInfoChange(ctx, customerID, before, &repo.CustomerShippingInfo{
+		Address:     shippingInfo.Address,
+		PhoneNumber: shippingInfo.PhoneNumber,
+	})
+	return nil
+}
+
+// PatchCustomerPersonalInfo partially updates customer's personal info
+func (svc *CustomerServiceImpl) PatchCustomerPersonalInfo(ctx context.Context, customerID uint64, patch *model.CustomerPersonalInfoPatch) error {
+	before, err := svc.customerRepo.PatchCustomerPersonalInfo(ctx, customerID, patch)
+	if err != nil {
+		svc.logUpdateError(ctx, err)
+		return err
+	}
+	after := *before
+	patchField(&after.FirstName, patch.FirstName)
+	patchField(&after.LastName, patch.LastName)
+	patchField(&after.Email, patch.Email)
+	svc.recordPersonalInfoChange(ctx, customerID, before, &after)
+	return nil
+}
+
+// PatchCustomerShippingInfo partially updates customer's shipping info
+// a postal address is validated and replaces the formatted address as a whole
+func (svc *CustomerServiceImpl) PatchCustomerShippingInfo(ctx context.Context, customerID uint64, patch *model.CustomerShippingInfoPatch) error {
+	region := svc.phoneRegion
+	if patch.PostalAddress != nil {
+		if err := contact.NormalizeAddress(patch.PostalAddress); err != nil {
+			return err
+		}
+		address := patch.PostalAddress.Format()
+		patch.Address = &address
+		region = patch.PostalAddress.Country
+	}
+	if patch.PhoneNumber != nil {
+		phoneNumber, err := contact.NormalizePhoneNumber(*patch.PhoneNumber, region)
+		if err != nil {
+			return err
+		}
+		patch.PhoneNumber = &phoneNumber
+	}
+	before, err := svc.customerRepo.PatchCustomerShippingInfo(ctx, customerID, patch)
+	if err != nil {
+		svc.logUpdateError(ctx, err)
+		return err
+	}
+	after := *before
+	patchField(&after.Address, patch.Address)
+	patchField(&after.PhoneNumber, patch.PhoneNumber)
+	svc.recordShippingInfoChange(ctx, customerID, before, &after)
+	return nil
+}
+
+// UpdateCustomerStatus activates or deactivates a customer
+func (svc *CustomerServiceImpl) UpdateCustomerStatus(ctx context.Context, customerID uint64, active bool) error {
+	previous, err := svc.customerRepo.UpdateCustomerStatus(ctx, customerID, active)
+	if err != nil {
+		svc.logUpdateError(ctx, err)
+		return err
+	}
+	if previous == active {
+		return nil
+	}
+	svc.auditSvc.Record(ctx, &model.AuditEvent{
+		CustomerID: customerID,
+		Type:       model.AuditStatusChange,
+		Changes:    audit.Changes{}.Value("active", strconv.FormatBool(previous), strconv.FormatBool(active)),
+	})
+	return nil
+}
+
+func (svc *CustomerServiceImpl) recordPersonalInfoChange(ctx context.Context, customerID uint64, before, after *repo.CustomerPersonalInfo) {
+	svc.auditSvc.Record(ctx, &model.AuditEvent{
+		CustomerID: customerID,
+		Type:       model.AuditPersonalInfoChange,
+		Changes: audit.Changes{}.
+			Text("first_name", before.FirstName, after.FirstName).
+			Text("last_name", before.LastName, after.LastName).
+			Email("email", before.Email, after.Email),
+	})
+}
+
+func (svc *CustomerServiceImpl) recordShippingInfoChange(ctx context.Context, customerID uint64, before, after *repo.CustomerShippingInfo) {
+	svc.auditSvc.Record(ctx, &model.AuditEvent{
+		CustomerID: customerID,
+		Type:       model.AuditShippingInfoChange,
+		Changes: audit.Changes{}.
+			Text("address", before.Address, after.Address).
+			PhoneNumber("phone_number", before.PhoneNumber, after.PhoneNumber),
+	})
+}
+
+func patchField(field *string, val *string) {
+	if val != nil {
+		*field = *val
+	}
+}
+
+func (svc *CustomerServiceImpl) logUpdateError(ctx context.Context, err error) {
+	switch err {
+	case repo.ErrCustomerNotFound, repo.ErrVersionConflict, repo.ErrDuplicateEntry, repo.ErrShippingInfoInAddressBook:
+	default:
+		svc.logger.WithContext(ctx).Error(err.Error())
+	}
 }
@@ -12,4 +12,18 @@ type CustomerService interface {
 	GetCustomerShippingInfo(ctx context.Context, customerID uint64) (*model.CustomerShippingInfo, error)
 	UpdateCustomerPersonalInfo(ctx context.Context, customerID uint64, personalInfo *model.CustomerPersonalInfo) error
 	UpdateCustomerShippingInfo(ctx context.Context, customerID uint64, shippingInfo *model.CustomerShippingInfo) error
+	PatchCustomerPersonalInfo(ctx context.Context, customerID uint64, patch *model.CustomerPersonalInfoPatch) error
+	PatchCustomerShippingInfo(ctx context.Context, customerID uint64, patch *model.CustomerShippingInfoPatch) error
+	UpdateCustomerStatus(ctx context.Context, customerID uint64, active bool) error
+}
+
+// AddressService defines customer address book interface
+type AddressService interface {
+	ListAddresses(ctx context.Context, customerID uint64) ([]model.Address, error)
+	GetAddress(ctx context.Context, customerID, addressID uint64) (*model.Address, error)
+	GetDefaultAddress(ctx context.Context, customerID uint64) (*model.Address, error)
+	CreateAddress(ctx context.Context, address *model.Address) error
+	UpdateAddress(ctx context.Context, address *model.Address) error
+	DeleteAddress(ctx context.Context, customerID, addressID uint64) error
+	SetDefaultAddress(ctx context.Context, customerID, addressID uint64) error
 }
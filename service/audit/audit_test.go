@@ -0,0 +1,88 @@
+package audit
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	conf "github.com/minghsu0107/saga-account/config"
+	"github.com/minghsu0107/saga-account/domain/model"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestAudit(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "audit suite")
+}
+
+type TestIDGenerator struct{}
+
+func (g TestIDGenerator) NextID() (uint64, error) {
+	return 1, nil
+}
+
+type TestAuditRepository struct {
+	events []*model.AuditEvent
+}
+
+func (r *TestAuditRepository) AppendAuditEvent(ctx context.Context, event *model.AuditEvent) error {
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (r *TestAuditRepository) ListAuditEvents(ctx context.Context, query *model.AuditQuery) ([]model.AuditEvent, error) {
+	return nil, nil
+}
+
+var _ = Describe("audit service", func() {
+	var auditRepo *TestAuditRepository
+	var auditSvc AuditService
+	BeforeEach(func() {
+		auditRepo = &TestAuditRepository{}
+		auditSvc = NewAuditService(&conf.Config{
+			Logger: &conf.Logger{
+				Writer: ioutil.Discard,
+				ContextLogger: log.WithFields(log.Fields{
+					"app": "test",
+				}),
+			},
+		}, auditRepo, TestIDGenerator{})
+	})
+	It("should record the request metadata", func() {
+		ctx := WithRequestMeta(context.Background(), &model.RequestMeta{
+			IP:        "127.0.0.1",
+			UserAgent: "curl/7.79.1",
+		})
+		auditSvc.Record(ctx, &model.AuditEvent{
+			CustomerID: 2,
+			Type:       model.AuditLoginFailure,
+		})
+		Expect(auditRepo.events).To(HaveLen(1))
+		event := auditRepo.events[0]
+		Expect(event.IP).To(Equal("127.0.0.1"))
+		Expect(event.UserAgent).To(Equal("curl/7.79.1"))
+		Expect(event.Actor).To(Equal("customer:2"))
+	})
+	It("should truncate request metadata exceeding its columns", func() {
+		// 1 KB of two-byte runes
+		userAgent := strings.Repeat("é", 512)
+		ctx := WithRequestMeta(context.Background(), &model.RequestMeta{
+			IP:        strings.Repeat("1", 100),
+			UserAgent: userAgent,
+		})
+		auditSvc.Record(ctx, &model.AuditEvent{
+			CustomerID: 2,
+			Type:       model.AuditLoginFailure,
+		})
+		Expect(auditRepo.events).To(HaveLen(1))
+		event := auditRepo.events[0]
+		Expect(event.IP).To(HaveLen(maxIPLength))
+		Expect(utf8.ValidString(event.UserAgent)).To(BeTrue())
+		Expect(utf8.RuneCountInString(event.UserAgent)).To(Equal(maxUserAgentLength))
+		Expect(strings.HasPrefix(userAgent, event.UserAgent)).To(BeTrue())
+	})
+})
@@ -0,0 +1,40 @@
+package audit
+
+import (
+	"github.com/minghsu0107/saga-account/domain/model"
+	"github.com/minghsu0107/saga-account/pkg/redact"
+)
+
+// Changes collects before/after values of changed attributes
+// PII values are redacted as they are added; unchanged attributes are skipped
+type Changes map[string]model.AuditChange
+
+// Email adds a changed email
+func (c Changes) Email(name, before, after string) Changes {
+	return c.add(name, before, after, redact.Email)
+}
+
+// PhoneNumber adds a changed phone number
+func (c Changes) PhoneNumber(name, before, after string) Changes {
+	return c.add(name, before, after, redact.PhoneNumber)
+}
+
+// Text adds a changed free text attribute such as a name or an address
+func (c Changes) Text(name, before, after string) Changes {
+	return c.add(name, before, after, redact.Text)
+}
+
+// Value adds a changed attribute that is not PII, such as the customer status
+func (c Changes) Value(name, before, after string) Changes {
+	return c.add(name, before, after, func(s string) string { return s })
+}
+
+func (c Changes) add(name, before, after string, redactFunc func(string) string) Changes {
+	if before != after {
+		c[name] = model.AuditChange{
+			Before: redactFunc(before),
+			After:  redactFunc(after),
+		}
+	}
+	return c
+}
@@ -0,0 +1,22 @@
+package audit
+
+import (
+	"context"
+
+	"github.com/minghsu0107/saga-account/config"
+	"github.com/minghsu0107/saga-account/domain/model"
+)
+
+// WithRequestMeta returns a copy of ctx carrying the request metadata
+func WithRequestMeta(ctx context.Context, meta *model.RequestMeta) context.Context {
+	return context.WithValue(ctx, config.RequestMetaKey, meta)
+}
+
+// RequestMetaFromContext returns the request metadata carried by ctx
+// it returns empty metadata if there is none, e.g. for internal calls
+func RequestMetaFromContext(ctx context.Context) *model.RequestMeta {
+	if meta, ok := ctx.Value(config.RequestMetaKey).(*model.RequestMeta); ok {
+		return meta
+	}
+	return &model.RequestMeta{}
+}
@@ -0,0 +1,114 @@
+package audit
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	conf "github.com/minghsu0107/saga-account/config"
+	"github.com/minghsu0107/saga-account/domain/model"
+	"github.com/minghsu0107/saga-account/pkg"
+	"github.com/minghsu0107/saga-account/pkg/logging"
+	"github.com/minghsu0107/saga-account/repo"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultPageSize is the number of events returned if the query has no limit
+	DefaultPageSize = 20
+	// MaxPageSize is the maximum number of events returned at a time
+	MaxPageSize = 100
+
+	// the lengths of the request metadata columns, in characters
+	maxActorLength     = 50
+	maxIPLength        = 45
+	maxUserAgentLength = 255
+	maxRequestIDLength = 128
+)
+
+// AuditServiceImpl implements AuditService interface
+type AuditServiceImpl struct {
+	auditRepo repo.AuditRepository
+	sf        pkg.IDGenerator
+	logger    *log.Entry
+}
+
+// NewAuditService is the factory of AuditService
+func NewAuditService(config *conf.Config, auditRepo repo.AuditRepository, sf pkg.IDGenerator) AuditService {
+	return &AuditServiceImpl{
+		auditRepo: auditRepo,
+		sf:        sf,
+		logger: config.Logger.ContextLogger.WithFields(log.Fields{
+			"type": "service:AuditService",
+		}),
+	}
+}
+
+// Record appends an event to the audit log
+// the request metadata and request id are taken from ctx and truncated to fit their columns, so that a client
+// cannot keep its events from being recorded; the actor defaults to the customer of the event.
+// failures are logged but never propagated, so auditing cannot break the audited operation
+func (svc *AuditServiceImpl) Record(ctx context.Context, event *model.AuditEvent) {
+	meta := RequestMetaFromContext(ctx)
+	event.IP = pkg.Truncate(meta.IP, maxIPLength)
+	event.UserAgent = pkg.Truncate(meta.UserAgent, maxUserAgentLength)
+	event.Actor = pkg.Truncate(meta.Actor, maxActorLength)
+	event.RequestID = pkg.Truncate(logging.RequestID(ctx), maxRequestIDLength)
+	if event.Actor == "" {
+		event.Actor = customerActor(event.CustomerID)
+	}
+	if event.Changes == nil {
+		event.Changes = make(map[string]model.AuditChange)
+	}
+	event.CreatedAt = time.Now()
+
+	sonyflakeID, err := svc.sf.NextID()
+	if err != nil {
+		svc.logger.WithContext(ctx).Error(err.Error())
+		return
+	}
+	event.ID = sonyflakeID
+	if err := svc.auditRepo.AppendAuditEvent(ctx, event); err != nil {
+		svc.logger.WithContext(ctx).WithFields(log.Fields{
+			"customer_id": event.CustomerID,
+			"event":       event.Type,
+		}).Error(err.Error())
+	}
+}
+
+// ListActivity lists audit events matching the query, latest first
+func (svc *AuditServiceImpl) ListActivity(ctx context.Context, query *model.AuditQuery) (*model.AuditPage, error) {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+	// fetch one more event to find out whether there is a next page
+	events, err := svc.auditRepo.ListAuditEvents(ctx, &model.AuditQuery{
+		CustomerID: query.CustomerID,
+		Types:      query.Types,
+		Before:     query.Before,
+		Limit:      limit + 1,
+	})
+	if err != nil {
+		svc.logger.WithContext(ctx).Error(err.Error())
+		return nil, err
+	}
+	page := &model.AuditPage{
+		Events: events,
+	}
+	if len(events) > limit {
+		page.Events = events[:limit]
+		page.NextCursor = page.Events[limit-1].ID
+	}
+	return page, nil
+}
+
+func customerActor(customerID uint64) string {
+	if customerID == 0 {
+		return "anonymous"
+	}
+	return pkg.Join("customer:", strconv.FormatUint(customerID, 10))
+}
@@ -0,0 +1,13 @@
+package audit
+
+import (
+	"context"
+
+	"github.com/minghsu0107/saga-account/domain/model"
+)
+
+// AuditService defines audit log interface
+type AuditService interface {
+	Record(ctx context.Context, event *model.AuditEvent)
+	ListActivity(ctx context.Context, query *model.AuditQuery) (*model.AuditPage, error)
+}
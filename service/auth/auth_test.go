@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/minghsu0107/saga-account/pkg"
+	"github.com/minghsu0107/saga-account/pkg/contact"
 
 	"github.com/minghsu0107/saga-account/repo"
 
@@ -16,13 +17,19 @@ import (
 	mock_repo "github.com/minghsu0107/saga-account/mock/repo"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
 	mockCtrl        *gomock.Controller
 	mockJWTAuthRepo *mock_repo.MockJWTAuthRepository
 	authSvc         JWTAuthService
+	testAuditSvc    *TestAuditService
 	testCustomerID  uint64 = 347951634795465221
 	testJWTSecret          = "testsecretkey"
 )
@@ -35,6 +42,25 @@ func (g TestIDGenerator) NextID() (uint64, error) {
 	return g.testCustomerID, nil
 }
 
+type TestAuditService struct {
+	events []*model.AuditEvent
+}
+
+func (s *TestAuditService) Record(ctx context.Context, event *model.AuditEvent) {
+	s.events = append(s.events, event)
+}
+
+func (s *TestAuditService) ListActivity(ctx context.Context, query *model.AuditQuery) (*model.AuditPage, error) {
+	return &model.AuditPage{}, nil
+}
+
+func (s *TestAuditService) lastEvent() *model.AuditEvent {
+	if len(s.events) == 0 {
+		return nil
+	}
+	return s.events[len(s.events)-1]
+}
+
 func TestAuth(t *testing.T) {
 	mockCtrl = gomock.NewController(t)
 	RegisterFailHandler(Fail)
@@ -43,6 +69,7 @@ func TestAuth(t *testing.T) {
 
 func InitMocks() {
 	mockJWTAuthRepo = mock_repo.NewMockJWTAuthRepository(mockCtrl)
+	testAuditSvc = &TestAuditService{}
 }
 
 func NewTestJWTAuthService() JWTAuthService {
@@ -62,7 +89,7 @@ func NewTestJWTAuthService() JWTAuthService {
 	testSf := TestIDGenerator{
 		testCustomerID: testCustomerID,
 	}
-	return NewJWTAuthService(config, mockJWTAuthRepo, testSf)
+	return NewJWTAuthService(config, mockJWTAuthRepo, testAuditSvc, testSf)
 }
 
 var _ = BeforeSuite(func() {
@@ -80,6 +107,7 @@ var _ = Describe("authentication", func() {
 	BeforeEach(func() {
 		customerID = testCustomerID
 		authPayload = model.AuthPayload{}
+		testAuditSvc.events = nil
 	})
 	var _ = When("token is valid", func() {
 		BeforeEach(func() {
@@ -113,8 +141,10 @@ var _ = Describe("authentication", func() {
 			authPayload.AccessToken = "invalidtoken"
 		})
 		It("should fail when passing invalid access token", func() {
+			before := testutil.ToFloat64(authResults.WithLabelValues(resultInvalid))
 			_, err := authSvc.Auth(context.Background(), &authPayload)
 			Expect(err).To(Equal(ErrInvalidToken))
+			Expect(testutil.ToFloat64(authResults.WithLabelValues(resultInvalid)) - before).To(Equal(float64(1)))
 		})
 	})
 	var _ = When("use refresh token as access token", func() {
@@ -140,7 +170,7 @@ var _ = Describe("authentication", func() {
 			})
 			It("should generate a new token pair", func() {
 				mockJWTAuthRepo.EXPECT().
-					CheckCustomer(context.Background(), customerID).Return(true, true, nil)
+					CheckCustomer(gomock.Any(), customerID).Return(true, true, nil)
 				newAccessToken, newRefreshToken, err := authSvc.RefreshToken(context.Background(), refreshToken)
 				Expect(err).To(BeNil())
 				Expect(accessToken).NotTo(Equal(newAccessToken))
@@ -154,9 +184,13 @@ var _ = Describe("authentication", func() {
 				}))
 
 				mockJWTAuthRepo.EXPECT().
-					CheckCustomer(context.Background(), customerID).Return(true, true, nil)
+					CheckCustomer(gomock.Any(), customerID).Return(true, true, nil)
 				_, _, err = authSvc.RefreshToken(context.Background(), newRefreshToken)
 				Expect(err).To(BeNil())
+
+				Expect(testAuditSvc.events).To(HaveLen(2))
+				Expect(testAuditSvc.lastEvent().Type).To(Equal(model.AuditTokenRefresh))
+				Expect(testAuditSvc.lastEvent().CustomerID).To(Equal(customerID))
 			})
 		})
 		var _ = When("refresh token expires", func() {
@@ -195,13 +229,14 @@ var _ = Describe("authentication", func() {
 			})
 			It("should fail when customer does not exist", func() {
 				mockJWTAuthRepo.EXPECT().
-					CheckCustomer(context.Background(), customerID).Return(false, false, nil)
+					CheckCustomer(gomock.Any(), customerID).Return(false, false, nil)
 				_, _, err := authSvc.RefreshToken(context.Background(), refreshToken)
 				Expect(err).To(Equal(ErrCustomerNotFound))
+				Expect(testAuditSvc.events).To(BeEmpty())
 			})
 			It("should fail when customer does not exist", func() {
 				mockJWTAuthRepo.EXPECT().
-					CheckCustomer(context.Background(), customerID).Return(true, false, nil)
+					CheckCustomer(gomock.Any(), customerID).Return(true, false, nil)
 				_, _, err := authSvc.RefreshToken(context.Background(), refreshToken)
 				Expect(err).To(Equal(ErrCustomerInactive))
 			})
@@ -216,7 +251,7 @@ var _ = Describe("authentication", func() {
 		})
 		It("should create a new customer successfully", func() {
 			mockJWTAuthRepo.EXPECT().
-				CreateCustomer(context.Background(), &customer).Return(nil)
+				CreateCustomer(gomock.Any(), &customer).Return(nil)
 			accessToken, refreshToken, err := authSvc.SignUp(context.Background(), &model.Customer{})
 			Expect(err).To(BeNil())
 
@@ -229,15 +264,94 @@ var _ = Describe("authentication", func() {
 			}))
 
 			mockJWTAuthRepo.EXPECT().
-				CheckCustomer(context.Background(), customerID).Return(true, true, nil)
+				CheckCustomer(gomock.Any(), customerID).Return(true, true, nil)
 			_, _, err = authSvc.RefreshToken(context.Background(), refreshToken)
 			Expect(err).To(BeNil())
 		})
+		It("should record a redacted signup event", func() {
+			expected := customer
+			expected.PersonalInfo = &model.CustomerPersonalInfo{
+				Email: "ming@ming.com",
+			}
+			expected.ShippingInfo = &model.CustomerShippingInfo{
+				Address:       "first street, Taipei, 100, TW",
+				PostalAddress: newPostalAddress(),
+				PhoneNumber:   "+886923456978",
+			}
+			mockJWTAuthRepo.EXPECT().
+				CreateCustomer(gomock.Any(), &expected).Return(nil)
+			_, _, err := authSvc.SignUp(context.Background(), &model.Customer{
+				PersonalInfo: &model.CustomerPersonalInfo{
+					Email: "ming@ming.com",
+				},
+				ShippingInfo: &model.CustomerShippingInfo{
+					PostalAddress: newPostalAddress(),
+					PhoneNumber:   "+886923456978",
+				},
+			})
+			Expect(err).To(BeNil())
+			Expect(testAuditSvc.events).To(HaveLen(1))
+			event := testAuditSvc.lastEvent()
+			Expect(event.Type).To(Equal(model.AuditSignUp))
+			Expect(event.CustomerID).To(Equal(customerID))
+			Expect(event.Changes).To(Equal(map[string]model.AuditChange{
+				"email":        {Before: "", After: "m***@ming.com"},
+				"phone_number": {Before: "", After: "+88*******978"},
+			}))
+		})
+		It("should normalize address and phone number before creating customer", func() {
+			expected := customer
+			expected.ShippingInfo = &model.CustomerShippingInfo{
+				Address: "first street, Taipei, 100, TW",
+				PostalAddress: &model.PostalAddress{
+					Line1:      "first street",
+					City:       "Taipei",
+					PostalCode: "100",
+					Country:    "TW",
+				},
+				PhoneNumber: "+886923456978",
+			}
+			mockJWTAuthRepo.EXPECT().
+				CreateCustomer(gomock.Any(), &expected).Return(nil)
+			_, _, err := authSvc.SignUp(context.Background(), &model.Customer{
+				ShippingInfo: &model.CustomerShippingInfo{
+					PostalAddress: &model.PostalAddress{
+						Line1:      " first   street ",
+						City:       "Taipei",
+						PostalCode: "100",
+						Country:    "tw",
+					},
+					PhoneNumber: "0923-456-978",
+				},
+			})
+			Expect(err).To(BeNil())
+		})
+		It("should reject invalid address", func() {
+			address := newPostalAddress()
+			address.PostalCode = "1"
+			_, _, err := authSvc.SignUp(context.Background(), &model.Customer{
+				ShippingInfo: &model.CustomerShippingInfo{
+					PostalAddress: address,
+					PhoneNumber:   "+886923456978",
+				},
+			})
+			Expect(err).To(Equal(contact.ErrInvalidPostalCode))
+		})
+		It("should reject invalid phone number", func() {
+			_, _, err := authSvc.SignUp(context.Background(), &model.Customer{
+				ShippingInfo: &model.CustomerShippingInfo{
+					PostalAddress: newPostalAddress(),
+					PhoneNumber:   "invalid",
+				},
+			})
+			Expect(err).To(Equal(contact.ErrInvalidPhoneNumber))
+		})
 		It("should get error when inserting duplicate entry", func() {
 			mockJWTAuthRepo.EXPECT().
-				CreateCustomer(context.Background(), &customer).Return(repo.ErrDuplicateEntry)
+				CreateCustomer(gomock.Any(), &customer).Return(repo.ErrDuplicateEntry)
 			_, _, err := authSvc.SignUp(context.Background(), &model.Customer{})
 			Expect(err).To(Equal(repo.ErrDuplicateEntry))
+			Expect(testAuditSvc.events).To(BeEmpty())
 		})
 	})
 	var _ = When("logging in", func() {
@@ -251,13 +365,15 @@ var _ = Describe("authentication", func() {
 		})
 		It("should login a customer succesfully", func() {
 			mockJWTAuthRepo.EXPECT().
-				GetCustomerCredentials(context.Background(), email).Return(true, &repo.CustomerCredentials{
+				GetCustomerCredentials(gomock.Any(), email).Return(true, &repo.CustomerCredentials{
 				ID:               customerID,
 				Active:           true,
 				BcryptedPassword: bcryptedPassword,
 			}, nil)
 			accessToken, refreshToken, err := authSvc.Login(context.Background(), email, password)
 			Expect(err).To(BeNil())
+			Expect(testAuditSvc.lastEvent().Type).To(Equal(model.AuditLoginSuccess))
+			Expect(testAuditSvc.lastEvent().CustomerID).To(Equal(customerID))
 
 			authPayload.AccessToken = accessToken
 			authResponse, err := authSvc.Auth(context.Background(), &authPayload)
@@ -268,20 +384,20 @@ var _ = Describe("authentication", func() {
 			}))
 
 			mockJWTAuthRepo.EXPECT().
-				CheckCustomer(context.Background(), customerID).Return(true, true, nil)
+				CheckCustomer(gomock.Any(), customerID).Return(true, true, nil)
 			_, _, err = authSvc.RefreshToken(context.Background(), refreshToken)
 			Expect(err).To(BeNil())
 		})
 		It("should fail authentication", func() {
 			When("customer does not exist", func() {
 				mockJWTAuthRepo.EXPECT().
-					GetCustomerCredentials(context.Background(), email).Return(false, nil, nil)
+					GetCustomerCredentials(gomock.Any(), email).Return(false, nil, nil)
 				_, _, err := authSvc.Login(context.Background(), email, password)
 				Expect(err).To(Equal(ErrCustomerNotFound))
 			})
 			When("customer is not active", func() {
 				mockJWTAuthRepo.EXPECT().
-					GetCustomerCredentials(context.Background(), email).Return(true, &repo.CustomerCredentials{
+					GetCustomerCredentials(gomock.Any(), email).Return(true, &repo.CustomerCredentials{
 					ID:               customerID,
 					Active:           false,
 					BcryptedPassword: bcryptedPassword,
@@ -291,7 +407,7 @@ var _ = Describe("authentication", func() {
 			})
 			When("enter wrong password", func() {
 				mockJWTAuthRepo.EXPECT().
-					GetCustomerCredentials(context.Background(), email).Return(true, &repo.CustomerCredentials{
+					GetCustomerCredentials(gomock.Any(), email).Return(true, &repo.CustomerCredentials{
 					ID:               customerID,
 					Active:           true,
 					BcryptedPassword: bcryptedPassword,
@@ -300,5 +416,81 @@ var _ = Describe("authentication", func() {
 				Expect(err).To(Equal(ErrAuthentication))
 			})
 		})
+		It("should count logins by outcome", func() {
+			count := func(outcome string) float64 {
+				return testutil.ToFloat64(logins.WithLabelValues(outcome))
+			}
+			notFound, wrongPassword := count(outcomeNotFound), count(outcomeWrongPassword)
+			mockJWTAuthRepo.EXPECT().
+				GetCustomerCredentials(gomock.Any(), email).Return(false, nil, nil)
+			_, _, err := authSvc.Login(context.Background(), email, password)
+			Expect(err).To(Equal(ErrCustomerNotFound))
+			mockJWTAuthRepo.EXPECT().
+				GetCustomerCredentials(gomock.Any(), email).Return(true, &repo.CustomerCredentials{
+				ID:               customerID,
+				Active:           true,
+				BcryptedPassword: bcryptedPassword,
+			}, nil)
+			_, _, err = authSvc.Login(context.Background(), email, "wrongpassword")
+			Expect(err).To(Equal(ErrAuthentication))
+
+			Expect(count(outcomeNotFound) - notFound).To(Equal(float64(1)))
+			Expect(count(outcomeWrongPassword) - wrongPassword).To(Equal(float64(1)))
+		})
+		It("should trace logins with their outcome", func() {
+			exporter := tracetest.NewInMemoryExporter()
+			otel.SetTracerProvider(tracesdk.NewTracerProvider(tracesdk.WithSyncer(exporter)))
+			defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+			var repoSpan trace.SpanContext
+			mockJWTAuthRepo.EXPECT().
+				GetCustomerCredentials(gomock.Any(), email).DoAndReturn(
+				func(ctx context.Context, email string) (bool, *repo.CustomerCredentials, error) {
+					repoSpan = trace.SpanContextFromContext(ctx)
+					return false, nil, nil
+				})
+			_, _, err := authSvc.Login(context.Background(), email, password)
+			Expect(err).To(Equal(ErrCustomerNotFound))
+
+			spans := exporter.GetSpans()
+			Expect(spans).To(HaveLen(1))
+			Expect(spans[0].Name).To(Equal("JWTAuthService.Login"))
+			Expect(spans[0].Attributes).To(ContainElement(HaveField("Value.AsString()", outcomeNotFound)))
+			// the repository is called within the span
+			Expect(repoSpan.SpanID()).To(Equal(spans[0].SpanContext.SpanID()))
+		})
+		It("should record login failures", func() {
+			mockJWTAuthRepo.EXPECT().
+				GetCustomerCredentials(gomock.Any(), email).Return(false, nil, nil)
+			_, _, err := authSvc.Login(context.Background(), email, password)
+			Expect(err).To(Equal(ErrCustomerNotFound))
+			Expect(testAuditSvc.lastEvent()).To(Equal(&model.AuditEvent{
+				Type: model.AuditLoginFailure,
+				Changes: map[string]model.AuditChange{
+					"email":  {Before: "", After: "m***@ming.com"},
+					"reason": {Before: "", After: ErrCustomerNotFound.Error()},
+				},
+			}))
+
+			mockJWTAuthRepo.EXPECT().
+				GetCustomerCredentials(gomock.Any(), email).Return(true, &repo.CustomerCredentials{
+				ID:               customerID,
+				Active:           true,
+				BcryptedPassword: bcryptedPassword,
+			}, nil)
+			_, _, err = authSvc.Login(context.Background(), email, "wrongpassword")
+			Expect(err).To(Equal(ErrAuthentication))
+			Expect(testAuditSvc.lastEvent().CustomerID).To(Equal(customerID))
+			Expect(testAuditSvc.lastEvent().Changes["reason"].After).To(Equal(ErrAuthentication.Error()))
+		})
 	})
 })
+
+func newPostalAddress() *model.PostalAddress {
+	return &model.PostalAddress{
+		Line1:      "first street",
+		City:       "Taipei",
+		PostalCode: "100",
+		Country:    "TW",
+	}
+}
@@ -6,13 +6,58 @@ import (
 	"time"
 
 	"github.com/minghsu0107/saga-account/pkg"
+	"github.com/minghsu0107/saga-account/pkg/contact"
 	"github.com/minghsu0107/saga-account/repo"
 	"github.com/minghsu0107/saga-account/repo/proxy"
+	"github.com/minghsu0107/saga-account/service/audit"
 
 	"github.com/golang-jwt/jwt/v4"
 	conf "github.com/minghsu0107/saga-account/config"
 	"github.com/minghsu0107/saga-account/domain/model"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/minghsu0107/saga-account/service/auth"
+
+// outcomes of authentication requests
+const (
+	outcomeSuccess       = "success"
+	outcomeDuplicate     = "duplicate"
+	outcomeInvalid       = "invalid"
+	outcomeNotFound      = "not_found"
+	outcomeInactive      = "inactive"
+	outcomeWrongPassword = "wrong_password"
+	outcomeExpired       = "expired"
+	outcomeError         = "error"
+
+	resultValid   = "valid"
+	resultExpired = "expired"
+	resultInvalid = "invalid"
+)
+
+var (
+	signUps = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "account_signups_total",
+		Help: "The number of signups by outcome.",
+	}, []string{"outcome"})
+	logins = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "account_logins_total",
+		Help: "The number of logins by outcome.",
+	}, []string{"outcome"})
+	tokenRefreshes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "account_token_refreshes_total",
+		Help: "The number of token refreshes by outcome.",
+	}, []string{"outcome"})
+	authResults = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "account_auth_results_total",
+		Help: "The number of access token checks by result.",
+	}, []string{"result"})
 )
 
 // JWTAuthServiceImpl implements JWTAuthService interface
@@ -21,17 +66,19 @@ type JWTAuthServiceImpl struct {
 	accessTokenExpireSecond  int64
 	refreshTokenExpireSecond int64
 	jwtAuthRepo              proxy.JWTAuthRepoCache
+	auditSvc                 audit.AuditService
 	sf                       pkg.IDGenerator
 	logger                   *log.Entry
 }
 
 // NewJWTAuthService is the factory of JWTAuthService
-func NewJWTAuthService(config *conf.Config, jwtAuthRepo proxy.JWTAuthRepoCache, sf pkg.IDGenerator) JWTAuthService {
+func NewJWTAuthService(config *conf.Config, jwtAuthRepo proxy.JWTAuthRepoCache, auditSvc audit.AuditService, sf pkg.IDGenerator) JWTAuthService {
 	return &JWTAuthServiceImpl{
 		jwtSecret:                config.JWTConfig.Secret,
 		accessTokenExpireSecond:  config.JWTConfig.AccessTokenExpireSecond,
 		refreshTokenExpireSecond: config.JWTConfig.RefreshTokenExpireSecond,
 		jwtAuthRepo:              jwtAuthRepo,
+		auditSvc:                 auditSvc,
 		sf:                       sf,
 		logger: config.Logger.ContextLogger.WithFields(log.Fields{
 			"type": "service:JWTAuthService",
@@ -41,26 +88,34 @@ func NewJWTAuthService(config *conf.Config, jwtAuthRepo proxy.JWTAuthRepoCache,
 
 // Auth authenticates an user by checking access token
 func (svc *JWTAuthServiceImpl) Auth(ctx context.Context, authPayload *model.AuthPayload) (*model.AuthResponse, error) {
+	_, span := otel.Tracer(tracerName).Start(ctx, "JWTAuthService.Auth")
+	defer span.End()
+
 	token, err := svc.parseToken(authPayload.AccessToken)
 	if err != nil {
 		v := err.(*jwt.ValidationError)
 		if v.Errors == jwt.ValidationErrorExpired {
+			observe(span, authResults, resultExpired)
 			return &model.AuthResponse{
 				Expired: true,
 			}, nil
 		}
+		observe(span, authResults, resultInvalid)
 		return nil, ErrInvalidToken
 	}
 
 	claims, ok := token.Claims.(*model.JWTClaims)
 	if !(ok && token.Valid) {
+		observe(span, authResults, resultInvalid)
 		return nil, ErrInvalidToken
 	}
 
 	if claims.Refresh {
+		observe(span, authResults, resultInvalid)
 		return nil, ErrInvalidToken
 	}
 
+	observe(span, authResults, resultValid)
 	return &model.AuthResponse{
 		CustomerID: claims.CustomerID,
 		Expired:    false,
@@ -69,75 +124,159 @@ func (svc *JWTAuthServiceImpl) Auth(ctx context.Context, authPayload *model.Auth
 
 // SignUp creates a new customer and returns a token pair
 func (svc *JWTAuthServiceImpl) SignUp(ctx context.Context, customer *model.Customer) (string, string, error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "JWTAuthService.SignUp")
+	defer span.End()
+
+	if customer.ShippingInfo != nil {
+		if err := normalizeShippingInfo(customer.ShippingInfo); err != nil {
+			observe(span, signUps, outcomeInvalid)
+			return "", "", err
+		}
+	}
 	sonyflakeID, err := svc.sf.NextID()
 	if err != nil {
+		observe(span, signUps, outcomeError)
+		recordError(span, err)
 		return "", "", err
 	}
 	customer.ID = sonyflakeID
 	customer.Active = true
 	if err := svc.jwtAuthRepo.CreateCustomer(ctx, customer); err != nil {
-		if err != repo.ErrDuplicateEntry {
-			svc.logger.Error(err.Error())
+		if err == repo.ErrDuplicateEntry {
+			observe(span, signUps, outcomeDuplicate)
+		} else {
+			observe(span, signUps, outcomeError)
+			recordError(span, err)
+			svc.logger.WithContext(ctx).Error(err.Error())
 		}
 		return "", "", err
 	}
+	observe(span, signUps, outcomeSuccess)
+	changes := audit.Changes{}
+	if customer.PersonalInfo != nil {
+		changes.Email("email", "", customer.PersonalInfo.Email)
+	}
+	if customer.ShippingInfo != nil {
+		changes.PhoneNumber("phone_number", "", customer.ShippingInfo.PhoneNumber)
+	}
+	svc.auditSvc.Record(ctx, &model.AuditEvent{
+		CustomerID: customer.ID,
+		Type:       model.AuditSignUp,
+		Changes:    changes,
+	})
 	return svc.newTokenPair(customer.ID)
 }
 
 // Login authenticate the user and returns a new token pair if succeed
 func (svc *JWTAuthServiceImpl) Login(ctx context.Context, email string, password string) (string, string, error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "JWTAuthService.Login")
+	defer span.End()
+
 	exist, credentials, err := svc.jwtAuthRepo.GetCustomerCredentials(ctx, email)
 	if err != nil {
-		svc.logger.Error(err.Error())
+		observe(span, logins, outcomeError)
+		recordError(span, err)
+		svc.logger.WithContext(ctx).Error(err.Error())
 		return "", "", err
 	}
 	if !exist {
+		observe(span, logins, outcomeNotFound)
+		svc.recordLoginFailure(ctx, 0, email, ErrCustomerNotFound)
 		return "", "", ErrCustomerNotFound
 	}
 	if !credentials.Active {
+		observe(span, logins, outcomeInactive)
+		svc.recordLoginFailure(ctx, credentials.ID, email, ErrCustomerInactive)
 		return "", "", ErrCustomerInactive
 	}
 	if pkg.CheckPasswordHash(password, credentials.BcryptedPassword) {
+		observe(span, logins, outcomeSuccess)
+		svc.auditSvc.Record(ctx, &model.AuditEvent{
+			CustomerID: credentials.ID,
+			Type:       model.AuditLoginSuccess,
+		})
 		return svc.newTokenPair(credentials.ID)
 	}
+	observe(span, logins, outcomeWrongPassword)
+	svc.recordLoginFailure(ctx, credentials.ID, email, ErrAuthentication)
 	return "", "", ErrAuthentication
 }
 
+// recordLoginFailure records a rejected login attempt
+// customerID is zero if no customer owns the email
+func (svc *JWTAuthServiceImpl) recordLoginFailure(ctx context.Context, customerID uint64, email string, reason error) {
+	svc.auditSvc.Record(ctx, &model.AuditEvent{
+		CustomerID: customerID,
+		Type:       model.AuditLoginFailure,
+		Changes: audit.Changes{}.
+			Email("email", "", email).
+			Value("reason", "", reason.Error()),
+	})
+}
+
 // RefreshToken checks the given refresh token and return a new token pair if the refresh token is valid
 func (svc *JWTAuthServiceImpl) RefreshToken(ctx context.Context, refreshToken string) (string, string, error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "JWTAuthService.RefreshToken")
+	defer span.End()
+
 	token, err := svc.parseToken(refreshToken)
 	if err != nil {
 		v := err.(*jwt.ValidationError)
 		if v.Errors == jwt.ValidationErrorExpired {
+			observe(span, tokenRefreshes, outcomeExpired)
 			return "", "", ErrTokenExpired
 		}
+		observe(span, tokenRefreshes, outcomeInvalid)
 		return "", "", ErrInvalidToken
 	}
 
 	claims, ok := token.Claims.(*model.JWTClaims)
 	if !(ok && token.Valid) {
+		observe(span, tokenRefreshes, outcomeInvalid)
 		return "", "", ErrInvalidToken
 	}
 
 	if !claims.Refresh {
+		observe(span, tokenRefreshes, outcomeInvalid)
 		return "", "", ErrInvalidToken
 	}
 
 	customerID := claims.CustomerID
 	exist, active, err := svc.jwtAuthRepo.CheckCustomer(ctx, customerID)
 	if err != nil {
+		observe(span, tokenRefreshes, outcomeError)
+		recordError(span, err)
 		return "", "", err
 	}
 	if !exist {
+		observe(span, tokenRefreshes, outcomeNotFound)
 		return "", "", ErrCustomerNotFound
 	}
 	if !active {
+		observe(span, tokenRefreshes, outcomeInactive)
 		return "", "", ErrCustomerInactive
 	}
+	observe(span, tokenRefreshes, outcomeSuccess)
 
+	svc.auditSvc.Record(ctx, &model.AuditEvent{
+		CustomerID: customerID,
+		Type:       model.AuditTokenRefresh,
+	})
 	return svc.newTokenPair(customerID)
 }
 
+// observe counts the outcome of a request and tags its span with it
+func observe(span trace.Span, counter *prometheus.CounterVec, outcome string) {
+	counter.WithLabelValues(outcome).Inc()
+	span.SetAttributes(attribute.String("auth.outcome", outcome))
+}
+
+// recordError marks the span of a request failed by err
+func recordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
 func (svc *JWTAuthServiceImpl) newTokenPair(customerID uint64) (string, string, error) {
 	now := time.Now()
 	accessTokenExpiresAt := now.Add(time.Duration(svc.accessTokenExpireSecond) * time.Second)
@@ -179,3 +318,21 @@ func (svc *JWTAuthServiceImpl) parseToken(accessToken string) (*jwt.Token, error
 		return []byte(svc.jwtSecret), nil
 	})
 }
+
+// normalizeShippingInfo validates the postal address of a signup, which is stored formatted,
+// and normalizes the phone number in the region of its country
+func normalizeShippingInfo(shippingInfo *model.CustomerShippingInfo) error {
+	if shippingInfo.PostalAddress == nil {
+		return contact.ErrAddressLineRequired
+	}
+	if err := contact.NormalizeAddress(shippingInfo.PostalAddress); err != nil {
+		return err
+	}
+	shippingInfo.Address = shippingInfo.PostalAddress.Format()
+	phoneNumber, err := contact.NormalizePhoneNumber(shippingInfo.PhoneNumber, shippingInfo.PostalAddress.Country)
+	if err != nil {
+		return err
+	}
+	shippingInfo.PhoneNumber = phoneNumber
+	return nil
+}
@@ -0,0 +1,17 @@
+package saga
+
+import "errors"
+
+var (
+	// ErrUnknownCommand is unknown saga command error
+	ErrUnknownCommand = errors.New("unknown saga command")
+	// ErrInvalidCommand is invalid saga command error
+	ErrInvalidCommand = errors.New("invalid saga command")
+)
+
+// reasons of failed reservations
+const (
+	reasonCustomerNotFound = "customer not found"
+	reasonCustomerInactive = "customer inactive"
+	reasonSagaReleased     = "saga released"
+)
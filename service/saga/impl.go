@@ -0,0 +1,140 @@
+package saga
+
+import (
+	"context"
+
+	conf "github.com/minghsu0107/saga-account/config"
+	"github.com/minghsu0107/saga-account/domain/model"
+	"github.com/minghsu0107/saga-account/repo"
+	"github.com/minghsu0107/saga-account/repo/proxy"
+	"github.com/minghsu0107/saga-account/service/account"
+	log "github.com/sirupsen/logrus"
+)
+
+// SagaServiceImpl implements SagaService interface
+type SagaServiceImpl struct {
+	customerSvc account.CustomerService
+	jwtAuthRepo proxy.JWTAuthRepoCache
+	sagaRepo    repo.SagaRepository
+	logger      *log.Entry
+}
+
+// NewSagaService is the factory of SagaService
+func NewSagaService(config *conf.Config, customerSvc account.CustomerService, jwtAuthRepo proxy.JWTAuthRepoCache, sagaRepo repo.SagaRepository) SagaService {
+	return &SagaServiceImpl{
+		customerSvc: customerSvc,
+		jwtAuthRepo: jwtAuthRepo,
+		sagaRepo:    sagaRepo,
+		logger: config.Logger.ContextLogger.WithFields(log.Fields{
+			"type": "service:SagaService",
+		}),
+	}
+}
+
+// HandleCommand handles a saga command and returns the reply
+// commands are idempotent by saga ID: a command that has already been processed gets its original reply.
+// an error is returned only if the command cannot be handled now and should be redelivered
+func (svc *SagaServiceImpl) HandleCommand(ctx context.Context, cmd *model.SagaCommand) (*model.SagaReply, error) {
+	if cmd.SagaID == "" {
+		return nil, ErrInvalidCommand
+	}
+	if cmd.Type != model.SagaReserveCustomer && cmd.Type != model.SagaReleaseCustomer {
+		return nil, ErrUnknownCommand
+	}
+	processed, reply, err := svc.sagaRepo.GetSagaReply(ctx, cmd.SagaID, cmd.Type)
+	if err != nil {
+		svc.logger.WithContext(ctx).Error(err.Error())
+		return nil, err
+	}
+	if processed {
+		return reply, nil
+	}
+
+	switch cmd.Type {
+	case model.SagaReserveCustomer:
+		reply, err = svc.reserve(ctx, cmd)
+	case model.SagaReleaseCustomer:
+		reply = newReply(cmd, model.SagaCustomerReleased)
+	}
+	if err != nil {
+		svc.logger.WithContext(ctx).Error(err.Error())
+		return nil, err
+	}
+
+	if err := svc.sagaRepo.SaveSagaReply(ctx, cmd.Type, reply); err != nil {
+		if err != repo.ErrDuplicateEntry {
+			svc.logger.WithContext(ctx).Error(err.Error())
+			return nil, err
+		}
+		// the same command is processed concurrently; reply as the winner does
+		_, reply, err = svc.sagaRepo.GetSagaReply(ctx, cmd.SagaID, cmd.Type)
+		if err != nil {
+			svc.logger.WithContext(ctx).Error(err.Error())
+			return nil, err
+		}
+	}
+	return reply, nil
+}
+
+// reserve validates that the customer is active and takes a snapshot of its shipping info
+// a reservation arriving after the release of the same saga fails, since the saga has been compensated
+func (svc *SagaServiceImpl) reserve(ctx context.Context, cmd *model.SagaCommand) (*model.SagaReply, error) {
+	released, _, err := svc.sagaRepo.GetSagaReply(ctx, cmd.SagaID, model.SagaReleaseCustomer)
+	if err != nil {
+		return nil, err
+	}
+	if released {
+		return newFailedReply(cmd, reasonSagaReleased), nil
+	}
+
+	exist, active, err := svc.jwtAuthRepo.CheckCustomer(ctx, cmd.CustomerID)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return newFailedReply(cmd, reasonCustomerNotFound), nil
+	}
+	if !active {
+		return newFailedReply(cmd, reasonCustomerInactive), nil
+	}
+
+	personalInfo, err := svc.customerSvc.GetCustomerPersonalInfo(ctx, cmd.CustomerID)
+	if err == repo.ErrCustomerNotFound {
+		return newFailedReply(cmd, reasonCustomerNotFound), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	shippingInfo, err := svc.customerSvc.GetCustomerShippingInfo(ctx, cmd.CustomerID)
+	if err == repo.ErrCustomerNotFound {
+		return newFailedReply(cmd, reasonCustomerNotFound), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reply := newReply(cmd, model.SagaCustomerReserved)
+	reply.ShippingSnapshot = &model.ShippingSnapshot{
+		FirstName:   personalInfo.FirstName,
+		LastName:    personalInfo.LastName,
+		Email:       personalInfo.Email,
+		Address:     shippingInfo.Address,
+		PhoneNumber: shippingInfo.PhoneNumber,
+	}
+	return reply, nil
+}
+
+func newReply(cmd *model.SagaCommand, replyType model.SagaReplyType) *model.SagaReply {
+	return &model.SagaReply{
+		SagaID:     cmd.SagaID,
+		Type:       replyType,
+		CustomerID: cmd.CustomerID,
+		OrderID:    cmd.OrderID,
+	}
+}
+
+func newFailedReply(cmd *model.SagaCommand, reason string) *model.SagaReply {
+	reply := newReply(cmd, model.SagaCustomerReservationFailed)
+	reply.Reason = reason
+	return reply
+}
@@ -0,0 +1,12 @@
+package saga
+
+import (
+	"context"
+
+	"github.com/minghsu0107/saga-account/domain/model"
+)
+
+// SagaService defines saga participant interface
+type SagaService interface {
+	HandleCommand(ctx context.Context, cmd *model.SagaCommand) (*model.SagaReply, error)
+}
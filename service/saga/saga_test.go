@@ -0,0 +1,211 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	conf "github.com/minghsu0107/saga-account/config"
+	"github.com/minghsu0107/saga-account/domain/model"
+	mock_repo "github.com/minghsu0107/saga-account/mock/repo"
+	mock_service "github.com/minghsu0107/saga-account/mock/service"
+	"github.com/minghsu0107/saga-account/repo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	mockCtrl        *gomock.Controller
+	mockCustomerSvc *mock_service.MockCustomerService
+	mockJWTAuthRepo *mock_repo.MockJWTAuthRepository
+	mockSagaRepo    *mock_repo.MockSagaRepository
+	sagaSvc         SagaService
+	testCustomerID  uint64 = 347951634795465221
+	testOrderID     uint64 = 347951634795465999
+	testSagaID             = "saga-1"
+)
+
+func TestSaga(t *testing.T) {
+	mockCtrl = gomock.NewController(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "saga suite")
+}
+
+func InitMocks() {
+	mockCustomerSvc = mock_service.NewMockCustomerService(mockCtrl)
+	mockJWTAuthRepo = mock_repo.NewMockJWTAuthRepository(mockCtrl)
+	mockSagaRepo = mock_repo.NewMockSagaRepository(mockCtrl)
+}
+
+func NewTestSagaService() SagaService {
+	config := &conf.Config{
+		Logger: &conf.Logger{
+			Writer: ioutil.Discard,
+			ContextLogger: log.WithFields(log.Fields{
+				"app": "test",
+			}),
+		},
+	}
+	return NewSagaService(config, mockCustomerSvc, mockJWTAuthRepo, mockSagaRepo)
+}
+
+var _ = BeforeSuite(func() {
+	InitMocks()
+	sagaSvc = NewTestSagaService()
+})
+
+var _ = AfterSuite(func() {
+	mockCtrl.Finish()
+})
+
+var _ = Describe("saga command", func() {
+	var cmd model.SagaCommand
+	BeforeEach(func() {
+		cmd = model.SagaCommand{
+			SagaID:     testSagaID,
+			Type:       model.SagaReserveCustomer,
+			CustomerID: testCustomerID,
+			OrderID:    testOrderID,
+		}
+	})
+	var _ = When("command is invalid", func() {
+		It("should reject command without saga ID", func() {
+			cmd.SagaID = ""
+			_, err := sagaSvc.HandleCommand(context.Background(), &cmd)
+			Expect(err).To(Equal(ErrInvalidCommand))
+		})
+		It("should reject unknown command", func() {
+			cmd.Type = "customer.unknown"
+			_, err := sagaSvc.HandleCommand(context.Background(), &cmd)
+			Expect(err).To(Equal(ErrUnknownCommand))
+		})
+	})
+	var _ = When("reserving a customer", func() {
+		It("should reply a shipping snapshot of an active customer", func() {
+			expected := &model.SagaReply{
+				SagaID:     testSagaID,
+				Type:       model.SagaCustomerReserved,
+				CustomerID: testCustomerID,
+				OrderID:    testOrderID,
+				ShippingSnapshot: &model.ShippingSnapshot{
+					FirstName:   "ming",
+					LastName:    "hsu",
+					Email:       "ming@ming.com",
+					Address:     "taipei",
+					PhoneNumber: "+886923456978",
+				},
+			}
+			mockSagaRepo.EXPECT().GetSagaReply(context.Background(), testSagaID, model.SagaReserveCustomer).Return(false, nil, nil)
+			mockSagaRepo.EXPECT().GetSagaReply(context.Background(), testSagaID, model.SagaReleaseCustomer).Return(false, nil, nil)
+			mockJWTAuthRepo.EXPECT().CheckCustomer(context.Background(), testCustomerID).Return(true, true, nil)
+			mockCustomerSvc.EXPECT().GetCustomerPersonalInfo(context.Background(), testCustomerID).Return(&model.CustomerPersonalInfo{
+				FirstName: "ming",
+				LastName:  "hsu",
+				Email:     "ming@ming.com",
+			}, nil)
+			mockCustomerSvc.EXPECT().GetCustomerShippingInfo(context.Background(), testCustomerID).Return(&model.CustomerShippingInfo{
+				Address:     "taipei",
+				PhoneNumber: "+886923456978",
+			}, nil)
+			mockSagaRepo.EXPECT().SaveSagaReply(context.Background(), model.SagaReserveCustomer, expected).Return(nil)
+
+			reply, err := sagaSvc.HandleCommand(context.Background(), &cmd)
+			Expect(err).To(BeNil())
+			Expect(reply).To(Equal(expected))
+		})
+		It("should fail to reserve an inactive customer", func() {
+			mockSagaRepo.EXPECT().GetSagaReply(context.Background(), testSagaID, model.SagaReserveCustomer).Return(false, nil, nil)
+			mockSagaRepo.EXPECT().GetSagaReply(context.Background(), testSagaID, model.SagaReleaseCustomer).Return(false, nil, nil)
+			mockJWTAuthRepo.EXPECT().CheckCustomer(context.Background(), testCustomerID).Return(true, false, nil)
+			mockSagaRepo.EXPECT().SaveSagaReply(context.Background(), model.SagaReserveCustomer, gomock.Any()).Return(nil)
+
+			reply, err := sagaSvc.HandleCommand(context.Background(), &cmd)
+			Expect(err).To(BeNil())
+			Expect(reply.Type).To(Equal(model.SagaCustomerReservationFailed))
+			Expect(reply.Reason).To(Equal(reasonCustomerInactive))
+			Expect(reply.ShippingSnapshot).To(BeNil())
+		})
+		It("should fail to reserve a non-existent customer", func() {
+			mockSagaRepo.EXPECT().GetSagaReply(context.Background(), testSagaID, model.SagaReserveCustomer).Return(false, nil, nil)
+			mockSagaRepo.EXPECT().GetSagaReply(context.Background(), testSagaID, model.SagaReleaseCustomer).Return(false, nil, nil)
+			mockJWTAuthRepo.EXPECT().CheckCustomer(context.Background(), testCustomerID).Return(false, false, nil)
+			mockSagaRepo.EXPECT().SaveSagaReply(context.Background(), model.SagaReserveCustomer, gomock.Any()).Return(nil)
+
+			reply, err := sagaSvc.HandleCommand(context.Background(), &cmd)
+			Expect(err).To(BeNil())
+			Expect(reply.Type).To(Equal(model.SagaCustomerReservationFailed))
+			Expect(reply.Reason).To(Equal(reasonCustomerNotFound))
+		})
+		It("should fail to reserve after the saga is released", func() {
+			mockSagaRepo.EXPECT().GetSagaReply(context.Background(), testSagaID, model.SagaReserveCustomer).Return(false, nil, nil)
+			mockSagaRepo.EXPECT().GetSagaReply(context.Background(), testSagaID, model.SagaReleaseCustomer).Return(true, &model.SagaReply{}, nil)
+			mockSagaRepo.EXPECT().SaveSagaReply(context.Background(), model.SagaReserveCustomer, gomock.Any()).Return(nil)
+
+			reply, err := sagaSvc.HandleCommand(context.Background(), &cmd)
+			Expect(err).To(BeNil())
+			Expect(reply.Type).To(Equal(model.SagaCustomerReservationFailed))
+			Expect(reply.Reason).To(Equal(reasonSagaReleased))
+		})
+		It("should return an error to be redelivered on transient failure", func() {
+			errDB := errors.New("db down")
+			mockSagaRepo.EXPECT().GetSagaReply(context.Background(), testSagaID, model.SagaReserveCustomer).Return(false, nil, nil)
+			mockSagaRepo.EXPECT().GetSagaReply(context.Background(), testSagaID, model.SagaReleaseCustomer).Return(false, nil, nil)
+			mockJWTAuthRepo.EXPECT().CheckCustomer(context.Background(), testCustomerID).Return(false, false, errDB)
+
+			_, err := sagaSvc.HandleCommand(context.Background(), &cmd)
+			Expect(err).To(Equal(errDB))
+		})
+	})
+	var _ = When("command is duplicated", func() {
+		It("should return the stored reply without side effects", func() {
+			stored := &model.SagaReply{
+				SagaID:     testSagaID,
+				Type:       model.SagaCustomerReserved,
+				CustomerID: testCustomerID,
+				OrderID:    testOrderID,
+			}
+			mockSagaRepo.EXPECT().GetSagaReply(context.Background(), testSagaID, model.SagaReserveCustomer).Return(true, stored, nil)
+
+			reply, err := sagaSvc.HandleCommand(context.Background(), &cmd)
+			Expect(err).To(BeNil())
+			Expect(reply).To(Equal(stored))
+		})
+		It("should return the reply of the concurrent winner", func() {
+			stored := &model.SagaReply{
+				SagaID:     testSagaID,
+				Type:       model.SagaCustomerReleased,
+				CustomerID: testCustomerID,
+				OrderID:    9,
+			}
+			cmd.Type = model.SagaReleaseCustomer
+			gomock.InOrder(
+				mockSagaRepo.EXPECT().GetSagaReply(context.Background(), testSagaID, model.SagaReleaseCustomer).Return(false, nil, nil),
+				mockSagaRepo.EXPECT().SaveSagaReply(context.Background(), model.SagaReleaseCustomer, gomock.Any()).Return(repo.ErrDuplicateEntry),
+				mockSagaRepo.EXPECT().GetSagaReply(context.Background(), testSagaID, model.SagaReleaseCustomer).Return(true, stored, nil),
+			)
+
+			reply, err := sagaSvc.HandleCommand(context.Background(), &cmd)
+			Expect(err).To(BeNil())
+			Expect(reply).To(Equal(stored))
+		})
+	})
+	var _ = When("releasing a customer", func() {
+		It("should reply released", func() {
+			cmd.Type = model.SagaReleaseCustomer
+			mockSagaRepo.EXPECT().GetSagaReply(context.Background(), testSagaID, model.SagaReleaseCustomer).Return(false, nil, nil)
+			mockSagaRepo.EXPECT().SaveSagaReply(context.Background(), model.SagaReleaseCustomer, gomock.Any()).Return(nil)
+
+			reply, err := sagaSvc.HandleCommand(context.Background(), &cmd)
+			Expect(err).To(BeNil())
+			Expect(reply).To(Equal(&model.SagaReply{
+				SagaID:     testSagaID,
+				Type:       model.SagaCustomerReleased,
+				CustomerID: testCustomerID,
+				OrderID:    testOrderID,
+			}))
+		})
+	})
+})